@@ -0,0 +1,232 @@
+package css
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A calcValue is the result of evaluating part of a math function. It's
+// either a plain number, or a length in px. Percentages are resolved to
+// lengths as soon as they're seen.
+type calcValue struct {
+	Value  float64
+	Length bool
+}
+
+// isMathFunction reports whether val is one of the math functions calc(),
+// min(), max() or clamp().
+func isMathFunction(val string) bool {
+	val = strings.ToLower(strings.TrimSpace(val))
+	for _, name := range []string{"calc(", "min(", "max(", "clamp("} {
+		if strings.HasPrefix(val, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// evalMathFunction evaluates the math function in cssString to a length in
+// px. Relative lengths are resolved against u, and percentages against
+// percentbasis.
+func evalMathFunction(u UnitContext, percentbasis int, cssString string) (float64, error) {
+	vals := trimWhitespace(ParseComponentValues(cssString))
+	if len(vals) != 1 {
+		return 0, fmt.Errorf("Invalid math function: %v", cssString)
+	}
+	f, ok := vals[0].(*Function)
+	if !ok {
+		return 0, fmt.Errorf("Invalid math function: %v", cssString)
+	}
+	c := calcContext{u, percentbasis}
+	v, err := c.function(f)
+	if err != nil {
+		return 0, err
+	}
+	if !v.Length {
+		return 0, fmt.Errorf("Math function is not a length: %v", cssString)
+	}
+	if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+		return 0, fmt.Errorf("Math function is not finite: %v", cssString)
+	}
+	return v.Value, nil
+}
+
+// calcContext holds the values that relative units in a math function are
+// resolved against.
+type calcContext struct {
+	units        UnitContext
+	percentbasis int
+}
+
+// function evaluates a math function.
+func (c calcContext) function(f *Function) (calcValue, error) {
+	var args []calcValue
+	for _, arg := range splitOnCommas(f.Value) {
+		v, err := c.sum(trimWhitespace(arg))
+		if err != nil {
+			return calcValue{}, err
+		}
+		args = append(args, v)
+	}
+	if len(args) == 0 {
+		return calcValue{}, fmt.Errorf("Missing arguments to %v()", f.Name)
+	}
+	for _, arg := range args[1:] {
+		if arg.Length != args[0].Length {
+			return calcValue{}, fmt.Errorf("Mismatched types in %v()", f.Name)
+		}
+	}
+
+	switch name := strings.ToLower(f.Name); name {
+	case "calc":
+		if len(args) != 1 {
+			return calcValue{}, fmt.Errorf("calc() takes one argument")
+		}
+		return args[0], nil
+	case "min", "max":
+		ret := args[0]
+		for _, arg := range args[1:] {
+			if (name == "min" && arg.Value < ret.Value) || (name == "max" && arg.Value > ret.Value) {
+				ret = arg
+			}
+		}
+		return ret, nil
+	case "clamp":
+		if len(args) != 3 {
+			return calcValue{}, fmt.Errorf("clamp() takes three arguments")
+		}
+		// If the minimum is larger than the maximum, the minimum
+		// wins.
+		ret := args[1]
+		if args[2].Value < ret.Value {
+			ret = args[2]
+		}
+		if args[0].Value > ret.Value {
+			ret = args[0]
+		}
+		return ret, nil
+	default:
+		return calcValue{}, fmt.Errorf("Unsupported function %v()", f.Name)
+	}
+}
+
+// sum evaluates a calc sum, which is made of products separated by + or -.
+func (c calcContext) sum(vals []ComponentValue) (calcValue, error) {
+	if len(vals) == 0 {
+		return calcValue{}, fmt.Errorf("Missing value in math function")
+	}
+	start := 0
+	var ret calcValue
+	op := "+"
+	for i := 0; i <= len(vals); i++ {
+		if i < len(vals) {
+			t, ok := vals[i].(Token)
+			if !ok || t.Type != DelimToken || (t.Value != "+" && t.Value != "-") {
+				continue
+			}
+			// + and - must be surrounded by whitespace.
+			if i == 0 || i == len(vals)-1 || tokenType(vals[i-1]) != WhitespaceToken || tokenType(vals[i+1]) != WhitespaceToken {
+				return calcValue{}, fmt.Errorf("Invalid %v in math function", t.Value)
+			}
+		}
+		v, err := c.product(trimWhitespace(vals[start:i]))
+		if err != nil {
+			return calcValue{}, err
+		}
+		if start == 0 {
+			ret = v
+		} else {
+			if v.Length != ret.Length {
+				return calcValue{}, fmt.Errorf("Can't add a number and a length")
+			}
+			if op == "+" {
+				ret.Value += v.Value
+			} else {
+				ret.Value -= v.Value
+			}
+		}
+		if i < len(vals) {
+			op = vals[i].(Token).Value
+			start = i + 1
+		}
+	}
+	return ret, nil
+}
+
+// product evaluates a calc product, which is made of values separated by *
+// or /.
+func (c calcContext) product(vals []ComponentValue) (calcValue, error) {
+	var ret calcValue
+	op := ""
+	expectValue := true
+	for _, v := range vals {
+		if tokenType(v) == WhitespaceToken {
+			continue
+		}
+		if !expectValue {
+			t, ok := v.(Token)
+			if !ok || t.Type != DelimToken || (t.Value != "*" && t.Value != "/") {
+				return calcValue{}, fmt.Errorf("Missing operator in math function")
+			}
+			op = t.Value
+			expectValue = true
+			continue
+		}
+		val, err := c.value(v)
+		if err != nil {
+			return calcValue{}, err
+		}
+		switch op {
+		case "":
+			ret = val
+		case "*":
+			if ret.Length && val.Length {
+				return calcValue{}, fmt.Errorf("Can't multiply two lengths")
+			}
+			ret = calcValue{ret.Value * val.Value, ret.Length || val.Length}
+		case "/":
+			if val.Length {
+				return calcValue{}, fmt.Errorf("Can't divide by a length")
+			}
+			ret.Value /= val.Value
+		}
+		expectValue = false
+	}
+	if expectValue {
+		return calcValue{}, fmt.Errorf("Missing value in math function")
+	}
+	return ret, nil
+}
+
+// value evaluates a single value in a math function.
+func (c calcContext) value(v ComponentValue) (calcValue, error) {
+	switch v := v.(type) {
+	case Token:
+		switch v.Type {
+		case NumberToken:
+			return calcValue{v.Number, false}, nil
+		case PercentageToken:
+			return calcValue{v.Number * float64(c.percentbasis) / 100, true}, nil
+		case DimensionToken:
+			if px, ok := lengthToPx(c.units, v.Number, strings.ToLower(v.Unit)); ok {
+				return calcValue{px, true}, nil
+			}
+			return calcValue{}, fmt.Errorf("Unsupported unit %v in math function", v.Unit)
+		case IdentToken:
+			switch strings.ToLower(v.Value) {
+			case "pi":
+				return calcValue{math.Pi, false}, nil
+			case "e":
+				return calcValue{math.E, false}, nil
+			}
+		}
+	case *SimpleBlock:
+		if v.Associated == OpenParenToken {
+			return c.sum(trimWhitespace(v.Value))
+		}
+	case *Function:
+		return c.function(v)
+	}
+	return calcValue{}, fmt.Errorf("Invalid value in math function: %v", v)
+}
@@ -0,0 +1,111 @@
+package css
+
+import (
+	"testing"
+)
+
+func TestConvertUnitToPx(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Expected int
+		Valid    bool
+	}{
+		{"0", 0, true},
+		{"12px", 12, true},
+		{"2em", 32, true},
+		{"2ex", 16, true},
+		{"1in", 96, true},
+		{"3pt", 4, true},
+		{"50%", 100, true},
+		{"12", 16, false},
+		{"12qq", 16, false},
+
+		{"calc(100% - 2em)", 168, true},
+		{"CALC(10px + 5px * 2)", 20, true},
+		{"calc((10px + 5px) * 2)", 30, true},
+		{"calc(2 * 1em / 4)", 8, true},
+		{"calc(1px + calc(2px * 3))", 7, true},
+		{"calc(10.4px)", 10, true},
+		{"calc(10.6px)", 11, true},
+		{"min(50%, 150px)", 100, true},
+		{"max(50%, 150px, 1in)", 150, true},
+		{"min(10px, max(2em, 1px))", 10, true},
+		{"clamp(10px, 50%, 60px)", 60, true},
+		{"clamp(10px, 1px, 60px)", 10, true},
+		// The minimum wins over the maximum.
+		{"clamp(70px, 50%, 60px)", 70, true},
+
+		// + and - need whitespace around them
+		{"calc(100%-2em)", 16, false},
+		{"calc(100% -2em)", 16, false},
+		{"calc(1px +2px)", 16, false},
+		// Type errors
+		{"calc(1px + 2)", 16, false},
+		{"calc(2)", 16, false},
+		{"calc(1px * 2px)", 16, false},
+		{"calc(1px / 2px)", 16, false},
+		{"calc(1px / 0)", 16, false},
+		{"min(1px, 2)", 16, false},
+		{"clamp(1px, 2px)", 16, false},
+		{"calc(1px, 2px)", 16, false},
+		{"calc()", 16, false},
+		{"calc(1px 2px)", 16, false},
+		{"calc(1px +)", 16, false},
+		{"calc(foo)", 16, false},
+	}
+	for _, tc := range tests {
+		got, err := ConvertUnitToPx(16, 200, tc.Value)
+		if (err == nil) != tc.Valid {
+			t.Errorf("Unexpected error for %q: %v", tc.Value, err)
+			continue
+		}
+		if got != tc.Expected {
+			t.Errorf("Unexpected value for %q: got %v want %v", tc.Value, got, tc.Expected)
+		}
+	}
+}
+
+func TestConvertLengthToPx(t *testing.T) {
+	u := UnitContext{
+		FontSize:       16,
+		RootFontSize:   20,
+		ViewportWidth:  800,
+		ViewportHeight: 600,
+		ZeroWidth:      func() float64 { return 9 },
+		LineHeight:     func() float64 { return 24 },
+	}
+	tests := []struct {
+		Units    UnitContext
+		Value    string
+		Expected int
+		Valid    bool
+	}{
+		{u, "2rem", 40, true},
+		{u, "2REM", 40, true},
+		{u, "10vw", 80, true},
+		{u, "10vh", 60, true},
+		{u, "10vmin", 60, true},
+		{u, "10vmax", 80, true},
+		{u, "2ch", 18, true},
+		{u, "2lh", 48, true},
+		{u, "calc(50vw - 1rem)", 380, true},
+		{u, "1.5em", 24, true},
+		{u, "vw", 16, false},
+		{u, "2vz", 16, false},
+		// Defaults when the context isn't known.
+		{UnitContext{FontSize: 16}, "1rem", DefaultFontSize, true},
+		{UnitContext{FontSize: 16}, "2ch", 16, true},
+		{UnitContext{FontSize: 10}, "2lh", 24, true},
+		{UnitContext{FontSize: 16}, "10vw", 0, true},
+	}
+	for _, tc := range tests {
+		got, err := ConvertLengthToPx(tc.Units, 200, tc.Value)
+		if (err == nil) != tc.Valid {
+			t.Errorf("Unexpected error for %q: %v", tc.Value, err)
+			continue
+		}
+		if got != tc.Expected {
+			t.Errorf("Unexpected value for %q: got %v want %v", tc.Value, got, tc.Expected)
+		}
+	}
+}
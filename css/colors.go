@@ -0,0 +1,440 @@
+package css
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// CurrentColor is returned when a colour is the currentColor keyword, which
+// means the value of the color property of the same element should be used.
+var CurrentColor = errors.New("Value is the current colour")
+
+// namedColors are the named colours from CSS Color Module Level 4, which
+// includes all of the X11 colours, not just the ones from CSS2.
+var namedColors = map[string]color.NRGBA{
+	"aliceblue":            color.NRGBA{0xf0, 0xf8, 0xff, 0xff},
+	"antiquewhite":         color.NRGBA{0xfa, 0xeb, 0xd7, 0xff},
+	"aqua":                 color.NRGBA{0x00, 0xff, 0xff, 0xff},
+	"aquamarine":           color.NRGBA{0x7f, 0xff, 0xd4, 0xff},
+	"azure":                color.NRGBA{0xf0, 0xff, 0xff, 0xff},
+	"beige":                color.NRGBA{0xf5, 0xf5, 0xdc, 0xff},
+	"bisque":               color.NRGBA{0xff, 0xe4, 0xc4, 0xff},
+	"black":                color.NRGBA{0x00, 0x00, 0x00, 0xff},
+	"blanchedalmond":       color.NRGBA{0xff, 0xeb, 0xcd, 0xff},
+	"blue":                 color.NRGBA{0x00, 0x00, 0xff, 0xff},
+	"blueviolet":           color.NRGBA{0x8a, 0x2b, 0xe2, 0xff},
+	"brown":                color.NRGBA{0xa5, 0x2a, 0x2a, 0xff},
+	"burlywood":            color.NRGBA{0xde, 0xb8, 0x87, 0xff},
+	"cadetblue":            color.NRGBA{0x5f, 0x9e, 0xa0, 0xff},
+	"chartreuse":           color.NRGBA{0x7f, 0xff, 0x00, 0xff},
+	"chocolate":            color.NRGBA{0xd2, 0x69, 0x1e, 0xff},
+	"coral":                color.NRGBA{0xff, 0x7f, 0x50, 0xff},
+	"cornflowerblue":       color.NRGBA{0x64, 0x95, 0xed, 0xff},
+	"cornsilk":             color.NRGBA{0xff, 0xf8, 0xdc, 0xff},
+	"crimson":              color.NRGBA{0xdc, 0x14, 0x3c, 0xff},
+	"cyan":                 color.NRGBA{0x00, 0xff, 0xff, 0xff},
+	"darkblue":             color.NRGBA{0x00, 0x00, 0x8b, 0xff},
+	"darkcyan":             color.NRGBA{0x00, 0x8b, 0x8b, 0xff},
+	"darkgoldenrod":        color.NRGBA{0xb8, 0x86, 0x0b, 0xff},
+	"darkgray":             color.NRGBA{0xa9, 0xa9, 0xa9, 0xff},
+	"darkgreen":            color.NRGBA{0x00, 0x64, 0x00, 0xff},
+	"darkgrey":             color.NRGBA{0xa9, 0xa9, 0xa9, 0xff},
+	"darkkhaki":            color.NRGBA{0xbd, 0xb7, 0x6b, 0xff},
+	"darkmagenta":          color.NRGBA{0x8b, 0x00, 0x8b, 0xff},
+	"darkolivegreen":       color.NRGBA{0x55, 0x6b, 0x2f, 0xff},
+	"darkorange":           color.NRGBA{0xff, 0x8c, 0x00, 0xff},
+	"darkorchid":           color.NRGBA{0x99, 0x32, 0xcc, 0xff},
+	"darkred":              color.NRGBA{0x8b, 0x00, 0x00, 0xff},
+	"darksalmon":           color.NRGBA{0xe9, 0x96, 0x7a, 0xff},
+	"darkseagreen":         color.NRGBA{0x8f, 0xbc, 0x8f, 0xff},
+	"darkslateblue":        color.NRGBA{0x48, 0x3d, 0x8b, 0xff},
+	"darkslategray":        color.NRGBA{0x2f, 0x4f, 0x4f, 0xff},
+	"darkslategrey":        color.NRGBA{0x2f, 0x4f, 0x4f, 0xff},
+	"darkturquoise":        color.NRGBA{0x00, 0xce, 0xd1, 0xff},
+	"darkviolet":           color.NRGBA{0x94, 0x00, 0xd3, 0xff},
+	"deeppink":             color.NRGBA{0xff, 0x14, 0x93, 0xff},
+	"deepskyblue":          color.NRGBA{0x00, 0xbf, 0xff, 0xff},
+	"dimgray":              color.NRGBA{0x69, 0x69, 0x69, 0xff},
+	"dimgrey":              color.NRGBA{0x69, 0x69, 0x69, 0xff},
+	"dodgerblue":           color.NRGBA{0x1e, 0x90, 0xff, 0xff},
+	"firebrick":            color.NRGBA{0xb2, 0x22, 0x22, 0xff},
+	"floralwhite":          color.NRGBA{0xff, 0xfa, 0xf0, 0xff},
+	"forestgreen":          color.NRGBA{0x22, 0x8b, 0x22, 0xff},
+	"fuchsia":              color.NRGBA{0xff, 0x00, 0xff, 0xff},
+	"gainsboro":            color.NRGBA{0xdc, 0xdc, 0xdc, 0xff},
+	"ghostwhite":           color.NRGBA{0xf8, 0xf8, 0xff, 0xff},
+	"gold":                 color.NRGBA{0xff, 0xd7, 0x00, 0xff},
+	"goldenrod":            color.NRGBA{0xda, 0xa5, 0x20, 0xff},
+	"gray":                 color.NRGBA{0x80, 0x80, 0x80, 0xff},
+	"green":                color.NRGBA{0x00, 0x80, 0x00, 0xff},
+	"greenyellow":          color.NRGBA{0xad, 0xff, 0x2f, 0xff},
+	"grey":                 color.NRGBA{0x80, 0x80, 0x80, 0xff},
+	"honeydew":             color.NRGBA{0xf0, 0xff, 0xf0, 0xff},
+	"hotpink":              color.NRGBA{0xff, 0x69, 0xb4, 0xff},
+	"indianred":            color.NRGBA{0xcd, 0x5c, 0x5c, 0xff},
+	"indigo":               color.NRGBA{0x4b, 0x00, 0x82, 0xff},
+	"ivory":                color.NRGBA{0xff, 0xff, 0xf0, 0xff},
+	"khaki":                color.NRGBA{0xf0, 0xe6, 0x8c, 0xff},
+	"lavender":             color.NRGBA{0xe6, 0xe6, 0xfa, 0xff},
+	"lavenderblush":        color.NRGBA{0xff, 0xf0, 0xf5, 0xff},
+	"lawngreen":            color.NRGBA{0x7c, 0xfc, 0x00, 0xff},
+	"lemonchiffon":         color.NRGBA{0xff, 0xfa, 0xcd, 0xff},
+	"lightblue":            color.NRGBA{0xad, 0xd8, 0xe6, 0xff},
+	"lightcoral":           color.NRGBA{0xf0, 0x80, 0x80, 0xff},
+	"lightcyan":            color.NRGBA{0xe0, 0xff, 0xff, 0xff},
+	"lightgoldenrodyellow": color.NRGBA{0xfa, 0xfa, 0xd2, 0xff},
+	"lightgray":            color.NRGBA{0xd3, 0xd3, 0xd3, 0xff},
+	"lightgreen":           color.NRGBA{0x90, 0xee, 0x90, 0xff},
+	"lightgrey":            color.NRGBA{0xd3, 0xd3, 0xd3, 0xff},
+	"lightpink":            color.NRGBA{0xff, 0xb6, 0xc1, 0xff},
+	"lightsalmon":          color.NRGBA{0xff, 0xa0, 0x7a, 0xff},
+	"lightseagreen":        color.NRGBA{0x20, 0xb2, 0xaa, 0xff},
+	"lightskyblue":         color.NRGBA{0x87, 0xce, 0xfa, 0xff},
+	"lightslategray":       color.NRGBA{0x77, 0x88, 0x99, 0xff},
+	"lightslategrey":       color.NRGBA{0x77, 0x88, 0x99, 0xff},
+	"lightsteelblue":       color.NRGBA{0xb0, 0xc4, 0xde, 0xff},
+	"lightyellow":          color.NRGBA{0xff, 0xff, 0xe0, 0xff},
+	"lime":                 color.NRGBA{0x00, 0xff, 0x00, 0xff},
+	"limegreen":            color.NRGBA{0x32, 0xcd, 0x32, 0xff},
+	"linen":                color.NRGBA{0xfa, 0xf0, 0xe6, 0xff},
+	"magenta":              color.NRGBA{0xff, 0x00, 0xff, 0xff},
+	"maroon":               color.NRGBA{0x80, 0x00, 0x00, 0xff},
+	"mediumaquamarine":     color.NRGBA{0x66, 0xcd, 0xaa, 0xff},
+	"mediumblue":           color.NRGBA{0x00, 0x00, 0xcd, 0xff},
+	"mediumorchid":         color.NRGBA{0xba, 0x55, 0xd3, 0xff},
+	"mediumpurple":         color.NRGBA{0x93, 0x70, 0xdb, 0xff},
+	"mediumseagreen":       color.NRGBA{0x3c, 0xb3, 0x71, 0xff},
+	"mediumslateblue":      color.NRGBA{0x7b, 0x68, 0xee, 0xff},
+	"mediumspringgreen":    color.NRGBA{0x00, 0xfa, 0x9a, 0xff},
+	"mediumturquoise":      color.NRGBA{0x48, 0xd1, 0xcc, 0xff},
+	"mediumvioletred":      color.NRGBA{0xc7, 0x15, 0x85, 0xff},
+	"midnightblue":         color.NRGBA{0x19, 0x19, 0x70, 0xff},
+	"mintcream":            color.NRGBA{0xf5, 0xff, 0xfa, 0xff},
+	"mistyrose":            color.NRGBA{0xff, 0xe4, 0xe1, 0xff},
+	"moccasin":             color.NRGBA{0xff, 0xe4, 0xb5, 0xff},
+	"navajowhite":          color.NRGBA{0xff, 0xde, 0xad, 0xff},
+	"navy":                 color.NRGBA{0x00, 0x00, 0x80, 0xff},
+	"oldlace":              color.NRGBA{0xfd, 0xf5, 0xe6, 0xff},
+	"olive":                color.NRGBA{0x80, 0x80, 0x00, 0xff},
+	"olivedrab":            color.NRGBA{0x6b, 0x8e, 0x23, 0xff},
+	"orange":               color.NRGBA{0xff, 0xa5, 0x00, 0xff},
+	"orangered":            color.NRGBA{0xff, 0x45, 0x00, 0xff},
+	"orchid":               color.NRGBA{0xda, 0x70, 0xd6, 0xff},
+	"palegoldenrod":        color.NRGBA{0xee, 0xe8, 0xaa, 0xff},
+	"palegreen":            color.NRGBA{0x98, 0xfb, 0x98, 0xff},
+	"paleturquoise":        color.NRGBA{0xaf, 0xee, 0xee, 0xff},
+	"palevioletred":        color.NRGBA{0xdb, 0x70, 0x93, 0xff},
+	"papayawhip":           color.NRGBA{0xff, 0xef, 0xd5, 0xff},
+	"peachpuff":            color.NRGBA{0xff, 0xda, 0xb9, 0xff},
+	"peru":                 color.NRGBA{0xcd, 0x85, 0x3f, 0xff},
+	"pink":                 color.NRGBA{0xff, 0xc0, 0xcb, 0xff},
+	"plum":                 color.NRGBA{0xdd, 0xa0, 0xdd, 0xff},
+	"powderblue":           color.NRGBA{0xb0, 0xe0, 0xe6, 0xff},
+	"purple":               color.NRGBA{0x80, 0x00, 0x80, 0xff},
+	"rebeccapurple":        color.NRGBA{0x66, 0x33, 0x99, 0xff},
+	"red":                  color.NRGBA{0xff, 0x00, 0x00, 0xff},
+	"rosybrown":            color.NRGBA{0xbc, 0x8f, 0x8f, 0xff},
+	"royalblue":            color.NRGBA{0x41, 0x69, 0xe1, 0xff},
+	"saddlebrown":          color.NRGBA{0x8b, 0x45, 0x13, 0xff},
+	"salmon":               color.NRGBA{0xfa, 0x80, 0x72, 0xff},
+	"sandybrown":           color.NRGBA{0xf4, 0xa4, 0x60, 0xff},
+	"seagreen":             color.NRGBA{0x2e, 0x8b, 0x57, 0xff},
+	"seashell":             color.NRGBA{0xff, 0xf5, 0xee, 0xff},
+	"sienna":               color.NRGBA{0xa0, 0x52, 0x2d, 0xff},
+	"silver":               color.NRGBA{0xc0, 0xc0, 0xc0, 0xff},
+	"skyblue":              color.NRGBA{0x87, 0xce, 0xeb, 0xff},
+	"slateblue":            color.NRGBA{0x6a, 0x5a, 0xcd, 0xff},
+	"slategray":            color.NRGBA{0x70, 0x80, 0x90, 0xff},
+	"slategrey":            color.NRGBA{0x70, 0x80, 0x90, 0xff},
+	"snow":                 color.NRGBA{0xff, 0xfa, 0xfa, 0xff},
+	"springgreen":          color.NRGBA{0x00, 0xff, 0x7f, 0xff},
+	"steelblue":            color.NRGBA{0x46, 0x82, 0xb4, 0xff},
+	"tan":                  color.NRGBA{0xd2, 0xb4, 0x8c, 0xff},
+	"teal":                 color.NRGBA{0x00, 0x80, 0x80, 0xff},
+	"thistle":              color.NRGBA{0xd8, 0xbf, 0xd8, 0xff},
+	"tomato":               color.NRGBA{0xff, 0x63, 0x47, 0xff},
+	"turquoise":            color.NRGBA{0x40, 0xe0, 0xd0, 0xff},
+	"violet":               color.NRGBA{0xee, 0x82, 0xee, 0xff},
+	"wheat":                color.NRGBA{0xf5, 0xde, 0xb3, 0xff},
+	"white":                color.NRGBA{0xff, 0xff, 0xff, 0xff},
+	"whitesmoke":           color.NRGBA{0xf5, 0xf5, 0xf5, 0xff},
+	"yellow":               color.NRGBA{0xff, 0xff, 0x00, 0xff},
+	"yellowgreen":          color.NRGBA{0x9a, 0xcd, 0x32, 0xff},
+}
+
+// ConvertColorToRGBA converts the CSS colour in cssString to an (alpha
+// premultiplied) RGBA colour. It supports named colours, hex colours with
+// 3, 4, 6 or 8 digits, and the rgb(), rgba(), hsl() and hsla() functions
+// using either the legacy comma separated syntax or the space separated one.
+//
+// If the value is inherit or currentColor, InheritValue or CurrentColor is
+// returned, respectively, along with black.
+func ConvertColorToRGBA(cssString string) (*color.RGBA, error) {
+	black := &color.RGBA{0, 0, 0, 255}
+	c, err := parseColor(cssString)
+	if err != nil {
+		return black, err
+	}
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	return &rgba, nil
+}
+
+// IsColor reports whether c is a valid CSS colour value (or inherit.)
+func IsColor(c string) bool {
+	_, err := parseColor(c)
+	return err == nil || err == InheritValue || err == CurrentColor
+}
+
+// parseColor parses the colour in cssString. The returned colour is not
+// alpha premultiplied.
+func parseColor(cssString string) (color.NRGBA, error) {
+	vals := trimWhitespace(ParseComponentValues(cssString))
+	if len(vals) != 1 {
+		if len(vals) == 0 {
+			return color.NRGBA{}, NoStyles
+		}
+		return color.NRGBA{}, fmt.Errorf("Invalid colour: %v", cssString)
+	}
+	switch v := vals[0].(type) {
+	case Token:
+		switch v.Type {
+		case HashToken:
+			if c, ok := parseHexColor(v.Value); ok {
+				return c, nil
+			}
+		case IdentToken:
+			name := strings.ToLower(v.Value)
+			switch name {
+			case "inherit":
+				return color.NRGBA{0, 0, 0, 255}, InheritValue
+			case "currentcolor":
+				return color.NRGBA{0, 0, 0, 255}, CurrentColor
+			case "transparent":
+				return color.NRGBA{0, 0, 0, 0}, nil
+			}
+			if c, ok := namedColors[name]; ok {
+				return c, nil
+			}
+			return color.NRGBA{}, NoStyles
+		}
+	case *Function:
+		switch strings.ToLower(v.Name) {
+		case "rgb", "rgba":
+			if c, ok := parseRGBFunction(v.Value); ok {
+				return c, nil
+			}
+		case "hsl", "hsla":
+			if c, ok := parseHSLFunction(v.Value); ok {
+				return c, nil
+			}
+		}
+	}
+	return color.NRGBA{}, fmt.Errorf("Invalid colour: %v", cssString)
+}
+
+// parseHexColor parses the digits of a hex colour (without the #).
+func parseHexColor(digits string) (color.NRGBA, bool) {
+	for _, c := range digits {
+		if !isHexDigit(c) {
+			return color.NRGBA{}, false
+		}
+	}
+	switch len(digits) {
+	case 3:
+		// #RGB
+		return color.NRGBA{sHexToUint8(digits[0]), sHexToUint8(digits[1]), sHexToUint8(digits[2]), 255}, true
+	case 4:
+		// #RGBA
+		return color.NRGBA{sHexToUint8(digits[0]), sHexToUint8(digits[1]), sHexToUint8(digits[2]), sHexToUint8(digits[3])}, true
+	case 6:
+		// #RRGGBB
+		return color.NRGBA{hexToUint8(digits[0:2]), hexToUint8(digits[2:4]), hexToUint8(digits[4:6]), 255}, true
+	case 8:
+		// #RRGGBBAA
+		return color.NRGBA{hexToUint8(digits[0:2]), hexToUint8(digits[2:4]), hexToUint8(digits[4:6]), hexToUint8(digits[6:8])}, true
+	}
+	return color.NRGBA{}, false
+}
+
+// colorArgs splits the arguments of a colour function into its components
+// and alpha value. Both the legacy syntax, where every argument is separated
+// by a comma, and the modern syntax, where the components are separated by
+// whitespace and the alpha by a "/", are supported. legacy is true if the
+// arguments used commas. alpha is nil if it wasn't specified.
+func colorArgs(vals []ComponentValue) (components []Token, alpha *Token, legacy bool, ok bool) {
+	var args []Token
+	slash := -1
+	for _, v := range vals {
+		t, isToken := v.(Token)
+		if !isToken {
+			return nil, nil, false, false
+		}
+		switch {
+		case t.Type == WhitespaceToken:
+		case t.Type == CommaToken:
+			legacy = true
+			args = append(args, t)
+		case t.isDelim("/"):
+			if slash >= 0 {
+				return nil, nil, false, false
+			}
+			slash = len(args)
+			args = append(args, t)
+		default:
+			args = append(args, t)
+		}
+	}
+	if legacy {
+		// Values must alternate with commas.
+		if slash >= 0 || len(args)%2 == 0 {
+			return nil, nil, false, false
+		}
+		for i, t := range args {
+			if (i%2 == 1) != (t.Type == CommaToken) {
+				return nil, nil, false, false
+			}
+			if i%2 == 0 {
+				components = append(components, t)
+			}
+		}
+		if len(components) == 4 {
+			alpha, components = &components[3], components[:3]
+		}
+		return components, alpha, true, len(components) == 3
+	}
+	if slash >= 0 {
+		if slash != len(args)-2 {
+			return nil, nil, false, false
+		}
+		alpha = &args[len(args)-1]
+		args = args[:slash]
+	}
+	return args, alpha, false, len(args) == 3
+}
+
+// parseAlpha converts the alpha value of a colour function, which is either
+// a number between 0 and 1 or a percentage, to a uint8.
+func parseAlpha(t *Token, legacy bool) (uint8, bool) {
+	if t == nil {
+		return 255, true
+	}
+	switch {
+	case t.Type == NumberToken:
+		return clampToUint8(t.Number * 255), true
+	case t.Type == PercentageToken:
+		return clampToUint8(t.Number * 255 / 100), true
+	case !legacy && t.isIdent("none"):
+		return 0, true
+	}
+	return 0, false
+}
+
+// clampToUint8 rounds f to the nearest integer and clamps it to the range
+// of a uint8.
+func clampToUint8(f float64) uint8 {
+	f = math.Round(f)
+	if f < 0 {
+		return 0
+	} else if f > 255 {
+		return 255
+	}
+	return uint8(f)
+}
+
+// parseRGBFunction parses the arguments of an rgb() or rgba() function.
+func parseRGBFunction(vals []ComponentValue) (color.NRGBA, bool) {
+	components, alpha, legacy, ok := colorArgs(vals)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	var rgb [3]uint8
+	for i, t := range components {
+		if legacy && t.Type != components[0].Type {
+			// The legacy syntax can't mix numbers and percentages.
+			return color.NRGBA{}, false
+		}
+		switch {
+		case t.Type == NumberToken:
+			rgb[i] = clampToUint8(t.Number)
+		case t.Type == PercentageToken:
+			rgb[i] = clampToUint8(t.Number * 255 / 100)
+		case !legacy && t.isIdent("none"):
+			rgb[i] = 0
+		default:
+			return color.NRGBA{}, false
+		}
+	}
+	a, ok := parseAlpha(alpha, legacy)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{rgb[0], rgb[1], rgb[2], a}, true
+}
+
+// parseHSLFunction parses the arguments of an hsl() or hsla() function.
+func parseHSLFunction(vals []ComponentValue) (color.NRGBA, bool) {
+	components, alpha, legacy, ok := colorArgs(vals)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	var h float64
+	switch t := components[0]; {
+	case t.Type == NumberToken:
+		h = t.Number
+	case t.Type == DimensionToken:
+		switch strings.ToLower(t.Unit) {
+		case "deg":
+			h = t.Number
+		case "grad":
+			h = t.Number * 360 / 400
+		case "rad":
+			h = t.Number * 180 / math.Pi
+		case "turn":
+			h = t.Number * 360
+		default:
+			return color.NRGBA{}, false
+		}
+	case !legacy && t.isIdent("none"):
+	default:
+		return color.NRGBA{}, false
+	}
+
+	var sl [2]float64
+	for i, t := range components[1:] {
+		switch {
+		case t.Type == PercentageToken:
+			sl[i] = t.Number / 100
+		case !legacy && t.Type == NumberToken:
+			sl[i] = t.Number / 100
+		case !legacy && t.isIdent("none"):
+		default:
+			return color.NRGBA{}, false
+		}
+		sl[i] = math.Max(0, math.Min(1, sl[i]))
+	}
+	a, ok := parseAlpha(alpha, legacy)
+	if !ok {
+		return color.NRGBA{}, false
+	}
+	r, g, b := hslToRGB(h, sl[0], sl[1])
+	return color.NRGBA{clampToUint8(r * 255), clampToUint8(g * 255), clampToUint8(b * 255), a}, true
+}
+
+// hslToRGB converts a hue in degrees, and saturation and lightness between
+// 0 and 1 to red, green and blue components between 0 and 1, using the
+// algorithm from CSS Color Module Level 4.
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	f := func(n float64) float64 {
+		k := math.Mod(n+h/30, 12)
+		a := s * math.Min(l, 1-l)
+		return l - a*math.Max(-1, math.Min(k-3, math.Min(9-k, 1)))
+	}
+	return f(0), f(8), f(4)
+}
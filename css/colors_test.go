@@ -0,0 +1,97 @@
+package css
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestConvertColorToRGBA(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Expected color.NRGBA
+		Err      error
+	}{
+		{"red", color.NRGBA{255, 0, 0, 255}, nil},
+		{"RebeccaPurple", color.NRGBA{0x66, 0x33, 0x99, 255}, nil},
+		{"lightgoldenrodyellow", color.NRGBA{0xfa, 0xfa, 0xd2, 255}, nil},
+		{"transparent", color.NRGBA{0, 0, 0, 0}, nil},
+		{"#0f0", color.NRGBA{0, 255, 0, 255}, nil},
+		{"#0f08", color.NRGBA{0, 255, 0, 0x88}, nil},
+		{"#00FF00", color.NRGBA{0, 255, 0, 255}, nil},
+		{"#00ff0080", color.NRGBA{0, 255, 0, 0x80}, nil},
+		{"rgb(1, 2, 3)", color.NRGBA{1, 2, 3, 255}, nil},
+		{"rgb(100%, 50%, 0%)", color.NRGBA{255, 128, 0, 255}, nil},
+		{"rgb(300, -20, 3)", color.NRGBA{255, 0, 3, 255}, nil},
+		{"rgba(255, 0, 0, 0.5)", color.NRGBA{255, 0, 0, 128}, nil},
+		{"rgb(255, 0, 0, 25%)", color.NRGBA{255, 0, 0, 64}, nil},
+		{"rgb(255 0 0)", color.NRGBA{255, 0, 0, 255}, nil},
+		{"rgba(255 0 0 / 0.5)", color.NRGBA{255, 0, 0, 128}, nil},
+		{"rgb(100% 0 none / 50%)", color.NRGBA{255, 0, 0, 128}, nil},
+		{"hsl(120, 100%, 50%)", color.NRGBA{0, 255, 0, 255}, nil},
+		{"hsl(0.5turn 100% 50%)", color.NRGBA{0, 255, 255, 255}, nil},
+		{"hsla(-120deg, 100%, 25%, 0.5)", color.NRGBA{0, 0, 128, 128}, nil},
+		{"hsl(270 60 70 / 1)", color.NRGBA{0xb3, 0x85, 0xe0, 255}, nil},
+
+		{"inherit", color.NRGBA{0, 0, 0, 255}, InheritValue},
+		{"currentColor", color.NRGBA{0, 0, 0, 255}, CurrentColor},
+		{"", color.NRGBA{0, 0, 0, 255}, NoStyles},
+		{"notacolour", color.NRGBA{0, 0, 0, 255}, NoStyles},
+	}
+	for i, tc := range tests {
+		c, err := ConvertColorToRGBA(tc.Value)
+		if err != tc.Err {
+			t.Errorf("Case %d (%v): got error %v want %v", i, tc.Value, err, tc.Err)
+			continue
+		}
+		if expected := color.RGBAModel.Convert(tc.Expected).(color.RGBA); *c != expected {
+			t.Errorf("Case %d (%v): got %v want %v", i, tc.Value, *c, expected)
+		}
+	}
+
+	// Invalid colours
+	for _, val := range []string{
+		"#12", "#12345", "#ggg",
+		"rgb(1, 2)", "rgb(1, 2, 3, 4, 5)", "rgb(1 2, 3)", "rgb(1, 2%, 3)",
+		"rgb(1, 2, 3 / 0.5)", "rgb(1 2 3 / )", "rgb(1, 2, none)",
+		"hsl(120, 100, 50)", "hsl(120px 100% 50%)", "red blue",
+	} {
+		if _, err := ConvertColorToRGBA(val); err == nil || err == NoStyles {
+			t.Errorf("%v: expected an invalid colour error, got %v", val, err)
+		}
+		if IsColor(val) {
+			t.Errorf("%v: IsColor returned true for invalid colour", val)
+		}
+	}
+}
+
+func TestNamedColorCount(t *testing.T) {
+	if len(namedColors) != 148 {
+		t.Errorf("Unexpected number of named colours. got %v want 148", len(namedColors))
+	}
+}
+
+func TestColorShorthands(t *testing.T) {
+	var e StyledElement
+	e.AddStyle(StyleRule{
+		Selector: CSSSelector{"div", 0},
+		Name:     "background",
+		Value:    StyleValue{"rgba(0, 0, 255, 0.5) no-repeat", false},
+		Src:      AuthorSrc,
+	})
+	e.AddStyle(StyleRule{
+		Selector: CSSSelector{"div", 1},
+		Name:     "border",
+		Value:    StyleValue{"1px solid hsl(0 100% 50% / 50%)", false},
+		Src:      AuthorSrc,
+	})
+	e.SortStyles()
+	if got := e.BackgroundColor.Value; got != "rgba(0, 0, 255, 0.5)" {
+		t.Errorf("Unexpected background-color. got %v", got)
+	}
+	if got := e.BackgroundRepeat.Value; got != "no-repeat" {
+		t.Errorf("Unexpected background-repeat. got %v", got)
+	}
+	if got := e.BorderLeftColor.Value; got != "hsl(0 100% 50% / 50%)" {
+		t.Errorf("Unexpected border-left-color. got %v", got)
+	}
+}
@@ -0,0 +1,565 @@
+package css
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// A LengthType is the kind of value that a computed Length holds.
+type LengthType uint8
+
+const (
+	// The length is an absolute number of px.
+	LengthPx LengthType = iota
+	// The length is a percentage of a value that's only known during
+	// layout, such as the width of the containing block.
+	LengthPercent
+	// The length depends on values that are only known during layout,
+	// such as a math function with percentages, or a viewport-relative
+	// unit. It's evaluated when it's resolved.
+	LengthCalc
+	LengthAuto
+	LengthNone
+)
+
+// A Length is the computed value of a property that takes a length.
+// Everything that can be resolved after the cascade has already been
+// converted to px.
+type Length struct {
+	Type LengthType
+
+	// The number of px for LengthPx, or the percentage for LengthPercent.
+	Value float64
+
+	// The original value for LengthCalc.
+	expr string
+}
+
+// Px resolves the length to px. Percentages are resolved against
+// percentbasis, and units is called for the current unit context if the
+// length still depends on it. Keywords such as auto resolve to 0.
+func (l Length) Px(percentbasis int, units func() UnitContext) int {
+	switch l.Type {
+	case LengthPx:
+		return int(l.Value)
+	case LengthPercent:
+		return int(math.Round(l.Value * float64(percentbasis) / 100))
+	case LengthCalc:
+		px, err := ConvertLengthToPx(units(), percentbasis, l.expr)
+		if err != nil {
+			return 0
+		}
+		return px
+	}
+	return 0
+}
+
+// String returns the length as it's written in CSS.
+func (l Length) String() string {
+	switch l.Type {
+	case LengthPx:
+		return fmt.Sprintf("%vpx", l.Value)
+	case LengthPercent:
+		return fmt.Sprintf("%v%%", l.Value)
+	case LengthCalc:
+		return l.expr
+	case LengthAuto:
+		return "auto"
+	}
+	return "none"
+}
+
+// Enumerated properties are represented by their keyword, so that values
+// which aren't listed here still round trip.
+type (
+	Display           string
+	Float             string
+	Clear             string
+	WhiteSpace        string
+	Overflow          string
+	VerticalAlign     string
+	TextTransform     string
+	ListStylePosition string
+	BackgroundRepeat  string
+	BorderStyle       string
+	FontVariant       string
+)
+
+const (
+	DisplayInline      Display = "inline"
+	DisplayBlock       Display = "block"
+	DisplayInlineBlock Display = "inline-block"
+	DisplayListItem    Display = "list-item"
+	DisplayTable       Display = "table"
+	DisplayNone        Display = "none"
+
+	FloatNone  Float = "none"
+	FloatLeft  Float = "left"
+	FloatRight Float = "right"
+
+	ClearNone  Clear = "none"
+	ClearLeft  Clear = "left"
+	ClearRight Clear = "right"
+	ClearBoth  Clear = "both"
+
+	WhiteSpaceNormal  WhiteSpace = "normal"
+	WhiteSpacePre     WhiteSpace = "pre"
+	WhiteSpaceNowrap  WhiteSpace = "nowrap"
+	WhiteSpacePreWrap WhiteSpace = "pre-wrap"
+	WhiteSpacePreLine WhiteSpace = "pre-line"
+
+	OverflowVisible Overflow = "visible"
+	OverflowHidden  Overflow = "hidden"
+	OverflowClip    Overflow = "clip"
+	OverflowScroll  Overflow = "scroll"
+	OverflowAuto    Overflow = "auto"
+
+	VerticalAlignBaseline   VerticalAlign = "baseline"
+	VerticalAlignSub        VerticalAlign = "sub"
+	VerticalAlignSuper      VerticalAlign = "super"
+	VerticalAlignTop        VerticalAlign = "top"
+	VerticalAlignTextTop    VerticalAlign = "text-top"
+	VerticalAlignMiddle     VerticalAlign = "middle"
+	VerticalAlignBottom     VerticalAlign = "bottom"
+	VerticalAlignTextBottom VerticalAlign = "text-bottom"
+
+	TextTransformNone       TextTransform = "none"
+	TextTransformCapitalize TextTransform = "capitalize"
+	TextTransformUppercase  TextTransform = "uppercase"
+	TextTransformLowercase  TextTransform = "lowercase"
+
+	ListStylePositionOutside ListStylePosition = "outside"
+	ListStylePositionInside  ListStylePosition = "inside"
+
+	BackgroundRepeatRepeat   BackgroundRepeat = "repeat"
+	BackgroundRepeatRepeatX  BackgroundRepeat = "repeat-x"
+	BackgroundRepeatRepeatY  BackgroundRepeat = "repeat-y"
+	BackgroundRepeatNoRepeat BackgroundRepeat = "no-repeat"
+
+	BorderStyleNone   BorderStyle = "none"
+	BorderStyleHidden BorderStyle = "hidden"
+	BorderStyleDotted BorderStyle = "dotted"
+	BorderStyleDashed BorderStyle = "dashed"
+	BorderStyleSolid  BorderStyle = "solid"
+	BorderStyleDouble BorderStyle = "double"
+	BorderStyleGroove BorderStyle = "groove"
+	BorderStyleRidge  BorderStyle = "ridge"
+	BorderStyleInset  BorderStyle = "inset"
+	BorderStyleOutset BorderStyle = "outset"
+
+	FontVariantNormal    FontVariant = "normal"
+	FontVariantSmallCaps FontVariant = "small-caps"
+)
+
+// A Border is the computed value of one side of an element's border.
+type Border struct {
+	// The width of the border. It's 0 if the style is none or hidden.
+	Width Length
+	Style BorderStyle
+	Color color.RGBA
+}
+
+// A ComputedStyle holds the typed values of an element's properties after
+// the cascade, so that they don't need to be parsed again every time that
+// they're used.
+type ComputedStyle struct {
+	Color           color.RGBA
+	BackgroundColor color.RGBA
+
+	FontFamily  FontFamily
+	FontSize    int
+	FontWeight  font.Weight
+	FontStyle   font.Style
+	FontStretch FontStretch
+	FontVariant FontVariant
+
+	Display           Display
+	Float             Float
+	Clear             Clear
+	WhiteSpace        WhiteSpace
+	OverflowX         Overflow
+	OverflowY         Overflow
+	VerticalAlign     VerticalAlign
+	TextTransform     TextTransform
+	ListStylePosition ListStylePosition
+	BackgroundRepeat  BackgroundRepeat
+
+	TextIndent Length
+
+	Width, Height       Length
+	MinWidth, MinHeight Length
+	MaxWidth, MaxHeight Length
+
+	MarginTop, MarginRight, MarginBottom, MarginLeft     Length
+	PaddingTop, PaddingRight, PaddingBottom, PaddingLeft Length
+
+	BorderTop, BorderRight, BorderBottom, BorderLeft Border
+}
+
+// value returns the computed value of the inherited property name, as it's
+// written in CSS, or false if it's not in the computed style.
+func (s *ComputedStyle) value(name StyleAttribute) (string, bool) {
+	switch name {
+	case "font-family":
+		return string(s.FontFamily), true
+	case "font-size":
+		return fmt.Sprintf("%dpx", s.FontSize), true
+	case "font-weight":
+		return strconv.Itoa(cssWeight(s.FontWeight)), true
+	case "font-style":
+		switch s.FontStyle {
+		case font.StyleItalic:
+			return "italic", true
+		case font.StyleOblique:
+			return "oblique", true
+		}
+		return "normal", true
+	case "font-stretch":
+		return fmt.Sprintf("%v%%", float64(s.FontStretch)), true
+	case "font-variant":
+		return string(s.FontVariant), true
+	case "color":
+		c := color.NRGBAModel.Convert(s.Color).(color.NRGBA)
+		return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A), true
+	case "white-space":
+		return string(s.WhiteSpace), true
+	case "text-transform":
+		return string(s.TextTransform), true
+	case "list-style-position":
+		return string(s.ListStylePosition), true
+	case "text-indent":
+		return s.TextIndent.String(), true
+	}
+	return "", false
+}
+
+// ComputedStyle returns the computed style of e, or nil if ComputeStyle
+// hasn't been called since the styles were sorted.
+func (e *StyledElement) ComputedStyle() *ComputedStyle {
+	if !e.hasComputedStyle {
+		return nil
+	}
+	return &e.computedStyle
+}
+
+// SameStyle reports whether e and o have the same computed style and the
+// same values for the rest of their properties, so that elements with them
+// are rendered the same way, regardless of which rules the values came from.
+func (e *StyledElement) SameStyle(o *StyledElement) bool {
+	if e.hasComputedStyle != o.hasComputedStyle || e.computedStyle != o.computedStyle {
+		return false
+	}
+	ev, ov := e.propertyValues(), o.propertyValues()
+	if len(ev) != len(ov) {
+		return false
+	}
+	for name, v := range ev {
+		if ov[name] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// computedProperties are the properties whose values are in ComputedStyle.
+var computedProperties = map[StyleAttribute]bool{
+	"font-family": true, "font-style": true, "font-variant": true,
+	"font-weight": true, "font-stretch": true, "font-size": true,
+
+	"color": true, "background-color": true, "background-repeat": true,
+
+	"display": true, "float": true, "clear": true, "white-space": true,
+	"overflow-x": true, "overflow-y": true, "vertical-align": true,
+	"text-transform": true, "list-style-position": true, "text-indent": true,
+
+	"width": true, "height": true, "min-width": true, "min-height": true,
+	"max-width": true, "max-height": true,
+
+	"margin-top": true, "margin-right": true, "margin-bottom": true, "margin-left": true,
+	"padding-top": true, "padding-right": true, "padding-bottom": true, "padding-left": true,
+
+	"border-top-width": true, "border-top-style": true, "border-top-color": true,
+	"border-right-width": true, "border-right-style": true, "border-right-color": true,
+	"border-bottom-width": true, "border-bottom-style": true, "border-bottom-color": true,
+	"border-left-width": true, "border-left-style": true, "border-left-color": true,
+}
+
+// propertyValues returns the value of each property that's set on e, other
+// than custom properties and the ones in its computed style.
+func (e *StyledElement) propertyValues() map[StyleAttribute]string {
+	vals := make(map[StyleAttribute]string, len(e.computed))
+	for _, rule := range e.computed {
+		if computedProperties[rule.Name] || isCustomProperty(rule.Name) {
+			continue
+		}
+		if _, ok := vals[rule.Name]; !ok {
+			vals[rule.Name] = rule.Value.Value
+		}
+	}
+	return vals
+}
+
+// ComputeStyle works out the typed values of e's properties. Inherited
+// properties which aren't specified come from parent, which is nil for the
+// root element. Relative lengths are resolved against u, except for the
+// ones that depend on the layout. The font size must already be set.
+func (e *StyledElement) ComputeStyle(parent *ComputedStyle, u UnitContext) {
+	if parent == nil {
+		parent = &ComputedStyle{
+			Color:             color.RGBA{0, 0, 0, 0xff},
+			FontFamily:        "sans-serif",
+			FontSize:          DefaultFontSize,
+			FontWeight:        font.WeightNormal,
+			FontStyle:         font.StyleNormal,
+			FontStretch:       FontStretchNormal,
+			FontVariant:       FontVariantNormal,
+			WhiteSpace:        WhiteSpaceNormal,
+			TextTransform:     TextTransformNone,
+			ListStylePosition: ListStylePositionOutside,
+		}
+	}
+	s := &e.computedStyle
+	*s = ComputedStyle{}
+	e.units = u
+
+	// The font and colour are computed first, and the style is marked
+	// as computed, since resolving some units (such as ch) needs them.
+	s.FontSize = parent.FontSize
+	if size, err := e.GetFontSize(); err == nil {
+		s.FontSize = size
+	}
+	s.FontFamily = computeFontFamily(e.FontFamily.Value, parent.FontFamily)
+	s.FontWeight = computeFontWeight(e.FontWeight.Value, parent.FontWeight)
+	s.FontStyle = computeFontStyle(e.FontStyle.Value, parent.FontStyle)
+	s.FontStretch = computeFontStretch(e.FontStretch.Value, parent.FontStretch)
+	s.FontVariant = FontVariantNormal
+	if strings.EqualFold(e.FontVariant.Value, "small-caps") {
+		s.FontVariant = FontVariantSmallCaps
+	}
+
+	s.Color = parent.Color
+	if c, err := ConvertColorToRGBA(e.Color.Value); err == nil {
+		s.Color = *c
+	}
+	s.BackgroundColor = computeColor(e.BackgroundColor.Value, color.RGBA{}, s.Color)
+	e.hasComputedStyle = true
+
+	s.Display = Display(strings.ToLower(strings.TrimSpace(e.Display.Value)))
+	if s.Display == "" {
+		s.Display = DisplayInline
+	}
+	s.Float = Float(keyword(e.Float.Value, "none", "left", "right"))
+	s.Clear = Clear(keyword(e.Clear.Value, "none", "left", "right", "both"))
+	s.WhiteSpace = WhiteSpace(keyword(e.WhiteSpace.Value, string(parent.WhiteSpace),
+		"normal", "pre", "nowrap", "pre-wrap", "pre-line"))
+	s.OverflowX = Overflow(keyword(e.OverflowX.Value, "visible", "hidden", "clip", "scroll", "auto"))
+	s.OverflowY = Overflow(keyword(e.OverflowY.Value, "visible", "hidden", "clip", "scroll", "auto"))
+	s.VerticalAlign = VerticalAlign(keyword(e.VerticalAlign.Value, "baseline",
+		"sub", "super", "top", "text-top", "middle", "bottom", "text-bottom"))
+	s.TextTransform = TextTransform(keyword(e.TextTransform.Value, string(parent.TextTransform),
+		"none", "capitalize", "uppercase", "lowercase"))
+	s.ListStylePosition = ListStylePosition(keyword(e.ListStylePosition.Value, string(parent.ListStylePosition),
+		"outside", "inside"))
+	s.BackgroundRepeat = BackgroundRepeat(keyword(e.BackgroundRepeat.Value, "repeat",
+		"repeat-x", "repeat-y", "no-repeat"))
+
+	zero := Length{Type: LengthPx}
+	auto := Length{Type: LengthAuto}
+	none := Length{Type: LengthNone}
+	s.TextIndent = parent.TextIndent
+	if e.TextIndent.Value != "" {
+		s.TextIndent = computeLength(e.TextIndent.Value, zero, u, false)
+	}
+	s.Width = computeLength(e.Width.Value, auto, u, true)
+	s.Height = computeLength(e.Height.Value, auto, u, true)
+	s.MinWidth = computeLength(e.MinWidth.Value, zero, u, false)
+	s.MinHeight = computeLength(e.MinHeight.Value, zero, u, false)
+	s.MaxWidth = computeLength(e.MaxWidth.Value, none, u, false)
+	s.MaxHeight = computeLength(e.MaxHeight.Value, none, u, false)
+
+	s.MarginTop = computeLength(e.MarginTop.Value, zero, u, true)
+	s.MarginRight = computeLength(e.MarginRight.Value, zero, u, true)
+	s.MarginBottom = computeLength(e.MarginBottom.Value, zero, u, true)
+	s.MarginLeft = computeLength(e.MarginLeft.Value, zero, u, true)
+	s.PaddingTop = computeLength(e.PaddingTop.Value, zero, u, false)
+	s.PaddingRight = computeLength(e.PaddingRight.Value, zero, u, false)
+	s.PaddingBottom = computeLength(e.PaddingBottom.Value, zero, u, false)
+	s.PaddingLeft = computeLength(e.PaddingLeft.Value, zero, u, false)
+
+	s.BorderTop = computeBorder(e.BorderTopWidth.Value, e.BorderTopStyle.Value, e.BorderTopColor.Value, s.Color, u)
+	s.BorderRight = computeBorder(e.BorderRightWidth.Value, e.BorderRightStyle.Value, e.BorderRightColor.Value, s.Color, u)
+	s.BorderBottom = computeBorder(e.BorderBottomWidth.Value, e.BorderBottomStyle.Value, e.BorderBottomColor.Value, s.Color, u)
+	s.BorderLeft = computeBorder(e.BorderLeftWidth.Value, e.BorderLeftStyle.Value, e.BorderLeftColor.Value, s.Color, u)
+}
+
+// keyword returns val if it's one of the keywords in valid, compared
+// case-insensitively, and dflt otherwise.
+func keyword(val string, dflt string, valid ...string) string {
+	val = strings.ToLower(strings.TrimSpace(val))
+	for _, v := range valid {
+		if v == val {
+			return v
+		}
+	}
+	return dflt
+}
+
+// computeColor returns the colour val, or dflt if it isn't valid. currentColor
+// is the value of the color property, current.
+func computeColor(val string, dflt, current color.RGBA) color.RGBA {
+	switch c, err := ConvertColorToRGBA(val); err {
+	case nil:
+		return *c
+	case CurrentColor:
+		return current
+	}
+	return dflt
+}
+
+// computeLength returns the computed value of the length val, or dflt if val
+// is empty or invalid. If auto is true, the auto keyword is allowed, and
+// none is always allowed if that's the default.
+func computeLength(val string, dflt Length, u UnitContext, auto bool) Length {
+	val = strings.TrimSpace(val)
+	switch strings.ToLower(val) {
+	case "":
+		return dflt
+	case "auto":
+		if auto {
+			return Length{Type: LengthAuto}
+		}
+		return dflt
+	case "none":
+		return dflt
+	}
+	vals := ParseComponentValues(val)
+	if hasViewportUnits(vals) || (isMathFunction(val) && hasPercentage(vals)) {
+		if _, err := ConvertLengthToPx(u, 0, val); err != nil {
+			return dflt
+		}
+		return Length{Type: LengthCalc, expr: val}
+	}
+	if t, ok := singleToken(vals); ok && t.Type == PercentageToken {
+		return Length{Type: LengthPercent, Value: t.Number}
+	}
+	px, err := ConvertLengthToPx(u, 0, val)
+	if err != nil {
+		return dflt
+	}
+	return Length{Type: LengthPx, Value: float64(px)}
+}
+
+// singleToken returns the token in vals if it's the only one other than
+// whitespace.
+func singleToken(vals []ComponentValue) (Token, bool) {
+	vals = trimWhitespace(vals)
+	if len(vals) != 1 {
+		return Token{}, false
+	}
+	t, ok := vals[0].(Token)
+	return t, ok
+}
+
+// hasPercentage reports whether there's a percentage anywhere in vals.
+func hasPercentage(vals []ComponentValue) bool {
+	for _, v := range vals {
+		switch v := v.(type) {
+		case Token:
+			if v.Type == PercentageToken {
+				return true
+			}
+		case *Function:
+			if hasPercentage(v.Value) {
+				return true
+			}
+		case *SimpleBlock:
+			if hasPercentage(v.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// computeBorder returns the computed value of a side of a border.
+func computeBorder(width, style, clr string, current color.RGBA, u UnitContext) Border {
+	b := Border{
+		Style: BorderStyle(strings.ToLower(strings.TrimSpace(style))),
+		Color: computeColor(clr, current, current),
+	}
+	if b.Style == "" {
+		b.Style = BorderStyleNone
+	}
+	zero := Length{Type: LengthPx}
+	switch strings.ToLower(strings.TrimSpace(width)) {
+	case "thin":
+		b.Width = Length{Type: LengthPx, Value: 1}
+	case "medium":
+		b.Width = Length{Type: LengthPx, Value: 3}
+	case "thick":
+		b.Width = Length{Type: LengthPx, Value: 5}
+	default:
+		// Border widths can't be percentages.
+		if b.Width = computeLength(width, zero, u, false); b.Width.Type == LengthPercent {
+			b.Width = zero
+		}
+	}
+	if b.Style == BorderStyleNone || b.Style == BorderStyleHidden {
+		b.Width = zero
+	}
+	return b
+}
+
+// computeFontWeight returns the font-weight val. Numeric weights are rounded
+// to the nearest hundred, and bolder and lighter are relative to the parent's
+// weight, as in the table in CSS Fonts Level 4.
+func computeFontWeight(val string, parent font.Weight) font.Weight {
+	switch v := strings.ToLower(strings.TrimSpace(val)); v {
+	case "normal":
+		return font.WeightNormal
+	case "bold":
+		return font.WeightBold
+	case "bolder":
+		switch p := cssWeight(parent); {
+		case p < 350:
+			return font.WeightNormal
+		case p < 550:
+			return font.WeightBold
+		default:
+			return font.WeightBlack
+		}
+	case "lighter":
+		switch p := cssWeight(parent); {
+		case p < 550:
+			return font.WeightThin
+		case p < 750:
+			return font.WeightNormal
+		default:
+			return font.WeightBold
+		}
+	default:
+		w, err := strconv.ParseFloat(v, 64)
+		if err != nil || w < 1 || w > 1000 {
+			return parent
+		}
+		return fontWeight(int(w))
+	}
+}
+
+// computeFontStyle returns the font-style val.
+func computeFontStyle(val string, parent font.Style) font.Style {
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "normal":
+		return font.StyleNormal
+	case "italic":
+		return font.StyleItalic
+	case "oblique":
+		return font.StyleOblique
+	}
+	return parent
+}
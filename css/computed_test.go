@@ -0,0 +1,180 @@
+package css
+
+import (
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/font"
+)
+
+func TestComputeStyle(t *testing.T) {
+	rule := func(order uint, name StyleAttribute, val string) StyleRule {
+		return StyleRule{
+			Selector: CSSSelector{"div", order},
+			Name:     name,
+			Value:    StyleValue{val, false},
+			Src:      AuthorSrc,
+		}
+	}
+	var parent StyledElement
+	for _, r := range []StyleRule{
+		rule(0, "color", "rgb(0, 128, 0)"),
+		rule(1, "font-weight", "bold"),
+		rule(2, "white-space", "pre"),
+	} {
+		parent.AddStyle(r)
+	}
+	parent.SortStyles()
+	parent.SetFontSize(20)
+	if parent.ComputedStyle() != nil {
+		t.Fatal("Got a computed style before computing it")
+	}
+	parent.ComputeStyle(nil, UnitContext{FontSize: 20})
+
+	var e StyledElement
+	for _, r := range []StyleRule{
+		rule(0, "margin", "1em auto 10% 2px"),
+		rule(1, "padding-left", "calc(50% - 2px)"),
+		rule(2, "width", "10vw"),
+		rule(3, "max-height", "none"),
+		rule(4, "border-top-width", "thin"),
+		rule(4, "border-top-style", "solid"),
+		rule(5, "border-bottom-width", "4px"),
+		rule(5, "border-bottom-color", "red"),
+		rule(6, "background-color", "currentColor"),
+		rule(7, "display", "BLOCK"),
+		rule(8, "float", "sideways"),
+		rule(9, "font-weight", "bolder"),
+	} {
+		e.AddStyle(r)
+	}
+	e.InheritStyles(&parent)
+	e.SortStyles()
+	e.SetFontSize(10)
+	e.ComputeStyle(parent.ComputedStyle(), UnitContext{FontSize: 10, ViewportWidth: 300})
+	cs := e.ComputedStyle()
+	if cs == nil {
+		t.Fatal("No computed style after computing it")
+	}
+
+	green := color.RGBA{0, 128, 0, 255}
+	if cs.Color != green {
+		t.Errorf("Unexpected colour: got %v want %v", cs.Color, green)
+	}
+	if cs.BackgroundColor != green {
+		t.Errorf("Unexpected background colour: got %v want %v", cs.BackgroundColor, green)
+	}
+	if cs.WhiteSpace != WhiteSpacePre {
+		t.Errorf("Unexpected white-space: got %v want %v", cs.WhiteSpace, WhiteSpacePre)
+	}
+	// bolder than the parent's bold is black.
+	if cs.FontWeight != font.WeightBlack {
+		t.Errorf("Unexpected font weight: got %v want %v", cs.FontWeight, font.WeightBlack)
+	}
+	if cs.FontSize != 10 {
+		t.Errorf("Unexpected font size: got %v want 10", cs.FontSize)
+	}
+	if cs.Display != DisplayBlock {
+		t.Errorf("Unexpected display: got %v want %v", cs.Display, DisplayBlock)
+	}
+	if cs.Float != FloatNone {
+		t.Errorf("Unexpected float: got %v want %v", cs.Float, FloatNone)
+	}
+
+	units := func() UnitContext {
+		return UnitContext{FontSize: 10, ViewportWidth: 600}
+	}
+	lengths := []struct {
+		Name     string
+		Value    Length
+		Type     LengthType
+		Expected int
+	}{
+		{"margin-top", cs.MarginTop, LengthPx, 10},
+		{"margin-right", cs.MarginRight, LengthAuto, 0},
+		{"margin-bottom", cs.MarginBottom, LengthPercent, 20},
+		{"margin-left", cs.MarginLeft, LengthPx, 2},
+		{"padding-left", cs.PaddingLeft, LengthCalc, 98},
+		{"padding-right", cs.PaddingRight, LengthPx, 0},
+		// Viewport units are resolved against the viewport at the
+		// time of layout.
+		{"width", cs.Width, LengthCalc, 60},
+		{"height", cs.Height, LengthAuto, 0},
+		{"max-height", cs.MaxHeight, LengthNone, 0},
+		{"min-width", cs.MinWidth, LengthPx, 0},
+	}
+	for _, tc := range lengths {
+		if tc.Value.Type != tc.Type {
+			t.Errorf("%v: unexpected type: got %v want %v", tc.Name, tc.Value.Type, tc.Type)
+		}
+		if px := tc.Value.Px(200, units); px != tc.Expected {
+			t.Errorf("%v: unexpected px: got %v want %v", tc.Name, px, tc.Expected)
+		}
+	}
+
+	if want := (Border{Width: Length{Type: LengthPx, Value: 1}, Style: BorderStyleSolid, Color: green}); cs.BorderTop != want {
+		t.Errorf("Unexpected top border: got %v want %v", cs.BorderTop, want)
+	}
+	// Borders without a style don't have a width.
+	if want := (Border{Width: Length{Type: LengthPx}, Style: BorderStyleNone, Color: color.RGBA{255, 0, 0, 255}}); cs.BorderBottom != want {
+		t.Errorf("Unexpected bottom border: got %v want %v", cs.BorderBottom, want)
+	}
+}
+
+func TestComputeFontWeight(t *testing.T) {
+	tests := []struct {
+		Val      string
+		Parent   font.Weight
+		Expected font.Weight
+	}{
+		{"normal", font.WeightBold, font.WeightNormal},
+		{"bold", font.WeightNormal, font.WeightBold},
+		{"100", font.WeightNormal, font.WeightThin},
+		{"200", font.WeightNormal, font.WeightExtraLight},
+		{"900", font.WeightNormal, font.WeightBlack},
+		{"450", font.WeightNormal, font.WeightMedium},
+		{"1", font.WeightNormal, font.WeightThin},
+		{"1000", font.WeightNormal, font.WeightBlack},
+		{"1001", font.WeightLight, font.WeightLight},
+		{"", font.WeightSemiBold, font.WeightSemiBold},
+
+		{"bolder", font.WeightThin, font.WeightNormal},
+		{"bolder", font.WeightLight, font.WeightNormal},
+		{"bolder", font.WeightNormal, font.WeightBold},
+		{"bolder", font.WeightMedium, font.WeightBold},
+		{"bolder", font.WeightSemiBold, font.WeightBlack},
+		{"bolder", font.WeightBlack, font.WeightBlack},
+
+		{"lighter", font.WeightThin, font.WeightThin},
+		{"lighter", font.WeightNormal, font.WeightThin},
+		{"lighter", font.WeightSemiBold, font.WeightNormal},
+		{"lighter", font.WeightBold, font.WeightNormal},
+		{"lighter", font.WeightExtraBold, font.WeightBold},
+		{"lighter", font.WeightBlack, font.WeightBold},
+	}
+	for _, tc := range tests {
+		if got := computeFontWeight(tc.Val, tc.Parent); got != tc.Expected {
+			t.Errorf("Unexpected weight for %q with parent %v: got %v want %v", tc.Val, cssWeight(tc.Parent), cssWeight(got), cssWeight(tc.Expected))
+		}
+	}
+}
+
+func TestComputeFontStretch(t *testing.T) {
+	tests := []struct {
+		Val      string
+		Expected FontStretch
+	}{
+		{"normal", 100},
+		{"ultra-condensed", 50},
+		{"semi-condensed", 87.5},
+		{"EXPANDED", 125},
+		{"150%", 150},
+		{"-10%", 80},
+		{"wide", 80},
+	}
+	for _, tc := range tests {
+		if got := computeFontStretch(tc.Val, 80); got != tc.Expected {
+			t.Errorf("Unexpected stretch for %q: got %v want %v", tc.Val, got, tc.Expected)
+		}
+	}
+}
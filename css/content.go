@@ -0,0 +1,293 @@
+package css
+
+import (
+	"errors"
+	"strings"
+)
+
+// InvalidValue is returned when a property's value can not be parsed.
+var InvalidValue = errors.New("Invalid value for property")
+
+// ContentType is the type of a component of the content property.
+type ContentType uint8
+
+const (
+	ContentString ContentType = iota
+	ContentAttr
+	ContentCounter
+	ContentCounters
+	ContentOpenQuote
+	ContentCloseQuote
+	ContentNoOpenQuote
+	ContentNoCloseQuote
+	ContentURL
+)
+
+// A ContentItem is one component of the value of the content property.
+type ContentItem struct {
+	Type ContentType
+
+	// The string for ContentString, the attribute name for ContentAttr,
+	// the counter name for counters, or the URL for ContentURL.
+	Value string
+
+	// The separator for ContentCounters.
+	Separator string
+
+	// The counter style for ContentCounter and ContentCounters.
+	Style string
+}
+
+// ParseContent parses the value of the content property into the items
+// that make it up. If the value is "normal" or "none" it returns NoStyles,
+// and if it's invalid it returns InvalidValue.
+func ParseContent(val string) ([]ContentItem, error) {
+	vals := trimWhitespace(ParseComponentValues(val))
+	if len(vals) == 0 {
+		return nil, NoStyles
+	}
+	if len(vals) == 1 {
+		if t, ok := vals[0].(Token); ok {
+			switch {
+			case t.isIdent("normal"), t.isIdent("none"):
+				return nil, NoStyles
+			case t.isIdent("inherit"):
+				return nil, InheritValue
+			}
+		}
+	}
+
+	var items []ContentItem
+	for _, v := range vals {
+		switch v := v.(type) {
+		case Token:
+			switch v.Type {
+			case WhitespaceToken:
+				continue
+			case StringToken:
+				items = append(items, ContentItem{Type: ContentString, Value: v.Value})
+			case URLToken:
+				items = append(items, ContentItem{Type: ContentURL, Value: v.Value})
+			case IdentToken:
+				switch strings.ToLower(v.Value) {
+				case "open-quote":
+					items = append(items, ContentItem{Type: ContentOpenQuote})
+				case "close-quote":
+					items = append(items, ContentItem{Type: ContentCloseQuote})
+				case "no-open-quote":
+					items = append(items, ContentItem{Type: ContentNoOpenQuote})
+				case "no-close-quote":
+					items = append(items, ContentItem{Type: ContentNoCloseQuote})
+				default:
+					return nil, InvalidValue
+				}
+			default:
+				return nil, InvalidValue
+			}
+		case *Function:
+			item, err := parseContentFunction(v)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		default:
+			return nil, InvalidValue
+		}
+	}
+	return items, nil
+}
+
+// parseContentFunction parses a function used in the content property.
+func parseContentFunction(f *Function) (ContentItem, error) {
+	var args [][]ComponentValue
+	for _, arg := range splitOnCommas(f.Value) {
+		args = append(args, trimWhitespace(arg))
+	}
+	ident := func(i int) (string, bool) {
+		if i >= len(args) || len(args[i]) != 1 || tokenType(args[i][0]) != IdentToken {
+			return "", false
+		}
+		return args[i][0].(Token).Value, true
+	}
+
+	switch name := strings.ToLower(f.Name); name {
+	case "attr":
+		// The attr() type and fallback from CSS Values Level 5
+		// aren't supported, only the original form.
+		if len(args) != 1 {
+			return ContentItem{}, InvalidValue
+		}
+		if attr, ok := ident(0); ok {
+			return ContentItem{Type: ContentAttr, Value: attr}, nil
+		}
+	case "counter":
+		if len(args) < 1 || len(args) > 2 {
+			return ContentItem{}, InvalidValue
+		}
+		item := ContentItem{Type: ContentCounter, Style: "decimal"}
+		var ok bool
+		if item.Value, ok = ident(0); !ok {
+			return ContentItem{}, InvalidValue
+		}
+		if len(args) == 2 {
+			if item.Style, ok = ident(1); !ok {
+				return ContentItem{}, InvalidValue
+			}
+		}
+		return item, nil
+	case "counters":
+		if len(args) < 2 || len(args) > 3 {
+			return ContentItem{}, InvalidValue
+		}
+		item := ContentItem{Type: ContentCounters, Style: "decimal"}
+		var ok bool
+		if item.Value, ok = ident(0); !ok {
+			return ContentItem{}, InvalidValue
+		}
+		if len(args[1]) != 1 || tokenType(args[1][0]) != StringToken {
+			return ContentItem{}, InvalidValue
+		}
+		item.Separator = args[1][0].(Token).Value
+		if len(args) == 3 {
+			if item.Style, ok = ident(2); !ok {
+				return ContentItem{}, InvalidValue
+			}
+		}
+		return item, nil
+	case "url":
+		if len(args) == 1 && len(args[0]) == 1 && tokenType(args[0][0]) == StringToken {
+			return ContentItem{Type: ContentURL, Value: args[0][0].(Token).Value}, nil
+		}
+	}
+	return ContentItem{}, InvalidValue
+}
+
+// DefaultQuotes are the quotes used when the quotes property isn't set.
+var DefaultQuotes = [][2]string{{"“", "”"}, {"‘", "’"}}
+
+// ParseQuotes parses the value of the quotes property into pairs of open
+// and close quotes, from the outermost level to the innermost. "none"
+// returns an empty list.
+func ParseQuotes(val string) ([][2]string, error) {
+	vals := trimWhitespace(ParseComponentValues(val))
+	if len(vals) == 0 {
+		return nil, NoStyles
+	}
+	if len(vals) == 1 {
+		if t, ok := vals[0].(Token); ok {
+			switch {
+			case t.isIdent("none"):
+				return [][2]string{}, nil
+			case t.isIdent("auto"):
+				return DefaultQuotes, nil
+			case t.isIdent("inherit"):
+				return nil, InheritValue
+			}
+		}
+	}
+	var strs []string
+	for _, v := range vals {
+		switch tokenType(v) {
+		case WhitespaceToken:
+		case StringToken:
+			strs = append(strs, v.(Token).Value)
+		default:
+			return nil, InvalidValue
+		}
+	}
+	if len(strs)%2 != 0 {
+		return nil, InvalidValue
+	}
+	quotes := make([][2]string, 0, len(strs)/2)
+	for i := 0; i < len(strs); i += 2 {
+		quotes = append(quotes, [2]string{strs[i], strs[i+1]})
+	}
+	return quotes, nil
+}
+
+// A CounterValue is a counter name and value pair from the counter-reset,
+// counter-increment or counter-set properties.
+type CounterValue struct {
+	Name  string
+	Value int
+
+	// Implicit is true if no integer was given, and Value is the
+	// default for the property.
+	Implicit bool
+
+	// Reversed is true for counters declared with reversed() in
+	// counter-reset.
+	Reversed bool
+}
+
+// ParseCounters parses the value of a property which consists of a list of
+// counter names, each optionally followed by an integer. Counters without
+// an integer get the value dflt. "none" returns an empty list.
+func ParseCounters(val string, dflt int) ([]CounterValue, error) {
+	vals := trimWhitespace(ParseComponentValues(val))
+	if len(vals) == 0 {
+		return nil, NoStyles
+	}
+	if len(vals) == 1 {
+		if t, ok := vals[0].(Token); ok {
+			switch {
+			case t.isIdent("none"):
+				return []CounterValue{}, nil
+			case t.isIdent("inherit"):
+				return nil, InheritValue
+			}
+		}
+	}
+	var counters []CounterValue
+	for _, v := range vals {
+		if f, ok := v.(*Function); ok {
+			// reversed(name), from CSS Lists Level 3
+			args := trimWhitespace(f.Value)
+			if !strings.EqualFold(f.Name, "reversed") || len(args) != 1 || !validCounterName(args[0]) {
+				return nil, InvalidValue
+			}
+			counters = append(counters, CounterValue{
+				Name:     args[0].(Token).Value,
+				Value:    dflt,
+				Implicit: true,
+				Reversed: true,
+			})
+			continue
+		}
+		t, ok := v.(Token)
+		if !ok {
+			return nil, InvalidValue
+		}
+		switch t.Type {
+		case WhitespaceToken:
+		case IdentToken:
+			if !validCounterName(t) {
+				return nil, InvalidValue
+			}
+			counters = append(counters, CounterValue{Name: t.Value, Value: dflt, Implicit: true})
+		case NumberToken:
+			if !t.Integer || len(counters) == 0 || !counters[len(counters)-1].Implicit {
+				return nil, InvalidValue
+			}
+			counters[len(counters)-1].Value = int(t.Number)
+			counters[len(counters)-1].Implicit = false
+		default:
+			return nil, InvalidValue
+		}
+	}
+	return counters, nil
+}
+
+// validCounterName reports whether v is an identifier that can be used as
+// the name of a counter.
+func validCounterName(v ComponentValue) bool {
+	t, ok := v.(Token)
+	if !ok || t.Type != IdentToken {
+		return false
+	}
+	switch strings.ToLower(t.Value) {
+	case "none", "inherit", "initial", "unset", "default":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package css
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseContent(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Expected []ContentItem
+		Err      error
+	}{
+		{"normal", nil, NoStyles},
+		{"none", nil, NoStyles},
+		{`"\A"`, []ContentItem{{Type: ContentString, Value: "\n"}}, nil},
+		{
+			`"Chapter " counter(chapter) ". "`,
+			[]ContentItem{
+				{Type: ContentString, Value: "Chapter "},
+				{Type: ContentCounter, Value: "chapter", Style: "decimal"},
+				{Type: ContentString, Value: ". "},
+			},
+			nil,
+		},
+		{
+			`counters(item, ".", upper-roman)`,
+			[]ContentItem{{Type: ContentCounters, Value: "item", Separator: ".", Style: "upper-roman"}},
+			nil,
+		},
+		{
+			`open-quote attr(title) close-quote no-open-quote no-close-quote`,
+			[]ContentItem{
+				{Type: ContentOpenQuote},
+				{Type: ContentAttr, Value: "title"},
+				{Type: ContentCloseQuote},
+				{Type: ContentNoOpenQuote},
+				{Type: ContentNoCloseQuote},
+			},
+			nil,
+		},
+		{
+			`url(foo.png) url("bar.png")`,
+			[]ContentItem{{Type: ContentURL, Value: "foo.png"}, {Type: ContentURL, Value: "bar.png"}},
+			nil,
+		},
+		{"foo", nil, InvalidValue},
+		{"3", nil, InvalidValue},
+		{"counters(item)", nil, InvalidValue},
+		{`counter("item")`, nil, InvalidValue},
+	}
+	for _, tc := range tests {
+		got, err := ParseContent(tc.Value)
+		if err != tc.Err {
+			t.Errorf("Unexpected error for %q: got %v want %v", tc.Value, err, tc.Err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Errorf("Unexpected content for %q: got %v want %v", tc.Value, got, tc.Expected)
+		}
+	}
+}
+
+func TestParseQuotes(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Expected [][2]string
+		Err      error
+	}{
+		{"none", [][2]string{}, nil},
+		{"auto", DefaultQuotes, nil},
+		{`"«" "»" "'" "'"`, [][2]string{{"«", "»"}, {"'", "'"}}, nil},
+		{`"«"`, nil, InvalidValue},
+		{"", nil, NoStyles},
+	}
+	for _, tc := range tests {
+		got, err := ParseQuotes(tc.Value)
+		if err != tc.Err {
+			t.Errorf("Unexpected error for %q: got %v want %v", tc.Value, err, tc.Err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Errorf("Unexpected quotes for %q: got %v want %v", tc.Value, got, tc.Expected)
+		}
+	}
+}
+
+func TestParseCounters(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Expected []CounterValue
+		Err      error
+	}{
+		{"none", []CounterValue{}, nil},
+		{"chapter", []CounterValue{{Name: "chapter", Value: 1, Implicit: true}}, nil},
+		{
+			"chapter 3 section -1 figure",
+			[]CounterValue{{Name: "chapter", Value: 3}, {Name: "section", Value: -1}, {Name: "figure", Value: 1, Implicit: true}},
+			nil,
+		},
+		{
+			"reversed(list-item) chapter reversed(section) 4",
+			[]CounterValue{
+				{Name: "list-item", Value: 1, Implicit: true, Reversed: true},
+				{Name: "chapter", Value: 1, Implicit: true},
+				{Name: "section", Value: 4, Reversed: true},
+			},
+			nil,
+		},
+		{"reversed(none)", nil, InvalidValue},
+		{"reverse(chapter)", nil, InvalidValue},
+		{"chapter 1 1", nil, InvalidValue},
+		{"3", nil, InvalidValue},
+		{"chapter 1.5", nil, InvalidValue},
+		{"chapter none", nil, InvalidValue},
+	}
+	for _, tc := range tests {
+		got, err := ParseCounters(tc.Value, 1)
+		if err != tc.Err {
+			t.Errorf("Unexpected error for %q: got %v want %v", tc.Value, err, tc.Err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Errorf("Unexpected counters for %q: got %v want %v", tc.Value, got, tc.Expected)
+		}
+	}
+}
+
+func TestListStyleShorthand(t *testing.T) {
+	tests := []struct {
+		Value                 string
+		Type, Position, Image string
+	}{
+		{"square", "square", "outside", "none"},
+		{"inside lower-roman", "lower-roman", "inside", "none"},
+		{`url("dot.png") inside "-"`, `"-"`, "inside", `url("dot.png")`},
+		{"none", "none", "outside", "none"},
+		{"none url(dot.png)", "none", "outside", "url(dot.png)"},
+		{"none circle", "circle", "outside", "none"},
+		// Without a parent, inherit is the initial value.
+		{"inherit", "disc", "outside", "none"},
+		// Invalid declarations are ignored.
+		{"none none none", "", "", ""},
+		{"inside outside", "", "", ""},
+		{"disc 3px", "", "", ""},
+	}
+	for _, tc := range tests {
+		var e StyledElement
+		e.AddStyle(StyleRule{
+			Selector: CSSSelector{"li", 0},
+			Name:     "list-style",
+			Value:    StyleValue{tc.Value, false},
+			Src:      AuthorSrc,
+		})
+		e.SortStyles()
+		if e.ListStyleType.Value != tc.Type || e.ListStylePosition.Value != tc.Position || e.ListStyleImage.Value != tc.Image {
+			t.Errorf("Unexpected longhands for %q: got (%q, %q, %q) want (%q, %q, %q)",
+				tc.Value,
+				e.ListStyleType.Value, e.ListStylePosition.Value, e.ListStyleImage.Value,
+				tc.Type, tc.Position, tc.Image,
+			)
+		}
+	}
+}
+
+func TestGetListStyleImage(t *testing.T) {
+	tests := []struct {
+		Value    string
+		Expected string
+		Err      error
+	}{
+		{"", "", NoStyles},
+		{"none", "", nil},
+		{"inherit", "", InheritValue},
+		{"url(dot.png)", "dot.png", nil},
+		{`url("dot.png")`, "dot.png", nil},
+		{"dot.png", "", InvalidValue},
+	}
+	for _, tc := range tests {
+		var e StyledElement
+		e.ListStyleImage.Value = tc.Value
+		got, err := e.GetListStyleImage()
+		if got != tc.Expected || err != tc.Err {
+			t.Errorf("Unexpected image for %q: got (%q, %v) want (%q, %v)", tc.Value, got, err, tc.Expected, tc.Err)
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package css
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CounterSystem is the algorithm used to convert a counter value to a
+// string, from the system descriptor of @counter-style.
+type CounterSystem uint8
+
+const (
+	CyclicSystem CounterSystem = iota
+	NumericSystem
+	AlphabeticSystem
+	SymbolicSystem
+	AdditiveSystem
+	FixedSystem
+)
+
+// An AdditiveSymbol is a weight and symbol pair from the additive-symbols
+// descriptor.
+type AdditiveSymbol struct {
+	Weight int
+	Symbol string
+}
+
+// A CounterStyle defines how counter values are represented, for
+// list markers and the counter() and counters() functions. See CSS
+// Counter Styles Level 3.
+type CounterStyle struct {
+	Name   string
+	System CounterSystem
+
+	// The value of the first symbol for the fixed system.
+	First int
+
+	Symbols         []string
+	AdditiveSymbols []AdditiveSymbol
+
+	// The strings added before and after negative values.
+	Negative [2]string
+
+	// The strings added before and after the representation in list
+	// markers.
+	Prefix, Suffix string
+
+	// The ranges of values that the style can represent. If nil, the
+	// range depends on the system.
+	Range [][2]int
+
+	// The minimum length of the representation, and the symbol used to
+	// pad it to that length.
+	Pad       int
+	PadSymbol string
+
+	// The counter style used for values that this one can't represent.
+	Fallback string
+}
+
+// counterStylePrefix is the selector prefix used for the StyleRules that hold
+// @counter-style descriptors. The selector never matches an element.
+const counterStylePrefix = "@counter-style "
+
+// reservedCounterStyles are the names that @counter-style rules can't
+// redefine.
+var reservedCounterStyles = map[string]bool{
+	"none": true, "decimal": true, "disc": true, "square": true,
+	"circle": true, "disclosure-open": true, "disclosure-closed": true,
+	"inherit": true, "initial": true, "unset": true, "default": true,
+}
+
+// parseCounterStyleRule converts an @counter-style rule into StyleRules,
+// one for each descriptor, which all share the same order number. It
+// returns nil if the rule is invalid. Invalid rules and parse errors in the
+// block are reported to report.
+func parseCounterStyleRule(r *AtRule, media []MediaQueryList, src StyleSource, orderNo uint, allowReserved bool, report *reporter) []StyleRule {
+	prelude := trimWhitespace(r.Prelude)
+	if r.Block == nil || len(prelude) != 1 || tokenType(prelude[0]) != IdentToken {
+		report.errorf(r.Offset, "Invalid @counter-style rule: %v", strings.TrimSpace(SerializeComponentValues(r.Prelude)))
+		return nil
+	}
+	name := prelude[0].(Token).Value
+	if !allowReserved && reservedCounterStyles[strings.ToLower(name)] {
+		report.errorf(r.Offset, "Counter style %v can't be redefined", name)
+		return nil
+	}
+	var rules []StyleRule
+	for _, decl := range parseBlockDeclarations(r.Block, report) {
+		rules = append(rules, StyleRule{
+			Selector: CSSSelector{counterStylePrefix + name, orderNo},
+			Name:     StyleAttribute(strings.ToLower(decl.Name)),
+			Value:    StyleValue{SerializeComponentValues(decl.Value), false},
+			Src:      src,
+			Media:    media,
+		})
+	}
+	return rules
+}
+
+// CounterStyles are the counter styles available to a document, by name.
+// The predefined counter styles are always available, even if the map is
+// nil.
+type CounterStyles map[string]*CounterStyle
+
+var predefinedCounterStyles CounterStyles
+
+// NewCounterStyles returns the counter styles defined by @counter-style rules
+// in sheets whose media queries match f, in addition to the predefined
+// counter styles. Later rules replace earlier rules with the same name.
+func NewCounterStyles(f MediaFeatures, sheets ...Stylesheet) CounterStyles {
+	defs := make(map[string]map[StyleAttribute]string)
+	order := make(map[string]uint)
+	for _, sheet := range sheets {
+		for _, rule := range sheet {
+			if !strings.HasPrefix(rule.Selector.Selector, counterStylePrefix) || !rule.MediaMatches(f) {
+				continue
+			}
+			name := strings.TrimPrefix(rule.Selector.Selector, counterStylePrefix)
+			if d, ok := defs[name]; !ok || order[name] != rule.Selector.OrderNumber {
+				// A new rule for the name replaces the old one.
+				d = make(map[StyleAttribute]string)
+				defs[name] = d
+				order[name] = rule.Selector.OrderNumber
+			}
+			defs[name][rule.Name] = rule.Value.Value
+		}
+	}
+	if len(defs) == 0 {
+		return nil
+	}
+	styles := make(CounterStyles)
+	for name, d := range predefinedCounterStyles {
+		styles[name] = d
+	}
+	built := make(map[string]bool)
+	building := make(map[string]bool)
+	var build func(name string) *CounterStyle
+	build = func(name string) *CounterStyle {
+		d, ok := defs[name]
+		if !ok || built[name] {
+			return styles[name]
+		}
+		if building[name] {
+			// The style extends itself.
+			return nil
+		}
+		building[name] = true
+		// Invalid rules are ignored, so that any predefined style of
+		// the same name is still used.
+		if s := newCounterStyle(name, d, build); s != nil {
+			styles[name] = s
+		}
+		built[name] = true
+		delete(building, name)
+		return styles[name]
+	}
+	for name := range defs {
+		build(name)
+	}
+	return styles
+}
+
+// newCounterStyle creates a counter style from the descriptors in d. base
+// looks up the counter style that the new one extends. If the descriptors
+// are invalid, it returns nil.
+func newCounterStyle(name string, d map[StyleAttribute]string, base func(string) *CounterStyle) *CounterStyle {
+	s := &CounterStyle{
+		Name:     name,
+		System:   SymbolicSystem,
+		First:    1,
+		Negative: [2]string{"-", ""},
+		Suffix:   ". ",
+		Fallback: "decimal",
+	}
+	extends := false
+	if sys, ok := d["system"]; ok {
+		vals := nonWhitespace(ParseComponentValues(sys))
+		if len(vals) == 0 || tokenType(vals[0]) != IdentToken {
+			return nil
+		}
+		switch strings.ToLower(vals[0].(Token).Value) {
+		case "cyclic":
+			s.System = CyclicSystem
+		case "numeric":
+			s.System = NumericSystem
+		case "alphabetic":
+			s.System = AlphabeticSystem
+		case "symbolic":
+			s.System = SymbolicSystem
+		case "additive":
+			s.System = AdditiveSystem
+		case "fixed":
+			s.System = FixedSystem
+			if len(vals) == 2 {
+				t, ok := vals[1].(Token)
+				if !ok || t.Type != NumberToken || !t.Integer {
+					return nil
+				}
+				s.First = int(t.Number)
+				vals = vals[:1]
+			}
+		case "extends":
+			if len(vals) != 2 || tokenType(vals[1]) != IdentToken {
+				return nil
+			}
+			b := base(lookupCounterStyleName(vals[1].(Token).Value))
+			if b == nil {
+				// Extending an unknown style or a cycle
+				// extends decimal.
+				b = predefinedCounterStyles["decimal"]
+			}
+			extended := *b
+			extended.Name = name
+			s = &extended
+			extends = true
+			vals = vals[:1]
+		default:
+			return nil
+		}
+		if len(vals) != 1 {
+			return nil
+		}
+	}
+
+	for desc, val := range d {
+		vals := nonWhitespace(ParseComponentValues(val))
+		switch desc {
+		case "symbols":
+			if extends {
+				return nil
+			}
+			s.Symbols = nil
+			for _, v := range vals {
+				sym, ok := counterSymbol(v)
+				if !ok {
+					return nil
+				}
+				s.Symbols = append(s.Symbols, sym)
+			}
+		case "additive-symbols":
+			if extends {
+				return nil
+			}
+			s.AdditiveSymbols = nil
+			for _, tuple := range splitOnCommas(ParseComponentValues(val)) {
+				tuple = nonWhitespace(tuple)
+				if len(tuple) != 2 {
+					return nil
+				}
+				if tokenType(tuple[0]) != NumberToken {
+					tuple[0], tuple[1] = tuple[1], tuple[0]
+				}
+				t, ok := tuple[0].(Token)
+				if !ok || t.Type != NumberToken || !t.Integer || t.Number < 0 {
+					return nil
+				}
+				sym, ok := counterSymbol(tuple[1])
+				if !ok {
+					return nil
+				}
+				weight := int(t.Number)
+				if n := len(s.AdditiveSymbols); n > 0 && s.AdditiveSymbols[n-1].Weight <= weight {
+					// Weights must be in descending order.
+					return nil
+				}
+				s.AdditiveSymbols = append(s.AdditiveSymbols, AdditiveSymbol{weight, sym})
+			}
+		case "negative":
+			if len(vals) < 1 || len(vals) > 2 {
+				return nil
+			}
+			s.Negative = [2]string{}
+			for i, v := range vals {
+				sym, ok := counterSymbol(v)
+				if !ok {
+					return nil
+				}
+				s.Negative[i] = sym
+			}
+		case "prefix", "suffix":
+			if len(vals) != 1 {
+				return nil
+			}
+			sym, ok := counterSymbol(vals[0])
+			if !ok {
+				return nil
+			}
+			if desc == "prefix" {
+				s.Prefix = sym
+			} else {
+				s.Suffix = sym
+			}
+		case "range":
+			if len(vals) == 1 && tokenType(vals[0]) == IdentToken && vals[0].(Token).isIdent("auto") {
+				s.Range = nil
+				continue
+			}
+			s.Range = nil
+			for _, r := range splitOnCommas(ParseComponentValues(val)) {
+				r = nonWhitespace(r)
+				if len(r) != 2 {
+					return nil
+				}
+				var bounds [2]int
+				for i, v := range r {
+					t, ok := v.(Token)
+					switch {
+					case ok && t.isIdent("infinite") && i == 0:
+						bounds[i] = minInt
+					case ok && t.isIdent("infinite") && i == 1:
+						bounds[i] = maxInt
+					case ok && t.Type == NumberToken && t.Integer:
+						bounds[i] = int(t.Number)
+					default:
+						return nil
+					}
+				}
+				if bounds[0] > bounds[1] {
+					return nil
+				}
+				s.Range = append(s.Range, bounds)
+			}
+		case "pad":
+			if len(vals) != 2 {
+				return nil
+			}
+			if tokenType(vals[0]) != NumberToken {
+				vals[0], vals[1] = vals[1], vals[0]
+			}
+			t, ok := vals[0].(Token)
+			if !ok || t.Type != NumberToken || !t.Integer || t.Number < 0 {
+				return nil
+			}
+			sym, ok := counterSymbol(vals[1])
+			if !ok {
+				return nil
+			}
+			s.Pad, s.PadSymbol = int(t.Number), sym
+		case "fallback":
+			if len(vals) != 1 || tokenType(vals[0]) != IdentToken {
+				return nil
+			}
+			s.Fallback = lookupCounterStyleName(vals[0].(Token).Value)
+		}
+	}
+
+	// Check that there are enough symbols for the system.
+	switch s.System {
+	case CyclicSystem, FixedSystem, SymbolicSystem:
+		if len(s.Symbols) < 1 {
+			return nil
+		}
+	case AlphabeticSystem, NumericSystem:
+		if len(s.Symbols) < 2 {
+			return nil
+		}
+	case AdditiveSystem:
+		if len(s.AdditiveSymbols) < 1 {
+			return nil
+		}
+	}
+	return s
+}
+
+const (
+	maxInt = int(^uint(0) >> 1)
+	minInt = -maxInt - 1
+)
+
+// nonWhitespace returns vals without any whitespace tokens.
+func nonWhitespace(vals []ComponentValue) []ComponentValue {
+	var ret []ComponentValue
+	for _, v := range vals {
+		if tokenType(v) != WhitespaceToken {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// counterSymbol returns the string for a <symbol> in a @counter-style
+// descriptor. Images aren't supported as symbols.
+func counterSymbol(v ComponentValue) (string, bool) {
+	t, ok := v.(Token)
+	if !ok {
+		return "", false
+	}
+	switch t.Type {
+	case StringToken, IdentToken:
+		return t.Value, true
+	}
+	return "", false
+}
+
+// lookupCounterStyleName returns the name that a reference to the counter
+// style name refers to. Counter style names are case sensitive, except for
+// the predefined ones.
+func lookupCounterStyleName(name string) string {
+	if lower := strings.ToLower(name); predefinedCounterStyles[lower] != nil {
+		return lower
+	}
+	return name
+}
+
+// get returns the counter style name, or nil if it doesn't exist.
+func (s CounterStyles) get(name string) *CounterStyle {
+	if style, ok := s[name]; ok {
+		return style
+	}
+	if style, ok := predefinedCounterStyles[lookupCounterStyleName(name)]; ok {
+		return style
+	}
+	return nil
+}
+
+// Exists reports whether there is a counter style called name.
+func (s CounterStyles) Exists(name string) bool {
+	return s.get(name) != nil
+}
+
+// Format returns the representation of n in the counter style name, without
+// the prefix or suffix. If the style can't represent n, or doesn't exist,
+// its fallback style is used.
+func (s CounterStyles) Format(n int, name string) string {
+	if name == "none" {
+		return ""
+	}
+	style := s.get(name)
+	seen := make(map[*CounterStyle]bool)
+	for style != nil && !seen[style] {
+		seen[style] = true
+		if repr, ok := style.represent(n); ok {
+			return repr
+		}
+		style = s.get(style.Fallback)
+	}
+	repr, _ := predefinedCounterStyles["decimal"].represent(n)
+	return repr
+}
+
+// Marker returns the text of a list marker for n in the counter style name,
+// including the style's prefix and suffix.
+func (s CounterStyles) Marker(n int, name string) string {
+	if name == "none" {
+		return ""
+	}
+	style := s.get(name)
+	if style == nil {
+		style = predefinedCounterStyles["decimal"]
+	}
+	return style.Prefix + s.Format(n, name) + style.Suffix
+}
+
+// inRange reports whether n is in the range of values that s can
+// represent.
+func (s *CounterStyle) inRange(n int) bool {
+	if s.Range == nil {
+		switch s.System {
+		case AlphabeticSystem, SymbolicSystem:
+			return n >= 1
+		case AdditiveSystem:
+			return n >= 0
+		}
+		return true
+	}
+	for _, r := range s.Range {
+		if n >= r[0] && n <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// represent returns the representation of n in the counter style s, and
+// whether it could be represented.
+func (s *CounterStyle) represent(n int) (string, bool) {
+	if !s.inRange(n) {
+		return "", false
+	}
+	negative := false
+	if n < 0 {
+		switch s.System {
+		case NumericSystem, AdditiveSystem, AlphabeticSystem, SymbolicSystem:
+			negative = true
+			n = -n
+		}
+	}
+	repr, ok := s.generate(n)
+	if !ok {
+		return "", false
+	}
+	if length := utf8.RuneCountInString(repr); length < s.Pad {
+		padding := s.Pad - length
+		if negative {
+			padding -= utf8.RuneCountInString(s.Negative[0] + s.Negative[1])
+		}
+		if padding > 0 {
+			repr = strings.Repeat(s.PadSymbol, padding) + repr
+		}
+	}
+	if negative {
+		repr = s.Negative[0] + repr + s.Negative[1]
+	}
+	return repr, true
+}
+
+// generate runs the counter system's algorithm for n.
+func (s *CounterStyle) generate(n int) (string, bool) {
+	syms := s.Symbols
+	switch s.System {
+	case CyclicSystem:
+		i := (n - 1) % len(syms)
+		if i < 0 {
+			i += len(syms)
+		}
+		return syms[i], true
+	case FixedSystem:
+		if n < s.First || n-s.First >= len(syms) {
+			return "", false
+		}
+		return syms[n-s.First], true
+	case SymbolicSystem:
+		if n < 1 {
+			return "", false
+		}
+		return strings.Repeat(syms[(n-1)%len(syms)], (n-1)/len(syms)+1), true
+	case AlphabeticSystem:
+		if n < 1 {
+			return "", false
+		}
+		var repr string
+		for n > 0 {
+			n--
+			repr = syms[n%len(syms)] + repr
+			n /= len(syms)
+		}
+		return repr, true
+	case NumericSystem:
+		if n == 0 {
+			return syms[0], true
+		}
+		var repr string
+		for n > 0 {
+			repr = syms[n%len(syms)] + repr
+			n /= len(syms)
+		}
+		return repr, true
+	case AdditiveSystem:
+		if n == 0 {
+			for _, sym := range s.AdditiveSymbols {
+				if sym.Weight == 0 {
+					return sym.Symbol, true
+				}
+			}
+			return "", false
+		}
+		var repr strings.Builder
+		for _, sym := range s.AdditiveSymbols {
+			if sym.Weight == 0 || sym.Weight > n {
+				continue
+			}
+			reps := n / sym.Weight
+			repr.WriteString(strings.Repeat(sym.Symbol, reps))
+			n -= reps * sym.Weight
+			if n == 0 {
+				return repr.String(), true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// additiveSequence returns additive symbols for a numbering system where
+// each symbol in each run of 9 is a consecutive code point, such as
+// Armenian, starting at the code point for 1.
+func additiveSequence(start rune, magnitudes int) string {
+	var tuples []string
+	for m := magnitudes - 1; m >= 0; m-- {
+		weight := 1
+		for i := 0; i < m; i++ {
+			weight *= 10
+		}
+		for d := 9; d >= 1; d-- {
+			tuples = append(tuples, strconv.Itoa(d*weight)+` "`+string(start+rune(m*9+d-1))+`"`)
+		}
+	}
+	return strings.Join(tuples, ", ")
+}
+
+// DefaultCounterStyles is the source for the predefined counter styles.
+var DefaultCounterStyles = `
+@counter-style decimal { system: numeric; symbols: "0" "1" "2" "3" "4" "5" "6" "7" "8" "9" }
+@counter-style decimal-leading-zero { system: extends decimal; pad: 2 "0" }
+@counter-style cjk-decimal {
+	system: numeric;
+	symbols: "〇" "一" "二" "三" "四" "五" "六" "七" "八" "九";
+	suffix: "、";
+}
+@counter-style lower-roman {
+	system: additive;
+	range: 1 3999;
+	additive-symbols: 1000 m, 900 cm, 500 d, 400 cd, 100 c, 90 xc, 50 l, 40 xl, 10 x, 9 ix, 5 v, 4 iv, 1 i;
+}
+@counter-style upper-roman {
+	system: additive;
+	range: 1 3999;
+	additive-symbols: 1000 M, 900 CM, 500 D, 400 CD, 100 C, 90 XC, 50 L, 40 XL, 10 X, 9 IX, 5 V, 4 IV, 1 I;
+}
+@counter-style lower-alpha {
+	system: alphabetic;
+	symbols: a b c d e f g h i j k l m n o p q r s t u v w x y z;
+}
+@counter-style lower-latin { system: extends lower-alpha }
+@counter-style upper-alpha {
+	system: alphabetic;
+	symbols: A B C D E F G H I J K L M N O P Q R S T U V W X Y Z;
+}
+@counter-style upper-latin { system: extends upper-alpha }
+@counter-style lower-greek {
+	system: alphabetic;
+	symbols: "α" "β" "γ" "δ" "ε" "ζ" "η" "θ" "ι" "κ" "λ" "μ" "ν" "ξ" "ο" "π" "ρ" "σ" "τ" "υ" "φ" "χ" "ψ" "ω";
+}
+@counter-style upper-armenian {
+	system: additive;
+	range: 1 9999;
+	additive-symbols: ` + additiveSequence('Ա', 4) + `;
+}
+@counter-style armenian { system: extends upper-armenian }
+@counter-style lower-armenian {
+	system: additive;
+	range: 1 9999;
+	additive-symbols: ` + additiveSequence('ա', 4) + `;
+}
+@counter-style georgian {
+	system: additive;
+	range: 1 19999;
+	additive-symbols: 10000 "ჵ", 9000 "ჰ", 8000 "ჯ", 7000 "ჴ", 6000 "ხ", 5000 "ჭ", 4000 "წ", 3000 "ძ", 2000 "ც", 1000 "ჩ", 900 "შ", 800 "ყ", 700 "ღ", 600 "ქ", 500 "ფ", 400 "ჳ", 300 "ტ", 200 "ს", 100 "რ", 90 "ჟ", 80 "პ", 70 "ო", 60 "ჲ", 50 "ნ", 40 "მ", 30 "ლ", 20 "კ", 10 "ი", 9 "თ", 8 "ჱ", 7 "ზ", 6 "ვ", 5 "ე", 4 "დ", 3 "გ", 2 "ბ", 1 "ა";
+}
+@counter-style disc { system: cyclic; symbols: "•"; suffix: " " }
+@counter-style circle { system: cyclic; symbols: "◦"; suffix: " " }
+@counter-style square { system: cyclic; symbols: "▪"; suffix: " " }
+@counter-style disclosure-open { system: cyclic; symbols: "▾"; suffix: " " }
+@counter-style disclosure-closed { system: cyclic; symbols: "▸"; suffix: " " }
+`
+
+func init() {
+	var sheet Stylesheet
+	for i, rule := range ParseRules(DefaultCounterStyles) {
+		if r, ok := rule.(*AtRule); ok && strings.EqualFold(r.Name, "counter-style") {
+			sheet = append(sheet, parseCounterStyleRule(r, nil, UserAgentSrc, uint(i), true, nil)...)
+		}
+	}
+	predefinedCounterStyles = NewCounterStyles(MediaFeatures{}, sheet)
+}
@@ -0,0 +1,126 @@
+package css
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestPredefinedCounterStyles(t *testing.T) {
+	var styles CounterStyles
+	tests := []struct {
+		N        int
+		Style    string
+		Expected string
+	}{
+		{3, "decimal", "3"},
+		{-3, "decimal", "-3"},
+		{7, "decimal-leading-zero", "07"},
+		{14, "lower-roman", "xiv"},
+		{1999, "upper-roman", "MCMXCIX"},
+		// Out of range, so the fallback is used.
+		{4000, "upper-roman", "4000"},
+		{0, "lower-roman", "0"},
+		{1, "lower-alpha", "a"},
+		{27, "lower-alpha", "aa"},
+		{28, "upper-latin", "AB"},
+		{2, "lower-greek", "β"},
+		{14, "armenian", "ԺԴ"},
+		{14, "georgian", "იდ"},
+		{12, "cjk-decimal", "一二"},
+		{5, "disc", "•"},
+		{5, "Square", "▪"},
+		{5, "none", ""},
+		// Unknown styles are decimal.
+		{5, "unknown", "5"},
+	}
+	for _, tc := range tests {
+		if got := styles.Format(tc.N, tc.Style); got != tc.Expected {
+			t.Errorf("Unexpected format of %d in %s: got %q want %q", tc.N, tc.Style, got, tc.Expected)
+		}
+	}
+	if got := styles.Marker(3, "lower-alpha"); got != "c. " {
+		t.Errorf("Unexpected lower-alpha marker: got %q want %q", got, "c. ")
+	}
+	if got := styles.Marker(3, "circle"); got != "◦ " {
+		t.Errorf("Unexpected circle marker: got %q want %q", got, "◦ ")
+	}
+}
+
+func TestCounterStyleRules(t *testing.T) {
+	sheet, _ := ParseStylesheet(`
+@counter-style thumbs {
+	system: cyclic;
+	symbols: "👍";
+	suffix: " ";
+}
+@counter-style paren-roman {
+	system: extends lower-roman;
+	prefix: "(";
+	suffix: ") ";
+}
+@counter-style fixed-letters {
+	system: fixed 3;
+	symbols: C D;
+}
+@counter-style padded {
+	system: numeric;
+	symbols: "0" "1";
+	pad: 4 "0";
+	negative: "(" ")";
+}
+@counter-style small {
+	system: extends decimal;
+	range: 1 3;
+	fallback: upper-alpha;
+}
+@counter-style decimal { system: cyclic; symbols: x }
+@counter-style invalid { system: alphabetic; symbols: a }
+@media print {
+	@counter-style print-only { system: cyclic; symbols: p }
+}
+`, AuthorSrc, nil, &url.URL{}, 0)
+	styles := NewCounterStyles(MediaFeatures{Type: "screen"}, sheet)
+
+	tests := []struct {
+		N        int
+		Style    string
+		Expected string
+	}{
+		{2, "thumbs", "👍"},
+		{4, "paren-roman", "iv"},
+		{3, "fixed-letters", "C"},
+		{4, "fixed-letters", "D"},
+		{5, "fixed-letters", "5"},
+		{5, "padded", "0101"},
+		// The negative sign counts towards the padding.
+		{-2, "padded", "(10)"},
+		{-4, "padded", "(100)"},
+		{3, "small", "3"},
+		{4, "small", "D"},
+		// decimal is reserved, so can't be redefined.
+		{4, "decimal", "4"},
+		// alphabetic systems need at least two symbols.
+		{4, "invalid", "4"},
+		{4, "print-only", "4"},
+	}
+	for _, tc := range tests {
+		if got := styles.Format(tc.N, tc.Style); got != tc.Expected {
+			t.Errorf("Unexpected format of %d in %s: got %q want %q", tc.N, tc.Style, got, tc.Expected)
+		}
+	}
+	if got := styles.Marker(4, "paren-roman"); got != "(iv) " {
+		t.Errorf("Unexpected paren-roman marker: got %q want %q", got, "(iv) ")
+	}
+	for name, exists := range map[string]bool{
+		"thumbs":      true,
+		"Thumbs":      false,
+		"lower-roman": true,
+		"LOWER-ROMAN": true,
+		"invalid":     false,
+		"print-only":  false,
+	} {
+		if got := styles.Exists(name); got != exists {
+			t.Errorf("Unexpected Exists(%q): got %v want %v", name, got, exists)
+		}
+	}
+}
@@ -41,7 +41,7 @@ i, cite, em,
 var, address    { font-style: italic }
 pre, tt, code,
 kbd, samp       { font-family: monospace }
-pre             { white-space: pre; diplay: block; }
+pre             { white-space: pre; display: block; }
 button, textarea,
 input, select   { display: inline-block }
 big             { font-size: 1.17em }
@@ -57,6 +57,13 @@ hr              { border: 1px inset }
 /* ol,  ul, */ dir,
 menu, dd        { margin-left: 40px }
 ol              { list-style-type: decimal }
+ol, ul, menu    { counter-reset: list-item }
+ol, ul          { padding-left: 40px }
+ol[reversed]    { counter-reset: reversed(list-item) }
+ol ul, ul ul,
+menu ul         { list-style-type: circle }
+ol ol ul, ol ul ul,
+ul ol ul, ul ul ul { list-style-type: square }
 ol ul, ul ol,
 ul ul, ol ol    { margin-top: 0; margin-bottom: 0 }
 u, ins          { text-decoration: underline }
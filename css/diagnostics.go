@@ -0,0 +1,107 @@
+package css
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// The Severity of a Diagnostic.
+type Severity int
+
+const (
+	// SeverityError is used for rules and declarations that are
+	// discarded.
+	SeverityError Severity = iota
+
+	// SeverityWarning is used for things which are valid CSS, but which
+	// gob doesn't support, such as unknown properties.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// A Diagnostic describes a problem found while parsing a stylesheet.
+type Diagnostic struct {
+	// The URL of the stylesheet that the problem is in, or an empty
+	// string if it's not known, such as for a style attribute.
+	File string
+
+	// The position of the problem in the stylesheet. Lines and columns
+	// start at 1, and columns are counted in characters.
+	Line, Column int
+
+	Message  string
+	Severity Severity
+}
+
+// String formats the diagnostic as "file:line:column: severity: message".
+// The file is left out if it's not known.
+func (d Diagnostic) String() string {
+	if d.File == "" {
+		return fmt.Sprintf("%d:%d: %v: %v", d.Line, d.Column, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%v:%d:%d: %v: %v", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// A DiagnosticFunc is called with each Diagnostic found while parsing CSS,
+// such as a declaration that's discarded because it's invalid.
+type DiagnosticFunc func(d Diagnostic)
+
+// A reporter converts the offsets of the problems found in a single source
+// into Diagnostics. Methods on a nil reporter do nothing, so that parsing
+// without a DiagnosticFunc doesn't need to check.
+type reporter struct {
+	report DiagnosticFunc
+	file   string
+	src    []rune
+}
+
+// newReporter returns a reporter for problems in the source src, which was
+// loaded from file. It returns nil if report is nil.
+func newReporter(report DiagnosticFunc, file *url.URL, src string) *reporter {
+	if report == nil {
+		return nil
+	}
+	r := &reporter{report: report, src: preprocess(src)}
+	if file != nil {
+		r.file = file.String()
+	}
+	return r
+}
+
+// errorf reports an error at the source offset.
+func (r *reporter) errorf(offset int, format string, args ...interface{}) {
+	r.add(SeverityError, offset, fmt.Sprintf(format, args...))
+}
+
+// warnf reports a warning at the source offset.
+func (r *reporter) warnf(offset int, format string, args ...interface{}) {
+	r.add(SeverityWarning, offset, fmt.Sprintf(format, args...))
+}
+
+func (r *reporter) add(sev Severity, offset int, msg string) {
+	if r == nil {
+		return
+	}
+	if offset > len(r.src) {
+		offset = len(r.src)
+	}
+	d := Diagnostic{File: r.file, Line: 1, Column: 1, Message: msg, Severity: sev}
+	for _, c := range r.src[:offset] {
+		if c == '\n' {
+			d.Line++
+			d.Column = 1
+		} else {
+			d.Column++
+		}
+	}
+	r.report(d)
+}
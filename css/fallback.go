@@ -0,0 +1,216 @@
+package css
+
+import (
+	"image"
+	"sort"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// FallbackFamilies are the font families used, in order, for characters
+// which aren't in any of the families in an element's font-family list. If
+// none of them have a glyph for a character either, the first installed
+// family which does is used.
+//
+// They can be configured with LoadFontConfig.
+var FallbackFamilies = []string{
+	"sans-serif",
+	"Noto Sans",
+	"Noto Sans CJK SC",
+	"Noto Sans CJK JP",
+	"Noto Sans CJK KR",
+	"Noto Sans Symbols",
+	"Noto Sans Symbols2",
+	"Noto Emoji",
+	"Symbola",
+	"Droid Sans Fallback",
+	"WenQuanYi Zen Hei",
+	"Unifont",
+}
+
+// runeFamilies are the installed families found for characters which
+// aren't in any of the FallbackFamilies, by character. An empty string
+// means that none of the installed fonts have a glyph for it.
+var runeFamilies map[rune]string
+
+// findFontFace returns a face of size fsize for the first family in the
+// list which has a font available, or the default sans-serif font if there
+// aren't any. Characters which aren't in its font are drawn with the next
+// family in the list that has them, then with the FallbackFamilies.
+func findFontFace(family FontFamily, fsize int, weight font.Weight, style font.Style, stretch FontStretch) font.Face {
+	f := &fallbackFace{
+		fsize:   fsize,
+		weight:  weight,
+		style:   style,
+		stretch: stretch,
+		runes:   make(map[rune]font.Face),
+	}
+	families := family.Families()
+	for i, name := range families {
+		if face := familyFace(name, fsize, weight, style, stretch); face != nil {
+			f.primary = face
+			f.fallbacks = append(f.fallbacks, families[i+1:]...)
+			break
+		}
+	}
+	if f.primary == nil {
+		f.primary = familyFace("sans-serif", fsize, weight, style, stretch)
+	}
+	f.fallbacks = append(f.fallbacks, FallbackFamilies...)
+	return f
+}
+
+// A fallbackFace draws each character with the first of its faces that has
+// a glyph for it: the primary face, then the faces of the fallback families,
+// which are only loaded once a character is missing from the ones before
+// them, and then the face of an installed font with the glyph. If none of
+// them have it, the primary face is used. Its metrics are those of the
+// primary face.
+type fallbackFace struct {
+	primary   *rangeFace
+	fallbacks []string
+
+	// The faces of the fallback families which have been loaded so far,
+	// which are nil for families that aren't available.
+	faces []*rangeFace
+
+	fsize   int
+	weight  font.Weight
+	style   font.Style
+	stretch FontStretch
+
+	// The faces used for characters which aren't in the primary face.
+	runes map[rune]font.Face
+}
+
+// needsGlyph reports whether r is a visible character, which is drawn with
+// a fallback font if the primary font doesn't have it.
+func needsGlyph(r rune) bool {
+	return unicode.IsGraphic(r) && !unicode.IsSpace(r) && !unicode.Is(unicode.Variation_Selector, r)
+}
+
+// face returns the face used for r.
+func (f *fallbackFace) face(r rune) font.Face {
+	if !needsGlyph(r) || f.primary.has(r) {
+		return f.primary
+	}
+	if face, ok := f.runes[r]; ok {
+		return face
+	}
+	face := f.find(r)
+	f.runes[r] = face
+	return face
+}
+
+// find finds the face for r, which isn't in the primary face.
+func (f *fallbackFace) find(r rune) font.Face {
+	for i, name := range f.fallbacks {
+		if i == len(f.faces) {
+			f.faces = append(f.faces, familyFace(name, f.fsize, f.weight, f.style, f.stretch))
+		}
+		if face := f.faces[i]; face != nil && face.has(r) {
+			return face
+		}
+	}
+	if name := systemFallbackFamily(r); name != "" {
+		if face := familyFace(name, f.fsize, f.weight, f.style, f.stretch); face != nil && face.has(r) {
+			return face
+		}
+	}
+	return f.primary
+}
+
+// systemFallbackFamily returns the first installed family, in alphabetical
+// order, that has a glyph for r, or an empty string if there isn't one.
+// Every installed font may need to be loaded to find it, so the result is
+// remembered.
+func systemFallbackFamily(r rune) string {
+	if name, ok := runeFamilies[r]; ok {
+		return name
+	}
+	families := systemFonts()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	found := ""
+search:
+	for _, name := range names {
+		for _, f := range families[name] {
+			if f.covers(r) {
+				found = name
+				break search
+			}
+		}
+	}
+	runeFamilies[r] = found
+	return found
+}
+
+func (f *fallbackFace) Close() error {
+	f.primary.Close()
+	for _, face := range f.faces {
+		if face != nil {
+			face.Close()
+		}
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.face(r).Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.face(r).GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.face(r).GlyphAdvance(r)
+}
+
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	if face := f.face(r0); face == f.face(r1) {
+		return face.Kern(r0, r1)
+	}
+	return 0
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.primary.Metrics()
+}
+
+// TextMetrics returns the metrics of face for the text s. If some of the
+// characters in s are drawn with fallback fonts, because they're missing
+// from the face's own font, the metrics are the largest of the fonts used,
+// so that a line of the text is tall enough for all of them.
+func TextMetrics(face font.Face, s string) font.Metrics {
+	m := face.Metrics()
+	f, ok := face.(*fallbackFace)
+	if !ok {
+		return m
+	}
+	seen := map[font.Face]bool{f.primary: true}
+	for _, r := range s {
+		used := f.face(r)
+		if seen[used] {
+			continue
+		}
+		seen[used] = true
+		um := used.Metrics()
+		if um.Height > m.Height {
+			m.Height = um.Height
+		}
+		if um.Ascent > m.Ascent {
+			m.Ascent = um.Ascent
+		}
+		if um.Descent > m.Descent {
+			m.Descent = um.Descent
+		}
+	}
+	return m
+}
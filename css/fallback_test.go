@@ -0,0 +1,135 @@
+package css
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/driusan/fonts"
+	"golang.org/x/image/font"
+)
+
+// tallFont returns a copy of the TrueType font b with twice the ascent, so
+// that lines using it are taller.
+func tallFont(t *testing.T, b []byte) []byte {
+	t.Helper()
+	b = append([]byte(nil), b...)
+	numTables := int(binary.BigEndian.Uint16(b[4:]))
+	for i := 0; i < numTables; i++ {
+		entry := b[12+16*i:]
+		if string(entry[:4]) != "hhea" {
+			continue
+		}
+		ascender := b[binary.BigEndian.Uint32(entry[8:])+4:]
+		binary.BigEndian.PutUint16(ascender, 2*binary.BigEndian.Uint16(ascender))
+		return b
+	}
+	t.Fatal("Font has no hhea table")
+	return nil
+}
+
+func TestFontFallback(t *testing.T) {
+	serif, err := fonts.Asset("DejaVuSerif.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sans, err := fonts.Asset("DejaVuSans.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := fontLoader{
+		"/latin.ttf": serif,
+		"/tall.ttf":  tallFont(t, sans),
+	}
+	sheet, _ := ParseStylesheet(`
+@font-face { font-family: Latin; src: url(/latin.ttf); unicode-range: U+0-7F; }
+@font-face { font-family: Tall; src: url(/tall.ttf); }
+`, AuthorSrc, loader, &url.URL{Scheme: "https", Host: "example.com"}, 0)
+	LoadFontFaces(loader, NewFontFaces(MediaFeatures{}, sheet))
+	defer LoadFontFaces(loader, nil)
+
+	var e StyledElement
+	advance := func(family FontFamily, r rune) interface{} {
+		adv, _ := e.GetFontFace(16, family, font.WeightNormal, font.StyleNormal, FontStretchNormal).GlyphAdvance(r)
+		return adv
+	}
+	tests := []struct {
+		Family   FontFamily
+		Rune     rune
+		Expected FontFamily
+	}{
+		// Characters in the first family are drawn with it.
+		{`"Latin", "Tall"`, 'm', "Latin"},
+		// Characters outside of its unicode-range come from the next
+		// family in the list which has them..
+		{`"Latin", "Tall"`, 'é', "Tall"},
+		{`"Latin", "Missing", monospace`, 'é', "monospace"},
+		// ..or from the fallback families if none of them do.
+		{`"Latin"`, 'é', "sans-serif"},
+	}
+	for _, tc := range tests {
+		if got, want := advance(tc.Family, tc.Rune), advance(tc.Expected, tc.Rune); got != want {
+			t.Errorf("Unexpected face for %q in %v: advance is %v, want %v from %v", tc.Rune, tc.Family, got, want, tc.Expected)
+		}
+	}
+
+	// A character which is missing from a font, rather than outside of
+	// its range, falls back to another font too.
+	serifFont, _ := parseBuiltinFont("DejaVuSerif.ttf")
+	sansFont, _ := parseBuiltinFont("DejaVuSans.ttf")
+	var missing rune
+	for r := rune(0x100); r < 0x3000; r++ {
+		if needsGlyph(r) && serifFont.Index(r) == 0 && sansFont.Index(r) != 0 {
+			missing = r
+			break
+		}
+	}
+	if missing == 0 {
+		t.Fatal("Could not find a character in DejaVu Sans but not DejaVu Serif")
+	}
+	if got, want := advance("serif", missing), advance("sans-serif", missing); got != want {
+		t.Errorf("Unexpected face for %q in serif: advance is %v, want %v from sans-serif", missing, got, want)
+	}
+
+	// Lines are as tall as the tallest font used for their text.
+	face := e.GetFontFace(16, `"Latin", "Tall"`, font.WeightNormal, font.StyleNormal, FontStretchNormal)
+	latin := e.GetFontFace(16, "Latin", font.WeightNormal, font.StyleNormal, FontStretchNormal).Metrics()
+	tall := e.GetFontFace(16, "Tall", font.WeightNormal, font.StyleNormal, FontStretchNormal).Metrics()
+	if tall.Ascent <= latin.Ascent {
+		t.Fatalf("Expected the tall font to be taller: got %v, Latin is %v", tall.Ascent, latin.Ascent)
+	}
+	if got := TextMetrics(face, "abc"); got != latin {
+		t.Errorf("Unexpected metrics for Latin text: got %+v want %+v", got, latin)
+	}
+	if got := TextMetrics(face, "abé"); got.Ascent != tall.Ascent || got.Descent != latin.Descent {
+		t.Errorf("Unexpected metrics for text with a fallback: got ascent %v descent %v want %v %v", got.Ascent, got.Descent, tall.Ascent, latin.Descent)
+	}
+	if got := face.Metrics(); got != latin {
+		t.Errorf("Unexpected face metrics: got %+v want %+v", got, latin)
+	}
+}
+
+func TestFallbackFamilies(t *testing.T) {
+	f, err := ioutil.TempFile("", "gobfonts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`fallback: "Noto Sans CJK JP", Symbola, serif;`)
+	f.Close()
+
+	oldFallbacks := FallbackFamilies
+	defer func() {
+		FallbackFamilies = oldFallbacks
+		ClearFontCache()
+	}()
+	if err := LoadFontConfig(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Noto Sans CJK JP", "Symbola", "serif"}; !reflect.DeepEqual(FallbackFamilies, want) {
+		t.Errorf("Unexpected fallback families: got %v want %v", FallbackFamilies, want)
+	}
+}
@@ -0,0 +1,466 @@
+package css
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/driusan/gob/net"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+)
+
+// fontFacePrefix is the selector used for the StyleRules that hold @font-face
+// descriptors. Like counter style rules, it never matches an element.
+const fontFacePrefix = "@font-face"
+
+// A FontFace is a font defined by an @font-face rule.
+type FontFace struct {
+	Family  string
+	Sources []FontSource
+
+	// The range of weights, from 1 to 1000, that the font is used for.
+	MinWeight, MaxWeight int
+
+	// The range of widths that the font is used for.
+	MinStretch, MaxStretch FontStretch
+
+	Style font.Style
+
+	// The characters that the font is used for. If it's empty, it's
+	// used for every character.
+	UnicodeRange []UnicodeRange
+}
+
+// A FontSource is one of the places that a FontFace can be loaded from.
+type FontSource struct {
+	// The URL of the font, or nil for a locally installed font.
+	URL *url.URL
+
+	// The name of a locally installed font.
+	Local string
+
+	// The format from the format() hint, if there is one.
+	Format string
+}
+
+// A UnicodeRange is a range of characters, including First and Last.
+type UnicodeRange struct {
+	First, Last rune
+}
+
+// Contains reports whether r is in the range.
+func (u UnicodeRange) Contains(r rune) bool {
+	return r >= u.First && r <= u.Last
+}
+
+// parseFontFaceRule converts an @font-face rule into StyleRules, one for each
+// descriptor, which all share the same order number. The URLs in the src
+// descriptor are resolved against urlContext. It returns nil if the rule
+// doesn't have a valid font-family and src. Invalid descriptors are reported
+// to report.
+func parseFontFaceRule(r *AtRule, media []MediaQueryList, src StyleSource, orderNo uint, urlContext *url.URL, report *reporter) []StyleRule {
+	if r.Block == nil || len(trimWhitespace(r.Prelude)) != 0 {
+		report.errorf(r.Offset, "Invalid @font-face rule")
+		return nil
+	}
+	descriptors := make(map[StyleAttribute]string)
+	var names []StyleAttribute
+	for _, decl := range parseBlockDeclarations(r.Block, report) {
+		name := StyleAttribute(strings.ToLower(decl.Name))
+		var val string
+		ok := true
+		switch name {
+		case "font-family":
+			families := fontFamilies(decl.Value)
+			ok = len(families) == 1 && !isGenericFamily(families[0])
+			if ok {
+				val = strconv.Quote(families[0])
+			}
+		case "src":
+			sources := parseFontSources(decl.Value, urlContext)
+			ok = len(sources) > 0
+			val = serializeFontSources(sources)
+		case "font-weight":
+			_, _, ok = parseFontFaceWeight(decl.Value)
+			val = SerializeComponentValues(decl.Value)
+		case "font-style":
+			_, ok = parseFontFaceStyle(decl.Value)
+			val = SerializeComponentValues(decl.Value)
+		case "font-stretch":
+			_, _, ok = parseFontFaceStretch(decl.Value)
+			val = SerializeComponentValues(decl.Value)
+		case "unicode-range":
+			val = SerializeComponentValues(decl.Value)
+			_, ok = parseUnicodeRanges(val)
+		default:
+			// Other descriptors, such as font-display, don't
+			// affect how gob uses the font.
+			continue
+		}
+		if !ok {
+			report.errorf(decl.Offset, "Invalid value for @font-face descriptor %v: %v", name, SerializeComponentValues(decl.Value))
+			continue
+		}
+		if _, ok := descriptors[name]; !ok {
+			names = append(names, name)
+		}
+		descriptors[name] = val
+	}
+	if descriptors["font-family"] == "" || descriptors["src"] == "" {
+		report.errorf(r.Offset, "@font-face rule without a valid font-family and src")
+		return nil
+	}
+	var rules []StyleRule
+	for _, name := range names {
+		rules = append(rules, StyleRule{
+			Selector: CSSSelector{fontFacePrefix, orderNo},
+			Name:     name,
+			Value:    StyleValue{descriptors[name], false},
+			Src:      src,
+			Media:    media,
+		})
+	}
+	return rules
+}
+
+// NewFontFaces returns the fonts defined by @font-face rules in sheets whose
+// media queries match f, in the order that they're defined.
+func NewFontFaces(f MediaFeatures, sheets ...Stylesheet) []*FontFace {
+	var faces []*FontFace
+	var orders []uint
+	byOrder := make(map[uint]*FontFace)
+	for _, sheet := range sheets {
+		for _, rule := range sheet {
+			if rule.Selector.Selector != fontFacePrefix || !rule.MediaMatches(f) {
+				continue
+			}
+			face, ok := byOrder[rule.Selector.OrderNumber]
+			if !ok {
+				face = &FontFace{
+					MinWeight:  400,
+					MaxWeight:  400,
+					MinStretch: FontStretchNormal,
+					MaxStretch: FontStretchNormal,
+					Style:      font.StyleNormal,
+				}
+				byOrder[rule.Selector.OrderNumber] = face
+				orders = append(orders, rule.Selector.OrderNumber)
+			}
+			vals := trimWhitespace(ParseComponentValues(rule.Value.Value))
+			switch rule.Name {
+			case "font-family":
+				if families := fontFamilies(vals); len(families) == 1 {
+					face.Family = families[0]
+				}
+			case "src":
+				face.Sources = parseFontSources(vals, nil)
+			case "font-weight":
+				face.MinWeight, face.MaxWeight, _ = parseFontFaceWeight(vals)
+			case "font-style":
+				face.Style, _ = parseFontFaceStyle(vals)
+			case "font-stretch":
+				face.MinStretch, face.MaxStretch, _ = parseFontFaceStretch(vals)
+			case "unicode-range":
+				face.UnicodeRange, _ = parseUnicodeRanges(rule.Value.Value)
+			}
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i] < orders[j] })
+	for _, o := range orders {
+		faces = append(faces, byOrder[o])
+	}
+	return faces
+}
+
+// fontFamilies returns the names of the font families in the comma separated
+// list vals. Each family is a string or a sequence of identifiers, which are
+// joined by a single space. It returns nil if the list is invalid.
+func fontFamilies(vals []ComponentValue) []string {
+	var families []string
+	for _, family := range splitOnCommas(vals) {
+		family = nonWhitespace(family)
+		if len(family) == 1 && tokenType(family[0]) == StringToken {
+			families = append(families, family[0].(Token).Value)
+			continue
+		}
+		var words []string
+		for _, v := range family {
+			if !isIdent(v) {
+				return nil
+			}
+			words = append(words, v.(Token).Value)
+		}
+		if len(words) == 0 {
+			return nil
+		}
+		families = append(families, strings.Join(words, " "))
+	}
+	return families
+}
+
+// isGenericFamily reports whether name is one of the generic font family
+// keywords.
+func isGenericFamily(name string) bool {
+	switch strings.ToLower(name) {
+	case "serif", "sans-serif", "monospace", "cursive", "fantasy", "system-ui":
+		return true
+	}
+	return false
+}
+
+// parseFontSources parses the value of an src descriptor. Sources which are
+// invalid are skipped. Relative URLs are resolved against base, if it's not
+// nil.
+func parseFontSources(vals []ComponentValue, base *url.URL) []FontSource {
+	var sources []FontSource
+	for _, item := range splitOnCommas(vals) {
+		item = nonWhitespace(item)
+		if len(item) == 0 {
+			continue
+		}
+		var src FontSource
+		switch {
+		case isURL(item[0]):
+			href := ""
+			if t, ok := item[0].(Token); ok {
+				href = t.Value
+			} else {
+				href = trimWhitespace(item[0].(*Function).Value)[0].(Token).Value
+			}
+			u, err := url.Parse(href)
+			if err != nil {
+				continue
+			}
+			if base != nil {
+				u = base.ResolveReference(u)
+			}
+			src.URL = u
+			if len(item) > 1 {
+				f, ok := item[1].(*Function)
+				if !ok || !strings.EqualFold(f.Name, "format") {
+					continue
+				}
+				args := nonWhitespace(f.Value)
+				if len(args) != 1 || (tokenType(args[0]) != StringToken && tokenType(args[0]) != IdentToken) {
+					continue
+				}
+				src.Format = strings.ToLower(args[0].(Token).Value)
+				// tech() hints are ignored.
+			}
+		case len(item) == 1:
+			f, ok := item[0].(*Function)
+			if !ok || !strings.EqualFold(f.Name, "local") {
+				continue
+			}
+			names := fontFamilies(f.Value)
+			if len(names) != 1 {
+				continue
+			}
+			src.Local = names[0]
+		default:
+			continue
+		}
+		sources = append(sources, src)
+	}
+	return sources
+}
+
+// serializeFontSources converts sources back into the value of an src
+// descriptor.
+func serializeFontSources(sources []FontSource) string {
+	var items []string
+	for _, src := range sources {
+		if src.URL == nil {
+			items = append(items, "local("+serializeString(src.Local)+")")
+			continue
+		}
+		item := "url(" + serializeString(src.URL.String()) + ")"
+		if src.Format != "" {
+			item += " format(" + serializeString(src.Format) + ")"
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ", ")
+}
+
+// parseFontFaceWeight parses the font-weight descriptor, which is a single
+// weight or a range of weights.
+func parseFontFaceWeight(vals []ComponentValue) (min, max int, ok bool) {
+	vals = nonWhitespace(vals)
+	if len(vals) < 1 || len(vals) > 2 {
+		return 400, 400, false
+	}
+	var weights []int
+	for _, v := range vals {
+		switch identValue(v) {
+		case "normal":
+			weights = append(weights, 400)
+			continue
+		case "bold":
+			weights = append(weights, 700)
+			continue
+		}
+		t, ok := v.(Token)
+		if !ok || t.Type != NumberToken || t.Number < 1 || t.Number > 1000 {
+			return 400, 400, false
+		}
+		weights = append(weights, int(t.Number))
+	}
+	min, max = weights[0], weights[len(weights)-1]
+	if min > max {
+		min, max = max, min
+	}
+	return min, max, true
+}
+
+// parseFontFaceStretch parses the font-stretch descriptor, which is a single
+// width or a range of widths.
+func parseFontFaceStretch(vals []ComponentValue) (min, max FontStretch, ok bool) {
+	vals = nonWhitespace(vals)
+	if len(vals) < 1 || len(vals) > 2 {
+		return FontStretchNormal, FontStretchNormal, false
+	}
+	var widths []FontStretch
+	for _, v := range vals {
+		stretch, ok := parseFontStretch(v)
+		if !ok {
+			return FontStretchNormal, FontStretchNormal, false
+		}
+		widths = append(widths, stretch)
+	}
+	min, max = widths[0], widths[len(widths)-1]
+	if min > max {
+		min, max = max, min
+	}
+	return min, max, true
+}
+
+// parseFontFaceStyle parses the font-style descriptor. The angles of
+// oblique are ignored.
+func parseFontFaceStyle(vals []ComponentValue) (font.Style, bool) {
+	vals = nonWhitespace(vals)
+	if len(vals) == 0 {
+		return font.StyleNormal, false
+	}
+	switch identValue(vals[0]) {
+	case "normal":
+		return font.StyleNormal, len(vals) == 1
+	case "italic":
+		return font.StyleItalic, len(vals) == 1
+	case "oblique":
+		return font.StyleOblique, len(vals) <= 3
+	}
+	return font.StyleNormal, false
+}
+
+// parseUnicodeRanges parses the value of a unicode-range descriptor, such as
+// "U+0-7F, U+4??". It's parsed from the serialized value, since the
+// tokenizer doesn't produce unicode-range tokens.
+func parseUnicodeRanges(val string) ([]UnicodeRange, bool) {
+	var ranges []UnicodeRange
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) < 3 || (item[0] != 'U' && item[0] != 'u') || item[1] != '+' {
+			return nil, false
+		}
+		item = item[2:]
+		var first, last string
+		if i := strings.IndexByte(item, '-'); i >= 0 {
+			first, last = item[:i], item[i+1:]
+		} else if strings.HasSuffix(item, "?") {
+			first = strings.Replace(item, "?", "0", -1)
+			last = strings.Replace(item, "?", "F", -1)
+		} else {
+			first, last = item, item
+		}
+		lo, err1 := strconv.ParseUint(first, 16, 32)
+		hi, err2 := strconv.ParseUint(last, 16, 32)
+		if err1 != nil || err2 != nil || len(first) > 6 || len(last) > 6 || lo > hi || hi > 0x10FFFF {
+			return nil, false
+		}
+		ranges = append(ranges, UnicodeRange{rune(lo), rune(hi)})
+	}
+	return ranges, len(ranges) > 0
+}
+
+// webFonts are the fonts loaded from @font-face rules, by lower case family
+// name, in the order that they're defined.
+var webFonts map[string][]availableFont
+
+// LoadFontFaces loads faces with loader, so that they're used for their
+// families by GetFontFace, replacing any fonts that were previously loaded.
+// Faces which can't be loaded from any of their sources are ignored.
+func LoadFontFaces(loader net.URLReader, faces []*FontFace) {
+	webFonts = make(map[string][]availableFont)
+	for _, face := range faces {
+		if face.Family == "" {
+			continue
+		}
+		f, err := face.Load(loader)
+		if err != nil {
+			continue
+		}
+		family := strings.ToLower(face.Family)
+		available := sfntFont(face.MinWeight, face.MaxWeight, face.Style, func() (*sfnt.Font, error) {
+			return f, nil
+		})
+		available.minStretch, available.maxStretch = face.MinStretch, face.MaxStretch
+		available.unicodeRange = face.UnicodeRange
+		webFonts[family] = append(webFonts[family], available)
+	}
+	ClearFontCache()
+}
+
+// Load loads the font from the first of its sources that's in a supported
+// format and can be loaded, either from a URL with loader or from the
+// locally installed fonts.
+func (f *FontFace) Load(loader net.URLReader) (*sfnt.Font, error) {
+	err := errors.New("No supported sources")
+	for _, src := range f.Sources {
+		if src.URL == nil {
+			if local, ok := localFonts()[strings.ToLower(src.Local)]; ok {
+				var ft *sfnt.Font
+				if ft, err = local.load(); err == nil {
+					return ft, nil
+				}
+			}
+			continue
+		}
+		switch src.Format {
+		case "", "woff", "woff2", "truetype", "opentype":
+		default:
+			continue
+		}
+		var ft *sfnt.Font
+		if ft, err = loadFont(loader, src.URL); err == nil {
+			return ft, nil
+		}
+	}
+	return nil, err
+}
+
+// loadFont loads the TrueType, OpenType, WOFF or WOFF2 font at u.
+func loadFont(loader net.URLReader, u *url.URL) (*sfnt.Font, error) {
+	r, resp, err := loader.GetURL(u)
+	if err != nil {
+		return nil, err
+	}
+	if r != nil {
+		defer r.Close()
+	}
+	if resp < 200 || resp >= 300 {
+		return nil, errors.New("Could not load font " + u.String())
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = decodeFont(b); err != nil {
+		return nil, err
+	}
+	return sfnt.Parse(b)
+}
@@ -0,0 +1,209 @@
+package css
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/driusan/fonts"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+// fontLoader serves the fonts in the map by URL path.
+type fontLoader map[string][]byte
+
+func (l fontLoader) GetURL(u *url.URL) (io.ReadCloser, int, error) {
+	b, ok := l[u.Path]
+	if !ok {
+		return ioutil.NopCloser(&bytes.Buffer{}), 404, nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), 200, nil
+}
+
+func (l fontLoader) HasVisited(u *url.URL) bool {
+	return false
+}
+
+func TestFontFaceRules(t *testing.T) {
+	base, _ := url.Parse("https://example.com/css/site.css")
+	var errors []int
+	sheet, _ := ParseStylesheetWithDiagnostics(`@font-face {
+	font-family: "Brand Sans";
+	src: local(Brand Sans), url(brand.woff2) format("woff2"), url("/fonts/brand.woff") format(woff), url(brand.eot) format("embedded-opentype");
+	font-weight: 300 700;
+	font-style: italic;
+	font-stretch: semi-expanded 75%;
+	unicode-range: U+0025-00FF, u+4??, U+1e00-1eff;
+	font-display: swap;
+}
+@font-face { font-family: Missing Src; }
+@font-face { font-family: serif; src: url(serif.ttf); }
+@font-face { font-family: Brand  Sans; src: url(bold.ttf); font-weight: bold; font-style: oblique 10deg; }
+@media print {
+	@font-face { font-family: Print; src: url(print.ttf); }
+}
+`, AuthorSrc, noopURLer{}, base, 0, func(d Diagnostic) {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.Line)
+		}
+	})
+	if !reflect.DeepEqual(errors, []int{10, 11, 11}) {
+		t.Errorf("Unexpected errors on lines %v", errors)
+	}
+
+	resolve := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	want := []*FontFace{
+		{
+			Family: "Brand Sans",
+			Sources: []FontSource{
+				{Local: "Brand Sans"},
+				{URL: resolve("https://example.com/css/brand.woff2"), Format: "woff2"},
+				{URL: resolve("https://example.com/fonts/brand.woff"), Format: "woff"},
+				{URL: resolve("https://example.com/css/brand.eot"), Format: "embedded-opentype"},
+			},
+			MinWeight:    300,
+			MaxWeight:    700,
+			MinStretch:   75,
+			MaxStretch:   112.5,
+			Style:        font.StyleItalic,
+			UnicodeRange: []UnicodeRange{{0x25, 0xFF}, {0x400, 0x4FF}, {0x1E00, 0x1EFF}},
+		},
+		{
+			Family:     "Brand Sans",
+			Sources:    []FontSource{{URL: resolve("https://example.com/css/bold.ttf")}},
+			MinWeight:  700,
+			MaxWeight:  700,
+			MinStretch: FontStretchNormal,
+			MaxStretch: FontStretchNormal,
+			Style:      font.StyleOblique,
+		},
+	}
+	if got := NewFontFaces(MediaFeatures{Type: "screen"}, sheet); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected font faces:")
+		for _, f := range got {
+			t.Errorf("\tgot %+v", *f)
+		}
+		for _, f := range want {
+			t.Errorf("\twant %+v", *f)
+		}
+	}
+	if got := NewFontFaces(MediaFeatures{Type: "print"}, sheet); len(got) != 3 || got[2].Family != "Print" {
+		t.Errorf("Expected the print font face with print media")
+	}
+}
+
+func TestUnicodeRanges(t *testing.T) {
+	tests := []struct {
+		Val      string
+		Expected []UnicodeRange
+	}{
+		{"U+26", []UnicodeRange{{0x26, 0x26}}},
+		{"U+0-7F", []UnicodeRange{{0, 0x7F}}},
+		{"U+0025-00FF, U+4??", []UnicodeRange{{0x25, 0xFF}, {0x400, 0x4FF}}},
+		{"u+10FFFF", []UnicodeRange{{0x10FFFF, 0x10FFFF}}},
+		{"U+110000", nil},
+		{"U+FF-00", nil},
+		{"U+?4", nil},
+		{"0-7F", nil},
+	}
+	for _, tc := range tests {
+		got, _ := parseUnicodeRanges(tc.Val)
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Errorf("Unexpected ranges for %q: got %v want %v", tc.Val, got, tc.Expected)
+		}
+	}
+}
+
+func TestWebFonts(t *testing.T) {
+	load := func(name string) []byte {
+		b, err := fonts.Asset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// A serif font is used for italic, so that it's easy to tell apart.
+	regular, bold, italic := load("DejaVuSans.ttf"), load("DejaVuSans-Bold.ttf"), load("DejaVuSerif.ttf")
+	loader := fontLoader{
+		"/regular.ttf": regular,
+		"/bold.woff":   encodeWOFF(bold),
+		"/italic.woff": encodeWOFF(italic),
+		"/broken.ttf":  []byte("not a font"),
+	}
+	sheet, _ := ParseStylesheet(`
+@font-face { font-family: Brand; src: url(/regular.ttf); }
+@font-face { font-family: Brand; src: url(/bold.woff) format("woff"); font-weight: 600 900; }
+@font-face { font-family: Brand; src: url(/missing.woff2), url(/italic.woff); font-style: italic; }
+@font-face { font-family: Broken; src: url(/broken.ttf); }
+@font-face { font-family: Ranged; src: url(/bold.woff); }
+@font-face { font-family: Ranged; src: url(/regular.ttf); unicode-range: U+0-7F; }
+`, AuthorSrc, loader, &url.URL{Scheme: "https", Host: "example.com"}, 0)
+	LoadFontFaces(loader, NewFontFaces(MediaFeatures{}, sheet))
+	defer LoadFontFaces(loader, nil)
+
+	// The fonts are told apart by the advance of "m", which is
+	// different in each of them.
+	advance := func(b []byte, r rune) interface{} {
+		f, err := sfnt.Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: 16 / PixelsPerPt, DPI: PixelsPerPt * 72, Hinting: font.HintingFull})
+		if err != nil {
+			t.Fatal(err)
+		}
+		adv, _ := face.GlyphAdvance(r)
+		return adv
+	}
+	tests := []struct {
+		Family   FontFamily
+		Weight   font.Weight
+		Style    font.Style
+		Rune     rune
+		Expected []byte
+	}{
+		{"Brand", font.WeightNormal, font.StyleNormal, 'm', regular},
+		{"brand", font.WeightNormal, font.StyleNormal, 'm', regular},
+		{"Brand", font.WeightMedium, font.StyleNormal, 'm', regular},
+		{"Brand", font.WeightSemiBold, font.StyleNormal, 'm', bold},
+		{"Brand", font.WeightBold, font.StyleNormal, 'm', bold},
+		{"Brand", font.WeightLight, font.StyleNormal, 'm', regular},
+		{"Brand", font.WeightNormal, font.StyleItalic, 'm', italic},
+		{"Brand", font.WeightNormal, font.StyleOblique, 'm', italic},
+		{"Ranged", font.WeightNormal, font.StyleNormal, 'm', regular},
+		{"Ranged", font.WeightNormal, font.StyleNormal, 'é', bold},
+	}
+	var e StyledElement
+	for _, tc := range tests {
+		face := e.GetFontFace(16, tc.Family, tc.Weight, tc.Style, FontStretchNormal)
+		got, _ := face.GlyphAdvance(tc.Rune)
+		if want := advance(tc.Expected, tc.Rune); got != want {
+			t.Errorf("Unexpected face for %v %v %v: advance of %q is %v, want %v", tc.Family, tc.Weight, tc.Style, tc.Rune, got, want)
+		}
+	}
+
+	// Fonts that aren't available are skipped in a list of families.
+	families := []struct {
+		Family   FontFamily
+		Expected FontFamily
+	}{
+		{`"Unknown", "Brand", serif`, "Brand"},
+		{`"Broken", serif`, "serif"},
+		{`"Unknown"`, "sans-serif"},
+	}
+	for _, tc := range families {
+		got, _ := e.GetFontFace(16, tc.Family, font.WeightNormal, font.StyleNormal, FontStretchNormal).GlyphAdvance('m')
+		want, _ := e.GetFontFace(16, tc.Expected, font.WeightNormal, font.StyleNormal, FontStretchNormal).GlyphAdvance('m')
+		if got != want {
+			t.Errorf("Unexpected face for %v: advance of 'm' is %v, want %v from %v", tc.Family, got, want, tc.Expected)
+		}
+	}
+}
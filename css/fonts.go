@@ -0,0 +1,542 @@
+package css
+
+import (
+	"image"
+	"io/ioutil"
+	"strings"
+
+	"github.com/driusan/fonts"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// An availableFont is a font that can be used for a font family, along with
+// the descriptors used to choose between the fonts of the family.
+type availableFont struct {
+	// The range of weights, from 1 to 1000, that the font is used for.
+	minWeight, maxWeight int
+
+	// The range of widths that the font is used for.
+	minStretch, maxStretch FontStretch
+
+	style font.Style
+
+	// The characters that the font is used for. If it's empty, it's
+	// used for every character.
+	unicodeRange []UnicodeRange
+
+	// newFace returns a face for the font with a size of fsize pixels.
+	newFace func(fsize int) (font.Face, error)
+
+	// covers reports whether the font has a glyph for r.
+	covers func(r rune) bool
+}
+
+// GenericFamilies are the font families used for each generic font family,
+// in order of preference, such as "Liberation Serif" for serif. They can be
+// the names of web fonts, the DejaVu fonts embedded in gob or locally
+// installed fonts. If none of them are available, or there aren't any, the
+// embedded DejaVu font for the generic family is used.
+//
+// The families can be configured with LoadFontConfig.
+var GenericFamilies = map[string][]string{}
+
+// defaultGenericFamilies are the embedded families used for the generic
+// families when none of the families in GenericFamilies are available.
+var defaultGenericFamilies = map[string]string{
+	"serif":      "dejavu serif",
+	"sans-serif": "dejavu sans",
+	"monospace":  "dejavu sans mono",
+	// The DejaVu fonts don't have cursive or fantasy fonts.
+	"cursive":   "dejavu sans",
+	"fantasy":   "dejavu sans",
+	"system-ui": "dejavu sans",
+}
+
+// builtinFonts are the DejaVu fonts that are embedded in gob, by lower case
+// family name.
+var builtinFonts = map[string][]availableFont{
+	"dejavu sans": {
+		builtinFont("DejaVuSans.ttf", 400, font.StyleNormal),
+		builtinFont("DejaVuSans-ExtraLight.ttf", 200, font.StyleNormal),
+		builtinFont("DejaVuSans-Bold.ttf", 700, font.StyleNormal),
+		builtinFont("DejaVuSans-Oblique.ttf", 400, font.StyleOblique),
+		builtinFont("DejaVuSans-BoldOblique.ttf", 700, font.StyleOblique),
+	},
+	"dejavu serif": {
+		builtinFont("DejaVuSerif.ttf", 400, font.StyleNormal),
+		builtinFont("DejaVuSerif-Bold.ttf", 700, font.StyleNormal),
+		builtinFont("DejaVuSerif-Italic.ttf", 400, font.StyleItalic),
+		builtinFont("DejaVuSerif-BoldItalic.ttf", 700, font.StyleItalic),
+	},
+	"dejavu sans mono": {
+		builtinFont("DejaVuSansMono.ttf", 400, font.StyleNormal),
+		builtinFont("DejaVuSansMono-Bold.ttf", 700, font.StyleNormal),
+		builtinFont("DejaVuSansMono-Oblique.ttf", 400, font.StyleOblique),
+		builtinFont("DejaVuSansMono-BoldOblique.ttf", 700, font.StyleOblique),
+	},
+}
+
+// builtinFont returns the embedded font in the file ttfFile.
+func builtinFont(ttfFile string, weight int, style font.Style) availableFont {
+	return availableFont{
+		minWeight:  weight,
+		maxWeight:  weight,
+		minStretch: FontStretchNormal,
+		maxStretch: FontStretchNormal,
+		style:      style,
+		newFace: func(fsize int) (font.Face, error) {
+			ft, err := parseBuiltinFont(ttfFile)
+			if err != nil {
+				return nil, err
+			}
+			return truetype.NewFace(ft,
+				&truetype.Options{
+					Size:    float64(fsize) / PixelsPerPt,
+					DPI:     PixelsPerPt * 72,
+					Hinting: font.HintingFull}), nil
+		},
+		covers: func(r rune) bool {
+			ft, err := parseBuiltinFont(ttfFile)
+			return err == nil && ft.Index(r) != 0
+		},
+	}
+}
+
+// sfntFont returns the TrueType or OpenType font returned by load, which
+// isn't called until the font is used. It's used for fonts of a normal
+// width unless its stretch range is changed.
+func sfntFont(minWeight, maxWeight int, style font.Style, load func() (*sfnt.Font, error)) availableFont {
+	var buf sfnt.Buffer
+	return availableFont{
+		minWeight:  minWeight,
+		maxWeight:  maxWeight,
+		minStretch: FontStretchNormal,
+		maxStretch: FontStretchNormal,
+		style:      style,
+		newFace: func(fsize int) (font.Face, error) {
+			ft, err := load()
+			if err != nil {
+				return nil, err
+			}
+			return opentype.NewFace(ft, &opentype.FaceOptions{
+				Size:    float64(fsize) / PixelsPerPt,
+				DPI:     PixelsPerPt * 72,
+				Hinting: font.HintingFull,
+			})
+		},
+		covers: func(r rune) bool {
+			ft, err := load()
+			if err != nil {
+				return false
+			}
+			idx, err := ft.GlyphIndex(&buf, r)
+			return err == nil && idx != 0
+		},
+	}
+}
+
+// parseBuiltinFont parses the embedded font in the file ttfFile, or returns
+// it from parsedFontCache if it's already been parsed.
+func parseBuiltinFont(ttfFile string) (*truetype.Font, error) {
+	if ft, ok := parsedFontCache[ttfFile]; ok {
+		return ft, nil
+	}
+	fontBytes, err := fonts.Asset(ttfFile)
+	if err != nil {
+		return nil, err
+	}
+	ft, err := truetype.Parse(fontBytes)
+	if err != nil {
+		return nil, err
+	}
+	parsedFontCache[ttfFile] = ft
+	return ft, nil
+}
+
+// LoadFontConfig sets GenericFamilies and FallbackFamilies from the font
+// configuration file at path, which has a declaration with a list of
+// families for each generic family that's configured, and for the fallback
+// families, such as:
+//
+//	serif: "Liberation Serif", "DejaVu Serif";
+//	monospace: Hack;
+//	fallback: sans-serif, "Noto Sans CJK JP", Symbola;
+//
+// Families which aren't in the file are left unchanged, and anything else
+// in it is ignored.
+func LoadFontConfig(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, d := range parseDeclarations(string(b), nil) {
+		name := strings.ToLower(d.Name)
+		families := fontFamilies(d.Value)
+		switch {
+		case families == nil:
+		case name == "fallback":
+			FallbackFamilies = families
+		case isGenericFamily(name):
+			GenericFamilies[name] = families
+		}
+	}
+	ClearFontCache()
+	return nil
+}
+
+// Families returns the names of the families in the list.
+func (f FontFamily) Families() []string {
+	return fontFamilies(ParseComponentValues(string(f)))
+}
+
+// computeFontFamily returns the font-family list val, with the family names
+// quoted and the generic families in lower case, or parent if val isn't a
+// valid list.
+func computeFontFamily(val string, parent FontFamily) FontFamily {
+	families := fontFamilies(ParseComponentValues(val))
+	if len(families) == 0 {
+		return parent
+	}
+	for i, family := range families {
+		if isGenericFamily(family) {
+			families[i] = strings.ToLower(family)
+		} else {
+			families[i] = serializeString(family)
+		}
+	}
+	return FontFamily(strings.Join(families, ", "))
+}
+
+// familyFace returns a face of size fsize for the font family name, or nil
+// if the family doesn't have any fonts available.
+func familyFace(name string, fsize int, weight font.Weight, style font.Style, stretch FontStretch) *rangeFace {
+	name = strings.ToLower(name)
+	if !isGenericFamily(name) {
+		return matchFont(familyFonts(name), fsize, weight, style, stretch)
+	}
+	for _, family := range GenericFamilies[name] {
+		if isGenericFamily(family) {
+			continue
+		}
+		if face := matchFont(familyFonts(family), fsize, weight, style, stretch); face != nil {
+			return face
+		}
+	}
+	return matchFont(builtinFonts[defaultGenericFamilies[name]], fsize, weight, style, stretch)
+}
+
+// familyFonts returns the fonts available for the family name, which isn't
+// a generic family. Web fonts take precedence over the embedded fonts,
+// which take precedence over locally installed fonts.
+func familyFonts(name string) []availableFont {
+	name = strings.ToLower(name)
+	if available, ok := webFonts[name]; ok {
+		return available
+	}
+	if available, ok := builtinFonts[name]; ok {
+		return available
+	}
+	return systemFonts()[name]
+}
+
+// smallCapsFamily returns the name of the small-caps family of the first
+// family in the list which has fonts available, or an empty string if it
+// doesn't have one. Generic families don't have small-caps families.
+func smallCapsFamily(family FontFamily) string {
+	for _, name := range family.Families() {
+		if isGenericFamily(name) {
+			return ""
+		}
+		if len(familyFonts(name)) == 0 {
+			continue
+		}
+		for _, suffix := range []string{" SC", " Small Caps"} {
+			if len(familyFonts(name+suffix)) > 0 {
+				return name + suffix
+			}
+		}
+		return ""
+	}
+	return ""
+}
+
+// cssWeight converts w to a numeric CSS font weight.
+func cssWeight(w font.Weight) int {
+	return 400 + 100*int(w)
+}
+
+// fontWeight converts the numeric CSS font weight w, from 1 to 1000, to the
+// nearest font.Weight, which are multiples of 100 from 100 to 900.
+func fontWeight(w int) font.Weight {
+	w = (w + 50) / 100
+	if w < 1 {
+		w = 1
+	} else if w > 9 {
+		w = 9
+	}
+	return font.Weight(w - 4)
+}
+
+// A FontStretch is the computed value of the font-stretch property, which is
+// a width as a percentage of the normal width of a font.
+type FontStretch float64
+
+const FontStretchNormal FontStretch = 100
+
+// fontStretchKeywords are the widths of the font-stretch keywords.
+var fontStretchKeywords = map[string]FontStretch{
+	"ultra-condensed": 50,
+	"extra-condensed": 62.5,
+	"condensed":       75,
+	"semi-condensed":  87.5,
+	"normal":          100,
+	"semi-expanded":   112.5,
+	"expanded":        125,
+	"extra-expanded":  150,
+	"ultra-expanded":  200,
+}
+
+// parseFontStretch parses a font-stretch keyword or a non-negative
+// percentage.
+func parseFontStretch(v ComponentValue) (FontStretch, bool) {
+	if stretch, ok := fontStretchKeywords[identValue(v)]; ok {
+		return stretch, true
+	}
+	if t, ok := v.(Token); ok && t.Type == PercentageToken && t.Number >= 0 {
+		return FontStretch(t.Number), true
+	}
+	return 0, false
+}
+
+// isFontStretch reports whether v is a valid value for font-stretch.
+func isFontStretch(v ComponentValue) bool {
+	_, ok := parseFontStretch(v)
+	return ok
+}
+
+// computeFontStretch returns the font-stretch val, or parent if it isn't
+// valid.
+func computeFontStretch(val string, parent FontStretch) FontStretch {
+	vals := trimWhitespace(ParseComponentValues(val))
+	if len(vals) != 1 {
+		return parent
+	}
+	if stretch, ok := parseFontStretch(vals[0]); ok {
+		return stretch
+	}
+	return parent
+}
+
+// matchFont returns a face of size fsize for the fonts of a family which
+// best match weight, style and stretch, or nil if there aren't any fonts.
+// The font matching algorithm from CSS Fonts Level 4 is used: stretch is
+// matched first, then style, then weight. If more than one font has the best
+// match, which is the case when they have different unicode ranges, they're
+// combined into a single face. If the fonts are lighter than a bold weight,
+// or upright when italic or oblique is wanted, the glyphs are made bolder or
+// slanted.
+func matchFont(available []availableFont, fsize int, weight font.Weight, style font.Style, stretch FontStretch) *rangeFace {
+	if len(available) == 0 {
+		return nil
+	}
+	w := cssWeight(weight)
+	rank := func(f availableFont) [5]int {
+		stretchTier, stretchDist := stretchRank(stretch, f.minStretch, f.maxStretch)
+		weightTier, weightDist := weightRank(w, f.minWeight, f.maxWeight)
+		return [5]int{stretchTier, stretchDist, styleRank(style, f.style), weightTier, weightDist}
+	}
+	less := func(a, b [5]int) bool {
+		for i := range a {
+			if a[i] != b[i] {
+				return a[i] < b[i]
+			}
+		}
+		return false
+	}
+	best := rank(available[0])
+	for _, f := range available[1:] {
+		if r := rank(f); less(r, best) {
+			best = r
+		}
+	}
+
+	faces := &rangeFace{}
+	// Fonts which are defined later take precedence.
+	for i := len(available) - 1; i >= 0; i-- {
+		f := available[i]
+		if rank(f) != best {
+			continue
+		}
+		face, err := f.newFace(fsize)
+		if err != nil {
+			continue
+		}
+		bold := w >= syntheticBoldWeight && f.maxWeight < syntheticBoldWeight
+		oblique := style != font.StyleNormal && f.style == font.StyleNormal
+		faces.faces = append(faces.faces, synthesize(face, fsize, bold, oblique))
+		faces.fonts = append(faces.fonts, f)
+	}
+	if len(faces.faces) == 0 {
+		return nil
+	}
+	return faces
+}
+
+// styleRank ranks how well a font with the style have matches the style
+// want, with 0 being the best.
+func styleRank(want, have font.Style) int {
+	var order [3]font.Style
+	switch want {
+	case font.StyleItalic:
+		order = [3]font.Style{font.StyleItalic, font.StyleOblique, font.StyleNormal}
+	case font.StyleOblique:
+		order = [3]font.Style{font.StyleOblique, font.StyleItalic, font.StyleNormal}
+	default:
+		order = [3]font.Style{font.StyleNormal, font.StyleOblique, font.StyleItalic}
+	}
+	for i, s := range order {
+		if s == have {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// stretchRank ranks how well a font for the widths min to max matches the
+// width want. Fonts with a lower tier are better, and within a tier, fonts
+// with a lower distance are better. Narrower fonts are preferred for
+// condensed widths, and wider fonts for expanded ones.
+func stretchRank(want, min, max FontStretch) (tier, dist int) {
+	// The distance is in tenths of a percent, so that it can be compared
+	// as an integer.
+	distance := func(a, b FontStretch) int {
+		return int((a - b) * 10)
+	}
+	switch {
+	case want >= min && want <= max:
+		return 0, 0
+	case want <= FontStretchNormal:
+		if max < want {
+			return 1, distance(want, max)
+		}
+		return 2, distance(min, want)
+	default:
+		if min > want {
+			return 1, distance(min, want)
+		}
+		return 2, distance(want, max)
+	}
+}
+
+// weightRank ranks how well a font for the weights min to max matches the
+// weight w. Fonts with a lower tier are better, and within a tier, fonts
+// with a lower distance are better.
+func weightRank(w, min, max int) (tier, dist int) {
+	switch {
+	case w >= min && w <= max:
+		return 0, 0
+	case w >= 400 && w <= 500:
+		// Heavier weights up to 500 are checked first, then lighter
+		// weights, then weights over 500.
+		if min > w && min <= 500 {
+			return 1, min - w
+		}
+		if max < w {
+			return 2, w - max
+		}
+		return 3, min - w
+	case w < 400:
+		if max < w {
+			return 1, w - max
+		}
+		return 2, min - w
+	default:
+		if min > w {
+			return 1, min - w
+		}
+		return 2, w - max
+	}
+}
+
+// A rangeFace is the face for the fonts of a family which best match a
+// weight and style. There's more than one font when they have different
+// unicode ranges. Each character is drawn with the first font whose range
+// includes it and which has a glyph for it, and the metrics are those of
+// the first font.
+type rangeFace struct {
+	faces []font.Face
+	fonts []availableFont
+}
+
+// inRange reports whether r is in the unicode range of the font i.
+func (f *rangeFace) inRange(i int, r rune) bool {
+	ranges := f.fonts[i].unicodeRange
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, rng := range ranges {
+		if rng.Contains(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// has reports whether any of the fonts has a glyph for r in its range.
+func (f *rangeFace) has(r rune) bool {
+	for i, ft := range f.fonts {
+		if f.inRange(i, r) && ft.covers(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// face returns the face used for r.
+func (f *rangeFace) face(r rune) font.Face {
+	for i, ft := range f.fonts {
+		if f.inRange(i, r) && ft.covers(r) {
+			return f.faces[i]
+		}
+	}
+	for i := range f.fonts {
+		if f.inRange(i, r) {
+			return f.faces[i]
+		}
+	}
+	return f.faces[0]
+}
+
+func (f *rangeFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *rangeFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.face(r).Glyph(dot, r)
+}
+
+func (f *rangeFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.face(r).GlyphBounds(r)
+}
+
+func (f *rangeFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.face(r).GlyphAdvance(r)
+}
+
+func (f *rangeFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	if face := f.face(r0); face == f.face(r1) {
+		return face.Kern(r0, r1)
+	}
+	return 0
+}
+
+func (f *rangeFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
@@ -0,0 +1,254 @@
+package css
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/driusan/fonts"
+	"golang.org/x/image/font"
+)
+
+func TestComputeFontFamily(t *testing.T) {
+	tests := []struct {
+		Val      string
+		Expected FontFamily
+	}{
+		{`"Helvetica Neue", Arial, sans-serif`, `"Helvetica Neue", "Arial", sans-serif`},
+		{`Times   New Roman, SERIF`, `"Times New Roman", serif`},
+		{`monospace`, `monospace`},
+		{``, `"Parent"`},
+		{`12px`, `"Parent"`},
+	}
+	for _, tc := range tests {
+		got := computeFontFamily(tc.Val, `"Parent"`)
+		if got != tc.Expected {
+			t.Errorf("Unexpected family for %q: got %v want %v", tc.Val, got, tc.Expected)
+		}
+	}
+	if got, want := FontFamily(`"Helvetica Neue", "Arial", sans-serif`).Families(), []string{"Helvetica Neue", "Arial", "sans-serif"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected families: got %v want %v", got, want)
+	}
+}
+
+func TestSystemFonts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobfonts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "dejavu"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"DejaVuSans.ttf", "DejaVuSans-Bold.ttf"} {
+		b, err := fonts.Asset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "dejavu", name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.otf"), []byte("not a font"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldDirs := FontDirs
+	FontDirs = []string{dir}
+	systemFontIndex = nil
+	defer func() {
+		FontDirs = oldDirs
+		systemFontIndex = nil
+	}()
+
+	found := systemFonts()["dejavu sans"]
+	if len(found) != 2 {
+		t.Fatalf("Expected 2 DejaVu Sans fonts, got %d", len(found))
+	}
+	var weights []int
+	for _, f := range found {
+		weights = append(weights, f.minWeight)
+	}
+	if !reflect.DeepEqual(weights, []int{400, 700}) && !reflect.DeepEqual(weights, []int{700, 400}) {
+		t.Errorf("Unexpected weights: %v", weights)
+	}
+
+	// The bold font is used for heavier weights.
+	advance := func(weight font.Weight) interface{} {
+		adv, _ := matchFont(found, 16, weight, font.StyleNormal, FontStretchNormal).GlyphAdvance('m')
+		return adv
+	}
+	if advance(font.WeightSemiBold) != advance(font.WeightBold) || advance(font.WeightNormal) == advance(font.WeightBold) {
+		t.Errorf("Expected the bold system font to be used for semi-bold and bold, but not normal")
+	}
+
+	// Local fonts can be used by @font-face rules by their full or
+	// PostScript name.
+	for _, name := range []string{"DejaVu Sans Bold", "dejavusans-bold"} {
+		ff := &FontFace{Family: "Local", Sources: []FontSource{{Local: "Missing"}, {Local: name}}}
+		if _, err := ff.Load(noopURLer{}); err != nil {
+			t.Errorf("Could not load local font %v: %v", name, err)
+		}
+	}
+}
+
+func TestGenericFamilies(t *testing.T) {
+	f, err := ioutil.TempFile("", "gobfonts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`serif: "Missing", DejaVu Sans Mono;
+cursive: "DejaVu Serif";
+color: red;
+`)
+	f.Close()
+
+	defer func() {
+		GenericFamilies = map[string][]string{}
+		ClearFontCache()
+	}()
+	if err := LoadFontConfig(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"serif":   {"Missing", "DejaVu Sans Mono"},
+		"cursive": {"DejaVu Serif"},
+	}
+	if !reflect.DeepEqual(GenericFamilies, want) {
+		t.Errorf("Unexpected generic families: got %v want %v", GenericFamilies, want)
+	}
+
+	var e StyledElement
+	tests := []struct {
+		Family   FontFamily
+		Expected FontFamily
+	}{
+		{"serif", `"DejaVu Sans Mono"`},
+		{"cursive", `"DejaVu Serif"`},
+		{"monospace", `"DejaVu Sans Mono"`},
+		{"fantasy", `"DejaVu Sans"`},
+	}
+	for _, tc := range tests {
+		got, _ := e.GetFontFace(16, tc.Family, font.WeightNormal, font.StyleNormal, FontStretchNormal).GlyphAdvance('i')
+		want, _ := e.GetFontFace(16, tc.Expected, font.WeightNormal, font.StyleNormal, FontStretchNormal).GlyphAdvance('i')
+		if got != want {
+			t.Errorf("Unexpected face for %v: advance of 'i' is %v, want %v from %v", tc.Family, got, want, tc.Expected)
+		}
+	}
+}
+
+func TestSubfamilyDescriptors(t *testing.T) {
+	tests := []struct {
+		Subfamily string
+		Weight    int
+		Stretch   FontStretch
+		Style     font.Style
+	}{
+		{"Regular", 400, 100, font.StyleNormal},
+		{"Bold", 700, 100, font.StyleNormal},
+		{"Bold Italic", 700, 100, font.StyleItalic},
+		{"SemiBold", 600, 100, font.StyleNormal},
+		{"Extra-Bold Oblique", 800, 100, font.StyleOblique},
+		{"ExtraLight", 200, 100, font.StyleNormal},
+		{"Light Italic", 300, 100, font.StyleItalic},
+		{"Black", 900, 100, font.StyleNormal},
+		{"Condensed", 400, 75, font.StyleNormal},
+		{"SemiCondensed Bold", 700, 87.5, font.StyleNormal},
+		{"Ultra Condensed Light", 300, 50, font.StyleNormal},
+		{"Expanded Italic", 400, 125, font.StyleItalic},
+		{"Narrow", 400, 75, font.StyleNormal},
+	}
+	for _, tc := range tests {
+		weight, stretch, style := subfamilyDescriptors(tc.Subfamily)
+		if weight != tc.Weight || stretch != tc.Stretch || style != tc.Style {
+			t.Errorf("Unexpected descriptors for %v: got %v %v %v want %v %v %v", tc.Subfamily, weight, stretch, style, tc.Weight, tc.Stretch, tc.Style)
+		}
+	}
+}
+
+func TestFontStretch(t *testing.T) {
+	load := func(name string) []byte {
+		b, err := fonts.Asset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// A serif font is used for the condensed font, so that it's easy to
+	// tell apart.
+	normal, condensed := load("DejaVuSans.ttf"), load("DejaVuSerif.ttf")
+	loader := fontLoader{"/normal.ttf": normal, "/condensed.ttf": condensed}
+	sheet, _ := ParseStylesheet(`
+@font-face { font-family: Brand; src: url(/normal.ttf); }
+@font-face { font-family: Brand; src: url(/condensed.ttf); font-stretch: condensed; }
+`, AuthorSrc, loader, &url.URL{Scheme: "https", Host: "example.com"}, 0)
+	LoadFontFaces(loader, NewFontFaces(MediaFeatures{}, sheet))
+	defer LoadFontFaces(loader, nil)
+
+	var e StyledElement
+	advance := func(family FontFamily, stretch FontStretch) interface{} {
+		adv, _ := e.GetFontFace(16, family, font.WeightNormal, font.StyleNormal, stretch).GlyphAdvance('m')
+		return adv
+	}
+	sans, serif := advance("Brand", 100), advance("serif", 100)
+	tests := []struct {
+		Stretch  FontStretch
+		Expected interface{}
+	}{
+		{100, sans},
+		{75, serif},
+		// Narrower fonts are preferred for condensed widths..
+		{87.5, serif},
+		{50, serif},
+		// ..and wider ones for expanded widths, but narrower ones are
+		// used if there aren't any.
+		{125, sans},
+	}
+	for _, tc := range tests {
+		if got := advance("Brand", tc.Stretch); got != tc.Expected {
+			t.Errorf("Unexpected face for stretch %v: advance of 'm' is %v want %v", tc.Stretch, got, tc.Expected)
+		}
+	}
+}
+
+func TestSmallCapsFace(t *testing.T) {
+	regular, err := fonts.Asset("DejaVuSans.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallCaps, err := fonts.Asset("DejaVuSerif.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := fontLoader{"/regular.ttf": regular, "/sc.ttf": smallCaps}
+	sheet, _ := ParseStylesheet(`
+@font-face { font-family: Brand; src: url(/regular.ttf); }
+@font-face { font-family: Brand SC; src: url(/sc.ttf); }
+@font-face { font-family: Other; src: url(/regular.ttf); }
+`, AuthorSrc, loader, &url.URL{Scheme: "https", Host: "example.com"}, 0)
+	LoadFontFaces(loader, NewFontFaces(MediaFeatures{}, sheet))
+	defer LoadFontFaces(loader, nil)
+
+	var e StyledElement
+	face := e.GetSmallCapsFace(16, `"Missing", "Brand", serif`, font.WeightNormal, font.StyleNormal, FontStretchNormal)
+	if face == nil {
+		t.Fatal("Expected a small-caps face for Brand")
+	}
+	got, _ := face.GlyphAdvance('m')
+	want, _ := e.GetFontFace(16, `"Brand SC"`, font.WeightNormal, font.StyleNormal, FontStretchNormal).GlyphAdvance('m')
+	if got != want {
+		t.Errorf("Unexpected small-caps face: advance of 'm' is %v want %v", got, want)
+	}
+
+	// Families without a small-caps family, including the generic
+	// families, need small-caps to be synthesized.
+	for _, family := range []FontFamily{`"Other", "Brand"`, "serif", `"Missing"`} {
+		if face := e.GetSmallCapsFace(16, family, font.WeightNormal, font.StyleNormal, FontStretchNormal); face != nil {
+			t.Errorf("Unexpected small-caps face for %v", family)
+		}
+	}
+}
@@ -0,0 +1,600 @@
+package css
+
+import (
+	"math"
+	"strings"
+)
+
+// MediaFeatures describes the device that media queries are evaluated
+// against.
+type MediaFeatures struct {
+	// The media type, such as "screen" or "print". If empty, "screen"
+	// is assumed.
+	Type string
+
+	// The size of the viewport in device pixels.
+	Width, Height int
+
+	// The resolution of the device, in dots per CSS px. If 0, it's
+	// calculated from PixelsPerPt.
+	Resolution float64
+}
+
+func (f MediaFeatures) mediaType() string {
+	if f.Type == "" {
+		return "screen"
+	}
+	return strings.ToLower(f.Type)
+}
+
+func (f MediaFeatures) resolution() float64 {
+	if f.Resolution == 0 {
+		// 1px is 0.75pt
+		return PixelsPerPt * 0.75
+	}
+	return f.Resolution
+}
+
+// A MediaQueryList is a comma separated list of media queries, such as the
+// prelude of an @media rule or the media attribute of a <link> element. It
+// matches if any of the queries in the list match. An empty list matches
+// all media.
+type MediaQueryList []MediaQuery
+
+// A MediaQuery is a single query from a media query list, such as
+// "screen and (min-width: 600px)".
+type MediaQuery struct {
+	// The query is negated by the "not" keyword.
+	Not bool
+
+	// The media type of the query. An empty string means "all".
+	Type string
+
+	condition mediaCondition
+
+	// Media queries which fail to parse are treated as "not all".
+	invalid bool
+}
+
+// ParseMediaQueryList parses a media query list, as in the media attribute
+// of a <link> or <style> element.
+func ParseMediaQueryList(s string) MediaQueryList {
+	return parseMediaQueryList(ParseComponentValues(s))
+}
+
+func parseMediaQueryList(vals []ComponentValue) MediaQueryList {
+	vals = trimWhitespace(vals)
+	if len(vals) == 0 {
+		return nil
+	}
+	var l MediaQueryList
+	for _, q := range splitOnCommas(vals) {
+		l = append(l, parseMediaQuery(q))
+	}
+	return l
+}
+
+// Matches reports whether any query in the list matches the media features f.
+func (l MediaQueryList) Matches(f MediaFeatures) bool {
+	if len(l) == 0 {
+		return true
+	}
+	for _, q := range l {
+		if q.Matches(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether the query matches the media features f.
+func (q MediaQuery) Matches(f MediaFeatures) bool {
+	if q.invalid {
+		return false
+	}
+	matches := true
+	switch q.Type {
+	case "", "all":
+	default:
+		matches = q.Type == f.mediaType()
+	}
+	if matches && q.condition != nil {
+		var known bool
+		matches, known = q.condition.eval(f)
+		if !known {
+			// Unknown conditions don't match, even when the
+			// query is negated.
+			return false
+		}
+	}
+	if q.Not {
+		return !matches
+	}
+	return matches
+}
+
+// stripWhitespace removes all whitespace tokens from vals. Whitespace is
+// insignificant between the parts of a media query.
+func stripWhitespace(vals []ComponentValue) []ComponentValue {
+	var ret []ComponentValue
+	for _, v := range vals {
+		if tokenType(v) != WhitespaceToken {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func parseMediaQuery(vals []ComponentValue) MediaQuery {
+	vals = stripWhitespace(vals)
+	if len(vals) == 0 {
+		return MediaQuery{invalid: true}
+	}
+	first, ok := vals[0].(Token)
+	if !ok || first.Type != IdentToken || (first.isIdent("not") && len(vals) > 1 && tokenType(vals[1]) != IdentToken) {
+		// A bare media condition, such as "(min-width: 600px)"
+		// or "not (color)".
+		cond, ok := parseMediaCondition(vals, true)
+		if !ok {
+			return MediaQuery{invalid: true}
+		}
+		return MediaQuery{condition: cond}
+	}
+
+	var q MediaQuery
+	if first.isIdent("not") {
+		q.Not = true
+		vals = vals[1:]
+	} else if first.isIdent("only") {
+		vals = vals[1:]
+	}
+	if len(vals) == 0 {
+		return MediaQuery{invalid: true}
+	}
+	typ, ok := vals[0].(Token)
+	if !ok || typ.Type != IdentToken {
+		return MediaQuery{invalid: true}
+	}
+	switch strings.ToLower(typ.Value) {
+	case "not", "only", "and", "or", "layer":
+		return MediaQuery{invalid: true}
+	}
+	q.Type = strings.ToLower(typ.Value)
+	vals = vals[1:]
+	if len(vals) == 0 {
+		return q
+	}
+	if and, ok := vals[0].(Token); !ok || !and.isIdent("and") {
+		return MediaQuery{invalid: true}
+	}
+	cond, ok := parseMediaCondition(vals[1:], false)
+	if !ok {
+		return MediaQuery{invalid: true}
+	}
+	q.condition = cond
+	return q
+}
+
+// A mediaCondition is a boolean expression of media features. As in Media
+// Queries Level 4, conditions that gob doesn't understand are neither true
+// nor false but unknown, which stays unknown when negated.
+type mediaCondition interface {
+	// eval returns whether the condition matches f, and whether the
+	// result is known. Unknown conditions never match.
+	eval(f MediaFeatures) (matches, known bool)
+}
+
+type mediaNot struct {
+	c mediaCondition
+}
+
+func (m mediaNot) eval(f MediaFeatures) (bool, bool) {
+	matches, known := m.c.eval(f)
+	return known && !matches, known
+}
+
+type mediaAnd []mediaCondition
+
+func (m mediaAnd) eval(f MediaFeatures) (bool, bool) {
+	known := true
+	for _, c := range m {
+		matches, k := c.eval(f)
+		if k && !matches {
+			return false, true
+		}
+		known = known && k
+	}
+	return known, known
+}
+
+type mediaOr []mediaCondition
+
+func (m mediaOr) eval(f MediaFeatures) (bool, bool) {
+	known := true
+	for _, c := range m {
+		matches, k := c.eval(f)
+		if k && matches {
+			return true, true
+		}
+		known = known && k
+	}
+	return false, known
+}
+
+// mediaUnknown is a condition that gob doesn't understand, such as
+// <general-enclosed>.
+type mediaUnknown struct{}
+
+func (m mediaUnknown) eval(f MediaFeatures) (bool, bool) {
+	return false, false
+}
+
+// parseMediaCondition parses vals, which must not contain whitespace, as a
+// <media-condition>. If allowOr is false, it's parsed as a
+// <media-condition-without-or>.
+func parseMediaCondition(vals []ComponentValue, allowOr bool) (mediaCondition, bool) {
+	if len(vals) == 0 {
+		return nil, false
+	}
+	if t, ok := vals[0].(Token); ok && t.isIdent("not") {
+		if len(vals) != 2 {
+			return nil, false
+		}
+		c, ok := parseMediaInParens(vals[1])
+		if !ok {
+			return nil, false
+		}
+		return mediaNot{c}, true
+	}
+
+	c, ok := parseMediaInParens(vals[0])
+	if !ok {
+		return nil, false
+	}
+	conds := []mediaCondition{c}
+	var connective string
+	for vals = vals[1:]; len(vals) > 0; vals = vals[2:] {
+		t, ok := vals[0].(Token)
+		if !ok || t.Type != IdentToken || len(vals) < 2 {
+			return nil, false
+		}
+		op := strings.ToLower(t.Value)
+		switch op {
+		case "and":
+		case "or":
+			if !allowOr {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+		// "and" and "or" can't be mixed without parentheses.
+		if connective != "" && connective != op {
+			return nil, false
+		}
+		connective = op
+		c, ok := parseMediaInParens(vals[1])
+		if !ok {
+			return nil, false
+		}
+		conds = append(conds, c)
+	}
+	switch connective {
+	case "and":
+		return mediaAnd(conds), true
+	case "or":
+		return mediaOr(conds), true
+	}
+	return c, true
+}
+
+// parseMediaInParens parses a <media-in-parens>.
+func parseMediaInParens(v ComponentValue) (mediaCondition, bool) {
+	switch b := v.(type) {
+	case *SimpleBlock:
+		if b.Associated != OpenParenToken {
+			return nil, false
+		}
+		inner := stripWhitespace(b.Value)
+		if len(inner) > 0 {
+			if c, ok := parseMediaCondition(inner, true); ok {
+				return c, true
+			}
+			if c, ok := parseMediaFeature(inner); ok {
+				return c, true
+			}
+		}
+		return mediaUnknown{}, true
+	case *Function:
+		return mediaUnknown{}, true
+	}
+	return nil, false
+}
+
+// A mediaFeature is a single test of a media feature such as
+// (min-width: 600px) or (width >= 600px). Op is empty for features
+// in a boolean context, such as (color).
+type mediaFeature struct {
+	name  string
+	op    string
+	value []ComponentValue
+}
+
+// parseMediaFeature parses the inside of a <media-feature>.
+func parseMediaFeature(vals []ComponentValue) (mediaCondition, bool) {
+	if name, ok := vals[0].(Token); ok && name.Type == IdentToken {
+		if len(vals) == 1 {
+			return mediaFeature{name: strings.ToLower(name.Value)}, true
+		}
+		if tokenType(vals[1]) == ColonToken {
+			if len(vals) == 2 {
+				return nil, false
+			}
+			n := strings.ToLower(name.Value)
+			op := "="
+			if strings.HasPrefix(n, "min-") {
+				n, op = n[4:], ">="
+			} else if strings.HasPrefix(n, "max-") {
+				n, op = n[4:], "<="
+			}
+			return mediaFeature{name: n, op: op, value: vals[2:]}, true
+		}
+	}
+
+	// Range syntax. Split into the values and the comparison operators
+	// between them.
+	var parts [][]ComponentValue
+	var ops []string
+	var cur []ComponentValue
+	for i := 0; i < len(vals); i++ {
+		t, ok := vals[i].(Token)
+		if !ok || t.Type != DelimToken || (t.Value != "<" && t.Value != ">" && t.Value != "=") {
+			cur = append(cur, vals[i])
+			continue
+		}
+		op := t.Value
+		if op != "=" && i+1 < len(vals) {
+			if eq, ok := vals[i+1].(Token); ok && eq.isDelim("=") {
+				op += "="
+				i++
+			}
+		}
+		parts = append(parts, cur)
+		ops = append(ops, op)
+		cur = nil
+	}
+	parts = append(parts, cur)
+	for _, p := range parts {
+		if len(p) == 0 {
+			return nil, false
+		}
+	}
+
+	featureName := func(vals []ComponentValue) (string, bool) {
+		if len(vals) != 1 {
+			return "", false
+		}
+		t, ok := vals[0].(Token)
+		if !ok || t.Type != IdentToken {
+			return "", false
+		}
+		return strings.ToLower(t.Value), true
+	}
+	switch len(parts) {
+	case 2:
+		if name, ok := featureName(parts[0]); ok {
+			return mediaFeature{name, ops[0], parts[1]}, true
+		}
+		if name, ok := featureName(parts[1]); ok {
+			return mediaFeature{name, flipMediaOp(ops[0]), parts[0]}, true
+		}
+	case 3:
+		// value < name < value
+		name, ok := featureName(parts[1])
+		if !ok || ops[0] == "=" || ops[0][0] != ops[1][0] {
+			return nil, false
+		}
+		return mediaAnd{
+			mediaFeature{name, flipMediaOp(ops[0]), parts[0]},
+			mediaFeature{name, ops[1], parts[2]},
+		}, true
+	}
+	return nil, false
+}
+
+// flipMediaOp converts "a op b" into the equivalent "b op a"
+func flipMediaOp(op string) string {
+	switch op {
+	case "<":
+		return ">"
+	case "<=":
+		return ">="
+	case ">":
+		return "<"
+	case ">=":
+		return "<="
+	}
+	return op
+}
+
+func (m mediaFeature) eval(f MediaFeatures) (bool, bool) {
+	switch m.name {
+	case "width", "height", "aspect-ratio", "orientation", "resolution",
+		"color", "monochrome", "color-index", "grid",
+		"hover", "any-hover", "pointer", "any-pointer":
+		return m.matches(f), true
+	}
+	// Features that gob doesn't know about are unknown.
+	return false, false
+}
+
+// matches reports whether the known feature m matches f.
+func (m mediaFeature) matches(f MediaFeatures) bool {
+	switch m.name {
+	case "width":
+		return m.compareLength(float64(f.Width))
+	case "height":
+		return m.compareLength(float64(f.Height))
+	case "aspect-ratio":
+		if f.Height == 0 {
+			return false
+		}
+		if m.op == "" {
+			return f.Width != 0
+		}
+		r, ok := parseMediaRatio(m.value)
+		return ok && compareMediaValue(float64(f.Width)/float64(f.Height), m.op, r)
+	case "orientation":
+		if m.op == "" {
+			return true
+		}
+		if m.op != "=" || len(m.value) != 1 {
+			return false
+		}
+		t, ok := m.value[0].(Token)
+		if !ok {
+			return false
+		}
+		if f.Height >= f.Width {
+			return t.isIdent("portrait")
+		}
+		return t.isIdent("landscape")
+	case "resolution":
+		if m.op == "" {
+			return f.resolution() != 0
+		}
+		if len(m.value) != 1 {
+			return false
+		}
+		t, ok := m.value[0].(Token)
+		if !ok || t.Type != DimensionToken {
+			return false
+		}
+		var dppx float64
+		switch strings.ToLower(t.Unit) {
+		case "dppx", "x":
+			dppx = t.Number
+		case "dpi":
+			dppx = t.Number / 96
+		case "dpcm":
+			dppx = t.Number * 2.54 / 96
+		default:
+			return false
+		}
+		return compareMediaValue(f.resolution(), m.op, dppx)
+	case "color":
+		// gob always renders into an RGBA image.
+		return m.compareInteger(8)
+	case "monochrome", "color-index", "grid":
+		return m.compareInteger(0)
+	case "hover", "any-hover":
+		return m.compareKeyword("hover", "none")
+	case "pointer", "any-pointer":
+		return m.compareKeyword("fine", "none")
+	}
+	return false
+}
+
+func (m mediaFeature) compareLength(actual float64) bool {
+	if m.op == "" {
+		return actual != 0
+	}
+	if len(m.value) != 1 {
+		return false
+	}
+	t, ok := m.value[0].(Token)
+	if !ok {
+		return false
+	}
+	switch t.Type {
+	case DimensionToken:
+	case NumberToken:
+		if t.Number != 0 {
+			return false
+		}
+	default:
+		return false
+	}
+	// Relative units in media queries are relative to the initial
+	// value, not the element.
+	px, err := ConvertUnitToPx(DefaultFontSize, 0, t.String())
+	if err != nil {
+		return false
+	}
+	return compareMediaValue(actual, m.op, float64(px))
+}
+
+func (m mediaFeature) compareInteger(actual int) bool {
+	if m.op == "" {
+		return actual != 0
+	}
+	if len(m.value) != 1 {
+		return false
+	}
+	t, ok := m.value[0].(Token)
+	if !ok || t.Type != NumberToken || !t.Integer {
+		return false
+	}
+	return compareMediaValue(float64(actual), m.op, t.Number)
+}
+
+// compareKeyword evaluates a discrete feature whose value is actual.
+// none is the value which is false in a boolean context.
+func (m mediaFeature) compareKeyword(actual, none string) bool {
+	if m.op == "" {
+		return actual != none
+	}
+	if m.op != "=" || len(m.value) != 1 {
+		return false
+	}
+	t, ok := m.value[0].(Token)
+	return ok && t.isIdent(actual)
+}
+
+// parseMediaRatio parses a <ratio>, such as 16/9, or a single number.
+func parseMediaRatio(vals []ComponentValue) (float64, bool) {
+	var nums []float64
+	for i, v := range vals {
+		t, ok := v.(Token)
+		if !ok {
+			return 0, false
+		}
+		if i%2 == 1 {
+			if !t.isDelim("/") {
+				return 0, false
+			}
+			continue
+		}
+		if t.Type != NumberToken || t.Number < 0 {
+			return 0, false
+		}
+		nums = append(nums, t.Number)
+	}
+	switch len(nums) {
+	case 1:
+		return nums[0], len(vals) == 1
+	case 2:
+		if nums[1] == 0 {
+			return math.Inf(1), true
+		}
+		return nums[0] / nums[1], true
+	}
+	return 0, false
+}
+
+func compareMediaValue(actual float64, op string, val float64) bool {
+	const epsilon = 0.0001
+	switch op {
+	case "=":
+		return math.Abs(actual-val) < epsilon
+	case "<":
+		return actual < val
+	case "<=":
+		return actual <= val+epsilon
+	case ">":
+		return actual > val
+	case ">=":
+		return actual >= val-epsilon
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package css
+
+import (
+	"testing"
+)
+
+func TestMediaQueryMatches(t *testing.T) {
+	// 1px is 0.75pt, and the tests assume 96 DPI so that 1px is one
+	// device pixel.
+	oldPPP := PixelsPerPt
+	PixelsPerPt = 96.0 / 72.0
+	defer func() { PixelsPerPt = oldPPP }()
+
+	landscape := MediaFeatures{Width: 800, Height: 600}
+	portrait := MediaFeatures{Width: 400, Height: 600}
+	tests := []struct {
+		Query    string
+		Features MediaFeatures
+		Expected bool
+	}{
+		{"", landscape, true},
+		{"all", landscape, true},
+		{"screen", landscape, true},
+		{"SCREEN", landscape, true},
+		{"print", landscape, false},
+		{"not print", landscape, true},
+		{"not screen", landscape, false},
+		{"only screen", landscape, true},
+		{"print, screen", landscape, true},
+		{"(min-width: 600px)", landscape, true},
+		{"(min-width: 600px)", portrait, false},
+		{"(max-width: 600px)", portrait, true},
+		{"(width: 800px)", landscape, true},
+		{"screen and (min-width: 600px) and (max-width: 1000px)", landscape, true},
+		{"print and (min-width: 600px)", landscape, false},
+		{"(width >= 600px)", landscape, true},
+		{"(600px <= width)", landscape, true},
+		{"(400px < width < 700px)", landscape, false},
+		{"(400px < width < 900px)", landscape, true},
+		{"(orientation: landscape)", landscape, true},
+		{"(orientation: landscape)", portrait, false},
+		{"(orientation: portrait)", portrait, true},
+		{"(orientation)", portrait, true},
+		{"(min-aspect-ratio: 4/3)", landscape, true},
+		{"(min-aspect-ratio: 16/9)", landscape, false},
+		{"(min-resolution: 1dppx)", landscape, true},
+		{"(min-resolution: 2dppx)", landscape, false},
+		{"(resolution: 96dpi)", landscape, true},
+		{"(color)", landscape, true},
+		{"(monochrome)", landscape, false},
+		{"(min-width: 900px) or (orientation: landscape)", landscape, true},
+		{"not (min-width: 900px)", landscape, true},
+		{"not ((color) and (min-width: 900px))", landscape, true},
+		{"(min-width: 60em)", landscape, false},
+		{"(min-width: 10em)", landscape, true},
+		// Unknown features and invalid queries never match, and
+		// stay unknown when negated.
+		{"(unknown-feature)", landscape, false},
+		{"not (unknown-feature)", landscape, false},
+		{"not unknown(feature)", landscape, false},
+		{"not ((unknown-feature) and (color))", landscape, false},
+		{"not ((unknown-feature) and (monochrome))", landscape, true},
+		{"not ((unknown-feature) or (color))", landscape, false},
+		{"(unknown-feature) or (color)", landscape, true},
+		{"not screen and (unknown-feature)", landscape, false},
+		{"not print and (unknown-feature)", landscape, true},
+		{"screen and (color) or (hover)", landscape, false},
+		{"(color) and (hover) or (grid)", landscape, false},
+		{"(min-width: 600)", landscape, false},
+		{"and", landscape, false},
+		{"screen, and", landscape, true},
+	}
+	for i, tc := range tests {
+		if got := ParseMediaQueryList(tc.Query).Matches(tc.Features); got != tc.Expected {
+			t.Errorf("Unexpected result for test %d (%q): got %v want %v", i, tc.Query, got, tc.Expected)
+		}
+	}
+}
+
+func TestParseStylesheetMedia(t *testing.T) {
+	rules, _ := ParseStylesheet(
+		`p { color: red }
+		@media screen and (max-width: 600px) {
+			p { color: blue }
+			@media (orientation: portrait) {
+				p { color: green }
+			}
+		}
+		@media print { p { color: black } }`,
+		AuthorSrc, noopURLer{}, nil, 0,
+	)
+	if len(rules) != 4 {
+		t.Fatalf("Unexpected number of rules: got %v want 4", len(rules))
+	}
+	if rules[0].Media != nil {
+		t.Errorf("Unexpected media for unconditional rule: got %v", rules[0].Media)
+	}
+	if len(rules[1].Media) != 1 || len(rules[2].Media) != 2 || len(rules[3].Media) != 1 {
+		t.Errorf("Unexpected nesting of media rules: got %v, %v, %v", rules[1].Media, rules[2].Media, rules[3].Media)
+	}
+
+	tests := []struct {
+		Features MediaFeatures
+		Expected []bool
+	}{
+		{MediaFeatures{Width: 800, Height: 600}, []bool{true, false, false, false}},
+		{MediaFeatures{Width: 500, Height: 400}, []bool{true, true, false, false}},
+		{MediaFeatures{Width: 500, Height: 600}, []bool{true, true, true, false}},
+		{MediaFeatures{Type: "print", Width: 500, Height: 600}, []bool{true, false, false, true}},
+	}
+	for i, tc := range tests {
+		for j, rule := range rules {
+			if got := rule.MediaMatches(tc.Features); got != tc.Expected[j] {
+				t.Errorf("Unexpected match for rule %d in test %d: got %v want %v", j, i, got, tc.Expected[j])
+			}
+		}
+	}
+}
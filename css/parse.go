@@ -13,13 +13,15 @@ import (
 // body.
 func ExtractStyles(n *html.Node, loader net.URLReader, context *url.URL, orderNo uint) (styles Stylesheet, nextOrderNo uint) {
 	if n.Type == html.ElementNode && n.Data == "link" {
-		var href, rel string
+		var href, rel, media string
 		for _, attr := range n.Attr {
 			switch attr.Key {
 			case "href":
 				href = attr.Val
 			case "rel":
 				rel = attr.Val
+			case "media":
+				media = attr.Val
 			}
 		}
 		if href == "" || rel != "stylesheet" {
@@ -45,18 +47,26 @@ func ExtractStyles(n *html.Node, loader net.URLReader, context *url.URL, orderNo
 		if err != nil {
 			return nil, orderNo
 		}
-		return ParseStylesheet(string(styles), AuthorSrc, loader, newAbsoluteURL, orderNo)
+		// The stylesheet is loaded even if the media query doesn't
+		// currently match, since it may match after the viewport is
+		// resized.
+		return parseStylesheet(ParseRules(string(styles)), appendMedia(nil, ParseMediaQueryList(media)), true, AuthorSrc, loader, newAbsoluteURL, []string{newAbsoluteURL.String()}, orderNo, nil)
 	}
 
-	var styleElem string
+	var styleElem, media string
 	if n.Type == html.ElementNode && n.Data == "style" {
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			if c.Type == html.TextNode {
 				styleElem += c.Data
 			}
 		}
+		for _, attr := range n.Attr {
+			if attr.Key == "media" {
+				media = attr.Val
+			}
+		}
 	}
-	style, orderNo := ParseStylesheet(styleElem, AuthorSrc, loader, context, orderNo)
+	style, orderNo := parseStylesheet(ParseRules(styleElem), appendMedia(nil, ParseMediaQueryList(media)), true, AuthorSrc, loader, context, nil, orderNo, nil)
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
 		s, nextOrderNo := ExtractStyles(c, loader, context, orderNo)
 		style = append(style, s...)
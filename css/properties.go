@@ -0,0 +1,278 @@
+package css
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Property describes how the cascade treats a CSS property.
+type Property struct {
+	// The initial value of the property.
+	Initial string
+
+	// Whether the property is inherited by default when an element
+	// doesn't specify a value for it.
+	Inherited bool
+}
+
+// Properties are the longhand properties that a StyledElement knows about.
+// The CSS-wide keywords initial, inherit, unset and revert are resolved for
+// every property in the table when the styles are sorted, and properties
+// which are inherited take the parent's value if they're not specified.
+var Properties = map[StyleAttribute]Property{
+	"font-family":  {"sans-serif", true},
+	"font-style":   {"normal", true},
+	"font-variant": {"normal", true},
+	"font-weight":  {"normal", true},
+	"font-stretch": {"normal", true},
+	"font-size":    {"medium", true},
+
+	"color":                 {"black", true},
+	"background-color":      {"transparent", false},
+	"background-image":      {"none", false},
+	"background-repeat":     {"repeat", false},
+	"background-attachment": {"scroll", false},
+	"background-position":   {"0% 0%", false},
+
+	"word-spacing":    {"normal", true},
+	"letter-spacing":  {"normal", true},
+	"text-decoration": {"none", false},
+	"vertical-align":  {"baseline", false},
+	"text-transform":  {"none", true},
+	"text-align":      {"left", true},
+	"text-indent":     {"0", true},
+	"line-height":     {"normal", true},
+
+	"margin-top":    {"0", false},
+	"margin-right":  {"0", false},
+	"margin-bottom": {"0", false},
+	"margin-left":   {"0", false},
+
+	"padding-top":    {"0", false},
+	"padding-right":  {"0", false},
+	"padding-bottom": {"0", false},
+	"padding-left":   {"0", false},
+
+	"border-top-width":    {"medium", false},
+	"border-top-color":    {"currentcolor", false},
+	"border-top-style":    {"none", false},
+	"border-right-width":  {"medium", false},
+	"border-right-color":  {"currentcolor", false},
+	"border-right-style":  {"none", false},
+	"border-bottom-width": {"medium", false},
+	"border-bottom-color": {"currentcolor", false},
+	"border-bottom-style": {"none", false},
+	"border-left-width":   {"medium", false},
+	"border-left-color":   {"currentcolor", false},
+	"border-left-style":   {"none", false},
+
+	"width":      {"auto", false},
+	"height":     {"auto", false},
+	"min-width":  {"0", false},
+	"min-height": {"0", false},
+	"max-width":  {"none", false},
+	"max-height": {"none", false},
+
+	"float":       {"none", false},
+	"clear":       {"none", false},
+	"display":     {"inline", false},
+	"white-space": {"normal", true},
+	"overflow-x":  {"visible", false},
+	"overflow-y":  {"visible", false},
+
+	"top":    {"auto", false},
+	"right":  {"auto", false},
+	"bottom": {"auto", false},
+	"left":   {"auto", false},
+
+	"outline-width": {"medium", false},
+	"outline-style": {"none", false},
+	"outline-color": {"invert", false},
+
+	"flex-grow":   {"0", false},
+	"flex-shrink": {"1", false},
+	"flex-basis":  {"auto", false},
+
+	"grid-row-start":    {"auto", false},
+	"grid-column-start": {"auto", false},
+	"grid-row-end":      {"auto", false},
+	"grid-column-end":   {"auto", false},
+
+	"list-style-type":     {"disc", true},
+	"list-style-image":    {"none", true},
+	"list-style-position": {"outside", true},
+
+	"content":           {"normal", false},
+	"counter-increment": {"none", false},
+	"counter-reset":     {"none", false},
+	"counter-set":       {"none", false},
+	"quotes":            {"auto", true},
+}
+
+// inheritedProperties are the names of the properties in Properties which
+// are inherited, in a stable order.
+var inheritedProperties []StyleAttribute
+
+func init() {
+	for name, prop := range Properties {
+		if prop.Inherited {
+			inheritedProperties = append(inheritedProperties, name)
+		}
+	}
+	sort.Slice(inheritedProperties, func(i, j int) bool {
+		return inheritedProperties[i] < inheritedProperties[j]
+	})
+}
+
+// InheritStyles sets the element that e inherits property values from. It
+// must be called before the styles are sorted, and the parent's styles
+// must already be sorted.
+func (e *StyledElement) InheritStyles(parent *StyledElement) {
+	e.parent = parent
+}
+
+// cssWideKeyword returns the CSS-wide keyword that val is, or an empty
+// string if it's not one.
+func cssWideKeyword(val string) string {
+	switch v := strings.ToLower(strings.TrimSpace(val)); v {
+	case "initial", "inherit", "unset", "revert":
+		return v
+	}
+	return ""
+}
+
+// cascadeOrigin returns the origin of the source src for the purposes of
+// revert. Inline styles are part of the author origin.
+func cascadeOrigin(src StyleSource) StyleSource {
+	if src == InlineStyleSrc {
+		return AuthorSrc
+	}
+	return src
+}
+
+// resolveKeywords returns the sorted rules with the CSS-wide keywords
+// resolved, so that there's at most one rule for each property in
+// Properties, and adds a rule with the parent's value for every inherited
+// property that isn't specified. If e doesn't have a parent, unspecified
+// properties are left empty.
+func (e *StyledElement) resolveKeywords(rules []StyleRule) []StyleRule {
+	ret := make([]StyleRule, 0, len(rules))
+	seen := make(map[StyleAttribute]bool)
+	for i, rule := range rules {
+		prop, ok := Properties[rule.Name]
+		if !ok {
+			ret = append(ret, rule)
+			continue
+		}
+		if seen[rule.Name] {
+			continue
+		}
+		seen[rule.Name] = true
+		rule.Value.Value = e.cascadedValue(rule.Name, prop, rules[i:])
+		ret = append(ret, rule)
+	}
+	if e.parent == nil {
+		return ret
+	}
+	for _, name := range inheritedProperties {
+		if seen[name] {
+			continue
+		}
+		if v := e.inheritedValue(name); v != "" {
+			ret = append(ret, StyleRule{Name: name, Value: StyleValue{v, false}})
+		}
+	}
+	return ret
+}
+
+// cascadedValue returns the value of the property name from rules, which
+// are sorted with the highest precedence first and start with the winning
+// declaration.
+func (e *StyledElement) cascadedValue(name StyleAttribute, prop Property, rules []StyleRule) string {
+	origin := StyleSource(0)
+	for _, rule := range rules {
+		if rule.Name != name {
+			continue
+		}
+		if origin != 0 && cascadeOrigin(rule.Src) >= origin {
+			// revert rolls back to a lower origin.
+			continue
+		}
+		switch cssWideKeyword(rule.Value.Value) {
+		case "":
+			return rule.Value.Value
+		case "initial":
+			return prop.Initial
+		case "inherit":
+			return e.inheritedValue(name)
+		case "unset":
+			return e.unsetValue(name, prop)
+		case "revert":
+			origin = cascadeOrigin(rule.Src)
+		}
+	}
+	// Reverting every origin is the same as unset.
+	return e.unsetValue(name, prop)
+}
+
+// unsetValue returns the value of the property name when it's unset, which
+// is the parent's value for inherited properties and the initial value for
+// the rest.
+func (e *StyledElement) unsetValue(name StyleAttribute, prop Property) string {
+	if prop.Inherited {
+		if v := e.inheritedValue(name); v != "" {
+			return v
+		}
+	}
+	return prop.Initial
+}
+
+// inheritedValue returns the computed value of the property name on the
+// parent, or its initial value if there is no parent. Relative values are
+// resolved in the context of the parent, so that they're not recalculated
+// relative to e.
+func (e *StyledElement) inheritedValue(name StyleAttribute) string {
+	if e.parent == nil {
+		return Properties[name].Initial
+	}
+	p := e.parent
+	if p.hasComputedStyle {
+		if v, ok := p.computedStyle.value(name); ok {
+			return v
+		}
+	}
+	if name == "font-size" && p.fontSize != 0 {
+		return fmt.Sprintf("%dpx", p.fontSize)
+	}
+	v := p.GetAttribute(string(name)).Value
+	if v == "" || p.fontSize == 0 {
+		return v
+	}
+	t, ok := singleToken(ParseComponentValues(v))
+	if !ok {
+		return v
+	}
+	u := UnitContext{FontSize: p.fontSize}
+	if p.hasComputedStyle {
+		u = p.units
+	}
+	switch {
+	case name == "line-height" && (t.Type == DimensionToken || t.Type == PercentageToken):
+		// Lengths and percentages in line-height are relative to
+		// the parent, so it's the parent's line height that's
+		// inherited. Numbers are inherited as is.
+		if u.LineHeight != nil {
+			return fmt.Sprintf("%vpx", u.LineHeight())
+		}
+		if t.Type == PercentageToken {
+			return fmt.Sprintf("%vpx", t.Number*float64(p.fontSize)/100)
+		}
+		fallthrough
+	case t.Type == DimensionToken:
+		if px, ok := lengthToPx(u, t.Number, strings.ToLower(t.Unit)); ok {
+			return fmt.Sprintf("%vpx", px)
+		}
+	}
+	return v
+}
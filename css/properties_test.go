@@ -0,0 +1,109 @@
+package css
+
+import (
+	"testing"
+)
+
+func TestCSSWideKeywords(t *testing.T) {
+	rule := func(order uint, src StyleSource, name StyleAttribute, val string) StyleRule {
+		return StyleRule{
+			Selector: CSSSelector{"div", order},
+			Name:     name,
+			Value:    StyleValue{val, false},
+			Src:      src,
+		}
+	}
+	var parent StyledElement
+	for _, r := range []StyleRule{
+		rule(0, AuthorSrc, "color", "red"),
+		rule(1, AuthorSrc, "margin-left", "3px"),
+		rule(2, AuthorSrc, "text-indent", "2em"),
+		rule(3, AuthorSrc, "line-height", "150%"),
+		rule(4, AuthorSrc, "font-family", "serif"),
+		rule(5, AuthorSrc, "width", "50%"),
+	} {
+		parent.AddStyle(r)
+	}
+	parent.SortStyles()
+	parent.SetFontSize(20)
+
+	tests := []struct {
+		Rules    []StyleRule
+		Name     string
+		Expected string
+	}{
+		// Inherited properties inherit without being specified,
+		// and other properties don't.
+		{nil, "color", "red"},
+		{nil, "font-family", "serif"},
+		{nil, "margin-left", ""},
+		// Font relative lengths are computed on the parent.
+		{nil, "text-indent", "40px"},
+		{nil, "line-height", "30px"},
+		{nil, "font-size", "20px"},
+
+		{[]StyleRule{rule(0, AuthorSrc, "margin-left", "inherit")}, "margin-left", "3px"},
+		{[]StyleRule{rule(0, AuthorSrc, "width", "inherit")}, "width", "50%"},
+		{[]StyleRule{rule(0, AuthorSrc, "color", "INITIAL")}, "color", "black"},
+		{[]StyleRule{rule(0, AuthorSrc, "margin-left", "initial")}, "margin-left", "0"},
+		{[]StyleRule{rule(0, AuthorSrc, "color", "unset")}, "color", "red"},
+		{[]StyleRule{rule(0, AuthorSrc, "margin-left", "unset")}, "margin-left", "0"},
+		{[]StyleRule{rule(0, AuthorSrc, "font-size", "inherit")}, "font-size", "20px"},
+
+		// revert rolls back to the previous origin.
+		{
+			[]StyleRule{
+				rule(0, UserAgentSrc, "display", "block"),
+				rule(1, AuthorSrc, "display", "inline"),
+				rule(2, AuthorSrc, "display", "revert"),
+			},
+			"display", "block",
+		},
+		{
+			[]StyleRule{
+				rule(0, UserAgentSrc, "display", "block"),
+				rule(1, UserSrc, "display", "flex"),
+				rule(2, InlineStyleSrc, "display", "revert"),
+			},
+			"display", "flex",
+		},
+		{
+			[]StyleRule{
+				rule(0, UserAgentSrc, "color", "blue"),
+				rule(1, AuthorSrc, "color", "green"),
+				rule(2, AuthorSrc, "color", "revert"),
+			},
+			"color", "blue",
+		},
+		// If there's nothing to revert to, it's the same as unset.
+		{[]StyleRule{rule(0, AuthorSrc, "color", "revert")}, "color", "red"},
+		{[]StyleRule{rule(0, AuthorSrc, "display", "revert")}, "display", "inline"},
+		{[]StyleRule{rule(0, UserAgentSrc, "display", "revert")}, "display", "inline"},
+
+		// Shorthands expand the keywords to every longhand.
+		{[]StyleRule{rule(0, AuthorSrc, "margin", "inherit")}, "margin-left", "3px"},
+	}
+	for i, tc := range tests {
+		var e StyledElement
+		for _, r := range tc.Rules {
+			e.AddStyle(r)
+		}
+		e.InheritStyles(&parent)
+		e.SortStyles()
+		if got := e.GetAttribute(tc.Name).Value; got != tc.Expected {
+			t.Errorf("Case %d: unexpected %v: got %q want %q", i, tc.Name, got, tc.Expected)
+		}
+	}
+
+	// The root element has nothing to inherit from, so inherit is the
+	// initial value.
+	var root StyledElement
+	root.AddStyle(rule(0, AuthorSrc, "color", "inherit"))
+	root.SortStyles()
+	if got := root.Color.Value; got != "black" {
+		t.Errorf("Unexpected color for inherit on the root: got %q want %q", got, "black")
+	}
+	if got := root.FontFamily.Value; got != "" {
+		t.Errorf("Unexpected font-family on the root: got %q want %q", got, "")
+	}
+}
@@ -1,7 +1,9 @@
 package css
 
 import (
+	"strconv"
 	"strings"
+	"sync"
 
 	"golang.org/x/net/html"
 )
@@ -25,206 +27,1133 @@ func parseSelectors(val string, orderStart uint) []CSSSelector {
 	return ret
 }
 
-// match the ID and class part of the selector. This assumes that the string s
-// starts with a . or #. That is, the element tag must have already been stripped
-// off and separatedly matched. It will recursively call itself stripping off
-// one id or class part each time, until there's either left or something
-// didn't match
-func matchIDAndClassAndPseudoSelector(el *html.Node, s string, st State) bool {
-	if el == nil || el.Type != html.ElementNode || len(s) < 1 {
-		return false
+// Specificity is the specificity of a selector. IDs are the most
+// significant, followed by classes (which includes attribute selectors and
+// pseudo-classes), and then elements (which includes pseudo-elements.)
+type Specificity struct {
+	IDs, Classes, Elements int
+}
+
+// Less reports whether s is less specific than s2.
+func (s Specificity) Less(s2 Specificity) bool {
+	if s.IDs != s2.IDs {
+		return s.IDs < s2.IDs
 	}
-	remainingData := ""
-	classSelector := ""
-	pseudoSelector := ""
-	idSelector := ""
-	switch s[0] {
-	case '.':
-		chopped := s[1:]
-		if idx := strings.IndexAny(chopped, "*.#:"); idx != -1 {
-			classSelector = chopped[0:idx]
-			remainingData = chopped[idx:]
-		} else {
-			classSelector = chopped
-			remainingData = ""
-		}
-	case '#':
-		chopped := s[1:]
-		if idx := strings.IndexAny(s[1:], "*.#:"); idx != -1 {
-			idSelector = chopped[0:idx]
-			remainingData = chopped[idx:]
-		} else {
-			idSelector = chopped
-			remainingData = ""
-		}
-	case ':':
-		chopped := s[1:]
-		if idx := strings.IndexAny(s[1:], "*.#:"); idx != -1 {
-			pseudoSelector = chopped[0:idx]
-			remainingData = chopped[idx:]
-		} else {
-			pseudoSelector = chopped
-			remainingData = ""
+	if s.Classes != s2.Classes {
+		return s.Classes < s2.Classes
+	}
+	return s.Elements < s2.Elements
+}
+
+func (s Specificity) add(s2 Specificity) Specificity {
+	return Specificity{s.IDs + s2.IDs, s.Classes + s2.Classes, s.Elements + s2.Elements}
+}
+
+// A combinator describes the relationship between two compound selectors.
+type combinator byte
+
+const (
+	descendantCombinator        combinator = ' '
+	childCombinator             combinator = '>'
+	nextSiblingCombinator       combinator = '+'
+	subsequentSiblingCombinator combinator = '~'
+)
+
+// A simpleSelector is a single condition of a compound selector, such as a
+// class, ID, or pseudo-class.
+type simpleSelector interface {
+	matches(el *html.Node, ctx *matchContext) bool
+	specificity() Specificity
+}
+
+// A compoundSelector is a sequence of simple selectors which must all match
+// the same element, such as "div.header#top".
+type compoundSelector struct {
+	// The type selector. Empty or "*" match any element.
+	tag string
+
+	simple []simpleSelector
+
+	// The pseudo-element which this compound selects, if any. Only the
+	// rightmost compound selector may have one.
+	pseudoElement string
+}
+
+// A complexSelector is a list of compound selectors separated by
+// combinators. combinators[i] is the combinator between compounds[i] and
+// compounds[i+1].
+type complexSelector struct {
+	compounds   []compoundSelector
+	combinators []combinator
+
+	// Selectors which failed to parse never match anything.
+	invalid bool
+}
+
+// matchContext holds the information needed to match elements other than
+// the one a selector is being matched against.
+type matchContext struct {
+	states StateLookup
+
+	// The element that the relative selectors in a :has() are anchored
+	// to.
+	anchor *html.Node
+}
+
+// state returns the State of the element n.
+func (ctx *matchContext) state(n *html.Node) State {
+	if ctx.states == nil {
+		return State{}
+	}
+	return ctx.states(n)
+}
+
+// maxCompiledSelectors is the most selectors that are kept in
+// compiledSelectors, so that browsing many pages doesn't grow it forever.
+const maxCompiledSelectors = 8192
+
+var compiledSelectors = struct {
+	sync.RWMutex
+	m map[string]*complexSelector
+}{m: make(map[string]*complexSelector)}
+
+// compile returns the parsed form of the selector. Selectors are only parsed
+// once, no matter how many rules or elements they're used for, unless they've
+// been evicted from the cache to make room for others.
+func (s CSSSelector) compile() *complexSelector {
+	compiledSelectors.RLock()
+	c, ok := compiledSelectors.m[s.Selector]
+	compiledSelectors.RUnlock()
+	if ok {
+		return c
+	}
+	c, ok = parseComplexSelector(ParseComponentValues(s.Selector))
+	if !ok {
+		c = &complexSelector{invalid: true}
+	}
+	compiledSelectors.Lock()
+	for k := range compiledSelectors.m {
+		if len(compiledSelectors.m) < maxCompiledSelectors {
+			break
 		}
+		// Map iteration order is random, so this evicts an
+		// arbitrary selector.
+		delete(compiledSelectors.m, k)
+	}
+	compiledSelectors.m[s.Selector] = c
+	compiledSelectors.Unlock()
+	return c
+}
 
-	default:
-		return false
+// parseComplexSelector parses a single complex selector, such as
+// "div.header > a:link".
+func parseComplexSelector(vals []ComponentValue) (*complexSelector, bool) {
+	vals = trimWhitespace(vals)
+	if len(vals) == 0 {
+		return nil, false
+	}
+	c := &complexSelector{}
+	var cur compoundSelector
+	empty := true
+	var pending combinator
+	for i := 0; i < len(vals); i++ {
+		if tokenType(vals[i]) == WhitespaceToken {
+			if pending == 0 {
+				pending = descendantCombinator
+			}
+			continue
+		}
+		if t, ok := vals[i].(Token); ok && (t.isDelim(">") || t.isDelim("+") || t.isDelim("~")) {
+			if empty || (pending != 0 && pending != descendantCombinator) {
+				// A combinator needs a compound selector on
+				// both sides.
+				return nil, false
+			}
+			pending = combinator(t.Value[0])
+			continue
+		}
+		if pending != 0 {
+			c.compounds = append(c.compounds, cur)
+			c.combinators = append(c.combinators, pending)
+			cur = compoundSelector{}
+			empty = true
+			pending = 0
+		}
+		n, ok := cur.parseSimple(vals[i:], empty)
+		if !ok {
+			return nil, false
+		}
+		i += n - 1
+		empty = false
 	}
+	if pending != 0 && pending != descendantCombinator {
+		return nil, false
+	}
+	c.compounds = append(c.compounds, cur)
+	for _, compound := range c.compounds[:len(c.compounds)-1] {
+		if compound.pseudoElement != "" {
+			// Pseudo-elements must be in the last compound selector.
+			return nil, false
+		}
+	}
+	return c, true
+}
 
-	if idSelector != "" {
-		matchedId := false
-		for _, attrib := range el.Attr {
-			if attrib.Key == "id" {
-				if strings.ToLower(attrib.Val) == strings.ToLower(idSelector) {
-					matchedId = true
-				} else {
-					matchedId = false
+// parseSimple parses the simple selector at the start of vals and adds it to
+// c. It returns the number of component values consumed. first is true if
+// this is the first simple selector in the compound, which is the only place
+// a type selector is allowed.
+func (c *compoundSelector) parseSimple(vals []ComponentValue, first bool) (int, bool) {
+	if c.pseudoElement != "" {
+		// Nothing may follow a pseudo-element.
+		return 0, false
+	}
+	switch v := vals[0].(type) {
+	case Token:
+		switch v.Type {
+		case IdentToken:
+			if !first {
+				return 0, false
+			}
+			c.tag = strings.ToLower(v.Value)
+			return 1, true
+		case HashToken:
+			if !v.ID {
+				return 0, false
+			}
+			c.simple = append(c.simple, idSelector(v.Value))
+			return 1, true
+		case DelimToken:
+			switch v.Value {
+			case "*":
+				if !first {
+					return 0, false
 				}
-				break
+				c.tag = "*"
+				return 1, true
+			case ".":
+				if len(vals) < 2 {
+					return 0, false
+				}
+				class, ok := vals[1].(Token)
+				if !ok || class.Type != IdentToken {
+					return 0, false
+				}
+				c.simple = append(c.simple, classSelector(class.Value))
+				return 2, true
 			}
+		case ColonToken:
+			return c.parsePseudo(vals)
 		}
-		if matchedId == false {
-			return false
+	case *SimpleBlock:
+		if v.Associated == OpenSquareToken {
+			attr, ok := parseAttributeSelector(v.Value)
+			if !ok {
+				return 0, false
+			}
+			c.simple = append(c.simple, attr)
+			return 1, true
 		}
 	}
-	if classSelector != "" {
-		matchedClass := false
-		for _, attrib := range el.Attr {
-			if attrib.Key == "class" {
-				classes := strings.Fields(attrib.Val)
-				for _, class := range classes {
-					if strings.ToLower(classSelector) == strings.ToLower(class) {
-						matchedClass = true
-					}
-				}
-				break
+	return 0, false
+}
+
+// parsePseudo parses a pseudo-class or pseudo-element starting at the colon
+// at the start of vals.
+func (c *compoundSelector) parsePseudo(vals []ComponentValue) (int, bool) {
+	if len(vals) < 2 {
+		return 0, false
+	}
+	element := false
+	n := 1
+	if tokenType(vals[1]) == ColonToken {
+		element = true
+		n = 2
+		if len(vals) < 3 {
+			return 0, false
+		}
+	}
+	switch v := vals[n].(type) {
+	case Token:
+		if v.Type != IdentToken {
+			return 0, false
+		}
+		name := strings.ToLower(v.Value)
+		switch name {
+		case "first-line", "first-letter", "before", "after":
+			// CSS2 allowed these pseudo-elements with a single
+			// colon.
+			element = true
+		}
+		if element {
+			c.pseudoElement = name
+			return n + 1, true
+		}
+		switch name {
+		case "first-child":
+			c.simple = append(c.simple, nthSelector{b: 1})
+		case "last-child":
+			c.simple = append(c.simple, nthSelector{b: 1, fromEnd: true})
+		case "first-of-type":
+			c.simple = append(c.simple, nthSelector{b: 1, ofType: true})
+		case "last-of-type":
+			c.simple = append(c.simple, nthSelector{b: 1, fromEnd: true, ofType: true})
+		case "only-child":
+			c.simple = append(c.simple, onlyChildSelector{})
+		case "only-of-type":
+			c.simple = append(c.simple, onlyChildSelector{ofType: true})
+		case "root", "empty", "link", "visited", "active", "hover", "focus", "focus-within":
+			c.simple = append(c.simple, pseudoClassSelector(name))
+		default:
+			c.simple = append(c.simple, unsupportedSelector{Specificity{Classes: 1}})
+		}
+		return n + 1, true
+	case *Function:
+		if element {
+			return 0, false
+		}
+		switch strings.ToLower(v.Name) {
+		case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+			nth, ok := parseNthSelector(v)
+			if !ok {
+				return 0, false
+			}
+			c.simple = append(c.simple, nth)
+		case "not":
+			l, ok := parseSelectorListArgument(v.Value)
+			if !ok {
+				return 0, false
 			}
+			c.simple = append(c.simple, notSelector{l})
+		case "is", "where":
+			c.simple = append(c.simple, isSelector{
+				list:  parseForgivingSelectorList(v.Value),
+				where: strings.EqualFold(v.Name, "where"),
+			})
+		case "has":
+			l, ok := parseRelativeSelectorList(v.Value)
+			if !ok {
+				return 0, false
+			}
+			c.simple = append(c.simple, hasSelector(l))
+		default:
+			// Other functional pseudo-classes are not yet
+			// supported.
+			c.simple = append(c.simple, unsupportedSelector{Specificity{Classes: 1}})
 		}
-		if matchedClass == false {
-			return false
+		return n + 1, true
+	}
+	return 0, false
+}
+
+func (c *complexSelector) specificity() Specificity {
+	var s Specificity
+	for _, compound := range c.compounds {
+		s = s.add(compound.specificity())
+	}
+	return s
+}
+
+func (c compoundSelector) specificity() Specificity {
+	var s Specificity
+	if c.tag != "" && c.tag != "*" {
+		s.Elements++
+	}
+	if c.pseudoElement != "" {
+		s.Elements++
+	}
+	for _, simple := range c.simple {
+		s = s.add(simple.specificity())
+	}
+	return s
+}
+
+// matches checks if the element el matches the selector. Matching is done
+// from right to left, starting with the compound selector for el itself.
+func (c *complexSelector) matches(el *html.Node, ctx *matchContext) bool {
+	if c.invalid {
+		return false
+	}
+	return c.matchCompound(el, len(c.compounds)-1, ctx)
+}
+
+// matchCompound checks if el matches compounds[i], and that the elements
+// related to it by the combinators match the compounds to its left.
+func (c *complexSelector) matchCompound(el *html.Node, i int, ctx *matchContext) bool {
+	if !c.compounds[i].matches(el, ctx) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	switch c.combinators[i-1] {
+	case descendantCombinator:
+		for p := el.Parent; p != nil; p = p.Parent {
+			if c.matchCompound(p, i-1, ctx) {
+				return true
+			}
+		}
+	case childCombinator:
+		return el.Parent != nil && c.matchCompound(el.Parent, i-1, ctx)
+	case nextSiblingCombinator:
+		prev := previousElementSibling(el)
+		return prev != nil && c.matchCompound(prev, i-1, ctx)
+	case subsequentSiblingCombinator:
+		for prev := previousElementSibling(el); prev != nil; prev = previousElementSibling(prev) {
+			if c.matchCompound(prev, i-1, ctx) {
+				return true
+			}
 		}
 	}
-	switch pseudoSelector {
-	case "link":
-		if st.Link == false {
-			return false
+	return false
+}
+
+// previousElementSibling returns the closest sibling before el which is an
+// element, skipping over text and comment nodes.
+func previousElementSibling(el *html.Node) *html.Node {
+	for s := el.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.Type == html.ElementNode {
+			return s
 		}
-	case "visited":
-		// If it's true, keep checking other remainingData criteria
-		if st.Visited == false {
+	}
+	return nil
+}
+
+func (c compoundSelector) matches(el *html.Node, ctx *matchContext) bool {
+	if el == nil || el.Type != html.ElementNode {
+		return false
+	}
+	if c.tag != "" && c.tag != "*" && strings.ToLower(el.Data) != c.tag {
+		return false
+	}
+	for _, simple := range c.simple {
+		if !simple.matches(el, ctx) {
 			return false
 		}
-		// If it's true, keep checking other remainingData criteria
-	case "active":
-		if st.Link == false && st.Visited == false {
-			return false
+	}
+	// Pseudo-elements always match their originating element. The caller
+	// needs to selectively apply them.
+	return true
+}
+
+type idSelector string
+
+func (s idSelector) matches(el *html.Node, ctx *matchContext) bool {
+	for _, attrib := range el.Attr {
+		if attrib.Key == "id" {
+			return strings.ToLower(attrib.Val) == strings.ToLower(string(s))
 		}
-		if st.Active == false {
+	}
+	return false
+}
+
+func (s idSelector) specificity() Specificity {
+	return Specificity{IDs: 1}
+}
+
+type classSelector string
+
+func (s classSelector) matches(el *html.Node, ctx *matchContext) bool {
+	for _, attrib := range el.Attr {
+		if attrib.Key == "class" {
+			for _, class := range strings.Fields(attrib.Val) {
+				if strings.ToLower(string(s)) == strings.ToLower(class) {
+					return true
+				}
+			}
 			return false
 		}
-		// If it's true, keep checking other remainingData criteria
-	case "first-line", "first-letter":
-		// Always match pseudo-elements, the calling needs to selectively apply them
-		if strings.IndexAny(remainingData, ".#") != -1 {
-			// pseudo-elements must be at the end of the selector according to the CSS
-			// spec
-			return false
+	}
+	return false
+}
+
+func (s classSelector) specificity() Specificity {
+	return Specificity{Classes: 1}
+}
+
+// An attributeSelector matches an element based on its attributes, such as
+// [href] or [lang|="en" i].
+type attributeSelector struct {
+	name string
+
+	// The type of match. One of "" (the attribute only needs to exist),
+	// "=", "~=", "|=", "^=", "$=" or "*="
+	op    string
+	value string
+
+	// Set by the "i" modifier, or for HTML attributes whose values are
+	// case-insensitive. The "s" modifier forces it to be false.
+	caseInsensitive bool
+}
+
+// caseInsensitiveAttributes are the attributes which HTML says should be
+// matched ASCII case-insensitively by attribute selectors.
+var caseInsensitiveAttributes = map[string]bool{
+	"accept": true, "accept-charset": true, "align": true, "alink": true,
+	"axis": true, "bgcolor": true, "charset": true, "checked": true,
+	"clear": true, "codetype": true, "color": true, "compact": true,
+	"declare": true, "defer": true, "dir": true, "direction": true,
+	"disabled": true, "enctype": true, "face": true, "frame": true,
+	"hreflang": true, "http-equiv": true, "lang": true, "language": true,
+	"link": true, "media": true, "method": true, "multiple": true,
+	"nohref": true, "noresize": true, "noshade": true, "nowrap": true,
+	"readonly": true, "rel": true, "rev": true, "rules": true,
+	"scope": true, "scrolling": true, "selected": true, "shape": true,
+	"target": true, "text": true, "type": true, "valign": true,
+	"valuetype": true, "vlink": true,
+}
+
+// parseAttributeSelector parses the contents of a [] block in a selector.
+func parseAttributeSelector(vals []ComponentValue) (attributeSelector, bool) {
+	vals = stripWhitespace(vals)
+
+	// Namespaces aren't supported, but "*|attr" and "|attr" are
+	// equivalent to "attr" in HTML.
+	if len(vals) >= 3 && tokenType(vals[2]) == IdentToken {
+		if t, ok := vals[1].(Token); ok && t.isDelim("|") {
+			if ns, ok := vals[0].(Token); ok && ns.isDelim("*") {
+				vals = vals[2:]
+			}
 		}
-	case "":
+	}
+	if len(vals) >= 2 && tokenType(vals[1]) == IdentToken {
+		if t, ok := vals[0].(Token); ok && t.isDelim("|") {
+			vals = vals[1:]
+		}
+	}
+
+	if len(vals) == 0 || tokenType(vals[0]) != IdentToken {
+		return attributeSelector{}, false
+	}
+	name := strings.ToLower(vals[0].(Token).Value)
+	attr := attributeSelector{name: name, caseInsensitive: caseInsensitiveAttributes[name]}
+	vals = vals[1:]
+	if len(vals) == 0 {
+		return attr, true
+	}
+
+	op, ok := vals[0].(Token)
+	if !ok || op.Type != DelimToken {
+		return attributeSelector{}, false
+	}
+	switch op.Value {
+	case "=":
+		attr.op = "="
+		vals = vals[1:]
+	case "~", "|", "^", "$", "*":
+		if len(vals) < 2 {
+			return attributeSelector{}, false
+		}
+		if eq, ok := vals[1].(Token); !ok || !eq.isDelim("=") {
+			return attributeSelector{}, false
+		}
+		attr.op = op.Value + "="
+		vals = vals[2:]
 	default:
-		//panic("Unsupported pseudo-selector" + pseudoSelector)
+		return attributeSelector{}, false
+	}
+
+	if len(vals) == 0 {
+		return attributeSelector{}, false
+	}
+	val, ok := vals[0].(Token)
+	if !ok || (val.Type != IdentToken && val.Type != StringToken) {
+		return attributeSelector{}, false
+	}
+	attr.value = val.Value
+	vals = vals[1:]
+
+	switch len(vals) {
+	case 0:
+		return attr, true
+	case 1:
+		modifier, ok := vals[0].(Token)
+		if !ok {
+			return attributeSelector{}, false
+		}
+		switch {
+		case modifier.isIdent("i"):
+			attr.caseInsensitive = true
+			return attr, true
+		case modifier.isIdent("s"):
+			attr.caseInsensitive = false
+			return attr, true
+		}
+	}
+	return attributeSelector{}, false
+}
+
+func (s attributeSelector) matches(el *html.Node, ctx *matchContext) bool {
+	for _, attrib := range el.Attr {
+		if strings.ToLower(attrib.Key) != s.name || attrib.Namespace != "" {
+			continue
+		}
+		val, want := attrib.Val, s.value
+		if s.caseInsensitive {
+			val, want = strings.ToLower(val), strings.ToLower(want)
+		}
+		switch s.op {
+		case "":
+			return true
+		case "=":
+			return val == want
+		case "~=":
+			// A whitespace separated list of words, one of which is
+			// exactly want.
+			if want == "" || strings.ContainsAny(want, " \t\n\r\f") {
+				return false
+			}
+			for _, word := range strings.Fields(val) {
+				if word == want {
+					return true
+				}
+			}
+			return false
+		case "|=":
+			return val == want || strings.HasPrefix(val, want+"-")
+		case "^=":
+			return want != "" && strings.HasPrefix(val, want)
+		case "$=":
+			return want != "" && strings.HasSuffix(val, want)
+		case "*=":
+			return want != "" && strings.Contains(val, want)
+		}
 		return false
 	}
+	return false
+}
+
+func (s attributeSelector) specificity() Specificity {
+	return Specificity{Classes: 1}
+}
+
+type pseudoClassSelector string
+
+func (s pseudoClassSelector) matches(el *html.Node, ctx *matchContext) bool {
+	st := ctx.state(el)
+	switch s {
+	case "root":
+		return el.Parent != nil && el.Parent.Type == html.DocumentNode
+	case "empty":
+		for c := el.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case html.ElementNode:
+				return false
+			case html.TextNode:
+				if c.Data != "" {
+					return false
+				}
+			}
+		}
+		return true
+	case "link":
+		return st.Link
+	case "visited":
+		return st.Visited
+	case "active":
+		return st.Active
+	case "hover":
+		return st.Hover
+	case "focus":
+		return st.Focus
+	case "focus-within":
+		return st.Focus || st.FocusWithin
+	}
+	return false
+}
 
-	// all the classes and ids have been matched.
-	if remainingData == "" {
+// dynamic reports whether the pseudo-class depends on the user's interaction
+// with the page.
+func (s pseudoClassSelector) dynamic() bool {
+	switch s {
+	case "active", "hover", "focus", "focus-within":
 		return true
 	}
-	if remainingData == s {
-		// this shouldn't happen, but if nothing was consumed assume it doesn't match and prevent
-		// an infinite loop
-		return false
+	return false
+}
+
+func (s pseudoClassSelector) specificity() Specificity {
+	return Specificity{Classes: 1}
+}
+
+// A selectorList is a comma separated list of complex selectors, as used in
+// the arguments of some functional pseudo-classes. It matches if any of the
+// selectors match.
+type selectorList []*complexSelector
+
+// parseSelectorListArgument parses a selector list. If any of the selectors
+// are invalid, the whole list is invalid.
+func parseSelectorListArgument(vals []ComponentValue) (selectorList, bool) {
+	var l selectorList
+	for _, sel := range splitOnCommas(vals) {
+		c, ok := parseArgumentSelector(sel)
+		if !ok {
+			return nil, false
+		}
+		l = append(l, c)
 	}
-	// check the unconsumed pieces
-	return matchIDAndClassAndPseudoSelector(el, remainingData, st)
+	return l, true
 }
 
-func matchBasicSelector(el *html.Node, s string, st State) bool {
-	if el == nil || len(s) < 1 || el.Type != html.ElementNode {
-		return false
+// parseForgivingSelectorList parses a selector list, dropping any selectors
+// which are invalid rather than invalidating the whole list.
+func parseForgivingSelectorList(vals []ComponentValue) selectorList {
+	var l selectorList
+	for _, sel := range splitOnCommas(vals) {
+		if c, ok := parseArgumentSelector(sel); ok {
+			l = append(l, c)
+		}
+	}
+	return l
+}
+
+// parseArgumentSelector parses a complex selector that is an argument to a
+// pseudo-class. Pseudo-elements are not valid in arguments.
+func parseArgumentSelector(vals []ComponentValue) (*complexSelector, bool) {
+	c, ok := parseComplexSelector(vals)
+	if !ok || c.compounds[len(c.compounds)-1].pseudoElement != "" {
+		return nil, false
+	}
+	return c, true
+}
+
+func (l selectorList) matches(el *html.Node, ctx *matchContext) bool {
+	for _, c := range l {
+		if c.matches(el, ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// specificity returns the specificity of the most specific selector in the
+// list.
+func (l selectorList) specificity() Specificity {
+	var max Specificity
+	for _, c := range l {
+		if s := c.specificity(); max.Less(s) {
+			max = s
+		}
+	}
+	return max
+}
+
+// notSelector is the :not() pseudo-class, which matches elements which don't
+// match any of the selectors in its argument.
+type notSelector struct {
+	list selectorList
+}
+
+func (s notSelector) matches(el *html.Node, ctx *matchContext) bool {
+	return !s.list.matches(el, ctx)
+}
+
+func (s notSelector) specificity() Specificity {
+	return s.list.specificity()
+}
+
+// isSelector is the :is() pseudo-class, or :where() if where is set. They
+// match elements which match any selector in the argument, but :where()
+// doesn't add to the specificity.
+type isSelector struct {
+	list  selectorList
+	where bool
+}
+
+func (s isSelector) matches(el *html.Node, ctx *matchContext) bool {
+	return s.list.matches(el, ctx)
+}
+
+func (s isSelector) specificity() Specificity {
+	if s.where {
+		return Specificity{}
 	}
-	elementMatchTag := ""
-	remainingData := ""
+	return s.list.specificity()
+}
+
+// A relativeSelector is a selector which starts with a combinator, such as
+// "> img" in "a:has(> img)". It's stored as a complex selector whose first
+// compound only matches the anchor element that :has() is being evaluated
+// against.
+type relativeSelector struct {
+	*complexSelector
+}
 
-	if idx := strings.IndexAny(s, "*.#:"); idx != -1 {
-		elementMatchTag = s[0:idx]
-		remainingData = s[idx:]
-		if remainingData[0] == '*' {
-			remainingData = remainingData[1:]
+// anchorSelector only matches the element that a relative selector is
+// anchored to.
+type anchorSelector struct{}
+
+func (s anchorSelector) matches(el *html.Node, ctx *matchContext) bool {
+	return el == ctx.anchor
+}
+
+func (s anchorSelector) specificity() Specificity {
+	return Specificity{}
+}
+
+// parseRelativeSelectorList parses the argument of :has(). If any of the
+// selectors are invalid, the whole list is invalid.
+func parseRelativeSelectorList(vals []ComponentValue) ([]relativeSelector, bool) {
+	var l []relativeSelector
+	for _, sel := range splitOnCommas(vals) {
+		sel = trimWhitespace(sel)
+		comb := descendantCombinator
+		if len(sel) > 0 {
+			if t, ok := sel[0].(Token); ok && (t.isDelim(">") || t.isDelim("+") || t.isDelim("~")) {
+				comb = combinator(t.Value[0])
+				sel = sel[1:]
+			}
+		}
+		c, ok := parseArgumentSelector(sel)
+		if !ok {
+			return nil, false
 		}
-	} else {
-		elementMatchTag = s
+		for _, compound := range c.compounds {
+			for _, simple := range compound.simple {
+				if _, ok := simple.(hasSelector); ok {
+					// :has() can't be nested.
+					return nil, false
+				}
+			}
+		}
+		l = append(l, relativeSelector{&complexSelector{
+			compounds:   append([]compoundSelector{{simple: []simpleSelector{anchorSelector{}}}}, c.compounds...),
+			combinators: append([]combinator{comb}, c.combinators...),
+		}})
 	}
-	if elementMatchTag != "" && strings.ToLower(el.Data) != strings.ToLower(elementMatchTag) {
+	return l, true
+}
+
+// leadingCombinator returns the combinator between the anchor and the rest
+// of the selector.
+func (r relativeSelector) leadingCombinator() combinator {
+	return r.combinators[0]
+}
+
+// hasSelector is the :has() pseudo-class, which matches elements that
+// have another element matching a relative selector in its argument.
+type hasSelector []relativeSelector
+
+func (s hasSelector) matches(el *html.Node, ctx *matchContext) bool {
+	oldAnchor := ctx.anchor
+	ctx.anchor = el
+	defer func() { ctx.anchor = oldAnchor }()
+
+	for _, rel := range s {
+		// Find the elements that could possibly match, then check them
+		// against the anchored selector.
+		var candidates []*html.Node
+		switch rel.leadingCombinator() {
+		case descendantCombinator, childCombinator:
+			candidates = descendants(el, candidates)
+		default:
+			for sib := nextElementSibling(el); sib != nil; sib = nextElementSibling(sib) {
+				candidates = append(candidates, sib)
+				candidates = descendants(sib, candidates)
+			}
+		}
+		for _, c := range candidates {
+			if rel.matches(c, ctx) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s hasSelector) specificity() Specificity {
+	var max Specificity
+	for _, rel := range s {
+		if spec := rel.specificity(); max.Less(spec) {
+			max = spec
+		}
+	}
+	return max
+}
+
+// descendants appends all the elements which are descendants of el to
+// nodes.
+func descendants(el *html.Node, nodes []*html.Node) []*html.Node {
+	for c := el.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			nodes = append(nodes, c)
+			nodes = descendants(c, nodes)
+		}
+	}
+	return nodes
+}
+
+// An nthSelector is one of the :nth-child() family of pseudo-classes, which
+// match an element whose index among its siblings is a*n+b for some n >= 0.
+// :first-child and friends are nthSelectors with a = 0 and b = 1.
+type nthSelector struct {
+	a, b int
+
+	// Count from the last sibling instead of the first.
+	fromEnd bool
+
+	// Only count siblings with the same element type.
+	ofType bool
+
+	// Only count siblings which match this list, from
+	// :nth-child(An+B of S)
+	of selectorList
+}
+
+// parseNthSelector parses the arguments of a :nth-child() style function.
+func parseNthSelector(f *Function) (nthSelector, bool) {
+	var sel nthSelector
+	name := strings.ToLower(f.Name)
+	sel.fromEnd = strings.HasPrefix(name, "nth-last-")
+	sel.ofType = strings.HasSuffix(name, "-of-type")
+
+	args := f.Value
+	if !sel.ofType {
+		for i, v := range args {
+			if t, ok := v.(Token); ok && t.isIdent("of") {
+				of, ok := parseSelectorListArgument(args[i+1:])
+				if !ok {
+					return nthSelector{}, false
+				}
+				sel.of = of
+				args = args[:i]
+				break
+			}
+		}
+	}
+	a, b, ok := parseAnPlusB(args)
+	if !ok {
+		return nthSelector{}, false
+	}
+	sel.a, sel.b = a, b
+	return sel, true
+}
+
+// parseAnPlusB parses the An+B microsyntax from section 6 of CSS Syntax, such
+// as "2n+1", "-n+3", "odd" or "5".
+func parseAnPlusB(vals []ComponentValue) (a, b int, ok bool) {
+	var str strings.Builder
+	for _, v := range vals {
+		t, ok := v.(Token)
+		if !ok {
+			return 0, 0, false
+		}
+		if t.Type != WhitespaceToken {
+			str.WriteString(t.String())
+		}
+	}
+	s := strings.ToLower(str.String())
+	switch s {
+	case "":
+		return 0, 0, false
+	case "odd":
+		return 2, 1, true
+	case "even":
+		return 2, 0, true
+	}
+
+	idx := strings.IndexByte(s, 'n')
+	if idx == -1 {
+		b, err := strconv.Atoi(s)
+		return 0, b, err == nil
+	}
+	switch prefix := s[:idx]; prefix {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		var err error
+		if a, err = strconv.Atoi(prefix); err != nil {
+			return 0, 0, false
+		}
+	}
+	if rest := s[idx+1:]; rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			return 0, 0, false
+		}
+		var err error
+		if b, err = strconv.Atoi(rest); err != nil {
+			return 0, 0, false
+		}
+	}
+	return a, b, true
+}
+
+// nextElementSibling returns the closest sibling after el which is an
+// element.
+func nextElementSibling(el *html.Node) *html.Node {
+	for s := el.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s nthSelector) matches(el *html.Node, ctx *matchContext) bool {
+	if el.Parent == nil {
 		return false
 	}
-	if remainingData == "" {
-		// matched the tag and there's nothing left
-		return true
+	if s.of != nil && !s.of.matches(el, ctx) {
+		return false
+	}
+	sibling := previousElementSibling
+	if s.fromEnd {
+		sibling = nextElementSibling
+	}
+	idx := 1
+	for sib := sibling(el); sib != nil; sib = sibling(sib) {
+		if s.ofType && strings.ToLower(sib.Data) != strings.ToLower(el.Data) {
+			continue
+		}
+		if s.of != nil && !s.of.matches(sib, ctx) {
+			continue
+		}
+		idx++
 	}
 
-	return matchIDAndClassAndPseudoSelector(el, remainingData, st)
+	if s.a == 0 {
+		return idx == s.b
+	}
+	// idx = a*n + b for some integer n >= 0
+	n := idx - s.b
+	return n%s.a == 0 && n/s.a >= 0
 }
 
-func recursiveParentMatches(el *html.Node, selectorPieces []string, requireMatch bool, s State) bool {
-	switch len(selectorPieces) {
-	case 0:
+func (s nthSelector) specificity() Specificity {
+	return Specificity{Classes: 1}.add(s.of.specificity())
+}
+
+// onlyChildSelector is the :only-child pseudo-class, or :only-of-type if
+// ofType is set.
+type onlyChildSelector struct {
+	ofType bool
+}
+
+func (s onlyChildSelector) matches(el *html.Node, ctx *matchContext) bool {
+	if el.Parent == nil {
 		return false
-	case 1:
-		if matchBasicSelector(el, selectorPieces[0], s) {
-			return true
+	}
+	for sib := el.Parent.FirstChild; sib != nil; sib = sib.NextSibling {
+		if sib == el || sib.Type != html.ElementNode {
+			continue
 		}
-		if el == nil {
+		if !s.ofType || strings.ToLower(sib.Data) == strings.ToLower(el.Data) {
 			return false
 		}
-		return recursiveParentMatches(el.Parent, selectorPieces, requireMatch, s)
-	default:
-		lastSelector := selectorPieces[len(selectorPieces)-1]
-		otherSelectors := selectorPieces[0 : len(selectorPieces)-1]
-		if matchBasicSelector(el, lastSelector, s) == true {
-			return recursiveParentMatches(el.Parent, otherSelectors, false, s)
-		} else if requireMatch {
-			return false
+	}
+	return true
+}
+
+func (s onlyChildSelector) specificity() Specificity {
+	return Specificity{Classes: 1}
+}
+
+// unsupportedSelector is a simple selector which gob can parse, but doesn't
+// know how to match. It never matches anything.
+type unsupportedSelector struct {
+	spec Specificity
+}
+
+func (s unsupportedSelector) matches(el *html.Node, ctx *matchContext) bool {
+	return false
+}
+
+func (s unsupportedSelector) specificity() Specificity {
+	return s.spec
+}
+
+// unsupported reports whether any of the compound selectors in c contain
+// an unsupportedSelector, so that c never matches anything.
+func (c *complexSelector) unsupported() bool {
+	for _, compound := range c.compounds {
+		for _, simple := range compound.simple {
+			if _, ok := simple.(unsupportedSelector); ok {
+				return true
+			}
 		}
-		return recursiveParentMatches(el.Parent, otherSelectors, false, s)
 	}
+	return false
 }
+
+// Matches reports whether the element el, which is in the state st, matches
+// the selector. All other elements are assumed to be in the zero State.
 func (s CSSSelector) Matches(el *html.Node, st State) bool {
-	pieces := strings.Fields(s.Selector)
-	if len(pieces) <= 1 {
-		return matchBasicSelector(el, pieces[0], st)
-	}
-	return recursiveParentMatches(el, pieces, true, st)
+	return s.MatchesStates(el, func(n *html.Node) State {
+		if n == el {
+			return st
+		}
+		return State{}
+	})
 }
 
-func (s CSSSelector) NumberIDs() int {
-	return strings.Count(s.Selector, "#")
+// MatchesStates reports whether the element el matches the selector, looking
+// up the state of any elements involved with states.
+func (s CSSSelector) MatchesStates(el *html.Node, states StateLookup) bool {
+	return s.compile().matches(el, &matchContext{states: states})
 }
-func (s CSSSelector) NumberAttrs() int {
-	return strings.Count(s.Selector, "[")
+
+// IsDynamic reports whether the selector depends on user action
+// pseudo-classes such as :hover, so that whether it matches may change
+// without the document changing.
+func (s CSSSelector) IsDynamic() bool {
+	return s.compile().dynamic()
 }
-func (s CSSSelector) NumberClasses() int {
-	return strings.Count(s.Selector, ".")
+
+func (c *complexSelector) dynamic() bool {
+	for _, compound := range c.compounds {
+		for _, simple := range compound.simple {
+			switch sel := simple.(type) {
+			case pseudoClassSelector:
+				if sel.dynamic() {
+					return true
+				}
+			case notSelector:
+				if sel.list.dynamic() {
+					return true
+				}
+			case isSelector:
+				if sel.list.dynamic() {
+					return true
+				}
+			case nthSelector:
+				if sel.of.dynamic() {
+					return true
+				}
+			case hasSelector:
+				for _, rel := range sel {
+					if rel.dynamic() {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
 }
-func (s CSSSelector) NumberElements() int {
-	pieces := strings.Fields(s.Selector)
-	elems := len(pieces)
-	for _, piece := range pieces {
-		elems += strings.Count(piece, "+")
+
+func (l selectorList) dynamic() bool {
+	for _, c := range l {
+		if c.dynamic() {
+			return true
+		}
 	}
-	return elems
+	return false
 }
 
-func (s CSSSelector) NumberPseudo() int {
-	return strings.Count(s.Selector, ":")
+// Specificity returns the specificity of the selector.
+func (s CSSSelector) Specificity() Specificity {
+	return s.compile().specificity()
+}
+
+// PseudoElement returns the name of the pseudo-element that the selector
+// selects, such as "first-line", or the empty string if it selects the
+// element itself.
+func (s CSSSelector) PseudoElement() string {
+	c := s.compile()
+	if c.invalid {
+		return ""
+	}
+	return c.compounds[len(c.compounds)-1].pseudoElement
 }
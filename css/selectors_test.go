@@ -2,6 +2,7 @@ package css
 
 import (
 	"golang.org/x/net/html"
+	"strconv"
 	"strings"
 	"testing"
 	//"fmt"
@@ -258,3 +259,546 @@ func TestCSS1PseudoSelector(t *testing.T) {
 		t.Error("h1 is did not match first-line by class")
 	}
 }
+
+func TestSelectorSpecificity(t *testing.T) {
+	tests := []struct {
+		Selector string
+		Expected Specificity
+	}{
+		{"*", Specificity{0, 0, 0}},
+		{"li", Specificity{0, 0, 1}},
+		{"ul li", Specificity{0, 0, 2}},
+		{"UL OL LI", Specificity{0, 0, 3}},
+		{"h1:first-line", Specificity{0, 0, 2}},
+		{"h1::first-letter", Specificity{0, 0, 2}},
+		{".title", Specificity{0, 1, 0}},
+		{"*.title", Specificity{0, 1, 0}},
+		{"a:link", Specificity{0, 1, 1}},
+		{"ul li.red", Specificity{0, 1, 2}},
+		{"li.red.level", Specificity{0, 2, 1}},
+		{"#x34y", Specificity{1, 0, 0}},
+		{"div#sitediv.site", Specificity{1, 1, 1}},
+	}
+	for _, tc := range tests {
+		if got := (CSSSelector{tc.Selector, 0}).Specificity(); got != tc.Expected {
+			t.Errorf("Unexpected specificity for %q: got %v want %v", tc.Selector, got, tc.Expected)
+		}
+	}
+}
+
+func TestSelectorPseudoElement(t *testing.T) {
+	tests := []struct {
+		Selector string
+		Expected string
+	}{
+		{"h1", ""},
+		{"a:link", ""},
+		{"h1:first-line", "first-line"},
+		{".title::first-letter", "first-letter"},
+		{"p:before", "before"},
+		{"p::after", "after"},
+		{"li::marker", "marker"},
+		// Pseudo-elements must be in the last compound selector.
+		{"p::after span", ""},
+	}
+	for _, tc := range tests {
+		if got := (CSSSelector{tc.Selector, 0}).PseudoElement(); got != tc.Expected {
+			t.Errorf("Unexpected pseudo-element for %q: got %q want %q", tc.Selector, got, tc.Expected)
+		}
+	}
+}
+
+func TestCombinatorSelectors(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+	<nav id="nav">
+		<ul id="navlist">
+			<li id="li1"><ul id="nested"><li id="nestedli">Nested</li></ul></li>
+			<li id="li2">Two</li>
+			<!-- comment -->
+			<li id="li3">Three</li>
+		</ul>
+	</nav>
+	<h2 id="h2">Heading</h2>
+	text between
+	<p id="p1">First</p>
+	<p id="p2">Second</p>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]*html.Node)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for _, attr := range n.Attr {
+			if attr.Key == "id" {
+				ids[attr.Val] = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	tests := []struct {
+		Selector string
+		Element  string
+		Expected bool
+	}{
+		{"nav > ul", "navlist", true},
+		{"nav>ul", "navlist", true},
+		{"nav > ul", "nested", false},
+		{"nav ul", "nested", true},
+		{"nav > li", "li1", false},
+		{"ul > li", "li1", true},
+		{"nav > ul > li", "li2", true},
+		{"nav > ul > li", "nestedli", false},
+		{"nav li > ul > li", "nestedli", true},
+		{"h2 + p", "p1", true},
+		{"h2+p", "p1", true},
+		{"h2 + p", "p2", false},
+		{"p + p", "p2", true},
+		{"nav + h2", "h2", true},
+		{"#li2 + li", "li3", true},
+		{"#li1 + li", "li3", false},
+		{"h2 ~ p", "p1", true},
+		{"h2 ~ p", "p2", true},
+		{"h2~p", "p2", true},
+		{"p ~ h2", "h2", false},
+		{"#li1 ~ li", "li3", true},
+		{"#li1 ~ li", "li1", false},
+		{"nav ~ h2 + p", "p1", true},
+		{"nav ~ h2 + p", "p2", false},
+		{"body > nav li + li", "li2", true},
+		{"body > nav li + li", "nestedli", false},
+		// Invalid selectors never match.
+		{"> p", "p1", false},
+		{"h2 +", "p1", false},
+		{"h2 + > p", "p1", false},
+	}
+	for _, tc := range tests {
+		el, ok := ids[tc.Element]
+		if !ok {
+			t.Fatalf("Could not find element %v", tc.Element)
+		}
+		if got := (CSSSelector{tc.Selector, 0}).Matches(el, State{}); got != tc.Expected {
+			t.Errorf("Unexpected match for %q against #%v: got %v want %v", tc.Selector, tc.Element, got, tc.Expected)
+		}
+	}
+}
+
+func TestAttributeSelectors(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+	<a id="a" href="https://example.com/page.html" class="one two" lang="en-US" title="Hello World" dir="LTR">Link</a>
+	<bdo id="bdo" dir="rtl">Text</bdo>
+	<p id="p" data-empty="">Paragraph</p>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]*html.Node)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for _, attr := range n.Attr {
+			if attr.Key == "id" {
+				ids[attr.Val] = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	tests := []struct {
+		Selector string
+		Element  string
+		Expected bool
+	}{
+		{"[href]", "a", true},
+		{"a[href]", "a", true},
+		{"[HREF]", "a", true},
+		{"[ href ]", "a", true},
+		{"*|[href]", "a", false},
+		{"[*|href]", "a", true},
+		{"[|href]", "a", true},
+		{"[href]", "p", false},
+		{"[data-empty]", "p", true},
+		{`[class="one two"]`, "a", true},
+		{`[class=one]`, "a", false},
+		{`[class~=one]`, "a", true},
+		{`[class~="two"]`, "a", true},
+		{`[class~="one two"]`, "a", false},
+		{`[class~=""]`, "a", false},
+		{`[lang|=en]`, "a", true},
+		{`[lang|="en-US"]`, "a", true},
+		{`[lang|=e]`, "a", false},
+		{`[href^="https://"]`, "a", true},
+		{`[href^="http://"]`, "a", false},
+		{`[href$=".html"]`, "a", true},
+		{`[href$=".pdf"]`, "a", false},
+		{`[href*="example"]`, "a", true},
+		{`[href*=""]`, "a", false},
+		{`[data-empty=""]`, "p", true},
+		{`[data-empty^=""]`, "p", false},
+		// Case sensitivity
+		{`[title="hello world"]`, "a", false},
+		{`[title="hello world" i]`, "a", true},
+		{`[title="hello world" I]`, "a", true},
+		{`[title="Hello World" s]`, "a", true},
+		{`[dir="ltr"]`, "a", true},
+		{`[dir="ltr" s]`, "a", false},
+		// The user agent stylesheet uses these.
+		{`BDO[DIR="rtl"]`, "bdo", true},
+		{`*[DIR="rtl"]`, "bdo", true},
+		{`*[DIR="ltr"]`, "bdo", false},
+		// Invalid selectors never match
+		{`[href=]`, "a", false},
+		{`[href="a" "b"]`, "a", false},
+		{`[href="a" x]`, "a", false},
+		{`[="a"]`, "a", false},
+		{`[href=="a"]`, "a", false},
+	}
+	for _, tc := range tests {
+		el, ok := ids[tc.Element]
+		if !ok {
+			t.Fatalf("Could not find element %v", tc.Element)
+		}
+		if got := (CSSSelector{tc.Selector, 0}).Matches(el, State{}); got != tc.Expected {
+			t.Errorf("Unexpected match for %q against #%v: got %v want %v", tc.Selector, tc.Element, got, tc.Expected)
+		}
+	}
+}
+
+func TestParseAnPlusB(t *testing.T) {
+	tests := []struct {
+		Value string
+		A, B  int
+		OK    bool
+	}{
+		{"odd", 2, 1, true},
+		{"EVEN", 2, 0, true},
+		{"5", 0, 5, true},
+		{"-5", 0, -5, true},
+		{"+5", 0, 5, true},
+		{"n", 1, 0, true},
+		{"+n", 1, 0, true},
+		{"-n", -1, 0, true},
+		{"2n", 2, 0, true},
+		{"2n+1", 2, 1, true},
+		{"2n + 1", 2, 1, true},
+		{"2n- 1", 2, -1, true},
+		{"-n+3", -1, 3, true},
+		{"-2n-3", -2, -3, true},
+		{"3N+0", 3, 0, true},
+		{"", 0, 0, false},
+		{"n3", 0, 0, false},
+		{"2n+", 0, 0, false},
+		{"x", 0, 0, false},
+		{"2.5n", 0, 0, false},
+	}
+	for _, tc := range tests {
+		a, b, ok := parseAnPlusB(ParseComponentValues(tc.Value))
+		if ok != tc.OK || (ok && (a != tc.A || b != tc.B)) {
+			t.Errorf("Unexpected result parsing %q: got (%v, %v, %v) want (%v, %v, %v)", tc.Value, a, b, ok, tc.A, tc.B, tc.OK)
+		}
+	}
+}
+
+func TestStructuralPseudoClasses(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+	<table>
+		<tr id="r1" class="x"><td id="c1">1</td></tr>
+		<tr id="r2"><td id="c2"></td></tr>
+		<tr id="r3" class="x"><td id="c3"><!-- comment --></td></tr>
+		<tr id="r4" class="x"><td id="c4"> </td></tr>
+		<tr id="r5"><td id="c5"><b id="b">x</b></td></tr>
+	</table>
+	<div id="d"><h2 id="h1">A</h2><p id="p1">B</p><p id="p2">C</p><h2 id="h2">D</h2><p id="p3">E</p></div>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]*html.Node)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for _, attr := range n.Attr {
+			if attr.Key == "id" {
+				ids[attr.Val] = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	ids["html"] = doc.FirstChild
+
+	tests := []struct {
+		Selector string
+		Element  string
+		Expected bool
+	}{
+		{":root", "html", true},
+		{"html:root", "html", true},
+		{":root", "d", false},
+		{":empty", "c2", true},
+		{":empty", "c3", true},
+		{":empty", "c4", false},
+		{":empty", "c1", false},
+		{":empty", "c5", false},
+		{"tr:first-child", "r1", true},
+		{"tr:first-child", "r2", false},
+		{"tr:last-child", "r5", true},
+		{"tr:last-child", "r4", false},
+		{"td:only-child", "c1", true},
+		{"b:only-child", "b", true},
+		{"tr:only-child", "r1", false},
+		{"tr:nth-child(odd)", "r1", true},
+		{"tr:nth-child(odd)", "r2", false},
+		{"tr:nth-child(odd)", "r3", true},
+		{"tr:nth-child(even)", "r2", true},
+		{"tr:nth-child(even)", "r4", true},
+		{"tr:nth-child(even)", "r5", false},
+		{"tr:nth-child(3)", "r3", true},
+		{"tr:nth-child(3)", "r4", false},
+		{"tr:nth-child(-n+2)", "r2", true},
+		{"tr:nth-child(-n+2)", "r3", false},
+		{"tr:nth-child(n+4)", "r3", false},
+		{"tr:nth-child(n+4)", "r4", true},
+		{"tr:nth-child(3n+1)", "r4", true},
+		{"tr:nth-child(3n+1)", "r5", false},
+		{"tr:nth-last-child(1)", "r5", true},
+		{"tr:nth-last-child(2)", "r4", true},
+		{"tr:nth-last-child(odd)", "r1", true},
+		{"tr:nth-last-child(odd)", "r2", false},
+		{"tr:nth-child(2 of .x)", "r3", true},
+		{"tr:nth-child(2 of .x)", "r2", false},
+		{"tr:nth-child(3 of .x)", "r4", true},
+		{"tr:nth-child(1 of .x)", "r2", false},
+		{"tr:nth-last-child(1 of .x)", "r4", true},
+		{"h2:first-of-type", "h1", true},
+		{"h2:first-of-type", "h2", false},
+		{"p:first-of-type", "p1", true},
+		{"p:first-child", "p1", false},
+		{"p:last-of-type", "p3", true},
+		{"h2:last-of-type", "h2", true},
+		{"p:nth-of-type(2)", "p2", true},
+		{"p:nth-of-type(2n+1)", "p3", true},
+		{"p:nth-last-of-type(1)", "p3", true},
+		{"p:nth-last-of-type(3)", "p1", true},
+		{"p:only-of-type", "p1", false},
+		{"td:only-of-type", "c1", true},
+		{"tr:nth-child(2):nth-last-child(4)", "r2", true},
+		// Invalid selectors never match
+		{"tr:nth-child()", "r1", false},
+		{"tr:nth-child(foo)", "r1", false},
+		{"tr:nth-child(1 of)", "r1", false},
+		{"p:nth-of-type(1 of p)", "p1", false},
+	}
+	for _, tc := range tests {
+		el, ok := ids[tc.Element]
+		if !ok {
+			t.Fatalf("Could not find element %v", tc.Element)
+		}
+		if got := (CSSSelector{tc.Selector, 0}).Matches(el, State{}); got != tc.Expected {
+			t.Errorf("Unexpected match for %q against #%v: got %v want %v", tc.Selector, tc.Element, got, tc.Expected)
+		}
+	}
+
+	specificity := []struct {
+		Selector string
+		Expected Specificity
+	}{
+		{"li:first-child", Specificity{0, 1, 1}},
+		{":nth-child(2n+1)", Specificity{0, 1, 0}},
+		{":nth-child(2n+1 of #foo, li.bar)", Specificity{1, 1, 0}},
+		{":nth-last-child(1 of li.bar)", Specificity{0, 2, 1}},
+	}
+	for _, tc := range specificity {
+		if got := (CSSSelector{tc.Selector, 0}).Specificity(); got != tc.Expected {
+			t.Errorf("Unexpected specificity for %q: got %v want %v", tc.Selector, got, tc.Expected)
+		}
+	}
+}
+
+func TestLogicalPseudoClasses(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body>
+	<nav id="nav">
+		<a id="a1" class="active" href="/"><img id="img" src="x.png"></a>
+		<a id="a2" href="/two">Two</a>
+		<a id="a3">Three</a>
+	</nav>
+	<section id="s1"><h2 id="h2">Title</h2><p id="p1">Text</p></section>
+	<section id="s2"><p id="p2">Text <em id="em">em</em></p></section>
+</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]*html.Node)
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for _, attr := range n.Attr {
+			if attr.Key == "id" {
+				ids[attr.Val] = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	tests := []struct {
+		Selector string
+		Element  string
+		Expected bool
+	}{
+		{"a:not(.active)", "a1", false},
+		{"a:not(.active)", "a2", true},
+		{"a:not([href])", "a3", true},
+		{"a:not(.active, [href])", "a2", false},
+		{"a:not(.active, [href])", "a3", true},
+		{"a:not(nav > a)", "a2", false},
+		{"p:not(section > :first-child)", "p1", true},
+		{"a:not(:first-child):not(:last-child)", "a2", true},
+		{"a:not(:first-child):not(:last-child)", "a3", false},
+		{":is(h2, p)", "p1", true},
+		{":is(h2, p)", "em", false},
+		{"section :is(h2, em)", "em", true},
+		{":is(#s1, #s2) > p", "p2", true},
+		{":is(p, :bogus(x))", "p1", true},
+		{":is(:bogus(x)", "p1", false},
+		{":where(h2, p)", "h2", true},
+		{":where(.nope)", "h2", false},
+		{"a:has(img)", "a1", true},
+		{"a:has(img)", "a2", false},
+		{"a:has(> img)", "a1", true},
+		{"nav:has(> img)", "nav", false},
+		{"nav:has(img)", "nav", true},
+		{"h2:has(+ p)", "h2", true},
+		{"p:has(+ p)", "p1", false},
+		{"h2:has(~ p)", "h2", true},
+		{"section:has(p em)", "s2", true},
+		{"section:has(p em)", "s1", false},
+		{"section:has(h2, em)", "s1", true},
+		{"section:has(h2, em)", "s2", true},
+		{"section:not(:has(h2))", "s2", true},
+		{"section:not(:has(h2))", "s1", false},
+		{"body > :has(> h2) p", "p1", true},
+		// Invalid selectors never match.
+		{"a:not(::before)", "a2", false},
+		{"a:not()", "a2", false},
+		{"section:has()", "s1", false},
+		{"section:has(>)", "s1", false},
+		{"section:has(:has(p))", "s1", false},
+	}
+	for _, tc := range tests {
+		el, ok := ids[tc.Element]
+		if !ok {
+			t.Fatalf("Could not find element %v", tc.Element)
+		}
+		if got := (CSSSelector{tc.Selector, 0}).Matches(el, State{}); got != tc.Expected {
+			t.Errorf("Unexpected match for %q against #%v: got %v want %v", tc.Selector, tc.Element, got, tc.Expected)
+		}
+	}
+
+	specificity := []struct {
+		Selector string
+		Expected Specificity
+	}{
+		{":not(#foo)", Specificity{1, 0, 0}},
+		{"a:not(.b, #c)", Specificity{1, 0, 1}},
+		{":is(p, .a, #b)", Specificity{1, 0, 0}},
+		{":is(p, .a)", Specificity{0, 1, 0}},
+		{"a:where(#b, .c)", Specificity{0, 0, 1}},
+		{":where(#b) :is(.c)", Specificity{0, 1, 0}},
+		{"a:has(> img.x)", Specificity{0, 1, 2}},
+		{"a:has(> img, #y)", Specificity{1, 0, 1}},
+	}
+	for _, tc := range specificity {
+		if got := (CSSSelector{tc.Selector, 0}).Specificity(); got != tc.Expected {
+			t.Errorf("Unexpected specificity for %q: got %v want %v", tc.Selector, got, tc.Expected)
+		}
+	}
+}
+
+func TestUserActionPseudoClasses(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><body><ul><li><a href="/">Link</a></li></ul></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := doc.FirstChild.FirstChild.NextSibling
+	ul := body.FirstChild
+	li := ul.FirstChild
+	a := li.FirstChild
+
+	states := map[*html.Node]State{
+		body: State{Hover: true, FocusWithin: true},
+		ul:   State{Hover: true, FocusWithin: true},
+		li:   State{Hover: true, FocusWithin: true},
+		a:    State{Hover: true, Focus: true, Link: true, Active: true},
+	}
+	lookup := func(n *html.Node) State {
+		return states[n]
+	}
+	tests := []struct {
+		Selector string
+		Element  *html.Node
+		Expected bool
+	}{
+		{"a:hover", a, true},
+		{"li:hover > a", a, true},
+		{"li:hover", li, true},
+		{"a:focus", a, true},
+		{"li:focus", li, false},
+		{"li:focus-within", li, true},
+		{"a:focus-within", a, true},
+		{"a:link:active", a, true},
+		{"li:active", li, false},
+		{"ul:not(:hover) a", a, false},
+	}
+	for _, tc := range tests {
+		if got := (CSSSelector{tc.Selector, 0}).MatchesStates(tc.Element, lookup); got != tc.Expected {
+			t.Errorf("Unexpected match for %q: got %v want %v", tc.Selector, got, tc.Expected)
+		}
+	}
+
+	// Without a lookup, only the element itself has a state.
+	if (CSSSelector{"li:hover > a", 0}).Matches(a, states[a]) {
+		t.Error("Matches used the state of the element for its parent")
+	}
+
+	dynamic := []struct {
+		Selector string
+		Expected bool
+	}{
+		{"a", false},
+		{"a:link", false},
+		{"a:hover", true},
+		{"li:focus-within a", true},
+		{":not(:active)", true},
+		{":is(p, :focus)", true},
+		{":has(> :hover)", true},
+	}
+	for _, tc := range dynamic {
+		if got := (CSSSelector{tc.Selector, 0}).IsDynamic(); got != tc.Expected {
+			t.Errorf("Unexpected IsDynamic for %q: got %v want %v", tc.Selector, got, tc.Expected)
+		}
+	}
+}
+
+func TestCompiledSelectorsAreBounded(t *testing.T) {
+	for i := 0; i < maxCompiledSelectors+100; i++ {
+		sel := CSSSelector{".class" + strconv.Itoa(i), 0}
+		if got := sel.Specificity(); got != (Specificity{0, 1, 0}) {
+			t.Fatalf("Unexpected specificity for %v: got %v", sel.Selector, got)
+		}
+	}
+	compiledSelectors.RLock()
+	n := len(compiledSelectors.m)
+	compiledSelectors.RUnlock()
+	if n > maxCompiledSelectors {
+		t.Errorf("Unexpected number of compiled selectors: got %v want at most %v", n, maxCompiledSelectors)
+	}
+}
@@ -0,0 +1,636 @@
+package css
+
+import (
+	"strings"
+)
+
+// A shorthand is a property that sets several longhand properties at once.
+type shorthand struct {
+	// The longhands that the shorthand sets. Any longhand that isn't
+	// given a value by the shorthand is reset to its initial value.
+	longhands []StyleAttribute
+
+	// expand parses the value of the shorthand into the values of its
+	// longhands. It returns false if the value is invalid, in which case
+	// the declaration is ignored.
+	expand func(vals []ComponentValue) (map[StyleAttribute]string, bool)
+}
+
+// shorthands are the shorthand properties that AddStyle expands, by name.
+var shorthands = map[StyleAttribute]shorthand{
+	"margin":  {sideLonghands("margin-*"), expandBoxSides("margin-*")},
+	"padding": {sideLonghands("padding-*"), expandBoxSides("padding-*")},
+	"inset":   {sideLonghands("*"), expandBoxSides("*")},
+
+	"border-width": {sideLonghands("border-*-width"), expandBoxSides("border-*-width")},
+	"border-style": {sideLonghands("border-*-style"), expandBoxSides("border-*-style")},
+	"border-color": {sideLonghands("border-*-color"), expandBoxSides("border-*-color")},
+
+	"border": {
+		append(append(sideLonghands("border-*-width"), sideLonghands("border-*-style")...), sideLonghands("border-*-color")...),
+		expandBorder("top", "right", "bottom", "left"),
+	},
+	"border-top":    {borderLonghands("border-top"), expandBorder("top")},
+	"border-right":  {borderLonghands("border-right"), expandBorder("right")},
+	"border-bottom": {borderLonghands("border-bottom"), expandBorder("bottom")},
+	"border-left":   {borderLonghands("border-left"), expandBorder("left")},
+	"outline":       {borderLonghands("outline"), expandOutline},
+
+	"background": {
+		[]StyleAttribute{"background-color", "background-image", "background-repeat", "background-attachment", "background-position"},
+		expandBackground,
+	},
+	"list-style": {
+		[]StyleAttribute{"list-style-type", "list-style-position", "list-style-image"},
+		expandListStyle,
+	},
+	"font": {
+		[]StyleAttribute{"font-style", "font-variant", "font-weight", "font-stretch", "font-size", "line-height", "font-family"},
+		expandFont,
+	},
+	"overflow": {[]StyleAttribute{"overflow-x", "overflow-y"}, expandOverflow},
+	"flex":     {[]StyleAttribute{"flex-grow", "flex-shrink", "flex-basis"}, expandFlex},
+	"grid-area": {
+		[]StyleAttribute{"grid-row-start", "grid-column-start", "grid-row-end", "grid-column-end"},
+		expandGridArea,
+	},
+}
+
+// expandShorthand adds a rule for every longhand of the shorthand sh, using
+// the values from the shorthand rule s. The rules keep the importance and
+// order of the shorthand, so that they override (or are overridden by)
+// longhands in the same way as the shorthand would.
+func (e *StyledElement) expandShorthand(sh shorthand, s StyleRule) {
+	var values map[StyleAttribute]string
+	if kw := cssWideKeyword(s.Value.Value); kw != "" {
+		values = make(map[StyleAttribute]string)
+		for _, name := range sh.longhands {
+			values[name] = kw
+		}
+	} else {
+		var ok bool
+		values, ok = sh.expand(trimWhitespace(ParseComponentValues(s.Value.Value)))
+		if !ok {
+			return
+		}
+	}
+	for _, name := range sh.longhands {
+		v, ok := values[name]
+		if !ok {
+			v = Properties[name].Initial
+		}
+		s.Name, s.Value.Value = name, v
+		e.rules = append(e.rules, s)
+	}
+}
+
+// longhands returns the longhand properties that the shorthand property name
+// sets, or name itself if it isn't a shorthand.
+func longhands(name StyleAttribute) []StyleAttribute {
+	if sh, ok := shorthands[name]; ok {
+		return sh.longhands
+	}
+	return []StyleAttribute{name}
+}
+
+// sideLonghands returns the name of the property for each side of a box, in
+// the order top, right, bottom, left. The * in format is replaced by the
+// side.
+func sideLonghands(format string) []StyleAttribute {
+	var ret []StyleAttribute
+	for _, side := range []string{"top", "right", "bottom", "left"} {
+		ret = append(ret, StyleAttribute(strings.Replace(format, "*", side, 1)))
+	}
+	return ret
+}
+
+// borderLonghands returns the width, style and colour longhands of the
+// border-like property prefix.
+func borderLonghands(prefix string) []StyleAttribute {
+	return []StyleAttribute{
+		StyleAttribute(prefix + "-width"),
+		StyleAttribute(prefix + "-style"),
+		StyleAttribute(prefix + "-color"),
+	}
+}
+
+// expandBoxSides returns an expander for a shorthand that takes one to four
+// values for the sides of a box, such as margin. A missing right side is
+// the same as the top, a missing bottom the same as the top, and a missing
+// left the same as the right.
+func expandBoxSides(format string) func(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	return func(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+		values := shorthandStrings(vals)
+		switch len(values) {
+		case 1:
+			values = append(values, values[0], values[0], values[0])
+		case 2:
+			values = append(values, values[0], values[1])
+		case 3:
+			values = append(values, values[1])
+		case 4:
+		default:
+			return nil, false
+		}
+		ret := make(map[StyleAttribute]string)
+		for i, name := range sideLonghands(format) {
+			ret[name] = values[i]
+		}
+		return ret, true
+	}
+}
+
+// shorthandStrings returns the string value of each of the whitespace
+// separated component values in vals.
+func shorthandStrings(vals []ComponentValue) []string {
+	var ret []string
+	for _, v := range nonWhitespace(vals) {
+		ret = append(ret, v.String())
+	}
+	return ret
+}
+
+// isLengthValue reports whether v is a length, including 0 and math
+// functions.
+func isLengthValue(v ComponentValue) bool {
+	switch v := v.(type) {
+	case Token:
+		switch v.Type {
+		case NumberToken:
+			return v.Number == 0
+		case DimensionToken:
+			_, ok := lengthToPx(UnitContext{}, v.Number, strings.ToLower(v.Unit))
+			return ok
+		}
+	case *Function:
+		return isMathFunction(v.String())
+	}
+	return false
+}
+
+// isIdent reports whether v is an identifier.
+func isIdent(v ComponentValue) bool {
+	return tokenType(v) == IdentToken
+}
+
+// identValue returns the lower case value of v if it's an identifier, or an
+// empty string if it's not.
+func identValue(v ComponentValue) string {
+	if t, ok := v.(Token); ok && t.Type == IdentToken {
+		return strings.ToLower(t.Value)
+	}
+	return ""
+}
+
+// parseBorder parses the value of a border-like shorthand into its width,
+// style and colour, each of which may be given at most once in any order.
+// Values which aren't given are empty.
+func parseBorder(vals []ComponentValue, isStyle func(string) bool) (width, style, clr string, ok bool) {
+	for _, v := range nonWhitespace(vals) {
+		s := v.String()
+		switch ident := identValue(v); {
+		case isLengthValue(v) || ident == "thin" || ident == "medium" || ident == "thick":
+			if width != "" {
+				return "", "", "", false
+			}
+			width = s
+		case isStyle(ident):
+			if style != "" {
+				return "", "", "", false
+			}
+			style = ident
+		case IsColor(s) && cssWideKeyword(s) == "":
+			if clr != "" {
+				return "", "", "", false
+			}
+			clr = s
+		default:
+			return "", "", "", false
+		}
+	}
+	return width, style, clr, true
+}
+
+// expandBorder returns an expander that sets the width, style and colour of
+// each of the border sides.
+func expandBorder(sides ...string) func(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	return func(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+		width, style, clr, ok := parseBorder(vals, IsBorderStyle)
+		if !ok {
+			return nil, false
+		}
+		ret := make(map[StyleAttribute]string)
+		for _, side := range sides {
+			set(ret, StyleAttribute("border-"+side+"-width"), width)
+			set(ret, StyleAttribute("border-"+side+"-style"), style)
+			set(ret, StyleAttribute("border-"+side+"-color"), clr)
+		}
+		return ret, true
+	}
+}
+
+// set sets the value of the property name in m to val, unless val is empty.
+func set(m map[StyleAttribute]string, name StyleAttribute, val string) {
+	if val != "" {
+		m[name] = val
+	}
+}
+
+func expandOutline(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	isStyle := func(s string) bool {
+		// Outlines can't be hidden, but can be auto.
+		return s == "auto" || (s != "hidden" && IsBorderStyle(s))
+	}
+	// invert is a valid outline colour, but not a valid border colour,
+	// so it's taken out before parsing the rest as a border.
+	var rest []ComponentValue
+	invert := false
+	for _, v := range nonWhitespace(vals) {
+		if identValue(v) != "invert" {
+			rest = append(rest, v)
+		} else if invert {
+			return nil, false
+		} else {
+			invert = true
+		}
+	}
+	width, style, clr, ok := parseBorder(rest, isStyle)
+	if !ok || (invert && clr != "") {
+		return nil, false
+	}
+	if invert {
+		clr = "invert"
+	}
+	ret := make(map[StyleAttribute]string)
+	set(ret, "outline-width", width)
+	set(ret, "outline-style", style)
+	set(ret, "outline-color", clr)
+	return ret, true
+}
+
+func expandBackground(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	ret := make(map[StyleAttribute]string)
+	var position []string
+	for _, v := range nonWhitespace(vals) {
+		s := v.String()
+		switch ident := identValue(v); {
+		case ident == "none" || tokenType(v) == URLToken || isURLFunction(v):
+			if _, ok := ret["background-image"]; ok {
+				return nil, false
+			}
+			ret["background-image"] = s
+		case ident == "repeat" || ident == "repeat-x" || ident == "repeat-y" || ident == "no-repeat":
+			if _, ok := ret["background-repeat"]; ok {
+				return nil, false
+			}
+			ret["background-repeat"] = ident
+		case ident == "scroll" || ident == "fixed":
+			if _, ok := ret["background-attachment"]; ok {
+				return nil, false
+			}
+			ret["background-attachment"] = ident
+		case ident == "left" || ident == "right" || ident == "top" || ident == "center" || ident == "bottom",
+			tokenType(v) == PercentageToken || isLengthValue(v):
+			position = append(position, s)
+		case IsColor(s) && cssWideKeyword(s) == "":
+			if _, ok := ret["background-color"]; ok {
+				return nil, false
+			}
+			ret["background-color"] = s
+		default:
+			return nil, false
+		}
+	}
+	if len(position) > 2 {
+		return nil, false
+	}
+	set(ret, "background-position", strings.Join(position, " "))
+	return ret, true
+}
+
+// expandListStyle expands the list-style shorthand. "none" sets the type,
+// then the image, whichever aren't otherwise specified.
+func expandListStyle(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	var typ, pos, img string
+	nones := 0
+	for _, v := range nonWhitespace(vals) {
+		t, isToken := v.(Token)
+		switch {
+		case isToken && t.isIdent("none"):
+			nones++
+		case isToken && (t.isIdent("inside") || t.isIdent("outside")):
+			if pos != "" {
+				return nil, false
+			}
+			pos = strings.ToLower(t.Value)
+		case isToken && t.Type == URLToken,
+			!isToken && tokenType(v) == notAToken && isURLFunction(v):
+			if img != "" {
+				return nil, false
+			}
+			img = v.String()
+		case isToken && (t.Type == IdentToken || t.Type == StringToken):
+			if typ != "" {
+				return nil, false
+			}
+			typ = t.String()
+		default:
+			return nil, false
+		}
+	}
+	if nones > 0 && typ == "" {
+		typ = "none"
+		nones--
+	}
+	if nones > 0 && img == "" {
+		img = "none"
+		nones--
+	}
+	if nones > 0 {
+		return nil, false
+	}
+	ret := make(map[StyleAttribute]string)
+	set(ret, "list-style-type", typ)
+	set(ret, "list-style-position", pos)
+	set(ret, "list-style-image", img)
+	return ret, true
+}
+
+// isFontSize reports whether v is a valid font-size.
+func isFontSize(v ComponentValue) bool {
+	switch identValue(v) {
+	case "xx-small", "x-small", "small", "medium", "large", "x-large", "xx-large", "smaller", "larger":
+		return true
+	}
+	return isLengthValue(v) || tokenType(v) == PercentageToken
+}
+
+// expandFont expands the font shorthand. The style, variant, weight and
+// stretch come first in any order, followed by the size, an optional line
+// height after a "/", and the family, which is required. The system font
+// keywords use the default font.
+func expandFont(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	ret := make(map[StyleAttribute]string)
+	if len(vals) == 1 {
+		switch identValue(vals[0]) {
+		case "caption", "icon", "menu", "message-box", "small-caption", "status-bar":
+			ret["font-family"] = "sans-serif"
+			return ret, true
+		}
+	}
+
+	i := 0
+	next := func() ComponentValue {
+		for i < len(vals) && tokenType(vals[i]) == WhitespaceToken {
+			i++
+		}
+		if i == len(vals) {
+			return nil
+		}
+		return vals[i]
+	}
+
+	// Up to four of the style, variant, weight and stretch. normal applies
+	// to whichever isn't otherwise set, so it only needs to be counted.
+	for n := 0; n < 4; n++ {
+		v := next()
+		if v == nil {
+			return nil, false
+		}
+		var name StyleAttribute
+		switch ident := identValue(v); ident {
+		case "normal":
+		case "italic", "oblique":
+			name = "font-style"
+		case "small-caps":
+			name = "font-variant"
+		case "bold", "bolder", "lighter":
+			name = "font-weight"
+		case "ultra-condensed", "extra-condensed", "condensed", "semi-condensed",
+			"semi-expanded", "expanded", "extra-expanded", "ultra-expanded":
+			name = "font-stretch"
+		default:
+			t, ok := v.(Token)
+			if !ok || t.Type != NumberToken || t.Number < 1 || t.Number > 1000 {
+				n = 4
+				continue
+			}
+			name = "font-weight"
+		}
+		if name != "" {
+			if _, ok := ret[name]; ok {
+				return nil, false
+			}
+			ret[name] = v.String()
+		}
+		i++
+	}
+
+	size := next()
+	if size == nil || !isFontSize(size) {
+		return nil, false
+	}
+	ret["font-size"] = size.String()
+	i++
+
+	if v := next(); v != nil {
+		if t, ok := v.(Token); ok && t.Type == DelimToken && t.Value == "/" {
+			i++
+			lh := next()
+			if lh == nil {
+				return nil, false
+			}
+			switch t, _ := lh.(Token); {
+			case identValue(lh) == "normal", isLengthValue(lh),
+				t.Type == NumberToken, t.Type == PercentageToken:
+				ret["line-height"] = lh.String()
+			default:
+				return nil, false
+			}
+			i++
+		}
+	}
+
+	family := trimWhitespace(vals[i:])
+	if len(family) == 0 {
+		return nil, false
+	}
+	for _, name := range splitOnCommas(family) {
+		name = trimWhitespace(name)
+		if len(name) == 0 {
+			return nil, false
+		}
+		for _, v := range name {
+			if !isIdent(v) && tokenType(v) != StringToken && tokenType(v) != WhitespaceToken {
+				return nil, false
+			}
+		}
+	}
+	ret["font-family"] = SerializeComponentValues(family)
+	return ret, true
+}
+
+// expandOverflow expands overflow into overflow-x and overflow-y. If only
+// one value is given it's used for both.
+func expandOverflow(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	values := nonWhitespace(vals)
+	if len(values) == 0 || len(values) > 2 {
+		return nil, false
+	}
+	var idents []string
+	for _, v := range values {
+		switch ident := identValue(v); ident {
+		case "visible", "hidden", "clip", "scroll", "auto":
+			idents = append(idents, ident)
+		default:
+			return nil, false
+		}
+	}
+	if len(idents) == 1 {
+		idents = append(idents, idents[0])
+	}
+	return map[StyleAttribute]string{
+		"overflow-x": idents[0],
+		"overflow-y": idents[1],
+	}, true
+}
+
+// expandFlex expands the flex shorthand. When the basis is omitted it's 0%,
+// rather than its initial value of auto.
+func expandFlex(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	values := nonWhitespace(vals)
+	if len(values) == 1 {
+		switch identValue(values[0]) {
+		case "none":
+			return map[StyleAttribute]string{"flex-grow": "0", "flex-shrink": "0", "flex-basis": "auto"}, true
+		case "auto":
+			return map[StyleAttribute]string{"flex-grow": "1", "flex-shrink": "1", "flex-basis": "auto"}, true
+		}
+	}
+	var numbers []string
+	basis := ""
+	// The grow and shrink factors must be next to each other, so no
+	// more can come after a basis that follows them.
+	closed := false
+	for _, v := range values {
+		t, isToken := v.(Token)
+		switch {
+		case isToken && t.Type == NumberToken && t.Number >= 0:
+			if len(numbers) < 2 && !closed {
+				numbers = append(numbers, t.String())
+				continue
+			}
+			// A unitless 0 which can't be a factor is the basis.
+			if t.Number != 0 || basis != "" {
+				return nil, false
+			}
+			basis = "0"
+		case identValue(v) == "auto" || identValue(v) == "content",
+			isToken && t.Type == PercentageToken, isLengthValue(v):
+			if basis != "" {
+				return nil, false
+			}
+			basis = v.String()
+			closed = len(numbers) > 0
+		default:
+			return nil, false
+		}
+	}
+	if len(numbers) == 0 && basis == "" {
+		return nil, false
+	}
+	ret := map[StyleAttribute]string{"flex-grow": "1", "flex-shrink": "1", "flex-basis": "0%"}
+	if len(numbers) > 0 {
+		ret["flex-grow"] = numbers[0]
+	}
+	if len(numbers) > 1 {
+		ret["flex-shrink"] = numbers[1]
+	}
+	if basis != "" {
+		ret["flex-basis"] = basis
+	}
+	return ret, true
+}
+
+// expandGridArea expands grid-area, which is up to four grid lines
+// separated by "/". An omitted line is the same as the line on the opposite
+// side if that's a name, and auto otherwise.
+func expandGridArea(vals []ComponentValue) (map[StyleAttribute]string, bool) {
+	var lines [][]ComponentValue
+	start := 0
+	for i := 0; i <= len(vals); i++ {
+		if i < len(vals) {
+			if t, ok := vals[i].(Token); !ok || t.Type != DelimToken || t.Value != "/" {
+				continue
+			}
+		}
+		line := trimWhitespace(vals[start:i])
+		if !isGridLine(line) {
+			return nil, false
+		}
+		lines = append(lines, line)
+		start = i + 1
+	}
+	if len(lines) > 4 {
+		return nil, false
+	}
+	names := []StyleAttribute{"grid-row-start", "grid-column-start", "grid-row-end", "grid-column-end"}
+	ret := make(map[StyleAttribute]string)
+	for i, name := range names {
+		switch {
+		case i < len(lines):
+			ret[name] = SerializeComponentValues(lines[i])
+		case i == 1:
+			ret[name] = gridLineFallback(ret[names[0]])
+		default:
+			ret[name] = gridLineFallback(ret[names[i-2]])
+		}
+	}
+	return ret, true
+}
+
+// gridLineFallback returns the value of an omitted grid line, given the
+// value of the line on the opposite side.
+func gridLineFallback(opposite string) string {
+	vals := nonWhitespace(ParseComponentValues(opposite))
+	if len(vals) == 1 && isIdent(vals[0]) && identValue(vals[0]) != "auto" {
+		return opposite
+	}
+	return "auto"
+}
+
+// isGridLine reports whether vals is a valid grid line: auto, a name, or an
+// integer and a name (either of which may be omitted) optionally with span.
+func isGridLine(vals []ComponentValue) bool {
+	values := nonWhitespace(vals)
+	if len(values) == 0 || len(values) > 3 {
+		return false
+	}
+	if len(values) == 1 && identValue(values[0]) == "auto" {
+		return true
+	}
+	var span, integer, name bool
+	for _, v := range values {
+		t, isToken := v.(Token)
+		switch ident := identValue(v); {
+		case ident == "span":
+			if span {
+				return false
+			}
+			span = true
+		case ident != "" && ident != "auto":
+			if name {
+				return false
+			}
+			name = true
+		case isToken && t.Type == NumberToken && t.Number == float64(int(t.Number)) && t.Number != 0:
+			if integer || (span && t.Number < 0) {
+				return false
+			}
+			integer = true
+		default:
+			return false
+		}
+	}
+	return integer || name
+}
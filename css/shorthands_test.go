@@ -0,0 +1,195 @@
+package css
+
+import (
+	"testing"
+)
+
+func TestShorthands(t *testing.T) {
+	tests := []struct {
+		Name     StyleAttribute
+		Value    string
+		Expected map[string]string
+	}{
+		{
+			"margin", "1px 2px 3px",
+			map[string]string{"margin-top": "1px", "margin-right": "2px", "margin-bottom": "3px", "margin-left": "2px"},
+		},
+		{"margin", "1px 2px 3px 4px 5px", nil},
+		{
+			"inset", "0 auto",
+			map[string]string{"top": "0", "right": "auto", "bottom": "0", "left": "auto"},
+		},
+		{
+			"border-top", "thick dashed",
+			map[string]string{"border-top-width": "thick", "border-top-style": "dashed", "border-top-color": "currentcolor"},
+		},
+		{
+			"border-left", "red",
+			map[string]string{"border-left-width": "medium", "border-left-style": "none", "border-left-color": "red"},
+		},
+		{"border-right", "1px 2px solid", nil},
+		{
+			"border", "2px solid",
+			map[string]string{"border-bottom-width": "2px", "border-right-style": "solid", "border-left-color": "currentcolor"},
+		},
+		{
+			"outline", "dotted",
+			map[string]string{"outline-width": "medium", "outline-style": "dotted", "outline-color": "invert"},
+		},
+		{
+			"outline", "thin dotted invert",
+			map[string]string{"outline-width": "thin", "outline-style": "dotted", "outline-color": "invert"},
+		},
+		{"outline", "invert red", nil},
+		{"outline", "hidden", nil},
+		{
+			"background", "url(a.png) left top",
+			map[string]string{"background-color": "transparent", "background-image": "url(a.png)", "background-position": "left top", "background-repeat": "repeat"},
+		},
+		{
+			"list-style", "none inside",
+			map[string]string{"list-style-type": "none", "list-style-position": "inside", "list-style-image": "none"},
+		},
+		{
+			"font", "italic bold 12px/30px Georgia, serif",
+			map[string]string{"font-style": "italic", "font-variant": "normal", "font-weight": "bold", "font-size": "12px", "line-height": "30px", "font-family": "Georgia, serif"},
+		},
+		{
+			"font", `large "Times New Roman"`,
+			map[string]string{"font-style": "normal", "font-weight": "normal", "font-size": "large", "line-height": "normal", "font-family": `"Times New Roman"`},
+		},
+		{
+			"font", "normal small-caps 600 80% monospace",
+			map[string]string{"font-variant": "small-caps", "font-weight": "600", "font-size": "80%"},
+		},
+		{
+			"font", "condensed italic bold 12px serif",
+			map[string]string{"font-stretch": "condensed", "font-style": "italic", "font-variant": "normal", "font-weight": "bold", "font-size": "12px"},
+		},
+		{
+			"font", "italic bold 12px/30px serif",
+			map[string]string{"font-stretch": "normal"},
+		},
+		{
+			"font", "menu",
+			map[string]string{"font-size": "medium", "font-family": "sans-serif"},
+		},
+		{"font", "12px", nil},
+		{"font", "bold serif", nil},
+		{
+			"overflow", "hidden",
+			map[string]string{"overflow-x": "hidden", "overflow-y": "hidden"},
+		},
+		{
+			"overflow", "hidden auto",
+			map[string]string{"overflow-x": "hidden", "overflow-y": "auto"},
+		},
+		{"overflow", "hidden auto scroll", nil},
+		{
+			"flex", "1",
+			map[string]string{"flex-grow": "1", "flex-shrink": "1", "flex-basis": "0%"},
+		},
+		{
+			"flex", "none",
+			map[string]string{"flex-grow": "0", "flex-shrink": "0", "flex-basis": "auto"},
+		},
+		{
+			"flex", "2 3 10px",
+			map[string]string{"flex-grow": "2", "flex-shrink": "3", "flex-basis": "10px"},
+		},
+		{
+			"flex", "0 0 0",
+			map[string]string{"flex-grow": "0", "flex-shrink": "0", "flex-basis": "0"},
+		},
+		{
+			"flex", "auto 2",
+			map[string]string{"flex-grow": "2", "flex-shrink": "1", "flex-basis": "auto"},
+		},
+		{"flex", "1 auto 2", nil},
+		{
+			"grid-area", "header",
+			map[string]string{"grid-row-start": "header", "grid-column-start": "header", "grid-row-end": "header", "grid-column-end": "header"},
+		},
+		{
+			"grid-area", "1 / span 2",
+			map[string]string{"grid-row-start": "1", "grid-column-start": "span 2", "grid-row-end": "auto", "grid-column-end": "auto"},
+		},
+		{"grid-area", "1 / 2 / 3 / 4 / 5", nil},
+		{
+			"font", "inherit",
+			map[string]string{"font-style": "inherit", "font-size": "inherit", "line-height": "inherit"},
+		},
+	}
+	for _, tc := range tests {
+		var e StyledElement
+		e.AddStyle(StyleRule{
+			Selector: CSSSelector{"div", 0},
+			Name:     tc.Name,
+			Value:    StyleValue{tc.Value, false},
+			Src:      AuthorSrc,
+		})
+		if tc.Expected == nil {
+			if len(e.rules) != 0 {
+				t.Errorf("%v: %v: expected invalid declaration, got %v", tc.Name, tc.Value, e.rules)
+			}
+			continue
+		}
+		got := make(map[string]string)
+		for _, r := range e.rules {
+			got[string(r.Name)] = r.Value.Value
+		}
+		if len(got) != len(shorthands[tc.Name].longhands) {
+			t.Errorf("%v: %v: not every longhand was set: %v", tc.Name, tc.Value, got)
+		}
+		for name, val := range tc.Expected {
+			if got[name] != val {
+				t.Errorf("%v: %v: unexpected %v: got %q want %q", tc.Name, tc.Value, name, got[name], val)
+			}
+		}
+	}
+}
+
+func TestShorthandPrecedence(t *testing.T) {
+	rule := func(order uint, name StyleAttribute, val string, important bool) StyleRule {
+		return StyleRule{
+			Selector: CSSSelector{"div", order},
+			Name:     name,
+			Value:    StyleValue{val, important},
+			Src:      AuthorSrc,
+		}
+	}
+	var e StyledElement
+	for _, r := range []StyleRule{
+		// A shorthand resets longhands that came before it.
+		rule(0, "font-weight", "bold", false),
+		rule(1, "font", "12px serif", false),
+		// A longhand after a shorthand overrides it.
+		rule(2, "margin", "1px", false),
+		rule(3, "margin-left", "2px", false),
+		// !important on the shorthand applies to every longhand.
+		rule(4, "border-top", "1px solid", true),
+		rule(5, "border-top-width", "3px", false),
+		rule(6, "border-top-style", "dotted", false),
+	} {
+		e.AddStyle(r)
+	}
+	e.SortStyles()
+
+	for _, tc := range []struct {
+		Got, Expected string
+	}{
+		{e.FontWeight.Value, "normal"},
+		{e.FontFamily.Value, "serif"},
+		{e.MarginTop.Value, "1px"},
+		{e.MarginLeft.Value, "2px"},
+		{e.BorderTopWidth.Value, "1px"},
+		{e.BorderTopStyle.Value, "solid"},
+	} {
+		if tc.Got != tc.Expected {
+			t.Errorf("Unexpected value: got %v want %v", tc.Got, tc.Expected)
+		}
+	}
+	if !e.BorderTopColor.Important {
+		t.Error("border-top-color was not important")
+	}
+}
@@ -15,32 +15,20 @@ package css
 type byCSSPrecedence []StyleRule
 
 func specificityLess(i, j StyleRule) bool {
+	if i.Src == InlineStyleSrc && j.Src == InlineStyleSrc {
+		// Later declarations in the same style attribute win.
+		return i.Selector.OrderNumber > j.Selector.OrderNumber
+	}
 	if i.Src == InlineStyleSrc {
 		return true
 	} else if j.Src == InlineStyleSrc {
 		return false
 	}
 
-	iNumIDs := i.Selector.NumberIDs()
-	jNumIDs := j.Selector.NumberIDs()
-	if iNumIDs != jNumIDs {
-		return iNumIDs > jNumIDs
-	}
-
-	iNumAttribs := i.Selector.NumberAttrs()
-	jNumAttribs := j.Selector.NumberAttrs()
-	iNumClasses := i.Selector.NumberClasses()
-	jNumClasses := j.Selector.NumberClasses()
-
-	if (iNumAttribs + iNumClasses) != (jNumAttribs + jNumClasses) {
-		return (iNumAttribs + iNumClasses) > (jNumAttribs + jNumClasses)
-	}
-	iNumElements := i.Selector.NumberElements()
-	jNumElements := j.Selector.NumberElements()
-	iNumPseudo := i.Selector.NumberPseudo()
-	jNumPseudo := j.Selector.NumberPseudo()
-	if (iNumElements + iNumPseudo) != (jNumElements + jNumPseudo) {
-		return (iNumElements + iNumPseudo) > (jNumElements + jNumPseudo)
+	iSpec := i.Selector.Specificity()
+	jSpec := j.Selector.Specificity()
+	if iSpec != jSpec {
+		return jSpec.Less(iSpec)
 	}
 	return i.Selector.OrderNumber > j.Selector.OrderNumber
 }
@@ -54,6 +42,11 @@ func (r byCSSPrecedence) Less(i, j int) bool {
 				// Important overrides inline styles
 				return false
 			}
+			if r[j].Src == UserSrc && r[j].Value.Important {
+				// Important user styles override even important
+				// inline styles.
+				return false
+			}
 			return true
 		}
 		return specificityLess(r[i], r[j])
@@ -104,6 +97,16 @@ func (r byCSSPrecedence) Less(i, j int) bool {
 			return true
 		}
 
+		// Important user stylesheets are more important than
+		// normal ones, and normal ones of the same importance are
+		// ordered by specificity.
+		if r[j].Src == UserSrc {
+			if r[j].Value.Important {
+				return false
+			}
+			return specificityLess(r[i], r[j])
+		}
+
 		// all that's left is author stylesheets, and user normal
 		// stylesheets are never more important, so they're never
 		// "less"
@@ -65,3 +65,38 @@ func TestSpecificitySorting(t *testing.T) {
 	}*/
 
 }
+
+func TestLogicalPseudoClassSorting(t *testing.T) {
+	vals := []StyleRule{
+		StyleRule{Selector: CSSSelector{":where(#foo) p", 0}, Src: AuthorSrc},
+		StyleRule{Selector: CSSSelector{":is(#foo) p", 1}, Src: AuthorSrc},
+		StyleRule{Selector: CSSSelector{"p:not(.bar)", 2}, Src: AuthorSrc},
+		StyleRule{Selector: CSSSelector{"div p", 3}, Src: AuthorSrc},
+	}
+
+	sort.Sort(byCSSPrecedence(vals))
+	expected := []string{":is(#foo) p", "p:not(.bar)", "div p", ":where(#foo) p"}
+	for i, sel := range expected {
+		if vals[i].Selector.Selector != sel {
+			t.Errorf("Unexpected selector at index %d: got %v want %v", i, vals[i].Selector, sel)
+		}
+	}
+}
+
+func TestUserStyleSorting(t *testing.T) {
+	vals := []StyleRule{
+		StyleRule{Selector: CSSSelector{"p", 0}, Src: UserSrc},
+		StyleRule{Selector: CSSSelector{"", 1}, Src: InlineStyleSrc, Value: StyleValue{"", true}},
+		StyleRule{Selector: CSSSelector{"#foo", 2}, Src: UserSrc},
+		StyleRule{Selector: CSSSelector{"p", 3}, Src: AuthorSrc},
+		StyleRule{Selector: CSSSelector{"p", 4}, Src: UserSrc, Value: StyleValue{"", true}},
+	}
+
+	sort.Sort(byCSSPrecedence(vals))
+	expected := []uint{4, 1, 3, 2, 0}
+	for i, order := range expected {
+		if vals[i].Selector.OrderNumber != order {
+			t.Errorf("Unexpected rule at index %d: got %v want %v", i, vals[i].Selector.OrderNumber, order)
+		}
+	}
+}
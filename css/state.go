@@ -1,6 +1,16 @@
 package css
 
+import (
+	"golang.org/x/net/html"
+)
+
 type State struct {
 	Link, Visited, Active bool // CSS1
 	Hover                 bool // CSS2
+	Focus, FocusWithin    bool // Selectors Level 4
 }
+
+// A StateLookup returns the State of an element in the document. It's used to
+// match pseudo-classes against elements other than the one being styled, such
+// as the li in "li:hover > a".
+type StateLookup func(*html.Node) State
@@ -2,10 +2,8 @@ package css
 
 import (
 	"fmt"
-	"github.com/driusan/fonts"
 	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font"
-	"os"
 	//"golang.org/x/image/font/basicfont"
 	"image/color"
 	//"io/ioutil"
@@ -16,11 +14,14 @@ import (
 var DefaultFontSize int
 
 type fontStyle struct {
-	fontFamily FontFamily
-	fontWeight font.Weight
-	fontStyle  font.Style
-	fontSize   int
-	//font-variant not supported. Need a good small-caps font to implement..
+	fontFamily  FontFamily
+	fontWeight  font.Weight
+	fontStyle   font.Style
+	fontStretch FontStretch
+	fontSize    int
+
+	// Whether it's the face of a small-caps font, from GetSmallCapsFace.
+	smallCaps bool
 }
 
 var parsedFontCache map[string]*truetype.Font
@@ -29,6 +30,7 @@ var fontCache map[fontStyle]font.Face
 func ClearFontCache() {
 	fontCache = make(map[fontStyle]font.Face)
 	parsedFontCache = make(map[string]*truetype.Font)
+	runeFamilies = make(map[rune]string)
 }
 
 func init() {
@@ -67,6 +69,7 @@ type StyledElement struct {
 	FontStyle   StyleValue
 	FontVariant StyleValue
 	FontWeight  StyleValue
+	FontStretch StyleValue
 	FontSize    StyleValue
 	//Font        StyleValue
 
@@ -154,7 +157,9 @@ type StyledElement struct {
 	Left     StyleValue
 	Right    StyleValue
 
-	Overflow   StyleValue
+	OverflowX StyleValue
+	OverflowY StyleValue
+	// Overflow StyleValue
 	Visibility StyleValue
 	Clip       StyleValue
 	ZIndex     StyleValue
@@ -170,6 +175,9 @@ type StyledElement struct {
 	CounterReset     StyleValue
 	Quotes           StyleValue
 
+	// CSS Lists Level 3
+	CounterSet StyleValue
+
 	// UI Related properties
 	Cursor StyleValue
 
@@ -177,9 +185,47 @@ type StyledElement struct {
 	Direction   StyleValue
 	UnicodeBidi StyleValue
 
+	// CSS Basic User Interface
+	OutlineWidth StyleValue
+	OutlineStyle StyleValue
+	OutlineColor StyleValue
+	// Outline StyleValue
+
+	// CSS Flexible Box Layout
+	FlexGrow   StyleValue
+	FlexShrink StyleValue
+	FlexBasis  StyleValue
+	// Flex StyleValue
+
+	// CSS Grid Layout
+	GridRowStart    StyleValue
+	GridColumnStart StyleValue
+	GridRowEnd      StyleValue
+	GridColumnEnd   StyleValue
+	// GridArea StyleValue
+
 	// The rules that match this element.
 	rules    []StyleRule
 	fontSize int
+
+	// The rules after sorting them and substituting var() references.
+	// This is what the property values are populated from.
+	computed []StyleRule
+
+	// The custom properties inherited from the parent, and the computed
+	// custom properties of this element. customProperties is nil if the
+	// element doesn't set any custom properties of its own.
+	inheritedProperties CustomProperties
+	customProperties    CustomProperties
+
+	// The styles of the element that this one inherits from.
+	parent *StyledElement
+
+	// The typed values of the properties, once they've been computed,
+	// and the context that relative values were resolved in.
+	computedStyle    ComputedStyle
+	hasComputedStyle bool
+	units            UnitContext
 }
 
 func (e StyledElement) String() string {
@@ -200,103 +246,57 @@ func (e StyledElement) MergeStyles(other ...*StyledElement) StyledElement {
 	return e
 }
 
+// A FontFamily is the computed value of the font-family property: a comma
+// separated list of family names, which are quoted, and generic families.
 type FontFamily string
 
-func (e StyledElement) GetFontFace(fsize int, fontFamily FontFamily, weight font.Weight, style font.Style) font.Face {
+// GetFontFace returns a face of size fsize for the first family in
+// fontFamily which has a font available, choosing between the family's fonts
+// by weight, style and stretch. If none of the families are available, the
+// default sans-serif font is used.
+func (e StyledElement) GetFontFace(fsize int, fontFamily FontFamily, weight font.Weight, style font.Style, stretch FontStretch) font.Face {
 	fStyle := fontStyle{
-		fontFamily: fontFamily,
-		fontWeight: weight,
-		fontStyle:  style,
-		fontSize:   fsize,
+		fontFamily:  fontFamily,
+		fontWeight:  weight,
+		fontStyle:   style,
+		fontStretch: stretch,
+		fontSize:    fsize,
 	}
 	if face, ok := fontCache[fStyle]; ok {
 		return face
 	}
+	face := findFontFace(fontFamily, fsize, weight, style, stretch)
+	fontCache[fStyle] = face
+	return face
+}
 
-	var ttfFile string
-	switch fStyle.fontFamily {
-	default:
-		fallthrough
-	case "serif":
-		switch style {
-		default:
-			fallthrough
-		case font.StyleNormal:
-			if weight <= font.WeightNormal {
-				ttfFile = "DejaVuSerif.ttf"
-			} else { // weight > font.WeightNormal
-				ttfFile = "DejaVuSerif-Bold.ttf"
-			}
-		case font.StyleItalic, font.StyleOblique:
-			if weight <= font.WeightNormal {
-				ttfFile = "DejaVuSerif-Italic.ttf"
-			} else { //fontWeight > font.WeightNormal
-				ttfFile = "DejaVuSerif-BoldItalic.ttf"
-			}
-
-		}
-	case "sans-serif":
-		switch style {
-		default:
-			fallthrough
-		case font.StyleNormal:
-			// TODO: Look up the weight of DejaVuSans-ExtraLight and use
-			// it as appropriate
-			if weight <= font.WeightNormal {
-				ttfFile = "DejaVuSans.ttf"
-			} else { //fontWeight > font.WeightNormal
-				ttfFile = "DejaVuSans-Bold.ttf"
-			}
-		case font.StyleItalic, font.StyleOblique:
-			if weight <= font.WeightNormal {
-				ttfFile = "DejaVuSans-Oblique.ttf"
-			} else { //fontWeight > font.WeightNormal {
-				ttfFile = "DejaVuSans-BoldOblique.ttf"
-			}
-
-		}
-	case "monospace":
-		switch style {
-		default:
-			fallthrough
-		case font.StyleNormal:
-			// TODO: Look up the weight of DejaVuSans-ExtraLight and use
-			// it as appropriate
-			if weight <= font.WeightNormal {
-				ttfFile = "DejaVuSansMono.ttf"
-			} else { //if fontWeight > font.WeightNormal {
-				ttfFile = "DejaVuSansMono-Bold.ttf"
-			}
-		case font.StyleItalic, font.StyleOblique:
-			if weight <= font.WeightNormal {
-				ttfFile = "DejaVuSansMono-Oblique.ttf"
-			} else { //if fontWeight > font.WeightNormal {
-				ttfFile = "DejaVuSansMono-BoldOblique.ttf"
-			}
-
-		}
-
+// GetSmallCapsFace returns a face of size fsize for the small-caps version of
+// the first family in fontFamily which has a font available, or nil if it
+// doesn't have one. Small-caps fonts are found by their family name, which
+// is the name of the family followed by "SC" or "Small Caps", such as
+// "Alegreya SC". Lower case letters are drawn as small capitals by the font
+// itself, so when there isn't one, they need to be drawn as smaller upper
+// case letters instead.
+func (e StyledElement) GetSmallCapsFace(fsize int, fontFamily FontFamily, weight font.Weight, style font.Style, stretch FontStretch) font.Face {
+	fStyle := fontStyle{
+		fontFamily:  fontFamily,
+		fontWeight:  weight,
+		fontStyle:   style,
+		fontStretch: stretch,
+		fontSize:    fsize,
+		smallCaps:   true,
 	}
-
-	var ft *truetype.Font
-	if fb, ok := parsedFontCache[ttfFile]; ok {
-		ft = fb
-	} else {
-		fontBytes, err := fonts.Asset(ttfFile)
-		if err != nil {
-			panic(err)
-		}
-		ft, _ = truetype.Parse(fontBytes)
+	if face, ok := fontCache[fStyle]; ok {
+		return face
+	}
+	var face font.Face
+	if family := smallCapsFamily(fontFamily); family != "" {
+		face = findFontFace(FontFamily(serializeString(family)), fsize, weight, style, stretch)
 	}
-	face := truetype.NewFace(ft,
-		&truetype.Options{
-			Size:    float64(fsize) / PixelsPerPt,
-			DPI:     PixelsPerPt * 72,
-			Hinting: font.HintingFull})
 	fontCache[fStyle] = face
 	return face
-
 }
+
 func (e StyledElement) GetFontSize() (int, error) {
 	if e.fontSize == 0 {
 		return 0, InheritValue
@@ -304,218 +304,31 @@ func (e StyledElement) GetFontSize() (int, error) {
 	return e.fontSize, nil
 }
 
-func (e *StyledElement) expandBoxBorderShorthand(att StyleAttribute, s StyleRule) {
-	values := strings.Fields(s.Value.Value)
-	switch len(values) {
-	case 0:
-		return
-	case 1:
-		s.Name = "border-top-" + att
-		s.Value.Value = values[0]
-		e.rules = append(e.rules, s)
-		s.Name = "border-right-" + att
-		e.rules = append(e.rules, s)
-		s.Name = "border-bottom-" + att
-		e.rules = append(e.rules, s)
-		s.Name = "border-left-" + att
-		e.rules = append(e.rules, s)
-	case 2:
-		s.Name = "border-top-" + att
-		s.Value.Value = values[0]
-
-		e.rules = append(e.rules, s)
-		s.Name = "border-bottom-" + att
-		e.rules = append(e.rules, s)
-
-		s.Value.Value = values[1]
-		s.Name = "border-right-" + att
-		e.rules = append(e.rules, s)
-		s.Name = "border-left-" + att
-		e.rules = append(e.rules, s)
-	case 3:
-		s.Name = "border-top-" + att
-		s.Value.Value = values[0]
-		e.rules = append(e.rules, s)
-
-		s.Name = "border-right-" + att
-		s.Value.Value = values[1]
-		e.rules = append(e.rules, s)
-		s.Name = "border-left-" + att
-		e.rules = append(e.rules, s)
-
-		s.Name = "border-bottom-" + att
-		s.Value.Value = values[2]
-		e.rules = append(e.rules, s)
-	case 4:
-		fallthrough
-	default:
-		s.Name = "border-top-" + att
-		s.Value.Value = values[0]
-		e.rules = append(e.rules, s)
-
-		s.Name = "border-right-" + att
-		s.Value.Value = values[1]
-		e.rules = append(e.rules, s)
-
-		s.Name = "border-bottom-" + att
-		s.Value.Value = values[2]
-		e.rules = append(e.rules, s)
-
-		s.Name = "border-left-" + att
-		s.Value.Value = values[3]
-		e.rules = append(e.rules, s)
-	}
-}
-
-func (e *StyledElement) expandBorderShorthand(attrib StyleAttribute, s StyleRule) {
-	values := strings.Fields(s.Value.Value)
-	for _, v := range values {
-		if IsLength(v) {
-			s.Value.Value = v
-			e.expandBoxBorderShorthand("width", s)
-		} else if IsBorderStyle(v) {
-			s.Value.Value = v
-			e.expandBoxBorderShorthand("style", s)
-		} else if IsColor(v) {
-			s.Value.Value = v
-			e.expandBoxBorderShorthand("color", s)
-		} else {
-			fmt.Fprintln(os.Stderr, "Didn't know what to do with border property", v)
-		}
-	}
-}
-func (e *StyledElement) expandBoxSideShorthand(attrib StyleAttribute, s StyleRule) {
-	values := strings.Fields(s.Value.Value)
-	switch len(values) {
-	case 0:
-		return
-	case 1:
-		s.Name = attrib + "-top"
-		s.Value.Value = values[0]
-		e.rules = append(e.rules, s)
-		s.Name = attrib + "-right"
-		e.rules = append(e.rules, s)
-		s.Name = attrib + "-bottom"
-		e.rules = append(e.rules, s)
-		s.Name = attrib + "-left"
-		e.rules = append(e.rules, s)
-	case 2:
-		s.Name = attrib + "-top"
-		s.Value.Value = values[0]
-		e.rules = append(e.rules, s)
-		s.Name = attrib + "-bottom"
-		e.rules = append(e.rules, s)
-
-		s.Name = attrib + "-right"
-		s.Value.Value = values[1]
-		e.rules = append(e.rules, s)
-		s.Name = attrib + "-left"
-		e.rules = append(e.rules, s)
-	case 3:
-		s.Name = attrib + "-top"
-		s.Value.Value = values[0]
-		e.rules = append(e.rules, s)
-
-		s.Name = attrib + "-right"
-		s.Value.Value = values[1]
-		e.rules = append(e.rules, s)
-		s.Name = attrib + "-left"
-		e.rules = append(e.rules, s)
-
-		s.Name = attrib + "-bottom"
-		s.Value.Value = values[2]
-		e.rules = append(e.rules, s)
-	case 4:
-		fallthrough
-	default:
-		s.Name = attrib + "-top"
-		s.Value.Value = values[0]
-		e.rules = append(e.rules, s)
-
-		s.Name = attrib + "-right"
-		s.Value.Value = values[1]
-		e.rules = append(e.rules, s)
-
-		s.Name = attrib + "-bottom"
-		s.Value.Value = values[2]
-		e.rules = append(e.rules, s)
-
-		s.Name = attrib + "-left"
-		s.Value.Value = values[3]
-		e.rules = append(e.rules, s)
-	}
-}
-
-func (e *StyledElement) expandBackgroundShorthand(s StyleRule) {
-	values := strings.Fields(s.Value.Value)
-
-	// hack to build background-color from the shorthand even
-	// though we split on whitespace and rgb(1, 2, 3) has whitespace
-	buildColour := false
-	for _, val := range values {
-		if buildColour {
-			e.rules[len(e.rules)-1].Value.Value += (" " + val)
-			if strings.Index(val, ")") >= 0 {
-				buildColour = false
-			}
-		}
-		if val == "none" || IsURL(val) {
-			s.Name = "background-image"
-			s.Value.Value = val
-			e.rules = append(e.rules, s)
-		} else if IsColor(val) {
-			s.Name = "background-color"
-			s.Value.Value = val
-			e.rules = append(e.rules, s)
-			if strings.HasPrefix(val, "rgb(") {
-				buildColour = true
-			}
-		}
-		switch val {
-		case "repeat", "repeat-x", "repeat-y", "no-repeat":
-			s.Name = "background-repeat"
-			s.Value.Value = val
-			e.rules = append(e.rules, s)
-		case "scroll", "fixed":
-			s.Name = "background-attachment"
-			s.Value.Value = val
-			e.rules = append(e.rules, s)
-		case "left", "right", "top", "center", "bottom":
-			s.Name = "background-position"
-			s.Value.Value = val
-			e.rules = append(e.rules, s)
-		default:
-			if IsPercentage(val) || IsLength(val) {
-				s.Name = "background-position"
-				s.Value.Value = val
-				e.rules = append(e.rules, s)
-			}
-		}
+// isURLFunction reports whether v is a url() function with a string.
+func isURLFunction(v ComponentValue) bool {
+	f, ok := v.(*Function)
+	if !ok || !strings.EqualFold(f.Name, "url") {
+		return false
 	}
+	args := trimWhitespace(f.Value)
+	return len(args) == 1 && tokenType(args[0]) == StringToken
 }
 
+// AddStyle adds the rule s to the rules that apply to e. Shorthand properties
+// are expanded into their longhands, and invalid shorthands are ignored.
 func (e *StyledElement) AddStyle(s StyleRule) {
-	switch s.Name {
-	case "border":
-		e.expandBorderShorthand(s.Name, s)
-	case "padding", "margin":
-		e.expandBoxSideShorthand(s.Name, s)
-	case "background":
-		e.expandBackgroundShorthand(s)
-	case "border-width":
-		// border width expands to border-side-width, not
-		// border-width-side, so we can't use the normal helper
-		// function
-		e.expandBoxBorderShorthand("width", s)
-	case "border-color":
-		e.expandBoxBorderShorthand("color", s)
-	case "border-style":
-		e.expandBoxBorderShorthand("style", s)
-
-	default:
+	if isCustomProperty(s.Name) || hasVarReference(s.Value.Value) {
+		// Shorthands which reference custom properties can't be
+		// expanded until the references are substituted when the
+		// styles are sorted.
 		e.rules = append(e.rules, s)
+		return
+	}
+	if sh, ok := shorthands[s.Name]; ok {
+		e.expandShorthand(sh, s)
+		return
 	}
-	return
+	e.rules = append(e.rules, s)
 }
 
 func (e *StyledElement) ClearStyles() {
@@ -536,7 +349,10 @@ func (e *StyledElement) ClearStyles() {
 // BUG(driusan): The final tie break is not implemented
 func (e *StyledElement) SortStyles() error {
 	sort.Sort(byCSSPrecedence(e.rules))
+	e.computeCustomProperties()
+	e.computed = e.resolveKeywords(e.substituteRules())
 	e.populateValues()
+	e.hasComputedStyle = false
 	return nil
 }
 
@@ -544,8 +360,8 @@ func (e *StyledElement) populateValues() {
 	// rules are sorted so that the lowest index has the highest precedent, so going through
 	// backwords and just blindly populating will ensure that the highest precedent rule
 	// is the last to update the value
-	for i := len(e.rules) - 1; i >= 0; i-- {
-		switch rule := e.rules[i]; rule.Name {
+	for i := len(e.computed) - 1; i >= 0; i-- {
+		switch rule := e.computed[i]; rule.Name {
 		case "font-family":
 			e.FontFamily = rule.Value
 		case "font-style":
@@ -554,6 +370,8 @@ func (e *StyledElement) populateValues() {
 			e.FontVariant = rule.Value
 		case "font-weight":
 			e.FontWeight = rule.Value
+		case "font-stretch":
+			e.FontStretch = rule.Value
 		case "font-size":
 			e.FontSize = rule.Value
 		case "color":
@@ -661,15 +479,60 @@ func (e *StyledElement) populateValues() {
 		case "list-style-position":
 			e.ListStylePosition = rule.Value
 
-		case "overflow":
-			e.Overflow = rule.Value
+		case "overflow-x":
+			e.OverflowX = rule.Value
+		case "overflow-y":
+			e.OverflowY = rule.Value
+
+		case "top":
+			e.Top = rule.Value
+		case "right":
+			e.Right = rule.Value
+		case "bottom":
+			e.Bottom = rule.Value
+		case "left":
+			e.Left = rule.Value
+
+		case "outline-width":
+			e.OutlineWidth = rule.Value
+		case "outline-style":
+			e.OutlineStyle = rule.Value
+		case "outline-color":
+			e.OutlineColor = rule.Value
+
+		case "flex-grow":
+			e.FlexGrow = rule.Value
+		case "flex-shrink":
+			e.FlexShrink = rule.Value
+		case "flex-basis":
+			e.FlexBasis = rule.Value
+
+		case "grid-row-start":
+			e.GridRowStart = rule.Value
+		case "grid-column-start":
+			e.GridColumnStart = rule.Value
+		case "grid-row-end":
+			e.GridRowEnd = rule.Value
+		case "grid-column-end":
+			e.GridColumnEnd = rule.Value
+
+		case "content":
+			e.Content = rule.Value
+		case "counter-increment":
+			e.CounterIncrement = rule.Value
+		case "counter-reset":
+			e.CounterReset = rule.Value
+		case "counter-set":
+			e.CounterSet = rule.Value
+		case "quotes":
+			e.Quotes = rule.Value
 		}
 
 	}
 }
 
 func (e *StyledElement) DisplayProp() string {
-	for _, rule := range e.rules {
+	for _, rule := range e.computed {
 		if string(rule.Name) == "display" {
 			return rule.Value.Value
 		}
@@ -678,7 +541,7 @@ func (e *StyledElement) DisplayProp() string {
 }
 
 func (e *StyledElement) GetAttribute(attr string) StyleValue {
-	for _, rule := range e.rules {
+	for _, rule := range e.computed {
 		if string(rule.Name) == attr {
 			return rule.Value
 		}
@@ -710,15 +573,49 @@ func (e StyledElement) GetBackgroundImage() (string, error) {
 	return realURL, nil
 }
 
+// GetListStyleImage returns the URL of the list-style-image in the same way
+// as GetBackgroundImage. If the value is "none", it returns an empty URL and
+// no error.
+func (e StyledElement) GetListStyleImage() (string, error) {
+	switch v := strings.TrimSpace(e.ListStyleImage.Value); strings.ToLower(v) {
+	case "":
+		return "", NoStyles
+	case "inherit":
+		return "", InheritValue
+	case "none":
+		return "", nil
+	default:
+		vals := trimWhitespace(ParseComponentValues(v))
+		if len(vals) != 1 {
+			return "", InvalidValue
+		}
+		if t, ok := vals[0].(Token); ok && t.Type == URLToken {
+			return t.Value, nil
+		}
+		if isURLFunction(vals[0]) {
+			return trimWhitespace(vals[0].(*Function).Value)[0].(Token).Value, nil
+		}
+		return "", InvalidValue
+	}
+}
+
 func (e StyledElement) GetColor(defaultColour color.Color) (color.Color, error) {
 	switch e.Color.Value {
 	case "inherit":
 		return defaultColour, InheritValue
-	case "transparent":
-		return defaultColour, nil
 	case "":
 		return defaultColour, NoStyles
 	default:
-		return ConvertColorToRGBA(e.Color.Value)
+		c, err := ConvertColorToRGBA(e.Color.Value)
+		switch err {
+		case nil:
+			return c, nil
+		case CurrentColor:
+			// currentColor in the color property is the same
+			// as inherit.
+			return defaultColour, InheritValue
+		default:
+			return defaultColour, err
+		}
 	}
 }
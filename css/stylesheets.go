@@ -7,7 +7,6 @@ import (
 	"strings"
 
 	"github.com/driusan/gob/net"
-	"github.com/gorilla/css/scanner"
 	"golang.org/x/net/html"
 )
 
@@ -16,6 +15,10 @@ type StyleRule struct {
 	Name     StyleAttribute
 	Value    StyleValue
 	Src      StyleSource
+
+	// The media query lists from enclosing @media rules, @import rules,
+	// and media attributes. The rule only applies if all of them match.
+	Media []MediaQueryList
 }
 
 func (sr StyleRule) String() string {
@@ -35,377 +38,298 @@ func (sv StyleValue) String() string {
 	return sv.Value
 }
 
+// ParseBlock parses a list of declarations, such as the contents of a style
+// attribute, into a map of property names to values. Invalid declarations
+// are discarded.
 func ParseBlock(val string) map[StyleAttribute]StyleValue {
 	m := make(map[StyleAttribute]StyleValue)
-	pieces := strings.Split(val, ";")
-	for _, attrib := range pieces {
-		if strings.TrimSpace(attrib) == "" {
+	for _, decl := range ParseDeclarations(val) {
+		if !validDeclaration(decl, nil) {
 			continue
 		}
-		idx := strings.Index(attrib, ":")
-		if idx < 0 {
-			continue //panic("Got a bad selector" + attrib)
-		}
-		selector := strings.TrimSpace(attrib[0:idx])
-		value := strings.TrimSpace(attrib[idx+1:])
-
-		var important bool
-		if strings.HasSuffix(value, "important") {
-			important = true
-			value = value[0 : len(value)-len("important")]
-		}
-		m[StyleAttribute(selector)] = StyleValue{value, important}
-
+		m[StyleAttribute(decl.Name)] = declarationValue(decl)
 	}
 	return m
 }
 
-type parsingContext uint8
-
-const (
-	matchingUnknown = parsingContext(iota)
-	startContext
-	matchingSelector
-	matchingAttribute
-	matchingValue
-	appendingSelector // The next TokenIdent should be appended to the current selector
-	atImport
-)
-
-func (p parsingContext) String() string {
-	switch p {
-	case startContext:
-		return "start"
-	case matchingSelector:
-		return "selector"
-	case matchingAttribute:
-		return "attribute"
-	case matchingValue:
-		return "value"
-	case appendingSelector:
-		return "appendingselector"
-	case atImport:
-		return "@import"
-	default:
-		panic("Unhandled parsingContext")
-	}
-}
-
-func appendStyles(s []StyleRule, selectors []CSSSelector, attr StyleAttribute, val StyleValue, src StyleSource, order uint) ([]StyleRule, error) {
-	if attr == "" {
-		return s, nil
-	}
-	for i, sel := range selectors {
-		s = append(s, StyleRule{
-			Selector: CSSSelector{strings.TrimSpace(string(sel.Selector)), order + uint(i)},
-			Name:     attr,
-			Value:    val,
-			Src:      src,
+// ParseInlineStyle parses the declarations in a style attribute into rules
+// with the InlineStyleSrc source, in the order that they're specified, so
+// that a longhand after a shorthand for the same property overrides it.
+// Invalid declarations are discarded and reported to report, if it's not
+// nil.
+func ParseInlineStyle(val string, report DiagnosticFunc) []StyleRule {
+	var rules []StyleRule
+	r := newReporter(report, nil, val)
+	for _, decl := range parseDeclarations(val, r) {
+		if !validDeclaration(decl, r) {
+			continue
+		}
+		rules = append(rules, StyleRule{
+			Name:  StyleAttribute(decl.Name),
+			Value: declarationValue(decl),
+			Src:   InlineStyleSrc,
 		})
 	}
-	return s, nil
+	return rules
 }
 
-func ParseStylesheet(val string, src StyleSource, importLoader net.URLReader, urlContext *url.URL, orderNo uint) (styles Stylesheet, nextOrderNoStart uint) {
-	s := make([]StyleRule, 0)
+// declarationValue converts the component values of a parsed declaration
+// into the StyleValue that is stored on a StyleRule.
+func declarationValue(d Declaration) StyleValue {
+	return StyleValue{SerializeComponentValues(d.Value), d.Important}
+}
 
-	var blockSelectors []CSSSelector
-	var curSelector CSSSelector
-	var curAttribute StyleAttribute
-	var curValue StyleValue
-	var context parsingContext = startContext
-	spaceIfMatch := false
-	var importURL *url.URL
-	scn := scanner.New(val)
-	invalidSelector := false
-	skipblocks := 0
-	for {
-		token := scn.Next()
-		if token.Type == scanner.TokenEOF {
-			break
+// parseSelectorList splits the prelude of a qualified rule into the
+// selectors that it contains. If any selector in the list is invalid,
+// the whole list is invalid and ok is false.
+func parseSelectorList(prelude []ComponentValue) (selectors []string, ok bool) {
+	for _, sel := range splitOnCommas(prelude) {
+		sel = trimWhitespace(sel)
+		if len(sel) == 0 {
+			return nil, false
 		}
-		if invalidSelector {
-			if token.Value == "}" {
-				skipblocks--
-			} else if token.Value == "{" {
-				skipblocks++
-			}
-			if skipblocks == 0 && (token.Value == "}" || token.Value == ";") {
-				invalidSelector = false
-			}
-			curSelector = CSSSelector{}
-			continue
-		}
-
-		// This logic is far more convoluted than it should be and should
-		// probably be rewritten. It would likely be much easier to
-		// understand if it was switching in context and then handling the
-		// token types for each context, rather than switching on token type
-		// and then handling the different contexts for each type of token,
-		// but for now we just rely on tests to ensure the behaviour is
-		// working as expected.
-		switch token.Type {
-		// Different kinds of comments
-		case scanner.TokenCDO, scanner.TokenCDC:
-			continue
-		case scanner.TokenComment:
-			continue
-		case scanner.TokenS:
-			switch context {
-			case matchingSelector:
-				// if we get another Ident, it's an E F type selector.
-				// if not, the "," char will set it back to matching
-				context = appendingSelector
-				spaceIfMatch = true
-			case matchingValue:
-				spaceIfMatch = true
-			}
-			continue
-		case scanner.TokenIdent:
-			switch context {
-			case startContext, matchingSelector:
-				curSelector = CSSSelector{token.Value, orderNo}
-				spaceIfMatch = false
-			case appendingSelector:
-				if spaceIfMatch {
-					curSelector.Selector += " "
-					spaceIfMatch = false
-				}
-				curSelector.Selector += token.Value
-				context = matchingSelector
-			case matchingAttribute:
-				curAttribute = StyleAttribute(token.Value)
-				context = matchingValue
-			case matchingValue:
-				if token.Value == "important" {
-					curValue.Important = true
-				} else {
-					if curValue.Value == "" {
-						curValue.Value = token.Value
-					} else {
-						curValue.Value += " " + token.Value
+		var b strings.Builder
+		space := false
+		for _, v := range sel {
+			if t, ok := v.(Token); ok {
+				switch t.Type {
+				case WhitespaceToken:
+					space = true
+					continue
+				case DelimToken:
+					switch t.Value {
+					case ">", "+", "~":
+						// Whitespace around combinators is
+						// insignificant, so drop it.
+						space = false
+						b.WriteString(t.Value)
+						continue
 					}
+				case NumberToken, PercentageToken, DimensionToken,
+					StringToken, BadStringToken, URLToken, BadURLToken,
+					SemicolonToken, AtKeywordToken, CDOToken, CDCToken:
+					return nil, false
 				}
+			} else if blk, ok := v.(*SimpleBlock); ok && blk.Associated != OpenSquareToken {
+				return nil, false
 			}
-		case scanner.TokenChar:
-			switch context {
-			case startContext, matchingSelector, appendingSelector:
-				switch token.Value {
-				case ",":
-					if curSelector.Selector != "" {
-						blockSelectors = append(blockSelectors, curSelector)
+			if space {
+				if str := b.String(); str != "" {
+					switch str[len(str)-1] {
+					case '>', '+', '~':
+					default:
+						b.WriteByte(' ')
 					}
-					curSelector.Selector = ""
-					context = matchingSelector
-					spaceIfMatch = false
-				case "*":
-					curSelector.Selector += token.Value
-					context = matchingSelector
-					spaceIfMatch = false
-				case "=":
-					curSelector.Selector += token.Value
-					// the actual value is a TokenString, not a TokenIdent,
-					// so the context doesn't need to change to appending.
-					context = matchingSelector
-					spaceIfMatch = false
-
-				case ">", "[", "+", ":", ".", "~":
-					curSelector.Selector += token.Value
-					context = appendingSelector
-					spaceIfMatch = false
-
-				case "]", ")":
-					curSelector.Selector += token.Value
-					context = matchingSelector
-					spaceIfMatch = false
-
-				case "{":
-					if curSelector.Selector != "" {
-						blockSelectors = append(blockSelectors, curSelector)
-					}
-					context = matchingAttribute
-					curValue = StyleValue{}
-					curAttribute = ""
-					spaceIfMatch = false
-				case ";":
-					continue
-				default:
-					//panic(fmt.Sprintf("Unhandled character %v in context %v", token.Value, context))
-					invalidSelector = true
 				}
-			case matchingAttribute:
-				switch token.Value {
-				case ":":
-					curValue = StyleValue{}
-					context = matchingValue
-					spaceIfMatch = false
-				case "}":
-					spaceIfMatch = false
+			}
+			space = false
+			b.WriteString(v.String())
+		}
+		selectors = append(selectors, b.String())
+	}
+	return selectors, true
+}
 
-					if curAttribute != "" {
-						s, _ = appendStyles(s, blockSelectors, curAttribute, curValue, src, orderNo)
-						orderNo += uint(len(s))
-					}
-					// End of a block, so reset everything to the 0 value
-					// after appending the rules.
-					curSelector.Selector = ""
-					blockSelectors = nil
-					curSelector.OrderNumber = orderNo
-					curAttribute = ""
-					curValue = StyleValue{}
+// ParseStylesheet parses the CSS source in val into a list of StyleRules
+// with one rule per (selector, declaration) pair. Rules are numbered in the
+// order that they're specified, starting at orderNo, and the next unused
+// order number is returned. @import rules are resolved relative to
+// urlContext and loaded with importLoader. Invalid rules and declarations
+// are discarded.
+func ParseStylesheet(val string, src StyleSource, importLoader net.URLReader, urlContext *url.URL, orderNo uint) (styles Stylesheet, nextOrderNoStart uint) {
+	return ParseStylesheetWithDiagnostics(val, src, importLoader, urlContext, orderNo, nil)
+}
 
-					context = matchingSelector
-				}
-			case matchingValue:
-				switch token.Value {
-				case "-":
-					curValue.Value += token.Value
-					spaceIfMatch = false
-				case ",":
-					curValue.Value += token.Value
-					spaceIfMatch = true
-				case ")":
-					curValue.Value += token.Value
-				case ";":
-					if curAttribute != "" {
-						s, _ = appendStyles(s, blockSelectors, curAttribute, curValue, src, orderNo)
-						orderNo += uint(len(s))
-					}
-					curAttribute = ""
-					curValue = StyleValue{}
-					context = matchingAttribute
+// ParseStylesheetWithDiagnostics is like ParseStylesheet, but also reports
+// each rule or declaration that's discarded, including those in imported
+// stylesheets, to report.
+func ParseStylesheetWithDiagnostics(val string, src StyleSource, importLoader net.URLReader, urlContext *url.URL, orderNo uint, report DiagnosticFunc) (styles Stylesheet, nextOrderNoStart uint) {
+	r := newReporter(report, urlContext, val)
+	return parseStylesheet(parseRules(val, r), nil, true, src, importLoader, urlContext, nil, orderNo, r)
+}
 
-				case "}":
+// parseStylesheet converts a list of parsed rules into StyleRules. Every
+// rule generated is conditional on the media query lists in media, and
+// @import rules are only honoured if importsAllowed is true. imports is the
+// list of stylesheet URLs on the chain of @imports that led to rules, which
+// aren't imported again.
+func parseStylesheet(rules []Rule, media []MediaQueryList, importsAllowed bool, src StyleSource, importLoader net.URLReader, urlContext *url.URL, imports []string, orderNo uint, report *reporter) (Stylesheet, uint) {
+	s := make([]StyleRule, 0)
 
-					if curAttribute != "" {
-						s, _ = appendStyles(s, blockSelectors, curAttribute, curValue, src, orderNo)
-						orderNo += uint(len(s))
-					}
-					// End of a block, so reset everything to the 0 value
-					// after appending the rules.
-					blockSelectors = nil
-					curSelector.Selector = ""
-					curAttribute = ""
-					curValue = StyleValue{}
-					context = matchingSelector
-				}
-			case atImport:
-				switch token.Value {
-				case ";":
-					// Pretend we're at the start so future
-					// import lines succeed
-					context = startContext
-					r, resp, err := importLoader.GetURL(importURL)
-					if err != nil {
-						continue
-					}
-					defer r.Close()
-					if resp < 200 || resp >= 300 {
-						continue
-					}
-					styles, err := ioutil.ReadAll(r)
-					if err != nil {
-						continue
-					}
-					news, nextOrderNo := ParseStylesheet(string(styles), src, importLoader, importURL, orderNo)
-					orderNo = nextOrderNo
-					s = append(s, news...)
-				}
-			}
-		case scanner.TokenString:
-			if context == atImport {
-				undecorated := token.Value[1 : len(token.Value)-1]
-				iu, err := url.Parse(undecorated)
-				if err != nil {
+	for _, rule := range rules {
+		switch r := rule.(type) {
+		case *AtRule:
+			switch strings.ToLower(r.Name) {
+			case "charset":
+				continue
+			case "import":
+				// @import is only valid before any other rules
+				// (except @charset)
+				if !importsAllowed {
+					report.errorf(r.Offset, "@import must come before all other rules")
 					continue
 				}
-				importURL = urlContext.ResolveReference(iu)
-			}
-			fallthrough
-		case scanner.TokenNumber:
-			switch context {
-			case startContext, matchingSelector, appendingSelector:
-				// ".1" is an invalid selector according to
-				// CSS. gorilla css treats ".1" as a number,
-				// not as a dot followed by a number, so we need
-				// to flag it as an error if we're in a context
-				// which implies the dot is a class selector.
-				if token.Value[0] == '.' {
-					invalidSelector = true
+				news, nextOrderNo := importStylesheet(r, media, src, importLoader, urlContext, imports, orderNo, report)
+				orderNo = nextOrderNo
+				s = append(s, news...)
+			case "media":
+				importsAllowed = false
+				if r.Block == nil {
 					continue
 				}
+				news, nextOrderNo := parseStylesheet(
+					parseBlockRules(r.Block, report),
+					appendMedia(media, parseMediaQueryList(r.Prelude)),
+					false,
+					src, importLoader, urlContext, imports, orderNo, report,
+				)
+				orderNo = nextOrderNo
+				s = append(s, news...)
+			case "counter-style":
+				importsAllowed = false
+				s = append(s, parseCounterStyleRule(r, media, src, orderNo, false, report)...)
+				orderNo++
+			case "font-face":
+				importsAllowed = false
+				s = append(s, parseFontFaceRule(r, media, src, orderNo, urlContext, report)...)
+				orderNo++
+			default:
+				// Unsupported at-rules are ignored.
+				importsAllowed = false
 			}
-			fallthrough
-		case scanner.TokenHash:
-			switch context {
-			case startContext, matchingSelector, appendingSelector:
-				curSelector.Selector += token.Value
-				spaceIfMatch = false
-			case matchingValue:
-				if curValue.Value == "" {
-					curValue.Value += token.Value
-				} else {
-					if spaceIfMatch {
-						curValue.Value += " "
-						spaceIfMatch = false
-					}
-					curValue.Value += token.Value
-				}
-
+		case *QualifiedRule:
+			importsAllowed = false
+			selectors, ok := parseSelectorList(r.Prelude)
+			if !ok {
+				report.errorf(r.Offset, "Invalid selector: %v", strings.TrimSpace(SerializeComponentValues(r.Prelude)))
+				continue
 			}
-		case scanner.TokenIncludes, scanner.TokenPrefixMatch,
-			scanner.TokenSuffixMatch, scanner.TokenSubstringMatch,
-			scanner.TokenDashMatch, scanner.TokenFunction:
-			switch context {
-			case startContext, matchingSelector, appendingSelector:
-				curSelector.Selector += token.Value
-				spaceIfMatch = false
-			case matchingValue:
-				spaceIfMatch = false
-				if curValue.Value == "" {
-					curValue.Value = token.Value
-				} else {
-					curValue.Value += " " + token.Value
+			for _, sel := range selectors {
+				if c := (CSSSelector{sel, 0}).compile(); c.invalid {
+					report.errorf(r.Offset, "Invalid selector %v never matches", sel)
+				} else if c.unsupported() {
+					report.errorf(r.Offset, "Unsupported selector %v never matches", sel)
 				}
-
 			}
-		case scanner.TokenURI:
-			if context == atImport {
-				undecorated := strings.TrimSuffix(strings.TrimPrefix(token.Value, "url("), ")")
-				iu, err := url.Parse(undecorated)
-				if err != nil {
+			for _, decl := range parseBlockDeclarations(r.Block, report) {
+				if !validDeclaration(decl, report) {
 					continue
 				}
-				importURL = urlContext.ResolveReference(iu)
-				continue
-			}
-			fallthrough
-		case scanner.TokenDimension, scanner.TokenPercentage:
-			switch context {
-			case matchingValue:
-				if curValue.Value == "" {
-					curValue.Value = token.Value
-				} else {
-					if spaceIfMatch {
-						curValue.Value += " "
-						spaceIfMatch = false
-					}
-					curValue.Value += token.Value
+				value := declarationValue(decl)
+				for _, sel := range selectors {
+					s = append(s, StyleRule{
+						Selector: CSSSelector{sel, orderNo},
+						Name:     StyleAttribute(decl.Name),
+						Value:    value,
+						Src:      src,
+						Media:    media,
+					})
+					orderNo++
 				}
 			}
-		case scanner.TokenAtKeyword:
-			if token.Value == "@import" && context == startContext {
-				context = atImport
-			}
-		case scanner.TokenError:
-			fallthrough
-		default:
-			fmt.Printf("%s = %s: %v\n", token.Type, token.Value, token)
 		}
 	}
 	return s, orderNo
 }
 
+// appendMedia returns a new list of media conditions with l added to the end
+// of media. An empty query list matches everything, so it isn't added.
+func appendMedia(media []MediaQueryList, l MediaQueryList) []MediaQueryList {
+	if len(l) == 0 {
+		return media
+	}
+	ret := make([]MediaQueryList, len(media), len(media)+1)
+	copy(ret, media)
+	return append(ret, l)
+}
+
+// importStylesheet loads and parses the stylesheet referenced by an @import
+// rule. Stylesheets which are already in imports are skipped, so that
+// stylesheets which import themselves, directly or indirectly, don't recurse
+// forever.
+func importStylesheet(r *AtRule, media []MediaQueryList, src StyleSource, importLoader net.URLReader, urlContext *url.URL, imports []string, orderNo uint, report *reporter) (Stylesheet, uint) {
+	prelude := trimWhitespace(r.Prelude)
+	if len(prelude) == 0 {
+		return nil, orderNo
+	}
+	var href string
+	switch v := prelude[0].(type) {
+	case Token:
+		switch v.Type {
+		case StringToken, URLToken:
+			href = v.Value
+		default:
+			return nil, orderNo
+		}
+	case *Function:
+		args := trimWhitespace(v.Value)
+		if !strings.EqualFold(v.Name, "url") || len(args) != 1 || tokenType(args[0]) != StringToken {
+			return nil, orderNo
+		}
+		href = args[0].(Token).Value
+	default:
+		return nil, orderNo
+	}
+	iu, err := url.Parse(href)
+	if err != nil {
+		return nil, orderNo
+	}
+	importURL := iu
+	if urlContext != nil {
+		importURL = urlContext.ResolveReference(iu)
+	}
+	for _, u := range imports {
+		if u == importURL.String() {
+			report.errorf(r.Offset, "Recursive @import of %v ignored", importURL)
+			return nil, orderNo
+		}
+	}
+	r2, resp, err := importLoader.GetURL(importURL)
+	if err != nil {
+		return nil, orderNo
+	}
+	if r2 != nil {
+		defer r2.Close()
+	}
+	if resp < 200 || resp >= 300 {
+		return nil, orderNo
+	}
+	styles, err := ioutil.ReadAll(r2)
+	if err != nil {
+		return nil, orderNo
+	}
+	// Anything after the URL is a media query list that the imported
+	// stylesheet is conditional on.
+	media = appendMedia(media, parseMediaQueryList(prelude[1:]))
+	// Problems in the imported stylesheet are reported with its own
+	// URL and positions.
+	if report != nil {
+		report = newReporter(report.report, importURL, string(styles))
+	}
+	// A new slice is made so that sibling @imports don't share one.
+	chain := make([]string, len(imports), len(imports)+1)
+	copy(chain, imports)
+	chain = append(chain, importURL.String())
+	return parseStylesheet(parseRules(string(styles), report), media, true, src, importLoader, importURL, chain, orderNo, report)
+}
+
+// MediaMatches reports whether the media queries which the rule is
+// conditional on match the media features f.
+func (r StyleRule) MediaMatches(f MediaFeatures) bool {
+	for _, l := range r.Media {
+		if !l.Matches(f) {
+			return false
+		}
+	}
+	return true
+}
+
 func (r StyleRule) Matches(el *html.Node, st State) bool {
 	return r.Selector.Matches(el, st)
 }
+
+// MatchesStates reports whether the rule's selector matches el, using states
+// to look up the state of el and any other elements the selector involves.
+func (r StyleRule) MatchesStates(el *html.Node, states StateLookup) bool {
+	return r.Selector.MatchesStates(el, states)
+}
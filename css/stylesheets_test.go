@@ -1,6 +1,7 @@
 package css
 
 import (
+	"net/url"
 	"testing"
 )
 
@@ -13,10 +14,10 @@ hello, abc[foo], what, a > b {
 
 var multiplecsscontent string = `
 hello {
-	display: what; }
+	display: block; }
 
 goodbye {
-	display: yay;
+	display: inline;
 }
 `
 
@@ -90,8 +91,8 @@ func TestMultipleCSSBlocks(t *testing.T) {
 	assertName(t, sty[0], "display")
 	assertName(t, sty[1], "display")
 
-	assertValue(t, sty[0], StyleValue{"what", false})
-	assertValue(t, sty[1], StyleValue{"yay", false})
+	assertValue(t, sty[0], StyleValue{"block", false})
+	assertValue(t, sty[1], StyleValue{"inline", false})
 
 	for i, s := range sty {
 		if s.Src != AuthorSrc {
@@ -209,7 +210,8 @@ a {
 	assertValue(t, sty[2], StyleValue{"2pt", false})
 	assertValue(t, sty[3], StyleValue{"3", false})
 	assertValue(t, sty[4], StyleValue{"3.0", false})
-	assertValue(t, sty[5], StyleValue{"\"string\"", true})
+	// important is only important after a !
+	assertValue(t, sty[5], StyleValue{"\"string\" important", false})
 	assertValue(t, sty[6], StyleValue{"url(\"yay\")", false})
 	assertValue(t, sty[7], StyleValue{"fff f fff", false})
 	assertValue(t, sty[8], StyleValue{"rgb(255, 255, 255)", false})
@@ -227,7 +229,7 @@ func TestMultpleSelectors(t *testing.T) {
 // There was a regression at some point where if the first selector was an ID selector, it
 // wouldn't get parsed. This ensures that it's fixed.
 func TestIdRegression(t *testing.T) {
-	sty, _ := ParseStylesheet(`#one { display: what; } #two { display: yay; }`, AuthorSrc, noopURLer{}, nil, 0)
+	sty, _ := ParseStylesheet(`#one { display: block; } #two { display: inline; }`, AuthorSrc, noopURLer{}, nil, 0)
 	assertSelector(t, sty[0], `#one`)
 	assertSelector(t, sty[1], `#two`)
 }
@@ -244,3 +246,91 @@ func TestInvalidIdentifier(t *testing.T) {
 	assertSelector(t, sty[1], ".a1")
 	assertSelector(t, sty[2], "P.two")
 }
+
+func TestDeclarationErrorRecovery(t *testing.T) {
+	var errs []Diagnostic
+	sty, _ := ParseStylesheetWithDiagnostics(`div {
+	color: unimportant;
+	margin: 1px ! important;
+	padding: 2px !IMPORTANT;
+	width 10px;
+	height: -10px;
+	float: sideways;
+	border: 1px solid red blue;
+	font-weight: bold !important;
+	top: ;
+	@media print { }
+	--x: ;
+	display: block
+}`, AuthorSrc, noopURLer{}, nil, 0, func(d Diagnostic) { errs = append(errs, d) })
+	expected := []StyleRule{
+		{Name: "margin", Value: StyleValue{"1px", true}},
+		{Name: "padding", Value: StyleValue{"2px", true}},
+		{Name: "font-weight", Value: StyleValue{"bold", true}},
+		{Name: "--x", Value: StyleValue{"", false}},
+		{Name: "display", Value: StyleValue{"block", false}},
+	}
+	if len(sty) != len(expected) {
+		t.Fatalf("Unexpected rules: got %v want %v", sty, expected)
+	}
+	for i, rule := range expected {
+		assertName(t, sty[i], rule.Name)
+		assertValue(t, sty[i], rule.Value)
+	}
+	// color, width, height, float, border, top and the @media rule
+	if len(errs) != 7 {
+		t.Errorf("Unexpected diagnostics: got %v", errs)
+	}
+	found := false
+	for _, d := range errs {
+		if d.Message == "Invalid value for color: unimportant" {
+			found = true
+			if d.Line != 2 || d.Column != 2 || d.Severity != SeverityError {
+				t.Errorf("Unexpected diagnostic: got %v", d)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("color: unimportant was not reported: got %v", errs)
+	}
+
+	inline := ParseInlineStyle("color: bogus; color: red !important; margin: 1px 2px 3px 4px 5px", nil)
+	if len(inline) != 1 {
+		t.Fatalf("Unexpected inline rules: got %v", inline)
+	}
+	assertValue(t, inline[0], StyleValue{"red", true})
+}
+
+func TestDefaultCSSIsValid(t *testing.T) {
+	// The default stylesheet uses some properties that gob doesn't
+	// support, but nothing in it should be discarded.
+	ParseStylesheetWithDiagnostics(DefaultCSS, UserAgentSrc, noopURLer{}, nil, 0, func(d Diagnostic) {
+		if d.Severity == SeverityError {
+			t.Errorf("Unexpected diagnostic in the default stylesheet: %v", d)
+		}
+	})
+}
+
+func TestImportCycles(t *testing.T) {
+	loader := fontLoader{
+		"/self.css": []byte(`@import "self.css"; self { color: red }`),
+		"/a.css":    []byte(`@import url(b.css); a { color: red }`),
+		"/b.css":    []byte(`@import "a.css"; b { color: red }`),
+	}
+	base, _ := url.Parse("https://example.com/index.html")
+	sheet, _ := ParseStylesheet(`@import "self.css"; @import "a.css"; @import "b.css";`, AuthorSrc, loader, base, 0)
+	expected := []string{"self", "b", "a", "a", "b"}
+	if len(sheet) != len(expected) {
+		t.Fatalf("Unexpected rules: got %v want selectors %v", sheet, expected)
+	}
+	for i, sel := range expected {
+		assertSelector(t, sheet[i], sel)
+	}
+
+	// Without a URL context, only absolute URLs can be loaded.
+	sheet, _ = ParseStylesheet(`@import "https://example.com/self.css"; @import "a.css";`, AuthorSrc, loader, nil, 0)
+	if len(sheet) != 1 {
+		t.Fatalf("Unexpected rules: got %v", sheet)
+	}
+	assertSelector(t, sheet[0], "self")
+}
@@ -0,0 +1,474 @@
+package css
+
+import (
+	"strings"
+)
+
+// A ComponentValue is a preserved Token, a Function, or a SimpleBlock, as
+// defined in section 5 of the CSS Syntax Level 3 spec.
+type ComponentValue interface {
+	String() string
+	isComponentValue()
+}
+
+// A Function is a function token with the component values of its
+// arguments.
+type Function struct {
+	Name  string
+	Value []ComponentValue
+
+	// The offset of the function in the source, as for a Token.
+	Offset int
+}
+
+func (f *Function) isComponentValue() {}
+
+func (f *Function) String() string {
+	return serializeIdent(f.Name) + "(" + SerializeComponentValues(f.Value) + ")"
+}
+
+// A SimpleBlock is a {}, [] or () block. Associated is the token type which
+// opened the block.
+type SimpleBlock struct {
+	Associated TokenType
+	Value      []ComponentValue
+
+	// The offset of the block in the source, as for a Token.
+	Offset int
+}
+
+func (b *SimpleBlock) isComponentValue() {}
+
+func (b *SimpleBlock) String() string {
+	switch b.Associated {
+	case OpenCurlyToken:
+		return "{" + SerializeComponentValues(b.Value) + "}"
+	case OpenSquareToken:
+		return "[" + SerializeComponentValues(b.Value) + "]"
+	default:
+		return "(" + SerializeComponentValues(b.Value) + ")"
+	}
+}
+
+// A Rule is either an *AtRule or a *QualifiedRule.
+type Rule interface {
+	isRule()
+}
+
+// An AtRule is a rule starting with an at-keyword, such as @import or
+// @media. Block is nil for statement at-rules that end with a semicolon.
+type AtRule struct {
+	Name    string
+	Prelude []ComponentValue
+	Block   *SimpleBlock
+
+	// The offset of the rule in the source, as for a Token.
+	Offset int
+}
+
+func (r *AtRule) isRule() {}
+
+// A QualifiedRule is a rule with a prelude and a {} block. In a stylesheet,
+// the prelude is a selector list and the block is a list of declarations.
+type QualifiedRule struct {
+	Prelude []ComponentValue
+	Block   *SimpleBlock
+
+	// The offset of the rule in the source, as for a Token.
+	Offset int
+}
+
+func (r *QualifiedRule) isRule() {}
+
+// A Declaration is a name: value pair, such as "color: red !important".
+// The !important flag is removed from Value and sets Important.
+type Declaration struct {
+	Name      string
+	Value     []ComponentValue
+	Important bool
+
+	// The offset of the declaration in the source, as for a Token.
+	Offset int
+}
+
+// SerializeComponentValues converts component values back into a string
+// of CSS. Runs of whitespace are collapsed into a single space, and leading
+// and trailing whitespace is removed.
+func SerializeComponentValues(vals []ComponentValue) string {
+	var b strings.Builder
+	space := false
+	for _, v := range vals {
+		if t, ok := v.(Token); ok && t.Type == WhitespaceToken {
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+// A tokenStream is the list of tokens (or component values) that the parser
+// algorithms from section 5 consume.
+type tokenStream struct {
+	vals []ComponentValue
+	pos  int
+
+	// report is used to report the parse errors that are recovered
+	// from.
+	report *reporter
+}
+
+func newTokenStream(s string) *tokenStream {
+	toks := Tokenize(s)
+	vals := make([]ComponentValue, len(toks))
+	for i, t := range toks {
+		vals[i] = t
+	}
+	return &tokenStream{vals: vals}
+}
+
+func (s *tokenStream) next() ComponentValue {
+	if s.pos >= len(s.vals) {
+		return Token{Type: EOFToken}
+	}
+	v := s.vals[s.pos]
+	s.pos++
+	return v
+}
+
+func (s *tokenStream) peek() ComponentValue {
+	if s.pos >= len(s.vals) {
+		return Token{Type: EOFToken}
+	}
+	return s.vals[s.pos]
+}
+
+func (s *tokenStream) reconsume() {
+	s.pos--
+}
+
+// notAToken is returned by tokenType for functions and blocks.
+const notAToken TokenType = 255
+
+func tokenType(v ComponentValue) TokenType {
+	if t, ok := v.(Token); ok {
+		return t.Type
+	}
+	return notAToken
+}
+
+// offsetOf returns the offset of v in the source that it was parsed from.
+func offsetOf(v ComponentValue) int {
+	switch v := v.(type) {
+	case Token:
+		return v.Offset
+	case *Function:
+		return v.Offset
+	case *SimpleBlock:
+		return v.Offset
+	}
+	return 0
+}
+
+// ParseRules parses a stylesheet into a list of rules. (Section 5.3.3,
+// "Parse a stylesheet".)
+func ParseRules(s string) []Rule {
+	return parseRules(s, nil)
+}
+
+// parseRules parses a stylesheet into a list of rules, reporting parse
+// errors to report.
+func parseRules(s string, report *reporter) []Rule {
+	ts := newTokenStream(s)
+	ts.report = report
+	return ts.consumeRuleList(true)
+}
+
+// ParseDeclarations parses a list of declarations, such as the content of a
+// style attribute or of a qualified rule's block. Invalid declarations are
+// discarded. (Section 5.3.8, "Parse a list of declarations".)
+func ParseDeclarations(s string) []Declaration {
+	return parseDeclarations(s, nil)
+}
+
+// parseDeclarations parses a list of declarations, reporting parse errors
+// to report.
+func parseDeclarations(s string, report *reporter) []Declaration {
+	ts := newTokenStream(s)
+	ts.report = report
+	return ts.consumeDeclarationList()
+}
+
+// ParseComponentValues parses s into a list of component values (Section
+// 5.3.10)
+func ParseComponentValues(s string) []ComponentValue {
+	ts := newTokenStream(s)
+	var vals []ComponentValue
+	for tokenType(ts.peek()) != EOFToken {
+		vals = append(vals, ts.consumeComponentValue())
+	}
+	return vals
+}
+
+// parseBlockDeclarations parses the content of a {} block as a list of
+// declarations, reporting parse errors to report.
+func parseBlockDeclarations(b *SimpleBlock, report *reporter) []Declaration {
+	if b == nil {
+		return nil
+	}
+	ts := &tokenStream{vals: b.Value, report: report}
+	return ts.consumeDeclarationList()
+}
+
+// parseBlockRules parses the content of a {} block as a list of rules, as
+// in the block of a conditional group rule like @media. Parse errors are
+// reported to report.
+func parseBlockRules(b *SimpleBlock, report *reporter) []Rule {
+	if b == nil {
+		return nil
+	}
+	ts := &tokenStream{vals: b.Value, report: report}
+	return ts.consumeRuleList(false)
+}
+
+// Section 5.4.1
+func (s *tokenStream) consumeRuleList(toplevel bool) []Rule {
+	var rules []Rule
+	for {
+		v := s.next()
+		switch tokenType(v) {
+		case WhitespaceToken:
+			continue
+		case EOFToken:
+			return rules
+		case CDOToken, CDCToken:
+			if toplevel {
+				continue
+			}
+			s.reconsume()
+			if r := s.consumeQualifiedRule(); r != nil {
+				rules = append(rules, r)
+			}
+		case AtKeywordToken:
+			s.reconsume()
+			rules = append(rules, s.consumeAtRule())
+		default:
+			s.reconsume()
+			if r := s.consumeQualifiedRule(); r != nil {
+				rules = append(rules, r)
+			}
+		}
+	}
+}
+
+// Section 5.4.2
+func (s *tokenStream) consumeAtRule() *AtRule {
+	start := s.next().(Token)
+	rule := &AtRule{Name: start.Value, Offset: start.Offset}
+	for {
+		v := s.next()
+		switch tokenType(v) {
+		case SemicolonToken:
+			return rule
+		case EOFToken:
+			s.report.errorf(rule.Offset, "Unexpected end of input in @%v rule", rule.Name)
+			return rule
+		case OpenCurlyToken:
+			rule.Block = s.consumeSimpleBlock(v.(Token))
+			return rule
+		default:
+			if b, ok := v.(*SimpleBlock); ok && b.Associated == OpenCurlyToken {
+				rule.Block = b
+				return rule
+			}
+			s.reconsume()
+			rule.Prelude = append(rule.Prelude, s.consumeComponentValue())
+		}
+	}
+}
+
+// Section 5.4.3
+func (s *tokenStream) consumeQualifiedRule() *QualifiedRule {
+	rule := &QualifiedRule{Offset: offsetOf(s.peek())}
+	for {
+		v := s.next()
+		switch tokenType(v) {
+		case EOFToken:
+			// Parse error, nothing is returned.
+			s.report.errorf(rule.Offset, "Unexpected end of input in rule %v", strings.TrimSpace(SerializeComponentValues(rule.Prelude)))
+			return nil
+		case OpenCurlyToken:
+			rule.Block = s.consumeSimpleBlock(v.(Token))
+			return rule
+		default:
+			if b, ok := v.(*SimpleBlock); ok && b.Associated == OpenCurlyToken {
+				rule.Block = b
+				return rule
+			}
+			s.reconsume()
+			rule.Prelude = append(rule.Prelude, s.consumeComponentValue())
+		}
+	}
+}
+
+// Section 5.4.5. At-rules inside of a declaration list are consumed
+// and discarded, since no at-rules that gob supports are valid there.
+func (s *tokenStream) consumeDeclarationList() []Declaration {
+	var decls []Declaration
+	for {
+		v := s.next()
+		switch tokenType(v) {
+		case WhitespaceToken, SemicolonToken:
+			continue
+		case EOFToken:
+			return decls
+		case AtKeywordToken:
+			s.reconsume()
+			r := s.consumeAtRule()
+			s.report.errorf(r.Offset, "Unexpected @%v rule in declaration list", r.Name)
+		case IdentToken:
+			tmp := &tokenStream{vals: []ComponentValue{v}, report: s.report}
+			for t := tokenType(s.peek()); t != SemicolonToken && t != EOFToken; t = tokenType(s.peek()) {
+				tmp.vals = append(tmp.vals, s.consumeComponentValue())
+			}
+			if d, ok := tmp.consumeDeclaration(); ok {
+				decls = append(decls, d)
+			}
+		default:
+			// Parse error. Throw away everything up to the next
+			// semicolon.
+			s.reconsume()
+			var discarded []ComponentValue
+			for t := tokenType(s.peek()); t != SemicolonToken && t != EOFToken; t = tokenType(s.peek()) {
+				discarded = append(discarded, s.consumeComponentValue())
+			}
+			s.report.errorf(offsetOf(v), "Invalid declaration: %v", SerializeComponentValues(discarded))
+		}
+	}
+}
+
+// Section 5.4.6
+func (s *tokenStream) consumeDeclaration() (Declaration, bool) {
+	start := s.next().(Token)
+	d := Declaration{Name: start.Value, Offset: start.Offset}
+	for tokenType(s.peek()) == WhitespaceToken {
+		s.next()
+	}
+	if tokenType(s.peek()) != ColonToken {
+		s.report.errorf(d.Offset, "Expected a colon after %v", d.Name)
+		return d, false
+	}
+	s.next()
+	for tokenType(s.peek()) == WhitespaceToken {
+		s.next()
+	}
+	for tokenType(s.peek()) != EOFToken {
+		d.Value = append(d.Value, s.next())
+	}
+
+	// Strip trailing whitespace, then check for !important
+	d.Value = trimWhitespace(d.Value)
+	if n := len(d.Value); n >= 2 {
+		last, ok1 := d.Value[n-1].(Token)
+		if ok1 && last.isIdent("important") {
+			i := n - 2
+			for i > 0 && tokenType(d.Value[i]) == WhitespaceToken {
+				i--
+			}
+			if bang, ok := d.Value[i].(Token); ok && bang.isDelim("!") {
+				d.Important = true
+				d.Value = trimWhitespace(d.Value[:i])
+			}
+		}
+	}
+	return d, true
+}
+
+// Section 5.4.7
+func (s *tokenStream) consumeComponentValue() ComponentValue {
+	v := s.next()
+	t, ok := v.(Token)
+	if !ok {
+		return v
+	}
+	switch t.Type {
+	case OpenCurlyToken, OpenSquareToken, OpenParenToken:
+		return s.consumeSimpleBlock(t)
+	case FunctionToken:
+		return s.consumeFunction(t)
+	}
+	return t
+}
+
+// Section 5.4.8
+func (s *tokenStream) consumeSimpleBlock(start Token) *SimpleBlock {
+	var ending TokenType
+	switch start.Type {
+	case OpenCurlyToken:
+		ending = CloseCurlyToken
+	case OpenSquareToken:
+		ending = CloseSquareToken
+	case OpenParenToken:
+		ending = CloseParenToken
+	}
+	block := &SimpleBlock{Associated: start.Type, Offset: start.Offset}
+	for {
+		switch tokenType(s.peek()) {
+		case ending:
+			s.next()
+			return block
+		case EOFToken:
+			// Parse error
+			return block
+		default:
+			block.Value = append(block.Value, s.consumeComponentValue())
+		}
+	}
+}
+
+// Section 5.4.9
+func (s *tokenStream) consumeFunction(start Token) *Function {
+	f := &Function{Name: start.Value, Offset: start.Offset}
+	for {
+		switch tokenType(s.peek()) {
+		case CloseParenToken:
+			s.next()
+			return f
+		case EOFToken:
+			// Parse error
+			return f
+		default:
+			f.Value = append(f.Value, s.consumeComponentValue())
+		}
+	}
+}
+
+func trimWhitespace(vals []ComponentValue) []ComponentValue {
+	for len(vals) > 0 && tokenType(vals[0]) == WhitespaceToken {
+		vals = vals[1:]
+	}
+	for len(vals) > 0 && tokenType(vals[len(vals)-1]) == WhitespaceToken {
+		vals = vals[:len(vals)-1]
+	}
+	return vals
+}
+
+// splitOnCommas splits a list of component values on top-level comma
+// tokens, as used by selector lists and media query lists.
+func splitOnCommas(vals []ComponentValue) [][]ComponentValue {
+	var ret [][]ComponentValue
+	var cur []ComponentValue
+	for _, v := range vals {
+		if tokenType(v) == CommaToken {
+			ret = append(ret, cur)
+			cur = nil
+			continue
+		}
+		cur = append(cur, v)
+	}
+	return append(ret, cur)
+}
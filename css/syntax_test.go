@@ -0,0 +1,105 @@
+package css
+
+import (
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Expected []TokenType
+	}{
+		{"div", []TokenType{IdentToken}},
+		{"a > b", []TokenType{IdentToken, WhitespaceToken, DelimToken, WhitespaceToken, IdentToken}},
+		{"/* comment */red", []TokenType{IdentToken}},
+		{"#fff", []TokenType{HashToken}},
+		{"3.0cm", []TokenType{DimensionToken}},
+		{"50%", []TokenType{PercentageToken}},
+		{"-2", []TokenType{NumberToken}},
+		{"rgb(1,2,3)", []TokenType{FunctionToken, NumberToken, CommaToken, NumberToken, CommaToken, NumberToken, CloseParenToken}},
+		{"url(foo.png)", []TokenType{URLToken}},
+		{`url("foo.png")`, []TokenType{FunctionToken, StringToken, CloseParenToken}},
+		{"url(foo bar)", []TokenType{BadURLToken}},
+		{`"unterminated` + "\n", []TokenType{BadStringToken, WhitespaceToken}},
+		{"@media", []TokenType{AtKeywordToken}},
+		{"<!-- -->", []TokenType{CDOToken, WhitespaceToken, CDCToken}},
+		{"[a=b]", []TokenType{OpenSquareToken, IdentToken, DelimToken, IdentToken, CloseSquareToken}},
+	}
+	for i, tc := range tests {
+		toks := Tokenize(tc.Source)
+		if len(toks) != len(tc.Expected) {
+			t.Errorf("Unexpected tokens for test %d (%q): got %#v", i, tc.Source, toks)
+			continue
+		}
+		for j, tok := range toks {
+			if tok.Type != tc.Expected[j] {
+				t.Errorf("Unexpected token %d for test %d (%q): got %v want %v", j, i, tc.Source, tok.Type, tc.Expected[j])
+			}
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules := ParseRules(`@charset "utf-8"; @import url(foo.css); a, b { color: red } @media screen { p { margin: 0 } }`)
+	if len(rules) != 4 {
+		t.Fatalf("Unexpected number of rules: got %v want 4", len(rules))
+	}
+	if r, ok := rules[0].(*AtRule); !ok || r.Name != "charset" || r.Block != nil {
+		t.Errorf("Unexpected rule 0: got %#v", rules[0])
+	}
+	if r, ok := rules[1].(*AtRule); !ok || r.Name != "import" || SerializeComponentValues(r.Prelude) != "url(foo.css)" {
+		t.Errorf("Unexpected rule 1: got %#v", rules[1])
+	}
+	if r, ok := rules[2].(*QualifiedRule); !ok || SerializeComponentValues(r.Prelude) != "a, b" {
+		t.Errorf("Unexpected rule 2: got %#v", rules[2])
+	}
+	r, ok := rules[3].(*AtRule)
+	if !ok || r.Name != "media" || r.Block == nil {
+		t.Fatalf("Unexpected rule 3: got %#v", rules[3])
+	}
+	if nested := parseBlockRules(r.Block, nil); len(nested) != 1 {
+		t.Errorf("Unexpected rules in @media block: got %#v", nested)
+	}
+}
+
+func TestParseDeclarations(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Expected []Declaration
+	}{
+		{
+			"color: red",
+			[]Declaration{{Name: "color"}},
+		},
+		{
+			"color: red !important; margin: 0",
+			[]Declaration{{Name: "color", Important: true}, {Name: "margin"}},
+		},
+		{
+			// Invalid declarations are dropped, but parsing recovers
+			// at the next semicolon.
+			"margin 0; color: red; 5px: 3; display: block",
+			[]Declaration{{Name: "color"}, {Name: "display"}},
+		},
+		{
+			"background: url(a;b.png); color: red",
+			[]Declaration{{Name: "background"}, {Name: "color"}},
+		},
+		{
+			"color: red; @foo bar; display: block",
+			[]Declaration{{Name: "color"}, {Name: "display"}},
+		},
+	}
+	for i, tc := range tests {
+		decls := ParseDeclarations(tc.Source)
+		if len(decls) != len(tc.Expected) {
+			t.Errorf("Unexpected declarations for test %d: got %v want %v", i, decls, tc.Expected)
+			continue
+		}
+		for j, d := range decls {
+			if d.Name != tc.Expected[j].Name || d.Important != tc.Expected[j].Important {
+				t.Errorf("Unexpected declaration %d for test %d: got %v want %v", j, i, d, tc.Expected[j])
+			}
+		}
+	}
+}
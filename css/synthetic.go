@@ -0,0 +1,114 @@
+package css
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// syntheticBoldWeight is the weight from which fonts that are lighter are
+// drawn with synthetic bold glyphs.
+const syntheticBoldWeight = 600
+
+// syntheticObliqueAngle is the angle, in degrees, that glyphs are slanted by
+// when they're drawn with a synthetic oblique style. It's the default angle
+// for oblique in CSS.
+const syntheticObliqueAngle = 14
+
+// synthesize returns face, drawing its glyphs more boldly if bold is true,
+// and slanted if oblique is true, for a family which doesn't have a font
+// with the wanted weight or style.
+func synthesize(face font.Face, fsize int, bold, oblique bool) font.Face {
+	if !bold && !oblique {
+		return face
+	}
+	s := &syntheticFace{Face: face}
+	if bold {
+		s.bold = fsize / 24
+		if s.bold < 1 {
+			s.bold = 1
+		}
+	}
+	if oblique {
+		s.skew = math.Tan(syntheticObliqueAngle * math.Pi / 180)
+	}
+	return s
+}
+
+// A syntheticFace is a face whose glyphs are widened to make them bolder,
+// slanted to make them oblique, or both.
+type syntheticFace struct {
+	font.Face
+
+	// The number of pixels that glyphs are widened by, which is added to
+	// their advance.
+	bold int
+
+	// How far glyphs are moved to the right for every pixel above the
+	// baseline.
+	skew float64
+}
+
+func (f *syntheticFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	dr, mask, maskp, advance, ok := f.Face.Glyph(dot, r)
+	advance += fixed.I(f.bold)
+	if !ok || dr.Empty() {
+		return dr, mask, maskp, advance, ok
+	}
+
+	baseline := float64(dot.Y) / 64
+	shift := func(y int) float64 {
+		return f.skew * (baseline - (float64(y) + 0.5))
+	}
+	// alpha returns the coverage of the source glyph at x, which is
+	// interpolated between the pixels on either side of it.
+	alpha := func(x float64, y int) float64 {
+		x -= 0.5
+		x0 := int(math.Floor(x))
+		frac := x - float64(x0)
+		at := func(x int) float64 {
+			if x < dr.Min.X || x >= dr.Max.X {
+				return 0
+			}
+			_, _, _, a := mask.At(maskp.X+x-dr.Min.X, maskp.Y+y-dr.Min.Y).RGBA()
+			return float64(a) / 0xffff
+		}
+		return (1-frac)*at(x0) + frac*at(x0+1)
+	}
+
+	bounds := image.Rect(
+		dr.Min.X+int(math.Floor(shift(dr.Max.Y-1))),
+		dr.Min.Y,
+		dr.Max.X+int(math.Ceil(shift(dr.Min.Y)))+f.bold,
+		dr.Max.Y,
+	)
+	dst := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		sh := shift(y)
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var a float64
+			for k := 0; k <= f.bold; k++ {
+				a = math.Max(a, alpha(float64(x-k)+0.5-sh, y))
+			}
+			dst.Pix[dst.PixOffset(x, y)] = uint8(a*0xff + 0.5)
+		}
+	}
+	return bounds, dst, bounds.Min, advance, ok
+}
+
+func (f *syntheticFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	bounds, advance, ok := f.Face.GlyphBounds(r)
+	// Points are moved right by the skew for every pixel above the
+	// baseline, which is at y = 0.
+	bounds.Min.X -= fixed.Int26_6(f.skew * float64(bounds.Max.Y))
+	bounds.Max.X -= fixed.Int26_6(f.skew * float64(bounds.Min.Y))
+	bounds.Max.X += fixed.I(f.bold)
+	return bounds, advance + fixed.I(f.bold), ok
+}
+
+func (f *syntheticFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	advance, ok := f.Face.GlyphAdvance(r)
+	return advance + fixed.I(f.bold), ok
+}
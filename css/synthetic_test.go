@@ -0,0 +1,89 @@
+package css
+
+import (
+	"image"
+	"net/url"
+	"testing"
+
+	"github.com/driusan/fonts"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// glyphWidth returns the width of the pixels drawn for r by face.
+func glyphWidth(face font.Face, r rune) int {
+	dr, mask, maskp, _, ok := face.Glyph(fixed.P(0, 20), r)
+	if !ok {
+		return 0
+	}
+	inked := image.Rectangle{}
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			if _, _, _, a := mask.At(maskp.X+x-dr.Min.X, maskp.Y+y-dr.Min.Y).RGBA(); a > 0 {
+				inked = inked.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return inked.Dx()
+}
+
+func TestSyntheticFaces(t *testing.T) {
+	regular, err := fonts.Asset("DejaVuSans.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := fontLoader{"/regular.ttf": regular}
+	sheet, _ := ParseStylesheet(`@font-face { font-family: Plain; src: url(/regular.ttf); }`,
+		AuthorSrc, loader, &url.URL{Scheme: "https", Host: "example.com"}, 0)
+	LoadFontFaces(loader, NewFontFaces(MediaFeatures{}, sheet))
+	defer LoadFontFaces(loader, nil)
+
+	var e StyledElement
+	face := func(weight font.Weight, style font.Style) font.Face {
+		return e.GetFontFace(24, "Plain", weight, style, FontStretchNormal)
+	}
+	advance := func(f font.Face) fixed.Int26_6 {
+		adv, _ := f.GlyphAdvance('l')
+		return adv
+	}
+	normal := face(font.WeightNormal, font.StyleNormal)
+	normalWidth := glyphWidth(normal, 'l')
+
+	// Plain only has a normal font, which is used as is for weights
+	// lighter than semi-bold.
+	if medium := face(font.WeightMedium, font.StyleNormal); advance(medium) != advance(normal) || glyphWidth(medium, 'l') != normalWidth {
+		t.Errorf("Expected the normal font to be used for medium")
+	}
+
+	// It's widened for bold weights, by a pixel at this size.
+	bold := face(font.WeightBold, font.StyleNormal)
+	if got, want := advance(bold), advance(normal)+fixed.I(1); got != want {
+		t.Errorf("Unexpected advance for synthetic bold: got %v want %v", got, want)
+	}
+	if got, want := glyphWidth(bold, 'l'), normalWidth+1; got != want {
+		t.Errorf("Unexpected glyph width for synthetic bold: got %v want %v", got, want)
+	}
+
+	// It's slanted for italic and oblique, without changing the
+	// advance, so the upright stroke of the l becomes wider.
+	for _, style := range []font.Style{font.StyleItalic, font.StyleOblique} {
+		oblique := face(font.WeightNormal, style)
+		if advance(oblique) != advance(normal) {
+			t.Errorf("Unexpected advance for synthetic oblique: got %v want %v", advance(oblique), advance(normal))
+		}
+		if got := glyphWidth(oblique, 'l'); got <= normalWidth+2 {
+			t.Errorf("Expected synthetic oblique l to be slanted: got width %v, upright is %v", got, normalWidth)
+		}
+		bounds, _, _ := oblique.GlyphBounds('l')
+		upright, _, _ := normal.GlyphBounds('l')
+		if bounds.Max.X <= upright.Max.X || bounds.Min.Y != upright.Min.Y {
+			t.Errorf("Unexpected bounds for synthetic oblique: got %v, upright is %v", bounds, upright)
+		}
+	}
+
+	// Fonts with the weight and style aren't changed.
+	serif := e.GetFontFace(24, "serif", font.WeightBold, font.StyleNormal, FontStretchNormal)
+	if _, ok := serif.(*fallbackFace).primary.faces[0].(*syntheticFace); ok {
+		t.Errorf("Unexpected synthetic bold for the DejaVu Serif bold font")
+	}
+}
@@ -0,0 +1,224 @@
+package css
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+)
+
+// FontDirs are the directories that are searched, including their
+// subdirectories, for locally installed TrueType and OpenType fonts. They're
+// the same directories that fontconfig uses by default.
+var FontDirs = defaultFontDirs()
+
+func defaultFontDirs() []string {
+	dirs := []string{"/usr/share/fonts", "/usr/local/share/fonts"}
+	if u, err := user.Current(); err == nil {
+		dirs = append(dirs,
+			filepath.Join(u.HomeDir, ".fonts"),
+			filepath.Join(u.HomeDir, ".local", "share", "fonts"),
+		)
+	}
+	return dirs
+}
+
+// A systemFont is a locally installed font, which is only parsed once it's
+// used.
+type systemFont struct {
+	path string
+
+	// The index of the font in a font collection, or -1 if the file
+	// isn't a collection.
+	index int
+
+	font *sfnt.Font
+	err  error
+}
+
+// load parses the font. It's only parsed once, even if it can't be.
+func (f *systemFont) load() (*sfnt.Font, error) {
+	if f.font == nil && f.err == nil {
+		f.font, f.err = f.parse()
+	}
+	return f.font, f.err
+}
+
+func (f *systemFont) parse() (*sfnt.Font, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if f.index < 0 {
+		return sfnt.Parse(b)
+	}
+	c, err := sfnt.ParseCollection(b)
+	if err != nil {
+		return nil, err
+	}
+	return c.Font(f.index)
+}
+
+// A fontIndex holds the fonts found in FontDirs.
+type fontIndex struct {
+	// The fonts by lower case family name.
+	families map[string][]availableFont
+
+	// The fonts by lower case full name and PostScript name, for
+	// local() sources in @font-face rules.
+	names map[string]*systemFont
+}
+
+// systemFontIndex is the index of the locally installed fonts, or nil if
+// FontDirs haven't been searched yet.
+var systemFontIndex *fontIndex
+
+// systemFonts returns the locally installed fonts, by lower case family
+// name. FontDirs are searched the first time that it's called.
+func systemFonts() map[string][]availableFont {
+	return loadFontIndex().families
+}
+
+// localFonts returns the locally installed fonts, by lower case full name
+// and PostScript name.
+func localFonts() map[string]*systemFont {
+	return loadFontIndex().names
+}
+
+func loadFontIndex() *fontIndex {
+	if systemFontIndex != nil {
+		return systemFontIndex
+	}
+	systemFontIndex = &fontIndex{
+		families: make(map[string][]availableFont),
+		names:    make(map[string]*systemFont),
+	}
+	for _, dir := range FontDirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".ttf", ".otf":
+				systemFontIndex.add(path, -1)
+			case ".ttc", ".otc":
+				systemFontIndex.add(path, 0)
+			}
+			return nil
+		})
+	}
+	return systemFontIndex
+}
+
+// add adds the fonts in the file at path to the index. If index isn't -1,
+// the file is a font collection, and every font in it is added.
+func (idx *fontIndex) add(path string, index int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var fonts []*sfnt.Font
+	if index < 0 {
+		ft, err := sfnt.ParseReaderAt(f)
+		if err != nil {
+			return
+		}
+		fonts = append(fonts, ft)
+	} else {
+		c, err := sfnt.ParseCollectionReaderAt(f)
+		if err != nil {
+			return
+		}
+		for i := 0; i < c.NumFonts(); i++ {
+			ft, err := c.Font(i)
+			if err != nil {
+				return
+			}
+			fonts = append(fonts, ft)
+		}
+	}
+
+	var buf sfnt.Buffer
+	name := func(ft *sfnt.Font, ids ...sfnt.NameID) string {
+		for _, id := range ids {
+			if n, err := ft.Name(&buf, id); err == nil && n != "" {
+				return n
+			}
+		}
+		return ""
+	}
+	for i, ft := range fonts {
+		src := &systemFont{path: path, index: -1}
+		if index >= 0 {
+			src.index = i
+		}
+		family := name(ft, sfnt.NameIDTypographicFamily, sfnt.NameIDFamily)
+		if family == "" {
+			continue
+		}
+		weight, stretch, style := subfamilyDescriptors(name(ft, sfnt.NameIDTypographicSubfamily, sfnt.NameIDSubfamily))
+		family = strings.ToLower(family)
+		available := sfntFont(weight, weight, style, src.load)
+		available.minStretch, available.maxStretch = stretch, stretch
+		idx.families[family] = append(idx.families[family], available)
+		for _, id := range []sfnt.NameID{sfnt.NameIDFull, sfnt.NameIDPostScript} {
+			if n := name(ft, id); n != "" {
+				idx.names[strings.ToLower(n)] = src
+			}
+		}
+	}
+}
+
+// subfamilyDescriptors returns the weight, width and style of a font from
+// its subfamily name, such as "Bold Italic", "SemiBold" or "Condensed".
+func subfamilyDescriptors(subfamily string) (weight int, stretch FontStretch, style font.Style) {
+	s := strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(subfamily))
+	switch {
+	case strings.Contains(s, "italic"):
+		style = font.StyleItalic
+	case strings.Contains(s, "oblique"):
+		style = font.StyleOblique
+	default:
+		style = font.StyleNormal
+	}
+	stretch = FontStretchNormal
+	widths := []struct {
+		name    string
+		stretch FontStretch
+	}{
+		{"ultracondensed", 50}, {"extracondensed", 62.5},
+		{"semicondensed", 87.5}, {"condensed", 75}, {"narrow", 75},
+		{"semiexpanded", 112.5}, {"extraexpanded", 150},
+		{"ultraexpanded", 200}, {"expanded", 125}, {"wide", 125},
+	}
+	for _, w := range widths {
+		if strings.Contains(s, w.name) {
+			stretch = w.stretch
+			break
+		}
+	}
+	weights := []struct {
+		name   string
+		weight int
+	}{
+		{"thin", 100}, {"hairline", 100},
+		{"extralight", 200}, {"ultralight", 200},
+		{"semilight", 350}, {"light", 300},
+		{"medium", 500},
+		{"semibold", 600}, {"demibold", 600},
+		{"extrabold", 800}, {"ultrabold", 800}, {"bold", 700},
+		{"black", 900}, {"heavy", 900},
+	}
+	for _, w := range weights {
+		if strings.Contains(s, w.name) {
+			return w.weight, stretch, style
+		}
+	}
+	return 400, stretch, style
+}
@@ -0,0 +1,715 @@
+package css
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// TokenType is the type of a token produced by the CSS tokenizer, as
+// defined in section 4 of the CSS Syntax Level 3 specification.
+type TokenType uint8
+
+const (
+	EOFToken TokenType = iota
+	IdentToken
+	FunctionToken
+	AtKeywordToken
+	HashToken
+	StringToken
+	BadStringToken
+	URLToken
+	BadURLToken
+	DelimToken
+	NumberToken
+	PercentageToken
+	DimensionToken
+	WhitespaceToken
+	CDOToken
+	CDCToken
+	ColonToken
+	SemicolonToken
+	CommaToken
+	OpenSquareToken
+	CloseSquareToken
+	OpenParenToken
+	CloseParenToken
+	OpenCurlyToken
+	CloseCurlyToken
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case EOFToken:
+		return "EOF"
+	case IdentToken:
+		return "ident"
+	case FunctionToken:
+		return "function"
+	case AtKeywordToken:
+		return "at-keyword"
+	case HashToken:
+		return "hash"
+	case StringToken:
+		return "string"
+	case BadStringToken:
+		return "bad-string"
+	case URLToken:
+		return "url"
+	case BadURLToken:
+		return "bad-url"
+	case DelimToken:
+		return "delim"
+	case NumberToken:
+		return "number"
+	case PercentageToken:
+		return "percentage"
+	case DimensionToken:
+		return "dimension"
+	case WhitespaceToken:
+		return "whitespace"
+	case CDOToken:
+		return "CDO"
+	case CDCToken:
+		return "CDC"
+	case ColonToken:
+		return "colon"
+	case SemicolonToken:
+		return "semicolon"
+	case CommaToken:
+		return "comma"
+	case OpenSquareToken:
+		return "["
+	case CloseSquareToken:
+		return "]"
+	case OpenParenToken:
+		return "("
+	case CloseParenToken:
+		return ")"
+	case OpenCurlyToken:
+		return "{"
+	case CloseCurlyToken:
+		return "}"
+	}
+	return "unknown"
+}
+
+// A Token is a single token from a CSS source.
+type Token struct {
+	Type TokenType
+
+	// The (unescaped) value of ident, function, at-keyword, hash, string
+	// and url tokens, or the character of a delim token.
+	Value string
+
+	// Numeric value of number, percentage and dimension tokens. Repr
+	// is the representation from the source, so that "3.0" isn't
+	// serialized as "3".
+	Number  float64
+	Repr    string
+	Integer bool
+	Unit    string
+
+	// Set for hash tokens which are valid identifiers (and could
+	// therefore be used as an ID selector.)
+	ID bool
+
+	// The offset of the start of the token in the preprocessed source,
+	// in code points.
+	Offset int
+}
+
+func (t Token) isComponentValue() {}
+
+// String serializes the token back into CSS source.
+func (t Token) String() string {
+	switch t.Type {
+	case IdentToken:
+		return serializeIdent(t.Value)
+	case FunctionToken:
+		return serializeIdent(t.Value) + "("
+	case AtKeywordToken:
+		return "@" + serializeIdent(t.Value)
+	case HashToken:
+		if t.ID {
+			return "#" + serializeIdent(t.Value)
+		}
+		return "#" + serializeName(t.Value)
+	case StringToken:
+		return serializeString(t.Value)
+	case BadStringToken:
+		return `"`
+	case URLToken:
+		return "url(" + serializeURL(t.Value) + ")"
+	case BadURLToken:
+		return "url()"
+	case DelimToken:
+		if t.Value == `\` {
+			return "\\\n"
+		}
+		return t.Value
+	case NumberToken:
+		return t.Repr
+	case PercentageToken:
+		return t.Repr + "%"
+	case DimensionToken:
+		return t.Repr + serializeUnit(t.Unit)
+	case WhitespaceToken:
+		return " "
+	case CDOToken:
+		return "<!--"
+	case CDCToken:
+		return "-->"
+	case ColonToken:
+		return ":"
+	case SemicolonToken:
+		return ";"
+	case CommaToken:
+		return ","
+	case OpenSquareToken:
+		return "["
+	case CloseSquareToken:
+		return "]"
+	case OpenParenToken:
+		return "("
+	case CloseParenToken:
+		return ")"
+	case OpenCurlyToken:
+		return "{"
+	case CloseCurlyToken:
+		return "}"
+	}
+	return ""
+}
+
+// GoString is used by %#v (and therefore test failures) to make it obvious
+// what type of token something is.
+func (t Token) GoString() string {
+	return fmt.Sprintf("<%v %q>", t.Type, t.String())
+}
+
+// isDelim returns true if t is a delim token for the character d.
+func (t Token) isDelim(d string) bool {
+	return t.Type == DelimToken && t.Value == d
+}
+
+// isIdent returns true if t is an ident token with the value v, compared
+// ASCII case-insensitively.
+func (t Token) isIdent(v string) bool {
+	return t.Type == IdentToken && strings.EqualFold(t.Value, v)
+}
+
+const eof = -1
+
+// A tokenizer converts a CSS source into a stream of tokens. It implements
+// section 4.3 of the CSS Syntax Level 3 spec.
+type tokenizer struct {
+	src []rune
+	pos int
+}
+
+// preprocess implements the input preprocessing from section 3.3 of
+// CSS Syntax, which normalizes newlines and removes NULLs.
+func preprocess(s string) []rune {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\r", "\n", -1)
+	s = strings.Replace(s, "\f", "\n", -1)
+	s = strings.Replace(s, "\x00", "�", -1)
+	return []rune(s)
+}
+
+func newTokenizer(s string) *tokenizer {
+	return &tokenizer{src: preprocess(s)}
+}
+
+// Tokenize converts the CSS source s into a slice of tokens. Comments are
+// discarded and the EOF token is not included.
+func Tokenize(s string) []Token {
+	t := newTokenizer(s)
+	var toks []Token
+	for {
+		tok := t.Next()
+		if tok.Type == EOFToken {
+			return toks
+		}
+		toks = append(toks, tok)
+	}
+}
+
+func (t *tokenizer) peek(n int) rune {
+	if t.pos+n >= len(t.src) || t.pos+n < 0 {
+		return eof
+	}
+	return t.src[t.pos+n]
+}
+
+// consume returns the next code point. The position advances even at EOF
+// so that a following reconsume is always symmetric.
+func (t *tokenizer) consume() rune {
+	r := t.peek(0)
+	t.pos++
+	return r
+}
+
+func (t *tokenizer) reconsume() {
+	t.pos--
+}
+
+func isNameStart(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r >= 0x80 || r == '_'
+}
+
+func isName(r rune) bool {
+	return isNameStart(r) || isDigit(r) || r == '-'
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isHexDigit(r rune) bool {
+	return isDigit(r) || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+func isWhitespace(r rune) bool {
+	return r == '\n' || r == '\t' || r == ' '
+}
+
+func isNonPrintable(r rune) bool {
+	return (r >= 0 && r <= 8) || r == 0x0b || (r >= 0x0e && r <= 0x1f) || r == 0x7f
+}
+
+// Checks if the two code points are a valid escape (section 4.3.8)
+func validEscape(r1, r2 rune) bool {
+	return r1 == '\\' && r2 != '\n'
+}
+
+// Checks if the three code points would start an identifier (section 4.3.9)
+func startsIdent(r1, r2, r3 rune) bool {
+	switch {
+	case r1 == '-':
+		return isNameStart(r2) || r2 == '-' || validEscape(r2, r3)
+	case isNameStart(r1):
+		return true
+	case r1 == '\\':
+		return validEscape(r1, r2)
+	}
+	return false
+}
+
+// Checks if the three code points would start a number (section 4.3.10)
+func startsNumber(r1, r2, r3 rune) bool {
+	switch {
+	case r1 == '+' || r1 == '-':
+		if isDigit(r2) {
+			return true
+		}
+		return r2 == '.' && isDigit(r3)
+	case r1 == '.':
+		return isDigit(r2)
+	}
+	return isDigit(r1)
+}
+
+// Next returns the next token from the stream.
+func (t *tokenizer) Next() Token {
+	t.consumeComments()
+	start := t.pos
+	tok := t.consumeToken()
+	tok.Offset = start
+	return tok
+}
+
+// consumeToken consumes the token starting at the current position, which
+// isn't a comment.
+func (t *tokenizer) consumeToken() Token {
+	r := t.consume()
+	switch {
+	case r == eof:
+		return Token{Type: EOFToken}
+	case isWhitespace(r):
+		for isWhitespace(t.peek(0)) {
+			t.consume()
+		}
+		return Token{Type: WhitespaceToken}
+	case r == '"' || r == '\'':
+		return t.consumeString(r)
+	case r == '#':
+		if isName(t.peek(0)) || validEscape(t.peek(0), t.peek(1)) {
+			id := startsIdent(t.peek(0), t.peek(1), t.peek(2))
+			return Token{Type: HashToken, Value: t.consumeName(), ID: id}
+		}
+		return Token{Type: DelimToken, Value: "#"}
+	case r == '(':
+		return Token{Type: OpenParenToken}
+	case r == ')':
+		return Token{Type: CloseParenToken}
+	case r == '+':
+		if startsNumber(r, t.peek(0), t.peek(1)) {
+			t.reconsume()
+			return t.consumeNumeric()
+		}
+		return Token{Type: DelimToken, Value: "+"}
+	case r == ',':
+		return Token{Type: CommaToken}
+	case r == '-':
+		if startsNumber(r, t.peek(0), t.peek(1)) {
+			t.reconsume()
+			return t.consumeNumeric()
+		}
+		if t.peek(0) == '-' && t.peek(1) == '>' {
+			t.consume()
+			t.consume()
+			return Token{Type: CDCToken}
+		}
+		if startsIdent(r, t.peek(0), t.peek(1)) {
+			t.reconsume()
+			return t.consumeIdentLike()
+		}
+		return Token{Type: DelimToken, Value: "-"}
+	case r == '.':
+		if startsNumber(r, t.peek(0), t.peek(1)) {
+			t.reconsume()
+			return t.consumeNumeric()
+		}
+		return Token{Type: DelimToken, Value: "."}
+	case r == ':':
+		return Token{Type: ColonToken}
+	case r == ';':
+		return Token{Type: SemicolonToken}
+	case r == '<':
+		if t.peek(0) == '!' && t.peek(1) == '-' && t.peek(2) == '-' {
+			t.consume()
+			t.consume()
+			t.consume()
+			return Token{Type: CDOToken}
+		}
+		return Token{Type: DelimToken, Value: "<"}
+	case r == '@':
+		if startsIdent(t.peek(0), t.peek(1), t.peek(2)) {
+			return Token{Type: AtKeywordToken, Value: t.consumeName()}
+		}
+		return Token{Type: DelimToken, Value: "@"}
+	case r == '[':
+		return Token{Type: OpenSquareToken}
+	case r == '\\':
+		if validEscape(r, t.peek(0)) {
+			t.reconsume()
+			return t.consumeIdentLike()
+		}
+		// Parse error
+		return Token{Type: DelimToken, Value: `\`}
+	case r == ']':
+		return Token{Type: CloseSquareToken}
+	case r == '{':
+		return Token{Type: OpenCurlyToken}
+	case r == '}':
+		return Token{Type: CloseCurlyToken}
+	case isDigit(r):
+		t.reconsume()
+		return t.consumeNumeric()
+	case isNameStart(r):
+		t.reconsume()
+		return t.consumeIdentLike()
+	}
+	return Token{Type: DelimToken, Value: string(r)}
+}
+
+// Section 4.3.2
+func (t *tokenizer) consumeComments() {
+	for t.peek(0) == '/' && t.peek(1) == '*' {
+		t.pos += 2
+		for {
+			r := t.consume()
+			if r == eof {
+				// Parse error
+				return
+			}
+			if r == '*' && t.peek(0) == '/' {
+				t.consume()
+				break
+			}
+		}
+	}
+}
+
+// Section 4.3.3
+func (t *tokenizer) consumeNumeric() Token {
+	repr, num, integer := t.consumeNumber()
+	if startsIdent(t.peek(0), t.peek(1), t.peek(2)) {
+		return Token{
+			Type:    DimensionToken,
+			Number:  num,
+			Repr:    repr,
+			Integer: integer,
+			Unit:    t.consumeName(),
+		}
+	}
+	if t.peek(0) == '%' {
+		t.consume()
+		return Token{Type: PercentageToken, Number: num, Repr: repr}
+	}
+	return Token{Type: NumberToken, Number: num, Repr: repr, Integer: integer}
+}
+
+// Section 4.3.4
+func (t *tokenizer) consumeIdentLike() Token {
+	name := t.consumeName()
+	if strings.EqualFold(name, "url") && t.peek(0) == '(' {
+		t.consume()
+		for isWhitespace(t.peek(0)) && isWhitespace(t.peek(1)) {
+			t.consume()
+		}
+		if next := t.peek(0); next == '"' || next == '\'' {
+			return Token{Type: FunctionToken, Value: name}
+		} else if isWhitespace(next) {
+			if next2 := t.peek(1); next2 == '"' || next2 == '\'' {
+				return Token{Type: FunctionToken, Value: name}
+			}
+		}
+		return t.consumeURL()
+	}
+	if t.peek(0) == '(' {
+		t.consume()
+		return Token{Type: FunctionToken, Value: name}
+	}
+	return Token{Type: IdentToken, Value: name}
+}
+
+// Section 4.3.5
+func (t *tokenizer) consumeString(ending rune) Token {
+	var s strings.Builder
+	for {
+		r := t.consume()
+		switch {
+		case r == ending:
+			return Token{Type: StringToken, Value: s.String()}
+		case r == eof:
+			// Parse error
+			return Token{Type: StringToken, Value: s.String()}
+		case r == '\n':
+			// Parse error
+			t.reconsume()
+			return Token{Type: BadStringToken}
+		case r == '\\':
+			switch next := t.peek(0); next {
+			case eof:
+				continue
+			case '\n':
+				t.consume()
+			default:
+				s.WriteRune(t.consumeEscape())
+			}
+		default:
+			s.WriteRune(r)
+		}
+	}
+}
+
+// Section 4.3.6
+func (t *tokenizer) consumeURL() Token {
+	var s strings.Builder
+	for isWhitespace(t.peek(0)) {
+		t.consume()
+	}
+	for {
+		r := t.consume()
+		switch {
+		case r == ')':
+			return Token{Type: URLToken, Value: s.String()}
+		case r == eof:
+			// Parse error
+			return Token{Type: URLToken, Value: s.String()}
+		case isWhitespace(r):
+			for isWhitespace(t.peek(0)) {
+				t.consume()
+			}
+			if t.peek(0) == ')' || t.peek(0) == eof {
+				t.consume()
+				return Token{Type: URLToken, Value: s.String()}
+			}
+			t.consumeBadURL()
+			return Token{Type: BadURLToken}
+		case r == '"' || r == '\'' || r == '(' || isNonPrintable(r):
+			// Parse error
+			t.consumeBadURL()
+			return Token{Type: BadURLToken}
+		case r == '\\':
+			if validEscape(r, t.peek(0)) {
+				s.WriteRune(t.consumeEscape())
+			} else {
+				// Parse error
+				t.consumeBadURL()
+				return Token{Type: BadURLToken}
+			}
+		default:
+			s.WriteRune(r)
+		}
+	}
+}
+
+// Section 4.3.7. Assumes the \ has already been consumed.
+func (t *tokenizer) consumeEscape() rune {
+	r := t.consume()
+	switch {
+	case isHexDigit(r):
+		hex := string(r)
+		for i := 0; i < 5 && isHexDigit(t.peek(0)); i++ {
+			hex += string(t.consume())
+		}
+		if isWhitespace(t.peek(0)) {
+			t.consume()
+		}
+		val, _ := strconv.ParseUint(hex, 16, 32)
+		if val == 0 || (val >= 0xD800 && val <= 0xDFFF) || val > utf8.MaxRune {
+			return '�'
+		}
+		return rune(val)
+	case r == eof:
+		// Parse error
+		return '�'
+	}
+	return r
+}
+
+// Section 4.3.12
+func (t *tokenizer) consumeName() string {
+	var s strings.Builder
+	for {
+		r := t.consume()
+		switch {
+		case isName(r):
+			s.WriteRune(r)
+		case validEscape(r, t.peek(0)):
+			s.WriteRune(t.consumeEscape())
+		default:
+			t.reconsume()
+			return s.String()
+		}
+	}
+}
+
+// Section 4.3.13. Returns the representation, the value, and whether it was
+// an integer.
+func (t *tokenizer) consumeNumber() (string, float64, bool) {
+	start := t.pos
+	integer := true
+	if r := t.peek(0); r == '+' || r == '-' {
+		t.consume()
+	}
+	for isDigit(t.peek(0)) {
+		t.consume()
+	}
+	if t.peek(0) == '.' && isDigit(t.peek(1)) {
+		t.consume()
+		integer = false
+		for isDigit(t.peek(0)) {
+			t.consume()
+		}
+	}
+	if r := t.peek(0); r == 'e' || r == 'E' {
+		if isDigit(t.peek(1)) || ((t.peek(1) == '+' || t.peek(1) == '-') && isDigit(t.peek(2))) {
+			t.consume()
+			t.consume()
+			integer = false
+			for isDigit(t.peek(0)) {
+				t.consume()
+			}
+		}
+	}
+	repr := string(t.src[start:t.pos])
+	val, _ := strconv.ParseFloat(repr, 64)
+	return repr, val, integer
+}
+
+// Section 4.3.14
+func (t *tokenizer) consumeBadURL() {
+	for {
+		r := t.consume()
+		switch {
+		case r == ')' || r == eof:
+			return
+		case validEscape(r, t.peek(0)):
+			t.consumeEscape()
+		}
+	}
+}
+
+// Serialization helpers, based on the CSSOM spec.
+
+func escapeCodePoint(r rune) string {
+	return fmt.Sprintf("\\%x ", r)
+}
+
+func serializeName(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == 0:
+			b.WriteRune('�')
+		case (r >= 1 && r <= 0x1f) || r == 0x7f:
+			b.WriteString(escapeCodePoint(r))
+		case isName(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func serializeIdent(s string) string {
+	if s == "-" {
+		return `\-`
+	}
+	var b strings.Builder
+	for i, r := range s {
+		if isDigit(r) && (i == 0 || (i == 1 && s[0] == '-')) {
+			b.WriteString(escapeCodePoint(r))
+			continue
+		}
+		b.WriteString(serializeName(string(r)))
+	}
+	return b.String()
+}
+
+// Units need to be escaped if they could be confused with an exponent.
+func serializeUnit(s string) string {
+	if len(s) > 0 && (s[0] == 'e' || s[0] == 'E') {
+		if len(s) == 1 || isDigit(rune(s[1])) || s[1] == '-' || s[1] == '+' {
+			return escapeCodePoint(rune(s[0])) + serializeName(s[1:])
+		}
+	}
+	return serializeName(s)
+}
+
+func serializeString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == 0:
+			b.WriteRune('�')
+		case (r >= 1 && r <= 0x1f) || r == 0x7f:
+			b.WriteString(escapeCodePoint(r))
+		case r == '"' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func serializeURL(s string) string {
+	for _, r := range s {
+		if isWhitespace(r) || r == '"' || r == '\'' || r == '(' || r == ')' || r == '\\' || isNonPrintable(r) {
+			return serializeString(s)
+		}
+	}
+	return s
+}
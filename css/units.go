@@ -4,7 +4,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"image/color"
 	"math"
 	"strconv"
 	"strings"
@@ -31,10 +30,45 @@ func NewValue(val string) StyleValue {
 	return StyleValue{val, false}
 }
 
+// A UnitContext holds the values that relative length units are resolved
+// against.
+type UnitContext struct {
+	// The font size of the element, for em and ex, and of the root
+	// element, for rem. If RootFontSize is 0, DefaultFontSize is used.
+	FontSize, RootFontSize int
+
+	// The size of the viewport, for vw, vh, vmin and vmax.
+	ViewportWidth, ViewportHeight int
+
+	// ZeroWidth returns the advance width of "0" in the element's font,
+	// for ch, and LineHeight returns the element's line height, for lh.
+	// They're only called if the unit is used. If they're nil, 1ch is
+	// 0.5em and 1lh is 1.2em.
+	ZeroWidth, LineHeight func() float64
+}
+
+// ConvertUnitToPx converts the CSS length in cssString to px, using fontsize
+// for font-relative units and percentbasis for percentages. Root-relative
+// units use DefaultFontSize, and viewport-relative units are 0.
 func ConvertUnitToPx(fontsize int, percentbasis int, cssString string) (int, error) {
+	return ConvertLengthToPx(UnitContext{FontSize: fontsize}, percentbasis, cssString)
+}
+
+// ConvertLengthToPx converts the CSS length in cssString to px, resolving
+// relative units against u and percentages against percentbasis. If the
+// length is invalid, it returns the font size and an error.
+func ConvertLengthToPx(u UnitContext, percentbasis int, cssString string) (int, error) {
+	fontsize := u.FontSize
 	if cssString == "0" {
 		return 0, nil
 	}
+	if isMathFunction(cssString) {
+		px, err := evalMathFunction(u, percentbasis, cssString)
+		if err != nil {
+			return fontsize, err
+		}
+		return int(math.Round(px)), nil
+	}
 	if len(cssString) < 2 {
 		return fontsize, fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
 	}
@@ -50,66 +84,115 @@ func ConvertUnitToPx(fontsize int, percentbasis int, cssString string) (int, err
 		return fontsize, fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
 	}
 
-	// all other units are 2 characters long
-	switch unit := string(val[len(val)-2:]); unit {
+	// The unit is the letters at the end of the value.
+	i := len(val)
+	for i > 0 && (val[i-1] >= 'a' && val[i-1] <= 'z' || val[i-1] >= 'A' && val[i-1] <= 'Z') {
+		i--
+	}
+	unit := strings.ToLower(val[i:])
+	if _, ok := lengthToPx(u, 0, unit); !ok {
+		return fontsize, fmt.Errorf("Unimplemented CSS Unit or invalid value: %v", cssString)
+	}
+	f, err := strconv.ParseFloat(string(val[:i]), 64)
+	if err != nil {
+		return fontsize, fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
+	}
+	px, _ := lengthToPx(u, f, unit)
+	switch unit {
+	case "px", "pt":
+		return int(math.Round(px)), nil
+	default:
+		return int(px), nil
+	}
+}
+
+// lengthToPx converts the length f in unit to px, without rounding. It
+// returns false if unit isn't a supported length unit.
+func lengthToPx(u UnitContext, f float64, unit string) (float64, bool) {
+	switch unit {
 	case "em":
 		// 1em is basically a scaling factor for the parent font
 		// when calculating font size
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(f * float64(fontsize)), nil
-		}
-		return fontsize, fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
+		return f * float64(u.FontSize), true
 	case "ex":
 		// 1ex is supposed to be the height of a lower case x, but
 		// the spec says you can use 1ex = 0.5em if calculating
 		// the size of an x is impossible or impracticle. Since
 		// I'm too lazy to figure out how to do that, it's impracticle.
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(f * float64(fontsize) / 2.0), nil
+		return f * float64(u.FontSize) / 2.0, true
+	case "rem":
+		if u.RootFontSize == 0 {
+			return f * float64(DefaultFontSize), true
 		}
-		return fontsize, fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
-	case "px":
-		// parse px as a float then cast, just in case someone
-		// used a decimal.
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(math.Round(f * PixelsPerPt * 0.75)), nil
+		return f * float64(u.RootFontSize), true
+	case "ch":
+		if u.ZeroWidth == nil {
+			return f * float64(u.FontSize) / 2.0, true
 		}
-		return fontsize, fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
-	case "in":
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(f * PixelsPerPt * 72), nil
+		return f * u.ZeroWidth(), true
+	case "lh":
+		if u.LineHeight == nil {
+			return f * float64(u.FontSize) * 1.2, true
 		}
-		return int(fontsize), fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
-	case "cm":
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(f * PixelsPerPt * 72.0 / 2.54), nil
+		return f * u.LineHeight(), true
+	case "vw":
+		return f * float64(u.ViewportWidth) / 100, true
+	case "vh":
+		return f * float64(u.ViewportHeight) / 100, true
+	case "vmin":
+		if u.ViewportWidth < u.ViewportHeight {
+			return f * float64(u.ViewportWidth) / 100, true
 		}
-		return int(fontsize), fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
-	case "mm":
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(f * PixelsPerPt * 72.0 / 25.4), nil
+		return f * float64(u.ViewportHeight) / 100, true
+	case "vmax":
+		if u.ViewportWidth > u.ViewportHeight {
+			return f * float64(u.ViewportWidth) / 100, true
 		}
-		return int(fontsize), fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
+		return f * float64(u.ViewportHeight) / 100, true
+	case "px":
+		return f * PixelsPerPt * 0.75, true
+	case "in":
+		return f * PixelsPerPt * 72, true
+	case "cm":
+		return f * PixelsPerPt * 72.0 / 2.54, true
+	case "mm":
+		return f * PixelsPerPt * 72.0 / 25.4, true
 	case "pt":
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(math.Round(f * PixelsPerPt)), nil
-		}
-		return int(fontsize), fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
+		return f * PixelsPerPt, true
 	case "pc":
-		f, err := strconv.ParseFloat(string(val[0:len(val)-2]), 64)
-		if err == nil {
-			return int(f * PixelsPerPt * 12), nil
+		return f * PixelsPerPt * 12, true
+	}
+	return 0, false
+}
+
+// HasViewportUnits reports whether the value val has any lengths relative to
+// the size of the viewport.
+func HasViewportUnits(val string) bool {
+	return hasViewportUnits(ParseComponentValues(val))
+}
+
+func hasViewportUnits(vals []ComponentValue) bool {
+	for _, v := range vals {
+		switch v := v.(type) {
+		case Token:
+			if v.Type != DimensionToken {
+				continue
+			}
+			switch strings.ToLower(v.Unit) {
+			case "vw", "vh", "vmin", "vmax":
+				return true
+			}
+		case *Function:
+			if hasViewportUnits(v.Value) {
+				return true
+			}
+		case *SimpleBlock:
+			if hasViewportUnits(v.Value) {
+				return true
+			}
 		}
-		return int(fontsize), fmt.Errorf("Invalid CSS Unit or value: %v", cssString)
 	}
-	return fontsize, fmt.Errorf("Unimplemented CSS Unit or invalid value: %v", cssString)
+	return false
 }
 
 func hexToUint8(val string) uint8 {
@@ -161,105 +244,6 @@ func sHexToUint8(val byte) uint8 {
 	panic("Invalid character")
 }
 
-func ConvertColorToRGBA(cssString string) (*color.RGBA, error) {
-	black := &color.RGBA{0, 0, 0, 255}
-	if len(cssString) > 3 && cssString[0:3] == "rgb" {
-		tuple := cssString[4 : len(cssString)-1]
-		pieces := strings.Split(tuple, ",")
-		if len(pieces) != 3 {
-			return black, fmt.Errorf("Invalid colour: %v", cssString)
-		}
-
-		rint, _ := strconv.Atoi(strings.TrimSpace(pieces[0]))
-		gint, _ := strconv.Atoi(strings.TrimSpace(pieces[1]))
-		bint, _ := strconv.Atoi(strings.TrimSpace(pieces[2]))
-		return &color.RGBA{uint8(rint), uint8(gint), uint8(bint), 255}, nil
-
-	} else if len(cssString) > 1 && cssString[0] == '#' {
-		switch len(cssString) {
-		case 7:
-			// #RRGGBB
-			return &color.RGBA{hexToUint8(cssString[1:3]), hexToUint8(cssString[3:5]), hexToUint8(cssString[5:]), 255}, nil
-		case 4:
-			// #RGB
-			return &color.RGBA{sHexToUint8(cssString[1]), sHexToUint8(cssString[2]), sHexToUint8(cssString[3]), 255}, nil
-		}
-		return black, fmt.Errorf("Invalid colour: %v", cssString)
-	}
-	switch cssString {
-	case "inherit":
-		return black, InheritValue
-	case "transparent":
-		return &color.RGBA{0x80, 0, 0, 0}, nil
-	case "maroon":
-		return &color.RGBA{0x80, 0, 0, 255}, nil
-	case "red":
-		return &color.RGBA{0xff, 0, 0, 255}, nil
-	case "orange":
-		return &color.RGBA{0xff, 0xa5, 0, 255}, nil
-	case "yellow":
-		return &color.RGBA{0xff, 0xff, 0, 255}, nil
-	case "olive":
-		return &color.RGBA{0x80, 0x80, 0, 255}, nil
-	case "purple":
-		return &color.RGBA{0x80, 0, 0x80, 255}, nil
-	case "fuchsia":
-		return &color.RGBA{0xff, 0, 0xff, 255}, nil
-	case "white":
-		return &color.RGBA{0xff, 0xff, 0xff, 255}, nil
-	case "lime":
-		return &color.RGBA{0, 0xff, 0, 255}, nil
-	case "green":
-		return &color.RGBA{0, 0x80, 0, 255}, nil
-	case "navy":
-		return &color.RGBA{0, 0, 0x80, 255}, nil
-	case "blue":
-		return &color.RGBA{0, 0, 0xff, 255}, nil
-	case "aqua":
-		return &color.RGBA{0, 0xff, 0xff, 255}, nil
-	case "teal":
-		return &color.RGBA{0, 0x80, 0x80, 255}, nil
-	case "black":
-		return &color.RGBA{0, 0, 0, 255}, nil
-	case "silver":
-		return &color.RGBA{0xc0, 0xc0, 0xc0, 255}, nil
-	case "gray", "grey":
-		return &color.RGBA{0x80, 0x80, 0x80, 255}, nil
-	}
-	return black, NoStyles
-}
-
-func IsColor(c string) bool {
-	c = strings.TrimSpace(c)
-	switch c {
-	case "inherit", "transparent", "maroon", "red", "orange", "yellow", "olive", "purple",
-		"fuchsia", "white", "lime", "green", "navy", "blue", "aqua", "teal",
-		"black", "silver", "gray", "grey":
-		return true
-	}
-	switch length := len(c); length {
-	case 0:
-		return false
-	case 4, 7:
-		if c[0] == '#' {
-			for _, letter := range c[1:] {
-				if (letter >= '0' && letter <= '9') ||
-					(letter >= 'a' && letter <= 'f') ||
-					(letter >= 'A' && letter <= 'F') {
-					continue
-				}
-			}
-			return true
-		}
-		return false
-	default:
-		if length > 4 && c[0:4] == "rgb(" {
-			return true
-		}
-		return false
-	}
-}
-
 func IsURL(u string) bool {
 	u = strings.TrimSpace(u)
 	if len(u) <= 4 {
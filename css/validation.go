@@ -0,0 +1,325 @@
+package css
+
+import (
+	"strings"
+)
+
+// A grammar reports whether the component values of a declaration, without
+// leading or trailing whitespace, are valid for a property.
+type grammar func(vals []ComponentValue) bool
+
+// grammars are the value grammars of the longhand properties in Properties
+// that are checked when parsing. Properties which aren't in the table accept
+// any value, and are left for the code that uses them to interpret.
+var grammars = map[StyleAttribute]grammar{
+	"font-family":  validFontFamily,
+	"font-style":   keywords("normal", "italic", "oblique"),
+	"font-variant": keywords("normal", "small-caps"),
+	"font-weight":  validFontWeight,
+	"font-stretch": single(isFontStretch),
+	"font-size":    single(isFontSize),
+
+	"color":                 validColor,
+	"background-color":      validColor,
+	"background-image":      imageOrNone,
+	"background-repeat":     validBackgroundRepeat,
+	"background-attachment": keywords("scroll", "fixed", "local"),
+	"background-position":   validPosition,
+
+	"word-spacing":   lengthOr("normal"),
+	"letter-spacing": lengthOr("normal"),
+	"vertical-align": lengthPercentageOr("baseline", "sub", "super", "text-top", "text-bottom", "middle", "top", "bottom"),
+	"text-transform": keywords("none", "capitalize", "uppercase", "lowercase", "full-width"),
+	"text-align":     keywords("left", "right", "center", "justify", "start", "end", "match-parent"),
+	"text-indent":    lengthPercentageOr(),
+	"line-height":    validLineHeight,
+
+	"margin-top":    lengthPercentageOr("auto"),
+	"margin-right":  lengthPercentageOr("auto"),
+	"margin-bottom": lengthPercentageOr("auto"),
+	"margin-left":   lengthPercentageOr("auto"),
+
+	"padding-top":    nonNegative(lengthPercentageOr()),
+	"padding-right":  nonNegative(lengthPercentageOr()),
+	"padding-bottom": nonNegative(lengthPercentageOr()),
+	"padding-left":   nonNegative(lengthPercentageOr()),
+
+	"border-top-width":    validLineWidth,
+	"border-top-color":    validColor,
+	"border-top-style":    single(isBorderStyle),
+	"border-right-width":  validLineWidth,
+	"border-right-color":  validColor,
+	"border-right-style":  single(isBorderStyle),
+	"border-bottom-width": validLineWidth,
+	"border-bottom-color": validColor,
+	"border-bottom-style": single(isBorderStyle),
+	"border-left-width":   validLineWidth,
+	"border-left-color":   validColor,
+	"border-left-style":   single(isBorderStyle),
+
+	"width":      validSize("auto"),
+	"height":     validSize("auto"),
+	"min-width":  validSize("auto"),
+	"min-height": validSize("auto"),
+	"max-width":  validSize("none"),
+	"max-height": validSize("none"),
+
+	"float": keywords("none", "left", "right", "inline-start", "inline-end"),
+	"clear": keywords("none", "left", "right", "both", "inline-start", "inline-end"),
+	"display": keywords(
+		"none", "contents", "block", "inline", "inline-block", "flow",
+		"flow-root", "list-item", "run-in", "flex", "inline-flex",
+		"grid", "inline-grid", "ruby", "table", "inline-table",
+		"table-row-group", "table-header-group", "table-footer-group",
+		"table-row", "table-cell", "table-column-group", "table-column",
+		"table-caption",
+	),
+	"white-space": keywords("normal", "pre", "nowrap", "pre-wrap", "pre-line", "break-spaces"),
+	"overflow-x":  keywords("visible", "hidden", "clip", "scroll", "auto"),
+	"overflow-y":  keywords("visible", "hidden", "clip", "scroll", "auto"),
+
+	"top":    lengthPercentageOr("auto"),
+	"right":  lengthPercentageOr("auto"),
+	"bottom": lengthPercentageOr("auto"),
+	"left":   lengthPercentageOr("auto"),
+
+	"outline-width": validLineWidth,
+	"outline-style": single(isOutlineStyle),
+	"outline-color": func(vals []ComponentValue) bool {
+		return keywords("invert")(vals) || validColor(vals)
+	},
+
+	"flex-grow":   nonNegative(single(isNumber)),
+	"flex-shrink": nonNegative(single(isNumber)),
+	"flex-basis":  validSize("auto", "content"),
+
+	"grid-row-start":    isGridLine,
+	"grid-column-start": isGridLine,
+	"grid-row-end":      isGridLine,
+	"grid-column-end":   isGridLine,
+
+	"list-style-image":    imageOrNone,
+	"list-style-position": keywords("inside", "outside"),
+}
+
+// validDeclaration reports whether the declaration d is valid. Invalid
+// declarations are reported to report as errors, and properties which gob
+// doesn't know about as warnings.
+//
+// Custom properties, the CSS-wide keywords and values that reference custom
+// properties are always valid, since they can't be checked until the
+// cascade.
+func validDeclaration(d Declaration, report *reporter) bool {
+	name := StyleAttribute(strings.ToLower(d.Name))
+	if isCustomProperty(StyleAttribute(d.Name)) {
+		return true
+	}
+	if _, ok := Properties[name]; !ok {
+		if _, ok := shorthands[name]; !ok {
+			report.warnf(d.Offset, "Unsupported property %v", name)
+		}
+	}
+	if len(d.Value) == 0 {
+		report.errorf(d.Offset, "Missing value for %v", name)
+		return false
+	}
+	if containsVar(d.Value) || (len(d.Value) == 1 && cssWideKeyword(d.Value[0].String()) != "") {
+		return true
+	}
+	if validValue(name, d.Value) {
+		return true
+	}
+	report.errorf(d.Offset, "Invalid value for %v: %v", name, SerializeComponentValues(d.Value))
+	return false
+}
+
+// validValue reports whether vals is a valid value for the property name.
+// Shorthands are valid if they can be expanded and every longhand they
+// set is valid.
+func validValue(name StyleAttribute, vals []ComponentValue) bool {
+	if sh, ok := shorthands[name]; ok {
+		values, ok := sh.expand(vals)
+		if !ok {
+			return false
+		}
+		for longhand, val := range values {
+			if !validValue(longhand, trimWhitespace(ParseComponentValues(val))) {
+				return false
+			}
+		}
+		return true
+	}
+	if g, ok := grammars[name]; ok {
+		return g(vals)
+	}
+	return true
+}
+
+// single returns a grammar that accepts a single component value that
+// matches valid.
+func single(valid func(v ComponentValue) bool) grammar {
+	return func(vals []ComponentValue) bool {
+		return len(vals) == 1 && valid(vals[0])
+	}
+}
+
+// keywords returns a grammar that accepts any one of the identifiers in
+// valid.
+func keywords(valid ...string) grammar {
+	return single(func(v ComponentValue) bool {
+		id := identValue(v)
+		for _, k := range valid {
+			if id == k {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// lengthOr returns a grammar that accepts a length or any of the
+// identifiers in valid.
+func lengthOr(valid ...string) grammar {
+	return func(vals []ComponentValue) bool {
+		return single(isLengthValue)(vals) || keywords(valid...)(vals)
+	}
+}
+
+// lengthPercentageOr returns a grammar that accepts a length, a percentage
+// or any of the identifiers in valid.
+func lengthPercentageOr(valid ...string) grammar {
+	return func(vals []ComponentValue) bool {
+		return single(isLengthPercentage)(vals) || keywords(valid...)(vals)
+	}
+}
+
+// validSize returns a grammar for a sizing property such as width, which
+// accepts a length, a percentage, the intrinsic sizing keywords and any of
+// the identifiers in valid.
+func validSize(valid ...string) grammar {
+	return nonNegative(lengthPercentageOr(append(valid, "min-content", "max-content", "fit-content")...))
+}
+
+// nonNegative returns a grammar that accepts the values accepted by g as
+// long as they don't contain a negative number.
+func nonNegative(g grammar) grammar {
+	return func(vals []ComponentValue) bool {
+		for _, v := range vals {
+			if t, ok := v.(Token); ok && t.Number < 0 {
+				switch t.Type {
+				case NumberToken, PercentageToken, DimensionToken:
+					return false
+				}
+			}
+		}
+		return g(vals)
+	}
+}
+
+// isLengthPercentage reports whether v is a length or a percentage.
+func isLengthPercentage(v ComponentValue) bool {
+	return isLengthValue(v) || tokenType(v) == PercentageToken
+}
+
+// isNumber reports whether v is a number, including math functions.
+func isNumber(v ComponentValue) bool {
+	if f, ok := v.(*Function); ok {
+		return isMathFunction(f.String())
+	}
+	return tokenType(v) == NumberToken
+}
+
+// isBorderStyle reports whether v is a border style keyword.
+func isBorderStyle(v ComponentValue) bool {
+	return IsBorderStyle(identValue(v))
+}
+
+// isOutlineStyle reports whether v is an outline style keyword, which are
+// the border styles except for hidden, and auto.
+func isOutlineStyle(v ComponentValue) bool {
+	switch id := identValue(v); id {
+	case "auto":
+		return true
+	case "hidden":
+		return false
+	default:
+		return IsBorderStyle(id)
+	}
+}
+
+func validColor(vals []ComponentValue) bool {
+	return len(vals) == 1 && IsColor(vals[0].String())
+}
+
+func validLineWidth(vals []ComponentValue) bool {
+	return nonNegative(lengthOr("thin", "medium", "thick"))(vals)
+}
+
+func validLineHeight(vals []ComponentValue) bool {
+	return nonNegative(lengthPercentageOr("normal"))(vals) || nonNegative(single(isNumber))(vals)
+}
+
+func imageOrNone(vals []ComponentValue) bool {
+	return keywords("none")(vals) || single(isURL)(vals)
+}
+
+// isURL reports whether v is a url, either as a url token or a url()
+// function with a string.
+func isURL(v ComponentValue) bool {
+	return tokenType(v) == URLToken || isURLFunction(v)
+}
+
+// validFontFamily reports whether vals is a comma separated list of font
+// families, each of which is either a string or a sequence of
+// identifiers.
+func validFontFamily(vals []ComponentValue) bool {
+	return fontFamilies(vals) != nil
+}
+
+func validFontWeight(vals []ComponentValue) bool {
+	if keywords("normal", "bold", "bolder", "lighter")(vals) {
+		return true
+	}
+	if len(vals) != 1 {
+		return false
+	}
+	if t, ok := vals[0].(Token); ok && t.Type == NumberToken {
+		return t.Number >= 1 && t.Number <= 1000
+	}
+	return isNumber(vals[0])
+}
+
+func validBackgroundRepeat(vals []ComponentValue) bool {
+	vals = nonWhitespace(vals)
+	if keywords("repeat-x", "repeat-y")(vals) {
+		return true
+	}
+	if len(vals) < 1 || len(vals) > 2 {
+		return false
+	}
+	for _, v := range vals {
+		if !keywords("repeat", "space", "round", "no-repeat")([]ComponentValue{v}) {
+			return false
+		}
+	}
+	return true
+}
+
+// validPosition reports whether vals is a background position of up to
+// four keywords, lengths and percentages.
+func validPosition(vals []ComponentValue) bool {
+	vals = nonWhitespace(vals)
+	if len(vals) < 1 || len(vals) > 4 {
+		return false
+	}
+	for _, v := range vals {
+		switch identValue(v) {
+		case "left", "right", "top", "bottom", "center":
+			continue
+		}
+		if !isLengthPercentage(v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package css
+
+import (
+	"strings"
+)
+
+// CustomProperties are the computed values of an element's custom properties
+// (properties whose names start with "--"), by name. Custom properties are
+// always inherited.
+type CustomProperties map[string]string
+
+// isCustomProperty reports whether name is the name of a custom property.
+func isCustomProperty(name StyleAttribute) bool {
+	return strings.HasPrefix(string(name), "--")
+}
+
+// hasVarReference reports whether val references a custom property with
+// var().
+func hasVarReference(val string) bool {
+	if !strings.Contains(strings.ToLower(val), "var(") {
+		return false
+	}
+	return containsVar(ParseComponentValues(val))
+}
+
+// containsVar reports whether there's a var() function anywhere in vals.
+func containsVar(vals []ComponentValue) bool {
+	for _, v := range vals {
+		switch v := v.(type) {
+		case *Function:
+			if strings.EqualFold(v.Name, "var") || containsVar(v.Value) {
+				return true
+			}
+		case *SimpleBlock:
+			if containsVar(v.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseVar parses the arguments of a var() function into the name of the
+// custom property and the fallback value. ok is false if the arguments are
+// invalid.
+func parseVar(f *Function) (name string, fallback []ComponentValue, hasFallback, ok bool) {
+	args := trimWhitespace(f.Value)
+	if len(args) == 0 {
+		return "", nil, false, false
+	}
+	t, isToken := args[0].(Token)
+	if !isToken || t.Type != IdentToken || !isCustomProperty(StyleAttribute(t.Value)) {
+		return "", nil, false, false
+	}
+	rest := trimWhitespace(args[1:])
+	if len(rest) == 0 {
+		return t.Value, nil, false, true
+	}
+	if tokenType(rest[0]) != CommaToken {
+		return "", nil, false, false
+	}
+	return t.Value, trimWhitespace(rest[1:]), true, true
+}
+
+// substituteVars replaces every var() function in vals with the value of the
+// custom property that it references, or its fallback if the property isn't
+// valid. lookup returns the value of a custom property, and whether it has a
+// valid value. It returns false if any reference can't be substituted.
+func substituteVars(vals []ComponentValue, lookup func(name string) ([]ComponentValue, bool)) ([]ComponentValue, bool) {
+	ret := make([]ComponentValue, 0, len(vals))
+	for _, v := range vals {
+		switch v := v.(type) {
+		case *Function:
+			if !strings.EqualFold(v.Name, "var") {
+				args, ok := substituteVars(v.Value, lookup)
+				if !ok {
+					return nil, false
+				}
+				ret = append(ret, &Function{Name: v.Name, Value: args})
+				continue
+			}
+			name, fallback, hasFallback, ok := parseVar(v)
+			if !ok {
+				return nil, false
+			}
+			if val, ok := lookup(name); ok {
+				ret = append(ret, val...)
+				continue
+			}
+			if !hasFallback {
+				return nil, false
+			}
+			val, ok := substituteVars(fallback, lookup)
+			if !ok {
+				return nil, false
+			}
+			ret = append(ret, val...)
+		case *SimpleBlock:
+			inner, ok := substituteVars(v.Value, lookup)
+			if !ok {
+				return nil, false
+			}
+			ret = append(ret, &SimpleBlock{Associated: v.Associated, Value: inner})
+		default:
+			ret = append(ret, v)
+		}
+	}
+	return ret, true
+}
+
+// InheritCustomProperties sets the custom properties that e inherits from its
+// parent. It must be called before the styles are sorted.
+func (e *StyledElement) InheritCustomProperties(parent CustomProperties) {
+	e.inheritedProperties = parent
+}
+
+// CustomProperties returns the computed values of the custom properties of e,
+// including the ones that it inherited.
+func (e *StyledElement) CustomProperties() CustomProperties {
+	if e.customProperties == nil {
+		return e.inheritedProperties
+	}
+	return e.customProperties
+}
+
+// CustomProperty returns the computed value of the custom property name,
+// and whether it has a valid value.
+func (e *StyledElement) CustomProperty(name string) (string, bool) {
+	v, ok := e.CustomProperties()[name]
+	return v, ok
+}
+
+// computeCustomProperties works out the values of the custom properties of e
+// from the sorted rules and the inherited custom properties, resolving any
+// var() references between them. Properties which are part of a reference
+// cycle are invalid, as are references to properties that don't exist
+// without a fallback.
+func (e *StyledElement) computeCustomProperties() {
+	specified := make(map[string]string)
+	for i := len(e.rules) - 1; i >= 0; i-- {
+		if rule := e.rules[i]; isCustomProperty(rule.Name) {
+			specified[string(rule.Name)] = rule.Value.Value
+		}
+	}
+	if len(specified) == 0 {
+		e.customProperties = nil
+		return
+	}
+
+	computed := make(CustomProperties, len(e.inheritedProperties)+len(specified))
+	for name, val := range e.inheritedProperties {
+		computed[name] = val
+	}
+
+	const (
+		unresolved = iota
+		resolving
+		resolved
+	)
+	state := make(map[string]int)
+	cyclic := make(map[string]bool)
+	var stack []string
+
+	var resolve func(name string) ([]ComponentValue, bool)
+	resolve = func(name string) ([]ComponentValue, bool) {
+		raw, ok := specified[name]
+		if !ok || state[name] == resolved {
+			val, ok := computed[name]
+			return ParseComponentValues(val), ok
+		}
+		if state[name] == resolving {
+			// Everything on the stack since the last time name was
+			// seen is part of the cycle.
+			for i := len(stack) - 1; i >= 0; i-- {
+				cyclic[stack[i]] = true
+				if stack[i] == name {
+					break
+				}
+			}
+			return nil, false
+		}
+
+		state[name] = resolving
+		stack = append(stack, name)
+		var vals []ComponentValue
+		switch strings.ToLower(strings.TrimSpace(raw)) {
+		case "initial":
+			// The initial value of a custom property is the
+			// guaranteed-invalid value.
+			ok = false
+		case "inherit", "unset":
+			var val string
+			val, ok = e.inheritedProperties[name]
+			vals = ParseComponentValues(val)
+		default:
+			vals, ok = substituteVars(ParseComponentValues(raw), resolve)
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = resolved
+
+		if ok && !cyclic[name] {
+			computed[name] = SerializeComponentValues(vals)
+		} else {
+			delete(computed, name)
+		}
+		return vals, ok && !cyclic[name]
+	}
+	for name := range specified {
+		resolve(name)
+	}
+	e.customProperties = computed
+}
+
+// substituteRules returns the sorted rules of e with var() references
+// substituted and any shorthands that contained them expanded. If a
+// reference can't be substituted, the declaration is invalid at computed
+// value time, and the property (or every longhand of a shorthand) is unset.
+func (e *StyledElement) substituteRules() []StyleRule {
+	ret := make([]StyleRule, 0, len(e.rules))
+	lookup := func(name string) ([]ComponentValue, bool) {
+		val, ok := e.CustomProperty(name)
+		return ParseComponentValues(val), ok
+	}
+	for _, rule := range e.rules {
+		if isCustomProperty(rule.Name) || !hasVarReference(rule.Value.Value) {
+			ret = append(ret, rule)
+			continue
+		}
+		var expanded StyledElement
+		if vals, ok := substituteVars(ParseComponentValues(rule.Value.Value), lookup); ok {
+			sub := rule
+			sub.Value.Value = SerializeComponentValues(vals)
+			expanded.AddStyle(sub)
+		}
+		if len(expanded.rules) == 0 {
+			// An empty value behaves like unset, since it inherits
+			// inherited properties and uses the initial value for
+			// the rest.
+			for _, name := range longhands(rule.Name) {
+				unset := rule
+				unset.Name, unset.Value.Value = name, ""
+				expanded.rules = append(expanded.rules, unset)
+			}
+		}
+		ret = append(ret, expanded.rules...)
+	}
+	return ret
+}
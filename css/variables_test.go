@@ -0,0 +1,93 @@
+package css
+
+import (
+	"reflect"
+	"testing"
+)
+
+// styledElement returns a StyledElement with the declarations in block
+// applied to it, in order, inheriting the custom properties parent.
+func styledElement(block string, parent CustomProperties) *StyledElement {
+	e := new(StyledElement)
+	e.InheritCustomProperties(parent)
+	for i, decl := range ParseDeclarations(block) {
+		e.AddStyle(StyleRule{
+			Selector: CSSSelector{"div", uint(i)},
+			Name:     StyleAttribute(decl.Name),
+			Value:    declarationValue(decl),
+			Src:      AuthorSrc,
+		})
+	}
+	e.SortStyles()
+	return e
+}
+
+func TestCustomProperties(t *testing.T) {
+	tests := []struct {
+		Block    string
+		Parent   CustomProperties
+		Expected CustomProperties
+	}{
+		{"--main-color: red; --Main-Color: blue", nil, CustomProperties{"--main-color": "red", "--Main-Color": "blue"}},
+		{"--a: 1px; --a: 2px", nil, CustomProperties{"--a": "2px"}},
+		{"--a: var(--b) var(--c); --b: 1px; --c: calc(var(--b) * 2)", nil, CustomProperties{"--a": "1px calc(1px * 2)", "--b": "1px", "--c": "calc(1px * 2)"}},
+		{"--b: 3px", CustomProperties{"--a": "1px"}, CustomProperties{"--a": "1px", "--b": "3px"}},
+		{"--b: var(--a)", CustomProperties{"--a": "1px"}, CustomProperties{"--a": "1px", "--b": "1px"}},
+		// Missing references without a fallback make the property
+		// invalid.
+		{"--a: var(--missing); --b: var(--missing, 4px)", nil, CustomProperties{"--b": "4px"}},
+		// Every property in a cycle is invalid, even with a
+		// fallback, but properties which reference the cycle can
+		// use their fallback.
+		{"--a: var(--b, 1px); --b: var(--a); --c: var(--a, 2px); --d: var(--d)", nil, CustomProperties{"--c": "2px"}},
+		{"--a: initial; --b: inherit", CustomProperties{"--a": "1px", "--b": "2px"}, CustomProperties{"--b": "2px"}},
+	}
+	for _, tc := range tests {
+		got := styledElement(tc.Block, tc.Parent).CustomProperties()
+		if !reflect.DeepEqual(got, tc.Expected) {
+			t.Errorf("Unexpected custom properties for %q: got %v want %v", tc.Block, got, tc.Expected)
+		}
+	}
+}
+
+func TestVarSubstitution(t *testing.T) {
+	parent := CustomProperties{"--inherited": "blue", "--space": "4px"}
+	tests := []struct {
+		Block    string
+		Property StyleAttribute
+		Expected string
+	}{
+		{"--c: red; color: var(--c)", "color", "red"},
+		{"color: var(--inherited)", "color", "blue"},
+		{"color: var(--missing, green)", "color", "green"},
+		{"color: var(--missing, var(--inherited))", "color", "blue"},
+		{"width: calc(var(--space) * 2)", "width", "calc(4px * 2)"},
+		// Invalid at computed value time, so the property is unset
+		// even though an earlier declaration was valid.
+		{"color: red; color: var(--missing)", "color", ""},
+		{"color: var(not-custom, red)", "color", ""},
+		// Shorthands are expanded after substitution.
+		{"--m: 1px 2px; margin: var(--m)", "margin-left", "2px"},
+		{"--m: 1px 2px; margin: var(--m)", "margin-bottom", "1px"},
+		{"margin-top: 3px; margin: var(--missing)", "margin-top", ""},
+		{"--ls: square inside; list-style: var(--ls)", "list-style-position", "inside"},
+		{"--b: 2px solid; border: var(--b)", "border-left-style", "solid"},
+		// Longhands specified after a shorthand still override it.
+		{"margin: var(--space); margin-left: 1px", "margin-left", "1px"},
+		{"margin: var(--space); margin-left: 1px", "margin-top", "4px"},
+	}
+	for _, tc := range tests {
+		e := styledElement(tc.Block, parent)
+		if got := e.GetAttribute(string(tc.Property)).Value; got != tc.Expected {
+			t.Errorf("Unexpected %s for %q: got %q want %q", tc.Property, tc.Block, got, tc.Expected)
+		}
+	}
+
+	e := styledElement("--d: none; display: var(--d)", nil)
+	if got := e.DisplayProp(); got != "none" {
+		t.Errorf("Unexpected display: got %q want %q", got, "none")
+	}
+	if got := e.Display.Value; got != "none" {
+		t.Errorf("Unexpected Display value: got %q want %q", got, "none")
+	}
+}
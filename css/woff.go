@@ -0,0 +1,684 @@
+package css
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/andybalholm/brotli"
+)
+
+// maxFontSize is the largest decompressed font that will be decoded, so
+// that a malicious font can't use up all of the memory.
+const maxFontSize = 64 << 20
+
+var errInvalidFont = errors.New("Invalid font data")
+
+// decodeFont converts the font data in b into an SFNT (TrueType or OpenType)
+// font, decompressing it if it's a WOFF or WOFF2 font.
+func decodeFont(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errInvalidFont
+	}
+	switch string(b[:4]) {
+	case "wOFF":
+		return decodeWOFF(b)
+	case "wOF2":
+		return decodeWOFF2(b)
+	case "\x00\x01\x00\x00", "OTTO", "true":
+		return b, nil
+	}
+	return nil, fmt.Errorf("Unsupported font format %q", b[:4])
+}
+
+// An sfntTable is a table of an SFNT font.
+type sfntTable struct {
+	tag  uint32
+	data []byte
+}
+
+// buildSFNT builds an SFNT font with the flavor (the sfnt version) and
+// tables, sorted by tag and with their checksums calculated.
+func buildSFNT(flavor uint32, tables []sfntTable) []byte {
+	sort.Slice(tables, func(i, j int) bool { return tables[i].tag < tables[j].tag })
+	n := len(tables)
+	entrySelector := 0
+	for 1<<uint(entrySelector+1) <= n {
+		entrySelector++
+	}
+	searchRange := 16 << uint(entrySelector)
+
+	size := 12 + 16*n
+	for _, t := range tables {
+		size += (len(t.data) + 3) &^ 3
+	}
+	out := make([]byte, size)
+	binary.BigEndian.PutUint32(out[0:], flavor)
+	binary.BigEndian.PutUint16(out[4:], uint16(n))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(16*n-searchRange))
+
+	offset := 12 + 16*n
+	headOffset := -1
+	for i, t := range tables {
+		if t.tag == tagHead && len(t.data) >= 12 {
+			headOffset = offset
+		}
+		copy(out[offset:], t.data)
+		if t.tag == tagHead && len(t.data) >= 12 {
+			// The checksum adjustment is calculated after
+			// the rest of the font.
+			binary.BigEndian.PutUint32(out[offset+8:], 0)
+		}
+		length := (len(t.data) + 3) &^ 3
+		rec := out[12+16*i:]
+		binary.BigEndian.PutUint32(rec[0:], t.tag)
+		binary.BigEndian.PutUint32(rec[4:], sfntChecksum(out[offset:offset+length]))
+		binary.BigEndian.PutUint32(rec[8:], uint32(offset))
+		binary.BigEndian.PutUint32(rec[12:], uint32(len(t.data)))
+		offset += length
+	}
+	if headOffset >= 0 {
+		binary.BigEndian.PutUint32(out[headOffset+8:], 0xB1B0AFBA-sfntChecksum(out))
+	}
+	return out
+}
+
+// sfntChecksum returns the checksum of b, which must be padded to a
+// multiple of 4 bytes.
+func sfntChecksum(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i+4 <= len(b); i += 4 {
+		sum += binary.BigEndian.Uint32(b[i:])
+	}
+	return sum
+}
+
+// tag returns the SFNT tag for the 4 character string s.
+func tag(s string) uint32 {
+	return binary.BigEndian.Uint32([]byte(s))
+}
+
+var (
+	tagGlyf = tag("glyf")
+	tagLoca = tag("loca")
+	tagHmtx = tag("hmtx")
+	tagHhea = tag("hhea")
+	tagHead = tag("head")
+	tagMaxp = tag("maxp")
+)
+
+// decodeWOFF decodes a WOFF 1.0 font, whose tables are individually
+// compressed with zlib.
+func decodeWOFF(b []byte) ([]byte, error) {
+	if len(b) < 44 {
+		return nil, errInvalidFont
+	}
+	flavor := binary.BigEndian.Uint32(b[4:])
+	numTables := int(binary.BigEndian.Uint16(b[12:]))
+	if 44+20*numTables > len(b) {
+		return nil, errInvalidFont
+	}
+	tables := make([]sfntTable, numTables)
+	total := 0
+	for i := range tables {
+		entry := b[44+20*i:]
+		offset := binary.BigEndian.Uint32(entry[4:])
+		compLength := binary.BigEndian.Uint32(entry[8:])
+		origLength := binary.BigEndian.Uint32(entry[12:])
+		if uint64(offset)+uint64(compLength) > uint64(len(b)) || compLength > origLength {
+			return nil, errInvalidFont
+		}
+		total += int(origLength)
+		if total > maxFontSize {
+			return nil, errInvalidFont
+		}
+		data := b[offset : offset+compLength]
+		if compLength < origLength {
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			data = make([]byte, origLength)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+		}
+		tables[i] = sfntTable{binary.BigEndian.Uint32(entry), data}
+	}
+	return buildSFNT(flavor, tables), nil
+}
+
+// woff2KnownTags are the tags that a WOFF2 table directory can refer to by
+// index.
+var woff2KnownTags = [63]string{
+	"cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post",
+	"cvt ", "fpgm", "glyf", "loca", "prep", "CFF ", "VORG", "EBDT",
+	"EBLC", "gasp", "hdmx", "kern", "LTSH", "PCLT", "VDMX", "vhea",
+	"vmtx", "BASE", "GDEF", "GPOS", "GSUB", "EBSC", "JSTF", "MATH",
+	"CBDT", "CBLC", "COLR", "CPAL", "SVG ", "sbix", "acnt", "avar",
+	"bdat", "bloc", "bsln", "cvar", "fdsc", "feat", "fmtx", "fvar",
+	"gvar", "hsty", "just", "lcar", "mort", "morx", "opbd", "prop",
+	"trak", "Zapf", "Silf", "Glat", "Gloc", "Feat", "Sill",
+}
+
+// A woffReader reads the big endian and variable length integers used by
+// WOFF2. Reading past the end of the data sets err, after which every
+// read returns 0.
+type woffReader struct {
+	b   []byte
+	err error
+}
+
+func (r *woffReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.b) {
+		r.err = errInvalidFont
+		return nil
+	}
+	ret := r.b[:n]
+	r.b = r.b[n:]
+	return ret
+}
+
+func (r *woffReader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *woffReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *woffReader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// base128 reads a UIntBase128.
+func (r *woffReader) base128() uint32 {
+	var v uint32
+	for i := 0; i < 5; i++ {
+		c := r.u8()
+		if r.err != nil {
+			return 0
+		}
+		if (i == 0 && c == 0x80) || v&0xFE000000 != 0 {
+			// Leading zeros and overflow are invalid.
+			r.err = errInvalidFont
+			return 0
+		}
+		v = v<<7 | uint32(c&0x7F)
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+	r.err = errInvalidFont
+	return 0
+}
+
+// u255 reads a 255UInt16.
+func (r *woffReader) u255() uint16 {
+	switch c := r.u8(); c {
+	case 253:
+		return r.u16()
+	case 254:
+		return uint16(r.u8()) + 506
+	case 255:
+		return uint16(r.u8()) + 253
+	default:
+		return uint16(c)
+	}
+}
+
+// A woff2Table is an entry in the table directory of a WOFF2 font.
+type woff2Table struct {
+	tag             uint32
+	transformed     bool
+	version         uint8
+	origLength      uint32
+	transformLength uint32
+}
+
+// decodeWOFF2 decodes a WOFF 2.0 font, whose tables are compressed
+// together with Brotli, with the glyf, loca and hmtx tables optionally
+// transformed to make them compress better. Font collections aren't
+// supported.
+func decodeWOFF2(b []byte) ([]byte, error) {
+	r := &woffReader{b: b}
+	r.u32() // signature
+	flavor := r.u32()
+	r.u32() // length
+	numTables := int(r.u16())
+	r.u16() // reserved
+	r.u32() // totalSfntSize
+	compressedSize := r.u32()
+	r.bytes(24) // version, metadata and private data
+	if r.err != nil || numTables == 0 {
+		return nil, errInvalidFont
+	}
+	if flavor == tag("ttcf") {
+		return nil, errors.New("WOFF2 font collections are not supported")
+	}
+
+	dir := make([]woff2Table, numTables)
+	total := uint64(0)
+	for i := range dir {
+		t := &dir[i]
+		flags := r.u8()
+		if idx := flags & 0x3F; idx == 63 {
+			t.tag = r.u32()
+		} else {
+			t.tag = tag(woff2KnownTags[idx])
+		}
+		t.version = flags >> 6
+		t.origLength = r.base128()
+		if t.tag == tagGlyf || t.tag == tagLoca {
+			// For glyf and loca, version 0 is the transform and
+			// 3 is the null transform.
+			t.transformed = t.version == 0
+		} else {
+			t.transformed = t.version != 0
+		}
+		if t.transformed {
+			t.transformLength = r.base128()
+			total += uint64(t.transformLength)
+		} else {
+			total += uint64(t.origLength)
+		}
+	}
+	if r.err != nil || total > maxFontSize || uint64(compressedSize) > uint64(len(r.b)) {
+		return nil, errInvalidFont
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(r.b[:compressedSize])), int64(total)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != total {
+		return nil, errInvalidFont
+	}
+
+	raw := make(map[uint32][]byte)
+	for _, t := range dir {
+		length := t.origLength
+		if t.transformed {
+			length = t.transformLength
+		}
+		raw[t.tag] = data[:length]
+		data = data[length:]
+	}
+
+	tables := make([]sfntTable, 0, numTables)
+	var xMins []int16
+	for _, t := range dir {
+		switch {
+		case t.tag == tagGlyf && t.transformed:
+			glyf, loca, mins, err := reconstructGlyf(raw[tagGlyf])
+			if err != nil {
+				return nil, err
+			}
+			xMins = mins
+			tables = append(tables, sfntTable{tagGlyf, glyf}, sfntTable{tagLoca, loca})
+		case t.tag == tagLoca && t.transformed:
+			// Reconstructed with glyf.
+		case t.tag == tagHmtx && t.transformed:
+			// Reconstructed after glyf, since it needs the
+			// glyph bounding boxes.
+		case t.transformed:
+			return nil, fmt.Errorf("Unsupported WOFF2 transform of %q table", tagString(t.tag))
+		default:
+			tables = append(tables, sfntTable{t.tag, raw[t.tag]})
+		}
+	}
+	for _, t := range dir {
+		if t.tag == tagHmtx && t.transformed {
+			if t.version != 1 {
+				return nil, errInvalidFont
+			}
+			hmtx, err := reconstructHmtx(raw[tagHmtx], raw[tagHhea], raw[tagMaxp], xMins)
+			if err != nil {
+				return nil, err
+			}
+			tables = append(tables, sfntTable{tagHmtx, hmtx})
+		}
+	}
+	return buildSFNT(flavor, tables), nil
+}
+
+func tagString(t uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], t)
+	return string(b[:])
+}
+
+// Flags of the points of simple glyphs in a glyf table.
+const (
+	glyfOnCurve = 1 << iota
+	glyfXShort
+	glyfYShort
+	glyfRepeat
+	glyfXSame
+	glyfYSame
+	glyfOverlapSimple
+)
+
+// Flags of the components of composite glyphs.
+const (
+	compositeArgsAreWords   = 0x0001
+	compositeHaveScale      = 0x0008
+	compositeMoreComponents = 0x0020
+	compositeHaveXYScale    = 0x0040
+	compositeHaveTwoByTwo   = 0x0080
+	compositeHaveInstrs     = 0x0100
+)
+
+// reconstructGlyf reconstructs the glyf and loca tables from a transformed
+// WOFF2 glyf table. It also returns the xMin of each glyph, which is needed
+// to reconstruct the hmtx table.
+func reconstructGlyf(b []byte) (glyf, loca []byte, xMins []int16, err error) {
+	r := &woffReader{b: b}
+	r.u16() // reserved
+	options := r.u16()
+	numGlyphs := int(r.u16())
+	indexFormat := r.u16()
+	var sizes [7]uint32
+	for i := range sizes {
+		sizes[i] = r.u32()
+	}
+	var streams [7]*woffReader
+	for i, size := range sizes {
+		streams[i] = &woffReader{b: r.bytes(int(size))}
+	}
+	nContours, nPoints, flagStream, glyphStream, compositeStream, bboxStream, instructions := streams[0], streams[1], streams[2], streams[3], streams[4], streams[5], streams[6]
+	bboxBitmap := bboxStream.bytes(4 * ((numGlyphs + 31) / 32))
+	var overlapBitmap []byte
+	if options&1 != 0 {
+		overlapBitmap = r.bytes((numGlyphs + 7) / 8)
+	}
+	if r.err != nil || bboxStream.err != nil {
+		return nil, nil, nil, errInvalidFont
+	}
+
+	var out bytes.Buffer
+	offsets := make([]int, numGlyphs+1)
+	xMins = make([]int16, numGlyphs)
+	for i := 0; i < numGlyphs; i++ {
+		offsets[i] = out.Len()
+		hasBBox := bboxBitmap[i>>3]&(0x80>>uint(i&7)) != 0
+		var bbox [4]int16
+		if hasBBox {
+			for j := range bbox {
+				bbox[j] = int16(bboxStream.u16())
+			}
+		}
+		n := int16(nContours.u16())
+		switch {
+		case n == 0:
+			if hasBBox {
+				return nil, nil, nil, errInvalidFont
+			}
+		case n > 0:
+			overlap := overlapBitmap != nil && overlapBitmap[i>>3]&(0x80>>uint(i&7)) != 0
+			bbox = writeSimpleGlyph(&out, int(n), nPoints, flagStream, glyphStream, instructions, bbox, hasBBox, overlap)
+			xMins[i] = bbox[0]
+		case n == -1:
+			if !hasBBox {
+				return nil, nil, nil, errInvalidFont
+			}
+			writeCompositeGlyph(&out, compositeStream, glyphStream, instructions, bbox)
+			xMins[i] = bbox[0]
+		default:
+			return nil, nil, nil, errInvalidFont
+		}
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+		for _, s := range streams {
+			if s.err != nil {
+				return nil, nil, nil, s.err
+			}
+		}
+	}
+	offsets[numGlyphs] = out.Len()
+
+	if indexFormat == 0 {
+		if out.Len() > 0x1FFFE {
+			return nil, nil, nil, errInvalidFont
+		}
+		loca = make([]byte, 2*(numGlyphs+1))
+		for i, o := range offsets {
+			binary.BigEndian.PutUint16(loca[2*i:], uint16(o/2))
+		}
+	} else {
+		loca = make([]byte, 4*(numGlyphs+1))
+		for i, o := range offsets {
+			binary.BigEndian.PutUint32(loca[4*i:], uint32(o))
+		}
+	}
+	return out.Bytes(), loca, xMins, nil
+}
+
+// writeSimpleGlyph writes a simple glyph with n contours from the WOFF2
+// glyf streams to out. The bounding box is calculated from the points if
+// hasBBox is false, and returned.
+func writeSimpleGlyph(out *bytes.Buffer, n int, nPoints, flagStream, glyphStream, instructions *woffReader, bbox [4]int16, hasBBox, overlap bool) [4]int16 {
+	endPts := make([]uint16, n)
+	total := 0
+	for i := range endPts {
+		total += int(nPoints.u255())
+		endPts[i] = uint16(total - 1)
+	}
+	if total > 0xFFFF {
+		nPoints.err = errInvalidFont
+		return bbox
+	}
+	type point struct {
+		x, y    int
+		onCurve bool
+	}
+	points := make([]point, total)
+	x, y := 0, 0
+	for i := range points {
+		flag := flagStream.u8()
+		dx, dy := decodeTriplet(flag&0x7F, glyphStream)
+		x += dx
+		y += dy
+		points[i] = point{x, y, flag&0x80 == 0}
+	}
+	instrLen := int(glyphStream.u255())
+	instrs := instructions.bytes(instrLen)
+
+	if !hasBBox && total > 0 {
+		minX, minY, maxX, maxY := points[0].x, points[0].y, points[0].x, points[0].y
+		for _, p := range points[1:] {
+			if p.x < minX {
+				minX = p.x
+			}
+			if p.x > maxX {
+				maxX = p.x
+			}
+			if p.y < minY {
+				minY = p.y
+			}
+			if p.y > maxY {
+				maxY = p.y
+			}
+		}
+		bbox = [4]int16{int16(minX), int16(minY), int16(maxX), int16(maxY)}
+	}
+
+	binary.Write(out, binary.BigEndian, int16(n))
+	binary.Write(out, binary.BigEndian, bbox)
+	binary.Write(out, binary.BigEndian, endPts)
+	binary.Write(out, binary.BigEndian, uint16(instrLen))
+	out.Write(instrs)
+
+	var xs, ys bytes.Buffer
+	lastX, lastY := 0, 0
+	for i, p := range points {
+		var flag byte
+		if p.onCurve {
+			flag |= glyfOnCurve
+		}
+		if i == 0 && overlap {
+			flag |= glyfOverlapSimple
+		}
+		switch dx := p.x - lastX; {
+		case dx == 0:
+			flag |= glyfXSame
+		case dx > -256 && dx < 256:
+			flag |= glyfXShort
+			if dx > 0 {
+				flag |= glyfXSame
+			} else {
+				dx = -dx
+			}
+			xs.WriteByte(byte(dx))
+		default:
+			binary.Write(&xs, binary.BigEndian, int16(dx))
+		}
+		switch dy := p.y - lastY; {
+		case dy == 0:
+			flag |= glyfYSame
+		case dy > -256 && dy < 256:
+			flag |= glyfYShort
+			if dy > 0 {
+				flag |= glyfYSame
+			} else {
+				dy = -dy
+			}
+			ys.WriteByte(byte(dy))
+		default:
+			binary.Write(&ys, binary.BigEndian, int16(dy))
+		}
+		out.WriteByte(flag)
+		lastX, lastY = p.x, p.y
+	}
+	out.Write(xs.Bytes())
+	out.Write(ys.Bytes())
+	return bbox
+}
+
+// decodeTriplet decodes the coordinate deltas of a point in a WOFF2 glyph
+// stream, which are encoded with a variable number of bytes depending on
+// the flag.
+func decodeTriplet(flag byte, r *woffReader) (dx, dy int) {
+	withSign := func(flag byte, v int) int {
+		if flag&1 != 0 {
+			return v
+		}
+		return -v
+	}
+	f := int(flag)
+	switch {
+	case flag < 10:
+		b0 := int(r.u8())
+		return 0, withSign(flag, (f&14)<<7+b0)
+	case flag < 20:
+		b0 := int(r.u8())
+		return withSign(flag, ((f-10)&14)<<7+b0), 0
+	case flag < 84:
+		b0, b1 := f-20, int(r.u8())
+		return withSign(flag, 1+(b0&0x30)+b1>>4), withSign(flag>>1, 1+(b0&0x0C)<<2+b1&0x0F)
+	case flag < 120:
+		b0 := f - 84
+		b1, b2 := int(r.u8()), int(r.u8())
+		return withSign(flag, 1+(b0/12)<<8+b1), withSign(flag>>1, 1+((b0%12)>>2)<<8+b2)
+	case flag < 124:
+		b1, b2, b3 := int(r.u8()), int(r.u8()), int(r.u8())
+		return withSign(flag, b1<<4+b2>>4), withSign(flag>>1, (b2&0x0F)<<8+b3)
+	default:
+		b1, b2, b3, b4 := int(r.u8()), int(r.u8()), int(r.u8()), int(r.u8())
+		return withSign(flag, b1<<8+b2), withSign(flag>>1, b3<<8+b4)
+	}
+}
+
+// writeCompositeGlyph writes a composite glyph from the WOFF2 glyf streams
+// to out. The components are stored unchanged in the composite stream.
+func writeCompositeGlyph(out *bytes.Buffer, compositeStream, glyphStream, instructions *woffReader, bbox [4]int16) {
+	binary.Write(out, binary.BigEndian, int16(-1))
+	binary.Write(out, binary.BigEndian, bbox)
+	haveInstructions := false
+	for {
+		flags := compositeStream.u16()
+		size := 2 // glyph index
+		if flags&compositeArgsAreWords != 0 {
+			size += 4
+		} else {
+			size += 2
+		}
+		switch {
+		case flags&compositeHaveScale != 0:
+			size += 2
+		case flags&compositeHaveXYScale != 0:
+			size += 4
+		case flags&compositeHaveTwoByTwo != 0:
+			size += 8
+		}
+		binary.Write(out, binary.BigEndian, flags)
+		out.Write(compositeStream.bytes(size))
+		if flags&compositeHaveInstrs != 0 {
+			haveInstructions = true
+		}
+		if flags&compositeMoreComponents == 0 || compositeStream.err != nil {
+			break
+		}
+	}
+	if haveInstructions {
+		instrLen := glyphStream.u255()
+		binary.Write(out, binary.BigEndian, instrLen)
+		out.Write(instructions.bytes(int(instrLen)))
+	}
+}
+
+// reconstructHmtx reconstructs the hmtx table from a transformed WOFF2
+// hmtx table, using the xMins of the glyphs for any left side bearings
+// which were left out.
+func reconstructHmtx(b, hhea, maxp []byte, xMins []int16) ([]byte, error) {
+	if len(hhea) < 36 || len(maxp) < 6 {
+		return nil, errInvalidFont
+	}
+	numHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	if numHMetrics < 1 || numHMetrics > numGlyphs || len(xMins) != numGlyphs {
+		return nil, errInvalidFont
+	}
+	r := &woffReader{b: b}
+	flags := r.u8()
+	advances := make([]uint16, numHMetrics)
+	for i := range advances {
+		advances[i] = r.u16()
+	}
+	lsbs := make([]int16, numGlyphs)
+	for i := range lsbs {
+		switch {
+		case i < numHMetrics && flags&1 == 0, i >= numHMetrics && flags&2 == 0:
+			lsbs[i] = int16(r.u16())
+		default:
+			lsbs[i] = xMins[i]
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	var out bytes.Buffer
+	for i, lsb := range lsbs {
+		if i < numHMetrics {
+			binary.Write(&out, binary.BigEndian, advances[i])
+		}
+		binary.Write(&out, binary.BigEndian, lsb)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,360 @@
+package css
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/driusan/fonts"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// testFont returns an SFNT font to encode in the WOFF tests.
+func testFont(t *testing.T) []byte {
+	t.Helper()
+	b, err := fonts.Asset("DejaVuSans.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// sfntTables returns the flavor and tables of the SFNT font b.
+func sfntTables(b []byte) (uint32, []sfntTable) {
+	n := int(binary.BigEndian.Uint16(b[4:]))
+	tables := make([]sfntTable, n)
+	for i := range tables {
+		rec := b[12+16*i:]
+		offset := binary.BigEndian.Uint32(rec[8:])
+		length := binary.BigEndian.Uint32(rec[12:])
+		tables[i] = sfntTable{binary.BigEndian.Uint32(rec), b[offset : offset+length]}
+	}
+	return binary.BigEndian.Uint32(b), tables
+}
+
+// encodeWOFF encodes the SFNT font b as a WOFF 1.0 font.
+func encodeWOFF(b []byte) []byte {
+	flavor, tables := sfntTables(b)
+	var data bytes.Buffer
+	dir := make([]byte, 20*len(tables))
+	for i, t := range tables {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(t.data)
+		w.Close()
+		compressed := z.Bytes()
+		if len(compressed) >= len(t.data) {
+			compressed = t.data
+		}
+		entry := dir[20*i:]
+		binary.BigEndian.PutUint32(entry, t.tag)
+		binary.BigEndian.PutUint32(entry[4:], uint32(44+len(dir)+data.Len()))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(compressed)))
+		binary.BigEndian.PutUint32(entry[12:], uint32(len(t.data)))
+		data.Write(compressed)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	header := make([]byte, 44)
+	copy(header, "wOFF")
+	binary.BigEndian.PutUint32(header[4:], flavor)
+	binary.BigEndian.PutUint32(header[8:], uint32(44+len(dir)+data.Len()))
+	binary.BigEndian.PutUint16(header[12:], uint16(len(tables)))
+	return append(append(header, dir...), data.Bytes()...)
+}
+
+// encodeWOFF2 encodes the SFNT font b as a WOFF 2.0 font. If transform is
+// true, the glyf and loca tables are transformed.
+func encodeWOFF2(t *testing.T, b []byte, transform bool) []byte {
+	t.Helper()
+	flavor, tables := sfntTables(b)
+	var glyf, loca, head []byte
+	for _, tbl := range tables {
+		switch tbl.tag {
+		case tagGlyf:
+			glyf = tbl.data
+		case tagLoca:
+			loca = tbl.data
+		case tagHead:
+			head = tbl.data
+		}
+	}
+
+	var dir, data bytes.Buffer
+	for _, tbl := range tables {
+		idx := 63
+		for i, known := range woff2KnownTags {
+			if tag(known) == tbl.tag {
+				idx = i
+			}
+		}
+		tableData := tbl.data
+		version := 0
+		isGlyf := tbl.tag == tagGlyf || tbl.tag == tagLoca
+		if isGlyf && !transform {
+			version = 3
+		}
+		dir.WriteByte(byte(version<<6 | idx))
+		if idx == 63 {
+			binary.Write(&dir, binary.BigEndian, tbl.tag)
+		}
+		dir.Write(base128(uint32(len(tbl.data))))
+		if isGlyf && transform {
+			tableData = nil
+			if tbl.tag == tagGlyf {
+				tableData = transformGlyf(t, glyf, loca, binary.BigEndian.Uint16(head[50:]))
+			}
+			dir.Write(base128(uint32(len(tableData))))
+		}
+		data.Write(tableData)
+	}
+	var compressed bytes.Buffer
+	w := brotli.NewWriter(&compressed)
+	w.Write(data.Bytes())
+	w.Close()
+
+	header := make([]byte, 48)
+	copy(header, "wOF2")
+	binary.BigEndian.PutUint32(header[4:], flavor)
+	binary.BigEndian.PutUint32(header[8:], uint32(48+dir.Len()+compressed.Len()))
+	binary.BigEndian.PutUint16(header[12:], uint16(len(tables)))
+	binary.BigEndian.PutUint32(header[16:], uint32(len(b)))
+	binary.BigEndian.PutUint32(header[20:], uint32(compressed.Len()))
+	return append(append(header, dir.Bytes()...), compressed.Bytes()...)
+}
+
+func base128(v uint32) []byte {
+	out := []byte{byte(v & 0x7F)}
+	for v >>= 7; v != 0; v >>= 7 {
+		out = append([]byte{byte(v&0x7F) | 0x80}, out...)
+	}
+	return out
+}
+
+func write255(buf *bytes.Buffer, v uint16) {
+	if v < 253 {
+		buf.WriteByte(byte(v))
+		return
+	}
+	buf.WriteByte(253)
+	binary.Write(buf, binary.BigEndian, v)
+}
+
+// transformGlyf applies the WOFF2 glyf transform to glyf. Every point is
+// encoded with the largest triplet encoding, and the bounding boxes of
+// simple glyphs are left out, so that the decoder has to calculate them.
+func transformGlyf(t *testing.T, glyf, loca []byte, indexFormat uint16) []byte {
+	t.Helper()
+	var offsets []int
+	if indexFormat == 0 {
+		for i := 0; i < len(loca); i += 2 {
+			offsets = append(offsets, 2*int(binary.BigEndian.Uint16(loca[i:])))
+		}
+	} else {
+		for i := 0; i < len(loca); i += 4 {
+			offsets = append(offsets, int(binary.BigEndian.Uint32(loca[i:])))
+		}
+	}
+	numGlyphs := len(offsets) - 1
+	var streams [7]bytes.Buffer
+	nContours, nPoints, flagStream, glyphStream, compositeStream, bboxStream, instructions := &streams[0], &streams[1], &streams[2], &streams[3], &streams[4], &streams[5], &streams[6]
+	bboxBitmap := make([]byte, 4*((numGlyphs+31)/32))
+
+	for i := 0; i < numGlyphs; i++ {
+		g := glyf[offsets[i]:offsets[i+1]]
+		if len(g) == 0 {
+			binary.Write(nContours, binary.BigEndian, int16(0))
+			continue
+		}
+		n := int16(binary.BigEndian.Uint16(g))
+		binary.Write(nContours, binary.BigEndian, n)
+		if n < 0 {
+			bboxBitmap[i>>3] |= 0x80 >> uint(i&7)
+			bboxStream.Write(g[2:10])
+			p := 10
+			haveInstructions := false
+			for {
+				flags := binary.BigEndian.Uint16(g[p:])
+				size := 4
+				if flags&compositeArgsAreWords != 0 {
+					size += 4
+				} else {
+					size += 2
+				}
+				switch {
+				case flags&compositeHaveScale != 0:
+					size += 2
+				case flags&compositeHaveXYScale != 0:
+					size += 4
+				case flags&compositeHaveTwoByTwo != 0:
+					size += 8
+				}
+				compositeStream.Write(g[p : p+size])
+				p += size
+				if flags&compositeHaveInstrs != 0 {
+					haveInstructions = true
+				}
+				if flags&compositeMoreComponents == 0 {
+					break
+				}
+			}
+			if haveInstructions {
+				l := binary.BigEndian.Uint16(g[p:])
+				write255(glyphStream, l)
+				instructions.Write(g[p+2 : p+2+int(l)])
+			}
+			continue
+		}
+
+		p := 10
+		last := -1
+		for c := 0; c < int(n); c++ {
+			end := int(binary.BigEndian.Uint16(g[p:]))
+			write255(nPoints, uint16(end-last))
+			last = end
+			p += 2
+		}
+		total := last + 1
+		instrLen := binary.BigEndian.Uint16(g[p:])
+		instrs := g[p+2 : p+2+int(instrLen)]
+		p += 2 + int(instrLen)
+
+		flags := make([]byte, 0, total)
+		for len(flags) < total {
+			f := g[p]
+			p++
+			flags = append(flags, f)
+			if f&glyfRepeat != 0 {
+				for r := g[p]; r > 0; r-- {
+					flags = append(flags, f)
+				}
+				p++
+			}
+		}
+		readCoords := func(short, same byte) []int {
+			coords := make([]int, total)
+			for j, f := range flags {
+				switch {
+				case f&short != 0:
+					coords[j] = int(g[p])
+					if f&same == 0 {
+						coords[j] = -coords[j]
+					}
+					p++
+				case f&same == 0:
+					coords[j] = int(int16(binary.BigEndian.Uint16(g[p:])))
+					p += 2
+				}
+			}
+			return coords
+		}
+		dxs := readCoords(glyfXShort, glyfXSame)
+		dys := readCoords(glyfYShort, glyfYSame)
+		for j, f := range flags {
+			flag := byte(124)
+			dx, dy := dxs[j], dys[j]
+			if dx >= 0 {
+				flag |= 1
+			} else {
+				dx = -dx
+			}
+			if dy >= 0 {
+				flag |= 2
+			} else {
+				dy = -dy
+			}
+			if f&glyfOnCurve == 0 {
+				flag |= 0x80
+			}
+			flagStream.WriteByte(flag)
+			binary.Write(glyphStream, binary.BigEndian, uint16(dx))
+			binary.Write(glyphStream, binary.BigEndian, uint16(dy))
+		}
+		write255(glyphStream, instrLen)
+		instructions.Write(instrs)
+	}
+
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, []uint16{0, 0, uint16(numGlyphs), indexFormat})
+	bboxStream = bytes.NewBuffer(append(bboxBitmap, bboxStream.Bytes()...))
+	streams[5] = *bboxStream
+	for _, s := range streams {
+		binary.Write(&out, binary.BigEndian, uint32(s.Len()))
+	}
+	for _, s := range streams {
+		out.Write(s.Bytes())
+	}
+	return out.Bytes()
+}
+
+// glyphOutlines returns the outline of every glyph in the font b.
+func glyphOutlines(t *testing.T, b []byte) [][]sfnt.Segment {
+	t.Helper()
+	f, err := sfnt.Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf sfnt.Buffer
+	var outlines [][]sfnt.Segment
+	for i := 0; i < f.NumGlyphs(); i++ {
+		segs, err := f.LoadGlyph(&buf, sfnt.GlyphIndex(i), fixed.I(64), nil)
+		if err != nil {
+			t.Fatalf("Glyph %d: %v", i, err)
+		}
+		outlines = append(outlines, append([]sfnt.Segment(nil), segs...))
+	}
+	return outlines
+}
+
+func TestDecodeFont(t *testing.T) {
+	ttf := testFont(t)
+	want := glyphOutlines(t, ttf)
+	tests := []struct {
+		Name string
+		Data []byte
+	}{
+		{"TrueType", ttf},
+		{"WOFF", encodeWOFF(ttf)},
+		{"WOFF2", encodeWOFF2(t, ttf, false)},
+		{"WOFF2 with transformed glyf", encodeWOFF2(t, ttf, true)},
+	}
+	for _, tc := range tests {
+		b, err := decodeFont(tc.Data)
+		if err != nil {
+			t.Errorf("%s: %v", tc.Name, err)
+			continue
+		}
+		if got := glyphOutlines(t, b); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: glyph outlines differ from the original font", tc.Name)
+		}
+	}
+
+	for _, data := range [][]byte{nil, []byte("GIF89a"), []byte("wOFF"), []byte("wOF2\x00\x01")} {
+		if _, err := decodeFont(data); err == nil {
+			t.Errorf("Expected an error decoding %q", data)
+		}
+	}
+}
+
+func TestReconstructHmtx(t *testing.T) {
+	hhea := make([]byte, 36)
+	binary.BigEndian.PutUint16(hhea[34:], 2)
+	maxp := make([]byte, 6)
+	binary.BigEndian.PutUint16(maxp[4:], 3)
+	// Two advances, with the left side bearings of the proportional
+	// glyphs left out but the monospaced glyph's included.
+	transformed := []byte{1, 0x02, 0x00, 0x01, 0x00, 0xFF, 0xFE}
+	got, err := reconstructHmtx(transformed, hhea, maxp, []int16{10, 20, 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x02, 0x00, 0, 10, 0x01, 0x00, 0, 20, 0xFF, 0xFE}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unexpected hmtx: got %v want %v", got, want)
+	}
+}
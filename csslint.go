@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+
+	"github.com/driusan/gob/css"
+	"github.com/driusan/gob/net"
+	"github.com/driusan/gob/renderer"
+)
+
+// csslint checks the stylesheets in args, which are URLs or file names, and
+// writes the problems found in them (and anything that they import) to w.
+// Without any arguments, it checks gob's own stylesheets: the default
+// stylesheet and the user stylesheets. It returns the number of errors
+// found, not including warnings.
+func csslint(w io.Writer, args []string) int {
+	errors := 0
+	lint := func(content string, u *url.URL, name string) {
+		css.ParseStylesheetWithDiagnostics(content, css.AuthorSrc, net.DefaultReader{}, u, 0, func(d css.Diagnostic) {
+			if d.File == "" {
+				d.File = name
+			}
+			if d.Severity == css.SeverityError {
+				errors++
+			}
+			fmt.Fprintln(w, d)
+		})
+	}
+
+	if len(args) == 0 {
+		lint(css.DefaultCSS, nil, "default.css")
+		if renderer.UserStylesDir == "" {
+			return errors
+		}
+		sites, _ := filepath.Glob(filepath.Join(renderer.UserStylesDir, "sites", "*.css"))
+		for _, path := range append([]string{filepath.Join(renderer.UserStylesDir, "user.css")}, sites...) {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			lint(string(content), &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}, path)
+		}
+		return errors
+	}
+
+	for _, arg := range args {
+		u, err := url.Parse(arg)
+		if err != nil || u.Scheme == "" {
+			// Anything that isn't a URL is a file name.
+			abs, err := filepath.Abs(arg)
+			if err != nil {
+				fmt.Fprintf(w, "%v: %v\n", arg, err)
+				errors++
+				continue
+			}
+			u = &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+		}
+		r, resp, err := net.DefaultReader{}.GetURL(u)
+		if err != nil || resp < 200 || resp >= 300 {
+			if r != nil {
+				r.Close()
+			}
+			if err == nil {
+				err = fmt.Errorf("Unexpected response code %d", resp)
+			}
+			fmt.Fprintf(w, "%v: %v\n", arg, err)
+			errors++
+			continue
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			fmt.Fprintf(w, "%v: %v\n", arg, err)
+			errors++
+			continue
+		}
+		lint(string(content), u, arg)
+	}
+	return errors
+}
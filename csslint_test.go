@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSSLint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobcsslint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "site.css")
+	if err := ioutil.WriteFile(path, []byte(`a { color: red }
+p {
+	colour: red;
+	margin: 1px 2px 3px 4px 5px;
+}
+div:unknown { display: block }
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if errors := csslint(&out, []string{path}); errors != 2 {
+		t.Errorf("Unexpected number of errors: got %v want 2", errors)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{
+		":3:2: warning: Unsupported property colour",
+		":4:2: error: Invalid value for margin: 1px 2px 3px 4px 5px",
+		":6:1: error: Unsupported selector div:unknown never matches",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Unexpected output: got %q", out.String())
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "file://") || !strings.HasSuffix(line, expected[i]) {
+			t.Errorf("Unexpected diagnostic %d: got %q want %q", i, line, expected[i])
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"image/draw"
 	"net/url"
 	"os"
+	"path/filepath"
 	// "runtime/pprof"
 )
 
@@ -34,7 +35,25 @@ var (
 
 // for debugging
 var hover *renderer.RenderableDomElement
-var activeEl *renderer.RenderableDomElement
+
+// elementFor returns the element that contains el, which may be a text node.
+func elementFor(el *renderer.RenderableDomElement) *renderer.RenderableDomElement {
+	for el != nil && el.Type != html.ElementNode {
+		el = el.Parent
+	}
+	return el
+}
+
+// relayoutPage lays out the subtrees of the page whose styles have changed
+// again and repaints it.
+func relayoutPage(s screen.Screen, w screen.Window, v *Viewport, page *renderer.Page, changed []*renderer.RenderableDomElement) {
+	newContext()
+	for _, el := range changed {
+		el.InvalidateSubtreeLayout()
+	}
+	page.Content.Layout(renderCtx, v.Size.Size())
+	renderNewPageIntoViewport(s, w, v, *page, false)
+}
 
 func debugelement(el *renderer.RenderableDomElement) {
 	cur := el.Element
@@ -110,6 +129,19 @@ func main() {
 		defer pprof.StopCPUProfile()
 	*/
 
+	if len(os.Args) > 1 && os.Args[1] == "csslint" {
+		if csslint(os.Stdout, os.Args[2:]) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if dir := net.GetConfigDir(); dir != "" {
+		if err := css.LoadFontConfig(filepath.Join(dir, "fonts.conf")); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error loading font configuration: %s\n", err.Error())
+		}
+	}
+
 	filename := "file:test.html"
 	if len(os.Args) > 1 {
 		filename = os.Args[1]
@@ -228,6 +260,7 @@ func main() {
 					panic(err)
 				}
 				newContext()
+				page.SetViewportSize(v.Size.Size())
 				page.Content.InvalidateLayout()
 				page.Content.Layout(renderCtx, v.Size.Size())
 				renderNewPageIntoViewport(s, w, &v, page, false)
@@ -251,6 +284,13 @@ func main() {
 					if page.Content != nil && page.Content.ImageMap != nil {
 
 						el := page.Content.ImageMap.At(int(e.X)+v.Cursor.X, int(e.Y)+v.Cursor.Y)
+						// Generated content acts as part of the
+						// element that it was generated for.
+						for p := el; p != nil; p = p.Parent {
+							if p.PseudoElement != "" {
+								el = p.Parent
+							}
+						}
 						if el != nil {
 							switch e.Direction {
 							case mouse.DirRelease:
@@ -261,41 +301,36 @@ func main() {
 									p, err := loadNewPage(page.URL, el.GetAttribute("href"))
 									page = p
 									if err == nil {
+										page.SetViewportSize(v.Size.Size())
 										page.Content.Layout(renderCtx, v.Size.Size())
 										renderNewPageIntoViewport(s, w, &v, p, true)
 									}
-								} else if activeEl != nil {
-									activeEl.State.Active = false
-									activeEl = nil
-									page.ReapplyStyles()
-									newContext()
-									page.Content.InvalidateLayout()
-									page.Content.Layout(context.TODO(), v.Size.Size())
-									renderNewPageIntoViewport(s, w, &v, page, false)
-
+								} else if changed := page.SetActive(nil); len(changed) > 0 {
+									relayoutPage(s, w, &v, &page, changed)
 								}
 							case mouse.DirPress:
+								changed := page.SetActive(elementFor(el))
 								if el.State.Link == true || el.State.Visited == true {
-									activeEl = el
-									el.State.Active = true
-									page.ReapplyStyles()
-									newContext()
-									page.Content.InvalidateLayout()
-									page.Content.Layout(context.TODO(), v.Size.Size())
-									renderNewPageIntoViewport(s, w, &v, page, false)
+									// Links are the only focusable
+									// elements.
+									changed = append(changed, page.SetFocus(el)...)
+								} else {
+									// Clicking anywhere else
+									// removes the focus.
+									changed = append(changed, page.SetFocus(nil)...)
+								}
+								if len(changed) > 0 {
+									relayoutPage(s, w, &v, &page, changed)
 								}
 							default:
 								if el.Type == html.ElementNode && el.Data == "a" {
 									//fmt.Printf("Hovering over link %s\n", el.GetAttribute("href"))
 								}
-								if el != hover {
-									if hover != nil {
-										hover.State.Hover = false
+								if target := elementFor(el); target != hover {
+									hover = target
+									if changed := page.SetHover(target); len(changed) > 0 {
+										relayoutPage(s, w, &v, &page, changed)
 									}
-									el.State.Hover = true
-								}
-								if el.Type == html.ElementNode {
-									hover = el
 								}
 							}
 						}
@@ -331,6 +366,9 @@ func loadNewPage(context *url.URL, path string) (renderer.Page, error) {
 	p.URL = newURL
 	p.Content.InvalidateLayout()
 	background = p.Background
+	// The hovered element was on the old page. Nothing on the new
+	// page is hovered, active or focused until the mouse moves.
+	hover = nil
 	return p, nil
 }
 
@@ -46,10 +46,15 @@ type DefaultReader struct{}
 func (d DefaultReader) GetURL(u *url.URL) (body io.ReadCloser, statuscode int, err error) {
 	switch u.Scheme {
 	case "file":
-		if _, err := os.Stat(u.Opaque); err != nil {
+		// file:/path URLs have a path, and file:path URLs are opaque.
+		path := u.Opaque
+		if path == "" {
+			path = u.Path
+		}
+		if _, err := os.Stat(path); err != nil {
 			return nil, 404, err
 		}
-		f, err := os.Open(u.Opaque)
+		f, err := os.Open(path)
 		return f, 200, err
 	case "data":
 		// See RFC 2397
@@ -95,12 +100,22 @@ func (d DefaultReader) HasVisited(u *url.URL) bool {
 	return !os.IsNotExist(err)
 }
 
-func GetCacheDir() string {
+// GetConfigDir returns the directory that gob's configuration, such as user
+// stylesheets, is stored in, or an empty string if it can't be determined.
+func GetConfigDir() string {
 	user, err := user.Current()
 	if err != nil {
 		return ""
 	}
-	return filepath.Join(user.HomeDir, ".gob", "cache")
+	return filepath.Join(user.HomeDir, ".gob")
+}
+
+func GetCacheDir() string {
+	dir := GetConfigDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "cache")
 }
 func GetCacheLocation(resource *url.URL) string {
 	cachedir := GetCacheDir()
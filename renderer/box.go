@@ -7,7 +7,6 @@ import (
 	// the standard draw package doesn't have Copy, which we need for background Repeat.
 	"image/draw"
 	"net/url"
-	"strings"
 
 	"golang.org/x/net/html"
 
@@ -138,6 +137,9 @@ func (b *outerBoxDrawer) RGBA(hideleft, hideright bool) *image.RGBA {
 		image.ZP,
 		draw.Over,
 	)
+	// The left and right borders stop at the top and bottom borders, so
+	// that the corners aren't composited twice if the colour isn't
+	// opaque.
 	if !hideleft {
 		// draw the left border
 		draw.Draw(
@@ -145,11 +147,11 @@ func (b *outerBoxDrawer) RGBA(hideleft, hideright bool) *image.RGBA {
 			image.Rectangle{
 				Min: image.Point{
 					X: b.Margin.Left.Width,
-					Y: b.Margin.Top.Width,
+					Y: b.Margin.Top.Width + b.Border.Top.Width,
 				},
 				Max: image.Point{
 					X: b.Margin.Left.Width + b.Border.Left.Width,
-					Y: bounds.Max.Y - b.Margin.Bottom.Width,
+					Y: bounds.Max.Y - b.Border.Bottom.Width,
 				},
 			},
 			&image.Uniform{b.Border.Left.Color},
@@ -163,8 +165,8 @@ func (b *outerBoxDrawer) RGBA(hideleft, hideright bool) *image.RGBA {
 			ri,
 			image.Rectangle{
 				Min: image.Point{
-					X: bounds.Max.X - b.Margin.Right.Width - b.Border.Left.Width,
-					Y: b.Margin.Top.Width,
+					X: bounds.Max.X - b.Margin.Right.Width - b.Border.Right.Width,
+					Y: b.Margin.Top.Width + b.Border.Top.Width,
 				},
 				Max: image.Point{
 					X: bounds.Max.X - b.Margin.Right.Width,
@@ -233,420 +235,79 @@ func (b *outerBoxDrawer) At(x, y int) color.Color {
 }
 
 func (e RenderableDomElement) GetBorderBottomWidth() int {
-	if e.Styles == nil {
-		return 0
-	}
-	value := e.Styles.BorderBottomWidth.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetBorderBottomWidth()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().BorderBottom.Width.Px(0, e.units)
 }
 func (e RenderableDomElement) GetBorderBottomColor() color.Color {
-	if e.Styles == nil {
-		return e.GetColor()
-	}
-	value := e.Styles.BorderBottomColor.Value
-	if value == "" {
-		return e.GetColor()
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return e.GetColor()
-		}
-		return e.Parent.GetBorderBottomColor()
-	}
-	c, err := css.ConvertColorToRGBA(value)
-	if err != nil {
-		return e.GetColor()
-	}
-	return c
+	return e.ComputedStyle().BorderBottom.Color
 }
 func (e RenderableDomElement) GetBorderTopWidth() int {
-	if e.Styles == nil {
-		return 0
-	}
-	value := e.Styles.BorderTopWidth.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetBorderTopWidth()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().BorderTop.Width.Px(0, e.units)
 }
 func (e RenderableDomElement) GetBorderTopColor() color.Color {
-	if e.Styles == nil {
-		return e.GetColor()
-	}
-	value := e.Styles.BorderTopColor.Value
-	if value == "" {
-		return e.GetColor()
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return e.GetColor()
-		}
-		return e.Parent.GetBorderTopColor()
-	}
-	c, err := css.ConvertColorToRGBA(value)
-	if err != nil {
-		return e.GetColor()
-	}
-	return c
+	return e.ComputedStyle().BorderTop.Color
 }
 
 func (e RenderableDomElement) GetBorderLeftWidth() int {
-	if e.Styles == nil {
-		return 0
-	}
-	value := e.Styles.BorderLeftWidth.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetBorderLeftWidth()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().BorderLeft.Width.Px(0, e.units)
 }
 func (e RenderableDomElement) GetBorderLeftColor() color.Color {
-	if e.Styles == nil {
-		return e.GetColor()
-	}
-	value := e.Styles.BorderLeftColor.Value
-	if value == "" {
-		return e.GetColor()
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return e.GetColor()
-		}
-		return e.Parent.GetBorderLeftColor()
-	}
-	c, err := css.ConvertColorToRGBA(value)
-	if err != nil {
-		return e.GetColor()
-	}
-	return c
+	return e.ComputedStyle().BorderLeft.Color
 }
 
 func (e RenderableDomElement) GetBorderRightWidth() int {
-	if e.Styles == nil {
-		return 0
-	}
-	value := e.Styles.BorderRightWidth.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetBorderRightWidth()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().BorderRight.Width.Px(0, e.units)
 }
 func (e RenderableDomElement) GetBorderRightColor() color.Color {
-	if e.Styles == nil {
-		return e.GetColor()
-	}
-	value := e.Styles.BorderRightColor.Value
-	if value == "" {
-		return e.GetColor()
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return e.GetColor()
-		}
-		return e.Parent.GetBorderRightColor()
-	}
-	c, err := css.ConvertColorToRGBA(value)
-	if err != nil {
-		return e.GetColor()
-	}
-	return c
+	return e.ComputedStyle().BorderRight.Color
 }
 func (e RenderableDomElement) GetMarginLeftSize() int {
-	switch value := e.Styles.MarginLeft.Value; value {
-	case "":
-		return 0
-	case "inherit":
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetMarginLeftSize()
-	case "auto":
-		if e.Styles.MarginRight.Value == "auto" {
+	cs := e.ComputedStyle()
+	if cs.MarginLeft.Type == css.LengthAuto {
+		if cs.MarginRight.Type == css.LengthAuto {
 			// return calculate how much is needed to center
 			return (e.containerWidth - e.contentWidth - e.GetBorderLeftWidth() - e.GetBorderRightWidth() - e.GetPaddingLeft() - e.GetPaddingRight()) / 2
 		}
 		return (e.containerWidth - e.contentWidth - e.GetBorderLeftWidth() - e.GetBorderRightWidth() - e.GetPaddingLeft() - e.GetPaddingRight())
-	default:
-		fontsize := e.GetFontSize()
-		val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-		if err != nil {
-			return 0
-		}
-		return val
 	}
+	return cs.MarginLeft.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetMarginRightSize() int {
-	value := e.Styles.MarginRight.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetMarginRightSize()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().MarginRight.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetMarginTopSize() int {
-	value := e.Styles.MarginTop.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetMarginTopSize()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().MarginTop.Px(e.containerWidth, e.units)
 }
 
 func (e RenderableDomElement) GetMarginBottomSize() int {
-	value := e.Styles.MarginBottom.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetMarginBottomSize()
-
-	}
-
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().MarginBottom.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetPaddingLeft() int {
-	value := e.Styles.PaddingLeft.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetPaddingLeft()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().PaddingLeft.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetPaddingRight() int {
-	value := e.Styles.PaddingRight.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetPaddingRight()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().PaddingRight.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetPaddingTop() int {
-	value := e.Styles.PaddingTop.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetPaddingTop()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().PaddingTop.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetPaddingBottom() int {
-	value := e.Styles.PaddingBottom.Value
-	if value == "" {
-		// No style, use default.
-		return 0
-	}
-	if value == "inherit" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetPaddingBottom()
-
-	}
-	fontsize := e.GetFontSize()
-	val, err := css.ConvertUnitToPx(fontsize, e.containerWidth, value)
-	if err != nil {
-		return 0
-	}
-	return val
+	return e.ComputedStyle().PaddingBottom.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetBorderTopStyle() string {
-	if e.Styles == nil {
-		return "none"
-	}
-	val := e.Styles.BorderTopStyle.Value
-	if val == "" {
-		return "none"
-	}
-	if val == "inherit" {
-		if e.Parent == nil {
-			return "none"
-		}
-		return e.Parent.GetBorderTopStyle()
-	}
-	return val
+	return string(e.ComputedStyle().BorderTop.Style)
 }
 func (e RenderableDomElement) GetBorderBottomStyle() string {
-	if e.Styles == nil {
-		return "none"
-	}
-	val := e.Styles.BorderBottomStyle.Value
-	if val == "" {
-		return "none"
-	}
-	if val == "inherit" {
-		if e.Parent == nil {
-			return "none"
-		}
-		return e.Parent.GetBorderBottomStyle()
-	}
-	return val
+	return string(e.ComputedStyle().BorderBottom.Style)
 }
 func (e RenderableDomElement) GetBorderLeftStyle() string {
-	if e.Styles == nil {
-		return "none"
-	}
-	val := e.Styles.BorderLeftStyle.Value
-	if val == "" {
-		return "none"
-	}
-	if val == "inherit" {
-		if e.Parent == nil {
-			return "none"
-		}
-		return e.Parent.GetBorderLeftStyle()
-	}
-	return val
+	return string(e.ComputedStyle().BorderLeft.Style)
 }
 func (e RenderableDomElement) GetBorderRightStyle() string {
-	if e.Styles == nil {
-		return "none"
-	}
-	val := e.Styles.BorderRightStyle.Value
-	if val == "" {
-		return "none"
-	}
-	if val == "inherit" {
-		if e.Parent == nil {
-			return "none"
-		}
-		return e.Parent.GetBorderRightStyle()
-	}
-	return val
+	return string(e.ComputedStyle().BorderRight.Style)
 }
 
 func (e *RenderableDomElement) GetBackgroundRepeat() string {
-	repeat := e.Styles.BackgroundRepeat.Value
-	switch strings.ToLower(repeat) {
-	case "inherit":
-		return e.Parent.GetBackgroundRepeat()
-	case "repeat", "repeat-x", "repeat-y", "no-repeat":
-		return repeat
-	default:
-		return "repeat"
-	}
+	return string(e.ComputedStyle().BackgroundRepeat)
 }
 func (e *RenderableDomElement) GetBackgroundImage() image.Image {
 	iURL, err := e.Styles.GetBackgroundImage()
@@ -655,12 +316,21 @@ func (e *RenderableDomElement) GetBackgroundImage() image.Image {
 	} else if err != nil {
 		return nil
 	}
+	return e.loadImage(iURL)
+}
+
+// loadImage loads and decodes the image at iURL, relative to the page that
+// e is on. It returns nil if the image can't be loaded.
+func (e *RenderableDomElement) loadImage(iURL string) image.Image {
 	u, err := url.Parse(iURL)
 	if err != nil {
 		return nil
 	}
 	newURL := e.PageLocation.ResolveReference(u)
 	r, resp, err := e.resolver.GetURL(newURL)
+	if r != nil {
+		defer r.Close()
+	}
 	if err != nil || resp < 200 || resp >= 300 {
 		return nil
 	}
@@ -669,7 +339,6 @@ func (e *RenderableDomElement) GetBackgroundImage() image.Image {
 		return nil
 	}
 	return content
-
 }
 
 // Given an image, returns an image representing the CSS Box that should
@@ -0,0 +1,82 @@
+package renderer
+
+import (
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/font"
+)
+
+func TestCSSWideKeywords(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><head><style>
+	p { margin: 0 }
+	p.revert { margin: revert }
+	div { color: green; border: 2px solid red }
+</style></head>
+<body>
+	<p id="zero">No margins</p>
+	<p id="revert" class="revert">Reverted margins</p>
+	<div>
+		<span id="inherit" style="border-top-width: inherit; border-top-style: inherit">a</span>
+		<span id="initial" style="color: initial">b</span>
+		<span id="unset" style="color: unset; border-style: solid; border-top-color: unset">c</span>
+	</div>
+</body></html>`,
+	)
+	els := elementsByID(page)
+	// The author stylesheet removes the margins, so revert goes back
+	// to the user agent's.
+	if m := els["zero"].GetMarginTopSize(); m != 0 {
+		t.Errorf("Unexpected margin without revert: got %v want 0", m)
+	}
+	if m := els["revert"].GetMarginTopSize(); m == 0 {
+		t.Errorf("margin: revert did not use the user agent margin")
+	}
+
+	green := color.RGBA{0, 0x80, 0, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+	if w := els["inherit"].GetBorderTopWidth(); w != 2 {
+		t.Errorf("Unexpected inherited border width: got %v want 2", w)
+	}
+	if s := els["inherit"].GetBorderTopStyle(); s != "solid" {
+		t.Errorf("Unexpected inherited border style: got %v want solid", s)
+	}
+	if w := els["inherit"].GetBorderBottomWidth(); w != 0 {
+		t.Errorf("Non-inherited property was inherited: got %v want 0", w)
+	}
+	if c := els["initial"].GetColor(); !colorEQ(c, black) {
+		t.Errorf("Unexpected initial colour: got %v want %v", c, black)
+	}
+	if c := els["unset"].GetColor(); !colorEQ(c, green) {
+		t.Errorf("Unexpected unset colour: got %v want %v", c, green)
+	}
+	// border-top-color isn't inherited, so unset is currentColor.
+	if c := els["unset"].GetBorderTopColor(); !colorEQ(c, green) {
+		t.Errorf("Unexpected unset border colour: got %v want %v", c, green)
+	}
+}
+
+func TestShorthandOrder(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><body>
+	<div id="inline" style="margin: 0; margin-left: 5px; margin: 1px 2px; margin-top: 3px">a</div>
+	<div id="font" style="font-weight: 100; font: 20px serif">b</div>
+</body></html>`,
+	)
+	els := elementsByID(page)
+	if m := els["inline"].GetMarginTopSize(); m != 3 {
+		t.Errorf("Unexpected margin-top: got %v want 3", m)
+	}
+	if m := els["inline"].GetMarginLeftSize(); m != 2 {
+		t.Errorf("Unexpected margin-left: got %v want 2", m)
+	}
+	if w := els["font"].GetFontWeight(); w != font.WeightNormal {
+		t.Errorf("font shorthand did not reset font-weight: got %v", w)
+	}
+	if s := els["font"].GetFontSize(); s != 20 {
+		t.Errorf("Unexpected font size: got %v want 20", s)
+	}
+}
@@ -0,0 +1,76 @@
+package renderer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Test that colours which aren't opaque are composited over the parent's
+// background.
+func TestAlphaCompositing(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html style="background: white">
+	<body style="margin: 0; padding: 0">
+		<div style="background: blue; color: red">
+			<div style="background: rgba(255, 0, 0, 0.5); height: 20px"></div>
+			<div style="border: 10px solid #00FF0080; height: 20px"></div>
+			<div style="background: currentColor; height: 20px"></div>
+			<div style="background: hsl(0 100% 50% / 0); height: 20px"></div>
+		</div>
+	</body>
+</html>`,
+	)
+	canvas := image.NewRGBA(image.Rectangle{image.ZP, image.Point{400, 300}})
+	page.Content.Layout(context.TODO(), canvas.Bounds().Size())
+	page.Content.RenderInto(context.TODO(), canvas, image.ZP)
+
+	tests := []struct {
+		Point    image.Point
+		Expected color.Color
+	}{
+		// Half red over blue
+		{image.Point{5, 5}, color.RGBA{0x80, 0, 0x7f, 0xff}},
+		// The border is half green over blue, including the corners.
+		{image.Point{5, 25}, color.RGBA{0, 0x80, 0x7f, 0xff}},
+		{image.Point{15, 35}, color.RGBA{0, 0, 0xff, 0xff}},
+		{image.Point{395, 35}, color.RGBA{0, 0x80, 0x7f, 0xff}},
+		{image.Point{5, 55}, color.RGBA{0, 0x80, 0x7f, 0xff}},
+		// currentColor is the color property.
+		{image.Point{5, 65}, color.RGBA{0xff, 0, 0, 0xff}},
+		// A transparent background shows the parent's.
+		{image.Point{5, 85}, color.RGBA{0, 0, 0xff, 0xff}},
+	}
+	for i, tc := range tests {
+		if c := canvas.At(tc.Point.X, tc.Point.Y); !colorEQ(c, tc.Expected) {
+			t.Errorf("Case %d: Pixel %v: got %v want %v", i, tc.Point, c, tc.Expected)
+		}
+	}
+}
+
+func TestColorKeywords(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<div style="color: rgb(0 128 0)">
+	<span id="current" style="color: currentColor">a</span>
+	<span id="named" style="color: DarkOrange">b</span>
+	<span id="transparent" style="color: transparent">c</span>
+</div>`,
+	)
+	els := elementsByID(page)
+	tests := []struct {
+		ID       string
+		Expected color.Color
+	}{
+		{"current", color.RGBA{0, 128, 0, 255}},
+		{"named", color.RGBA{0xff, 0x8c, 0, 255}},
+		{"transparent", color.RGBA{0, 0, 0, 0}},
+	}
+	for _, tc := range tests {
+		if c := els[tc.ID].GetColor(); !colorEQ(c, tc.Expected) {
+			t.Errorf("%v: got %v want %v", tc.ID, c, tc.Expected)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package renderer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/driusan/gob/css"
+	"golang.org/x/image/font"
+	"golang.org/x/net/html"
+)
+
+func TestComputedStyle(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><head><style>
+	body { margin: 0; color: rgb(0, 0, 255) }
+	div { width: 50vw; padding: 1em; border: 2px dashed; font-weight: bold }
+</style></head>
+<body>
+	<div id="div" style="font-size: 10px">Text</div>
+</body></html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{400, 300})
+	div := elementsByID(page)["div"]
+	cs := div.ComputedStyle()
+	if cs == nil {
+		t.Fatal("No computed style")
+	}
+
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	if cs.Color != blue {
+		t.Errorf("Unexpected colour: got %v want %v", cs.Color, blue)
+	}
+	if cs.Display != css.DisplayBlock {
+		t.Errorf("Unexpected display: got %v want %v", cs.Display, css.DisplayBlock)
+	}
+	if want := (css.Border{Width: css.Length{Type: css.LengthPx, Value: 2}, Style: css.BorderStyleDashed, Color: blue}); cs.BorderLeft != want {
+		t.Errorf("Unexpected border: got %v want %v", cs.BorderLeft, want)
+	}
+	if w := div.GetWidth(); w != 200 {
+		t.Errorf("Unexpected width: got %v want 200", w)
+	}
+	if p := div.GetPaddingTop(); p != 10 {
+		t.Errorf("Unexpected padding: got %v want 10", p)
+	}
+
+	// Text nodes compute their style from their parent.
+	text := div.FirstChild
+	if text == nil {
+		t.Fatal("No text node")
+	}
+	tcs := text.ComputedStyle()
+	if tcs.Color != blue || tcs.FontWeight != font.WeightBold || tcs.FontSize != 10 {
+		t.Errorf("Text node did not inherit styles: got %v, %v, %v", tcs.Color, tcs.FontWeight, tcs.FontSize)
+	}
+	if w := text.GetBorderLeftWidth(); w != 0 {
+		t.Errorf("Text node inherited border: got %v want 0", w)
+	}
+}
+
+// Test that bolder and lighter are relative to the weight of the parent,
+// and that the weight that they resolve to is what's inherited, rather than
+// the keyword being applied again by each descendant.
+func TestRelativeFontWeights(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><head><style>
+	strong { font-weight: bolder }
+	.light { font-weight: 300 }
+	.lighter { font-weight: lighter }
+</style></head>
+<body>
+	<strong id="strong"><em id="em"><span id="span">Nested</span></em></strong>
+	<div id="div" style="font-weight: bolder">Text</div>
+	<table><tr><th id="th">Heading</th></tr></table>
+	<div class="light"><p id="lighter" class="lighter"><span id="inner">Light</span></p></div>
+</body></html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{400, 300})
+	ids := elementsByID(page)
+	for _, tc := range []struct {
+		ID       string
+		Expected font.Weight
+	}{
+		{"strong", font.WeightBold},
+		{"em", font.WeightBold},
+		{"span", font.WeightBold},
+		{"div", font.WeightBold},
+		{"th", font.WeightBold},
+		{"lighter", font.WeightThin},
+		{"inner", font.WeightThin},
+	} {
+		if got := ids[tc.ID].GetFontWeight(); got != tc.Expected {
+			t.Errorf("Unexpected font weight for %s: got %v want %v", tc.ID, got, tc.Expected)
+		}
+	}
+
+	// The text inside of a bolder block has the weight of the block.
+	for _, id := range []string{"div", "th"} {
+		text := ids[id].FirstChild
+		if text == nil || text.Type != html.TextNode {
+			t.Fatalf("No text node in %s", id)
+		}
+		if got := text.GetFontWeight(); got != font.WeightBold {
+			t.Errorf("Unexpected font weight for the text in %s: got %v want %v", id, got, font.WeightBold)
+		}
+	}
+}
+
+// Test that inherited values are the ones computed for the parent, rather
+// than relative values being resolved again for each descendant.
+func TestInheritedComputedValues(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><head><style>
+	body { line-height: 20px }
+	#outer { font-size: 20px; text-indent: 2ch; line-height: 2lh }
+	#inner { font-size: 10px }
+</style></head>
+<body>
+	<div id="outer"><p id="inner"><span id="span">Text</span></p></div>
+</body></html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{400, 300})
+	ids := elementsByID(page)
+	outer := ids["outer"]
+	if got := outer.GetLineHeight(); got != 40 {
+		t.Errorf("Unexpected line height for outer: got %v want 40", got)
+	}
+	for _, id := range []string{"inner", "span"} {
+		if got, want := ids[id].GetTextIndent(400), outer.GetTextIndent(400); got != want {
+			t.Errorf("Unexpected text-indent for %s: got %v want %v", id, got, want)
+		}
+		if got, want := ids[id].GetLineHeight(), outer.GetLineHeight(); got != want {
+			t.Errorf("Unexpected line height for %s: got %v want %v", id, got, want)
+		}
+	}
+}
+
+func TestWebFontFamily(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><head><style>
+	@font-face { font-family: "Brand Serif"; src: url(/brand.ttf) format("truetype"); }
+	body { font-family: monospace }
+	#brand { font-family: "Brand Serif", sans-serif }
+	#missing { font-family: Missing Font }
+</style></head>
+<body>
+	<p id="brand">Brand</p>
+	<p id="missing">Missing</p>
+</body>
+</html>`,
+	)
+	defer css.LoadFontFaces(testLoader{}, nil)
+	els := elementsByID(page)
+	if got, want := els["brand"].GetFontFamily(), css.FontFamily(`"Brand Serif", sans-serif`); got != want {
+		t.Errorf("Unexpected font family for brand: got %v want %v", got, want)
+	}
+	if got, want := els["missing"].GetFontFamily(), css.FontFamily(`"Missing Font"`); got != want {
+		t.Errorf("Unexpected font family for missing: got %v want %v", got, want)
+	}
+	// The web font is a bold serif font, so its glyphs have different
+	// advances than the default sans-serif font, which is used when none
+	// of the families are available.
+	sans, _ := els["brand"].Styles.GetFontFace(16, "sans-serif", font.WeightNormal, font.StyleNormal, css.FontStretchNormal).GlyphAdvance('m')
+	if brand, _ := els["brand"].GetFontFace(16).GlyphAdvance('m'); brand == sans {
+		t.Errorf("Expected the web font to be used for brand")
+	}
+	if missing, _ := els["missing"].GetFontFace(16).GlyphAdvance('m'); missing != sans {
+		t.Errorf("Expected the default sans-serif font to be used for missing")
+	}
+}
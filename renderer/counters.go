@@ -0,0 +1,197 @@
+package renderer
+
+import (
+	"github.com/driusan/gob/css"
+	"golang.org/x/net/html"
+)
+
+// A counterInstance is a counter that was created by an element. It's in
+// scope for the descendants of scope that come after the element which
+// created it.
+type counterInstance struct {
+	value    int
+	reversed bool
+	scope    *RenderableDomElement
+}
+
+// contentState tracks the counters and quotes that generated content
+// depends on while walking the document in order.
+type contentState struct {
+	// The counters in scope, from the outermost to the innermost.
+	counters   map[string][]counterInstance
+	quoteDepth int
+}
+
+func newContentState() *contentState {
+	return &contentState{counters: make(map[string][]counterInstance)}
+}
+
+// resetCounter creates a new counter for el, replacing the counter of the
+// same name created by any of el's siblings.
+func (s *contentState) resetCounter(el *RenderableDomElement, name string, value int, reversed bool) {
+	stack := s.counters[name]
+	if n := len(stack); n > 0 && stack[n-1].scope == el.Parent {
+		stack = stack[:n-1]
+	}
+	s.counters[name] = append(stack, counterInstance{value, reversed, el.Parent})
+}
+
+// incrementCounter increments the innermost counter named name, creating
+// it on el if there's no counter in scope.
+func (s *contentState) incrementCounter(el *RenderableDomElement, name string, by int) {
+	if len(s.counters[name]) == 0 {
+		s.resetCounter(el, name, 0, false)
+	}
+	stack := s.counters[name]
+	stack[len(stack)-1].value += by
+}
+
+// setCounter sets the innermost counter named name, creating it on el if
+// there's no counter in scope.
+func (s *contentState) setCounter(el *RenderableDomElement, name string, value int) {
+	if len(s.counters[name]) == 0 {
+		s.resetCounter(el, name, 0, false)
+	}
+	stack := s.counters[name]
+	stack[len(stack)-1].value = value
+}
+
+// counter returns the value of the innermost counter named name.
+func (s *contentState) counter(name string) int {
+	if stack := s.counters[name]; len(stack) > 0 {
+		return stack[len(stack)-1].value
+	}
+	return 0
+}
+
+// reversed reports whether the innermost counter named name counts down.
+func (s *contentState) reversed(name string) bool {
+	if stack := s.counters[name]; len(stack) > 0 {
+		return stack[len(stack)-1].reversed
+	}
+	return false
+}
+
+// leaveScope removes the counters that were created by the children of
+// el, which go out of scope once el is finished.
+func (s *contentState) leaveScope(el *RenderableDomElement) {
+	for name, stack := range s.counters {
+		n := len(stack)
+		for n > 0 && stack[n-1].scope == el {
+			n--
+		}
+		s.counters[name] = stack[:n]
+	}
+}
+
+// applyCounters resets, increments and sets the counters that el's styles
+// refer to, in that order. List items also increment the list-item counter
+// unless they explicitly increment it themselves.
+func (s *contentState) applyCounters(el *RenderableDomElement) {
+	for _, c := range el.getCounterReset() {
+		if c.Reversed && c.Implicit {
+			c.Value = el.reversedCounterStart(c.Name)
+		}
+		s.resetCounter(el, c.Name, c.Value, c.Reversed)
+	}
+	for _, c := range el.getCounterIncrement(s.reversed("list-item")) {
+		s.incrementCounter(el, c.Name, c.Value)
+	}
+	for _, c := range el.getCounterSet() {
+		s.setCounter(el, c.Name, c.Value)
+	}
+	if el.GetDisplayProp() == "list-item" {
+		el.listItem = s.counter("list-item")
+	}
+}
+
+// counterStyles returns the styles that counter properties are read from.
+// Counters aren't affected by first-line styles.
+func (e *RenderableDomElement) counterStyles() *css.StyledElement {
+	if e.ConditionalStyles.Unconditional != nil {
+		return e.ConditionalStyles.Unconditional
+	}
+	return e.Styles
+}
+
+// getCounterReset returns the counters that e resets.
+func (e *RenderableDomElement) getCounterReset() []css.CounterValue {
+	styles := e.counterStyles()
+	if styles == nil {
+		return nil
+	}
+	counters, _ := css.ParseCounters(styles.CounterReset.Value, 0)
+	return counters
+}
+
+// getCounterSet returns the counters that e sets.
+func (e *RenderableDomElement) getCounterSet() []css.CounterValue {
+	styles := e.counterStyles()
+	if styles == nil {
+		return nil
+	}
+	counters, _ := css.ParseCounters(styles.CounterSet.Value, 0)
+	return counters
+}
+
+// getCounterIncrement returns the counters that e increments, including the
+// implicit increment of list-item for list items. reversed is whether the
+// list-item counter that would be incremented is reversed.
+func (e *RenderableDomElement) getCounterIncrement(reversed bool) []css.CounterValue {
+	styles := e.counterStyles()
+	if styles == nil {
+		return nil
+	}
+	counters, _ := css.ParseCounters(styles.CounterIncrement.Value, 1)
+	if e.GetDisplayProp() != "list-item" {
+		return counters
+	}
+	for _, c := range counters {
+		if c.Name == "list-item" {
+			return counters
+		}
+	}
+	c := css.CounterValue{Name: "list-item", Value: 1, Implicit: true}
+	if reversed {
+		c.Value = -1
+	}
+	return append(counters, c)
+}
+
+// reversedCounterStart calculates the starting value for a reversed counter
+// created by e without an explicit value, so that the first element which
+// increments it gets the number of elements that it counts.
+func (e *RenderableDomElement) reversedCounterStart(name string) int {
+	var first, total int
+	seen := false
+	var count func(*RenderableDomElement)
+	count = func(el *RenderableDomElement) {
+		for c := el.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.GetDisplayProp() == "none" {
+				continue
+			}
+			resets := false
+			for _, r := range c.getCounterReset() {
+				if r.Name == name {
+					resets = true
+				}
+			}
+			if resets {
+				// Nested counters of the same name are separate.
+				continue
+			}
+			for _, inc := range c.getCounterIncrement(true) {
+				if inc.Name == name {
+					if !seen {
+						first = inc.Value
+						seen = true
+					}
+					total += inc.Value
+				}
+			}
+			count(c)
+		}
+	}
+	count(e)
+	return -total - first
+}
@@ -0,0 +1,120 @@
+package renderer
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestListItemCounter(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		ol.override { counter-reset: list-item 99 }
+		</style>
+	</head>
+	<body>
+		<ol>
+			<li id="a1">One</li>
+			<li id="a2">Two
+				<ol>
+					<li id="a21">Nested</li>
+					<li id="a22">Nested</li>
+				</ol>
+			</li>
+			<li id="a3">Three</li>
+		</ol>
+		<ol start="5">
+			<li id="b1">Five</li>
+			<li id="b2">Six</li>
+		</ol>
+		<ol>
+			<li id="c1">One</li>
+			<li id="c2" value="10">Ten</li>
+			<li id="c3">Eleven</li>
+		</ol>
+		<ol reversed>
+			<li id="d1">Three</li>
+			<li id="d2">Two</li>
+			<li id="d3" style="display: none">Hidden</li>
+			<li id="d4">One</li>
+		</ol>
+		<ol reversed start="10">
+			<li id="e1">Ten</li>
+			<li id="e2">Nine</li>
+		</ol>
+		<ol class="override" start="3">
+			<li id="f1">One hundred</li>
+		</ol>
+	</body>
+</html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	ids := elementsByID(page)
+
+	tests := map[string]int{
+		"a1": 1, "a2": 2, "a21": 1, "a22": 2, "a3": 3,
+		"b1": 5, "b2": 6,
+		"c1": 1, "c2": 10, "c3": 11,
+		"d1": 3, "d2": 2, "d4": 1,
+		"e1": 10, "e2": 9,
+		"f1": 100,
+	}
+	for id, expected := range tests {
+		if got := ids[id].listItem; got != expected {
+			t.Errorf("Unexpected list-item counter for %s: got %v want %v", id, got, expected)
+		}
+	}
+}
+
+func TestCounterScopes(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		body { counter-reset: chapter }
+		h1 { counter-increment: chapter; counter-reset: section }
+		h2 { counter-increment: section }
+		h2::before { content: counter(chapter) "." counter(section) " " }
+		h2.skip { counter-set: section 5 }
+		div { counter-reset: chapter 7 }
+		</style>
+	</head>
+	<body>
+		<h1>Chapter 1</h1>
+		<h2 id="s11">Section</h2>
+		<h2 id="s12">Section</h2>
+		<h1>Chapter 2</h1>
+		<h2 id="s21">Section</h2>
+		<h2 id="s25" class="skip">Section</h2>
+		<h2 id="s26">Section</h2>
+		<div>
+			<h2 id="s27">Section</h2>
+		</div>
+		<h2 id="s28">Section</h2>
+	</body>
+</html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	ids := elementsByID(page)
+
+	tests := map[string]string{
+		"s11": "1.1 ",
+		"s12": "1.2 ",
+		"s21": "2.1 ",
+		"s25": "2.5 ",
+		"s26": "2.6 ",
+		// The div creates a new chapter counter, which stays in
+		// scope for the elements after it.
+		"s27": "7.7 ",
+		"s28": "7.8 ",
+	}
+	for id, expected := range tests {
+		if got, _ := generatedText(ids[id], "before"); got != expected {
+			t.Errorf("Unexpected content for %s: got %q want %q", id, got, expected)
+		}
+	}
+}
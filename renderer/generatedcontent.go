@@ -0,0 +1,348 @@
+package renderer
+
+import (
+	"image"
+	"strings"
+
+	"github.com/driusan/gob/css"
+	"github.com/driusan/gob/dom"
+	"golang.org/x/net/html"
+)
+
+// pseudoElement returns e's ::marker, ::before or ::after pseudo-element,
+// or nil if it doesn't have one.
+func (e *RenderableDomElement) pseudoElement(name string) *RenderableDomElement {
+	switch name {
+	case "marker":
+		if c := e.FirstChild; c != nil && c.PseudoElement == name {
+			return c
+		}
+	case "before":
+		c := e.FirstChild
+		if c != nil && c.PseudoElement == "marker" {
+			c = c.NextSibling
+		}
+		if c != nil && c.PseudoElement == name {
+			return c
+		}
+	case "after":
+		for c := e.FirstChild; c != nil; c = c.NextSibling {
+			if c.NextSibling == nil && c.PseudoElement == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// A generatedStyle is what one of an element's pseudo-elements is rendered
+// from. Its styles are nil if the element doesn't have the pseudo-element.
+type generatedStyle struct {
+	styles  *css.StyledElement
+	content []css.ContentItem
+}
+
+// pseudoElementStyle returns the styles and content of one of e's
+// pseudo-elements.
+func (e *RenderableDomElement) pseudoElementStyle(name string) generatedStyle {
+	if pseudo := e.pseudoElement(name); pseudo != nil {
+		return generatedStyle{pseudo.Styles, pseudo.content}
+	}
+	return generatedStyle{}
+}
+
+// same reports whether g and o are rendered the same way.
+func (g generatedStyle) same(o generatedStyle) bool {
+	if g.styles == nil || o.styles == nil {
+		return g.styles == o.styles
+	}
+	if !g.styles.SameStyle(o.styles) || len(g.content) != len(o.content) {
+		return false
+	}
+	for i := range g.content {
+		if g.content[i] != o.content[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isReplaced reports whether e is a replaced element. Replaced elements
+// don't have pseudo-elements.
+func (e *RenderableDomElement) isReplaced() bool {
+	switch strings.ToLower(e.Data) {
+	case "img", "input", "textarea", "select", "iframe", "object", "embed", "video", "audio":
+		return true
+	}
+	return false
+}
+
+// setPseudoElement creates, updates or removes el's ::marker, ::before or
+// ::after pseudo-element, depending on whether styles gives it any content.
+// The styles of el must already be applied.
+func (p *Page) setPseudoElement(el *RenderableDomElement, name string, styles *css.StyledElement) {
+	styles.InheritCustomProperties(el.Styles.CustomProperties())
+	styles.InheritStyles(el.Styles)
+	styles.SortStyles()
+	pseudo := el.pseudoElement(name)
+	content, err := css.ParseContent(styles.Content.Value)
+	if name == "marker" {
+		// Only markers inside of the list item are boxes of their
+		// own. Their content comes from the list item, and is set
+		// when the list-item counter is known.
+		content, err = nil, nil
+		if el.GetDisplayProp() != "list-item" || el.GetListStylePosition() != "inside" {
+			err = css.NoStyles
+		}
+	}
+	if err != nil || el.isReplaced() {
+		if pseudo != nil {
+			el.removeChild(pseudo)
+		}
+		return
+	}
+
+	if pseudo == nil {
+		pseudo = &RenderableDomElement{
+			Element: (*dom.Element)(&html.Node{
+				Type: html.ElementNode,
+				Data: "::" + name,
+			}),
+			PseudoElement: name,
+			Parent:        el,
+			resolver:      el.resolver,
+		}
+		switch name {
+		case "marker":
+			el.insertAfter(pseudo, nil)
+		case "before":
+			el.insertAfter(pseudo, el.pseudoElement("marker"))
+		case "after":
+			last := el.FirstChild
+			for last != nil && last.NextSibling != nil {
+				last = last.NextSibling
+			}
+			el.insertAfter(pseudo, last)
+		}
+	}
+
+	switch strVal := styles.FontSize.Value; strVal {
+	case "":
+		size, _ := el.Styles.GetFontSize()
+		styles.SetFontSize(size)
+	default:
+		styles.SetFontSize(p.fontSizeToPx(strVal, el))
+	}
+	pseudo.Styles = styles
+	styles.ComputeStyle(el.ComputedStyle(), pseudo.units())
+	pseudo.ConditionalStyles.Unconditional = styles
+	pseudo.ConditionalStyles.FirstLine = styles
+	pseudo.ConditionalStyles.FirstLetter = styles
+	pseudo.PageLocation = p.URL
+	pseudo.content = content
+	pseudo.counterStyleDefs = el.counterStyleDefs
+}
+
+// insertAfter inserts c as a child of e after prev, or as the first child
+// if prev is nil.
+func (e *RenderableDomElement) insertAfter(c, prev *RenderableDomElement) {
+	c.Parent, c.PrevSibling = e, prev
+	if prev == nil {
+		c.NextSibling = e.FirstChild
+		e.FirstChild = c
+	} else {
+		c.NextSibling = prev.NextSibling
+		prev.NextSibling = c
+	}
+	if c.NextSibling != nil {
+		c.NextSibling.PrevSibling = c
+	}
+}
+
+// removeChild removes the child c from e.
+func (e *RenderableDomElement) removeChild(c *RenderableDomElement) {
+	if c.PrevSibling != nil {
+		c.PrevSibling.NextSibling = c.NextSibling
+	} else {
+		e.FirstChild = c.NextSibling
+	}
+	if c.NextSibling != nil {
+		c.NextSibling.PrevSibling = c.PrevSibling
+	}
+	c.Parent, c.PrevSibling, c.NextSibling = nil, nil, nil
+}
+
+// generateContent walks the subtree rooted at e in document order, updating
+// counters and creating the boxes for the content of pseudo-elements and
+// list markers.
+func (e *RenderableDomElement) generateContent(s *contentState) {
+	if e == nil || e.Type != html.ElementNode || e.GetDisplayProp() == "none" {
+		return
+	}
+
+	s.applyCounters(e)
+
+	if e.GetDisplayProp() == "list-item" {
+		e.setMarker()
+	}
+	if e.PseudoElement == "marker" {
+		e.content = e.Parent.marker
+	}
+	if e.PseudoElement != "" {
+		e.setContent(s)
+	}
+	for c := e.FirstChild; c != nil; c = c.NextSibling {
+		c.generateContent(s)
+	}
+	s.leaveScope(e)
+}
+
+// setContent replaces the children of the pseudo-element e with the boxes
+// for its content.
+func (e *RenderableDomElement) setContent(s *contentState) {
+	var children []*html.Node
+	// The images for the img elements in children.
+	images := make(map[*html.Node]image.Image)
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			children = append(children, &html.Node{Type: html.TextNode, Data: text.String()})
+			text.Reset()
+		}
+	}
+	for _, item := range e.content {
+		switch item.Type {
+		case css.ContentString:
+			text.WriteString(item.Value)
+		case css.ContentAttr:
+			if e.Parent != nil {
+				text.WriteString(e.Parent.GetAttribute(strings.ToLower(item.Value)))
+			}
+		case css.ContentCounter:
+			text.WriteString(e.counterStyleDefs.Format(s.counter(item.Value), item.Style))
+		case css.ContentCounters:
+			stack := s.counters[item.Value]
+			if len(stack) == 0 {
+				text.WriteString(e.counterStyleDefs.Format(0, item.Style))
+			}
+			for i, c := range stack {
+				if i > 0 {
+					text.WriteString(item.Separator)
+				}
+				text.WriteString(e.counterStyleDefs.Format(c.value, item.Style))
+			}
+		case css.ContentOpenQuote:
+			if quotes := e.GetQuotes(); len(quotes) > 0 {
+				if s.quoteDepth < len(quotes) {
+					text.WriteString(quotes[s.quoteDepth][0])
+				} else {
+					text.WriteString(quotes[len(quotes)-1][0])
+				}
+			}
+			s.quoteDepth++
+		case css.ContentCloseQuote:
+			if s.quoteDepth == 0 {
+				continue
+			}
+			s.quoteDepth--
+			if quotes := e.GetQuotes(); len(quotes) > 0 {
+				if s.quoteDepth < len(quotes) {
+					text.WriteString(quotes[s.quoteDepth][1])
+				} else {
+					text.WriteString(quotes[len(quotes)-1][1])
+				}
+			}
+		case css.ContentNoOpenQuote:
+			s.quoteDepth++
+		case css.ContentNoCloseQuote:
+			if s.quoteDepth > 0 {
+				s.quoteDepth--
+			}
+		case css.ContentURL:
+			var img image.Image
+			if e.PseudoElement == "marker" {
+				// The image was already loaded when the
+				// list item's marker was set.
+				img = e.Parent.markerImage
+			} else {
+				img = e.loadImage(item.Value)
+			}
+			// Images that can't be loaded are left out.
+			if img == nil {
+				continue
+			}
+			flush()
+			n := &html.Node{
+				Type: html.ElementNode,
+				Data: "img",
+				Attr: []html.Attribute{{Key: "src", Val: item.Value}},
+			}
+			images[n] = img
+			children = append(children, n)
+		}
+	}
+	flush()
+
+	if e.layoutDone && e.hasChildren(children) {
+		// The content didn't change, so the boxes that were already
+		// laid out for it are kept.
+		return
+	}
+	e.invalidateBranch()
+	e.FirstChild = nil
+	var prev *RenderableDomElement
+	for _, n := range children {
+		styles := new(css.StyledElement)
+		size, _ := e.Styles.GetFontSize()
+		styles.SetFontSize(size)
+		c := &RenderableDomElement{
+			Element:      (*dom.Element)(n),
+			Styles:       styles,
+			Parent:       e,
+			PrevSibling:  prev,
+			PageLocation: e.PageLocation,
+			resolver:     e.resolver,
+
+			counterStyleDefs: e.counterStyleDefs,
+			generatedImage:   images[n],
+		}
+		c.ConditionalStyles.Unconditional = styles
+		c.ConditionalStyles.FirstLine = styles
+		c.ConditionalStyles.FirstLetter = styles
+		if prev == nil {
+			e.FirstChild = c
+		} else {
+			prev.NextSibling = c
+		}
+		prev = c
+	}
+}
+
+// hasChildren reports whether the children of e are boxes for the same
+// text and images as the nodes in children.
+func (e *RenderableDomElement) hasChildren(children []*html.Node) bool {
+	c := e.FirstChild
+	for _, n := range children {
+		if c == nil || c.Type != n.Type || c.Data != n.Data {
+			return false
+		}
+		if n.Type == html.ElementNode && c.GetAttribute("src") != n.Attr[0].Val {
+			return false
+		}
+		c = c.NextSibling
+	}
+	return c == nil
+}
+
+// GetQuotes returns the pairs of quotes used by open-quote and close-quote,
+// from the outermost level of nesting to the innermost.
+func (e *RenderableDomElement) GetQuotes() [][2]string {
+	quotes, err := css.ParseQuotes(e.Styles.Quotes.Value)
+	if err != nil {
+		if e.Parent == nil {
+			return css.DefaultQuotes
+		}
+		return e.Parent.GetQuotes()
+	}
+	return quotes
+}
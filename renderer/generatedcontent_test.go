@@ -0,0 +1,204 @@
+package renderer
+
+import (
+	"context"
+	"errors"
+	"image"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/driusan/gob/net"
+	"golang.org/x/net/html"
+)
+
+// generatedText returns the text generated for el's ::before or ::after
+// pseudo-element.
+func generatedText(el *RenderableDomElement, name string) (string, bool) {
+	pseudo := el.pseudoElement(name)
+	if pseudo == nil {
+		return "", false
+	}
+	var text string
+	for c := pseudo.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text += c.Data
+		}
+	}
+	return text, true
+}
+
+// elementsByID returns the elements in the page which have an id.
+func elementsByID(page Page) map[string]*RenderableDomElement {
+	ids := make(map[string]*RenderableDomElement)
+	page.Content.Walk(func(el *RenderableDomElement) {
+		if id := el.GetAttribute("id"); id != "" {
+			ids[id] = el
+		}
+	})
+	return ids
+}
+
+func TestGeneratedContent(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		body { counter-reset: section }
+		h2 { counter-increment: section }
+		h2::before { content: "Section " counter(section) ": " }
+		h2.roman::before { content: counter(section, none) }
+		q { quotes: "<<" ">>" "<" ">" }
+		q::before { content: open-quote }
+		q::after { content: close-quote }
+		a::after { content: " (" attr(href) ")" }
+		ol { counter-reset: item }
+		li { display: block; counter-increment: item }
+		li::before { content: counters(item, ".") " " }
+		.hidden { display: none }
+		img::before { content: "Never" }
+		.icon::before { content: url(15x15.png) "Icon" }
+		.none::before { content: none }
+		</style>
+	</head>
+	<body>
+		<h2 id="first">First</h2>
+		<h2 class="hidden">Hidden</h2>
+		<h2 id="second">Second</h2>
+		<h2 id="roman" class="roman">Third</h2>
+		<p><q id="outer">Outer <q id="inner">inner</q></q></p>
+		<p><a id="link" href="foo.html">Link</a></p>
+		<ol>
+			<li id="li1">One</li>
+			<li id="li2">Two
+				<ol>
+					<li id="li21">Nested</li>
+					<li id="li22">Nested</li>
+				</ol>
+			</li>
+			<li id="li3">Three</li>
+		</ol>
+		<img id="img" src="15x15.png">
+		<span id="icon" class="icon">Text</span>
+		<span id="none" class="none">Text</span>
+	</body>
+</html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	ids := elementsByID(page)
+
+	tests := []struct {
+		ID, PseudoElement string
+		Expected          string
+	}{
+		{"first", "before", "Section 1: "},
+		{"second", "before", "Section 2: "},
+		{"roman", "before", ""},
+		{"outer", "before", "<<"},
+		{"outer", "after", ">>"},
+		{"inner", "before", "<"},
+		{"inner", "after", ">"},
+		{"link", "after", " (foo.html)"},
+		{"li1", "before", "1 "},
+		{"li2", "before", "2 "},
+		{"li21", "before", "2.1 "},
+		{"li22", "before", "2.2 "},
+		{"li3", "before", "3 "},
+		{"icon", "before", "Icon"},
+	}
+	for _, tc := range tests {
+		got, ok := generatedText(ids[tc.ID], tc.PseudoElement)
+		if !ok {
+			t.Errorf("No ::%s generated for %s", tc.PseudoElement, tc.ID)
+			continue
+		}
+		if got != tc.Expected {
+			t.Errorf("Unexpected ::%s content for %s: got %q want %q", tc.PseudoElement, tc.ID, got, tc.Expected)
+		}
+	}
+
+	if c := ids["icon"].pseudoElement("before").FirstChild; c == nil || c.Data != "img" || c.GetAttribute("src") != "15x15.png" {
+		t.Errorf("Unexpected url() content: got %v", c)
+	}
+	if _, ok := generatedText(ids["img"], "before"); ok {
+		t.Error("Replaced element has a ::before pseudo-element")
+	}
+	if _, ok := generatedText(ids["none"], "before"); ok {
+		t.Error("content: none generated a ::before pseudo-element")
+	}
+}
+
+// Test that pseudo-elements are added and removed when the state of the
+// element they're generated for changes.
+func TestDynamicGeneratedContent(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		span:hover::after { content: "!" }
+		</style>
+	</head>
+	<body>
+		<span id="span">Hover</span>
+	</body>
+</html>`,
+	)
+	span := elementsByID(page)["span"]
+	if _, ok := generatedText(span, "after"); ok {
+		t.Fatal("Unexpected ::after before hovering")
+	}
+	if len(page.SetHover(span)) == 0 {
+		t.Error("Hovering did not change the styles")
+	}
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	if got, _ := generatedText(span, "after"); got != "!" {
+		t.Errorf("Unexpected ::after content while hovering: got %q want %q", got, "!")
+	}
+	if len(page.SetHover(nil)) == 0 {
+		t.Error("Unhovering did not change the styles")
+	}
+	if _, ok := generatedText(span, "after"); ok {
+		t.Error("Unexpected ::after after hovering")
+	}
+	if span.FirstChild == nil || span.FirstChild.NextSibling != nil {
+		t.Error("Removing ::after did not restore the children")
+	}
+}
+
+// failingLoader fails to load every URL, as if the host couldn't be
+// reached.
+type failingLoader struct {
+	net.DefaultReader
+}
+
+func (l failingLoader) GetURL(u *url.URL) (io.ReadCloser, int, error) {
+	return nil, 0, errors.New("dial tcp: lookup " + u.Host + ": no such host")
+}
+
+// Test that images in content which can't be loaded are left out, rather
+// than stopping the page from loading.
+func TestGeneratedContentMissingImage(t *testing.T) {
+	u, err := url.Parse("https://example.com/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := LoadPage(strings.NewReader(`<html><head><style>
+	p::before { content: url(missing.png) "Text" url("data:image/svg+xml,<svg></svg>") }
+</style></head>
+<body><p id="p">Paragraph</p></body>
+</html>`), failingLoader{}, u)
+	page.Content.Layout(context.TODO(), image.Point{400, 300})
+
+	p := elementsByID(page)["p"]
+	if got, _ := generatedText(p, "before"); got != "Text" {
+		t.Errorf("Unexpected ::before content: got %q want %q", got, "Text")
+	}
+	for c := p.pseudoElement("before").FirstChild; c != nil; c = c.NextSibling {
+		if c.Data == "img" {
+			t.Errorf("Unexpected img for an image that couldn't be loaded")
+		}
+	}
+}
@@ -77,13 +77,42 @@ type RenderableDomElement struct {
 	resolver   net.URLReader
 	layoutDone bool
 
+	// The results of the last layout pass, which are reused when the
+	// parent is laid out again without any changes to this element.
+	layoutResult    image.Image
+	layoutResultDot image.Point
+	// Whether the last layout pass depended on nothing outside of the
+	// element except for its width, so that it can be reused.
+	reusableLayout bool
+
+	// The size of the viewport that viewport-relative units are resolved
+	// against. Only set on the root element.
+	viewport image.Point
+
 	leftFloats, rightFloats FloatStack
 
-	// The number of child bullet items that have been drawn.
-	// Used for determining the counter to place next to the list item
-	numBullets int
+	// The value of the list-item counter for list items. Used for
+	// determining the marker to place next to the list item.
+	listItem int
+	// The content of the marker for list items, and the decoded image
+	// if it's a list-style-image.
+	marker      []css.ContentItem
+	markerImage image.Image
+
+	// The counter styles defined by the page, for formatting counters
+	// and list markers.
+	counterStyleDefs css.CounterStyles
 
 	State css.State
+
+	// The name of the pseudo-element ("before", "after" or "marker") that this
+	// element was generated for, if it isn't from the document.
+	PseudoElement string
+	// The value of the content property for pseudo-elements.
+	content []css.ContentItem
+	// The decoded image for an img generated by a url() in content,
+	// which is used instead of loading its src again.
+	generatedImage image.Image
 }
 
 func (e RenderableDomElement) String() string {
@@ -124,7 +153,15 @@ func (lb lineBox) Baseline() int {
 
 func (lb lineBox) LineHeight() int {
 	lb.el.Styles = lb.styles
-	return lb.el.GetLineHeight()
+	lh := lb.el.GetLineHeight()
+	if lb.metrics != nil && lb.el.hasNormalLineHeight() {
+		// A normal line height needs to be tall enough for any
+		// fallback fonts used by the text, too.
+		if h := (lb.metrics.Ascent + lb.metrics.Descent).Ceil(); h > lh {
+			return h
+		}
+	}
+	return lh
 }
 
 func (lb lineBox) Height() int {
@@ -166,6 +203,11 @@ func (lb lineBox) getFontDrawer(dst draw.Image, dot image.Point) (drawer font.Dr
 
 	fSize = lb.el.GetFontSize()
 	fontFace := lb.el.GetFontFace(fSize)
+	if lb.el.FontVariant() == "small-caps" {
+		if face := lb.el.GetSmallCapsFace(fSize); face != nil {
+			fontFace = face
+		}
+	}
 
 	clr := lb.el.GetColor()
 	drawer = font.Drawer{
@@ -181,7 +223,10 @@ func (lb lineBox) measureOrDraw(measure bool, fntDrawer *font.Drawer, fSize int)
 	fontFace := fntDrawer.Face
 	defer fontFace.Close()
 
-	smallcaps := lb.el.FontVariant() == "small-caps"
+	// If there's a small-caps font, it's already the drawer's face and
+	// the text is drawn as is, otherwise small-caps are synthesized by
+	// drawing lower case letters as smaller upper case letters.
+	smallcaps := lb.el.FontVariant() == "small-caps" && lb.el.GetSmallCapsFace(fSize) == nil
 
 	var smallFace font.Face
 	if smallcaps {
@@ -222,7 +267,7 @@ func (lb lineBox) measureOrDraw(measure bool, fntDrawer *font.Drawer, fSize int)
 					}
 					chr = strings.ToUpper(chr)
 					if measure {
-						rv += fntDrawer.MeasureString(word)
+						rv += fntDrawer.MeasureString(chr)
 					} else {
 						fntDrawer.DrawString(chr)
 					}
@@ -273,23 +318,20 @@ func (lb lineBox) drawAt(ctx context.Context, dst draw.Image, dot image.Point) e
 	lb.measureOrDraw(false, &fntDrawer, fSize)
 
 	if decoration := lb.el.GetTextDecoration(); decoration != "" && decoration != "none" && decoration != "blink" {
+		// Lines are composited the same way as the text, so that they
+		// respect the alpha of the colour.
+		line := func(y int) {
+			r := image.Rect(dot.X, y, fntDrawer.Dot.X.Ceil(), y+1)
+			draw.Draw(dst, r, &image.Uniform{clr}, image.ZP, draw.Over)
+		}
 		if strings.Contains(decoration, "underline") {
-			y := fntDrawer.Dot.Y.Floor() + 1
-			for px := dot.X; px < fntDrawer.Dot.X.Ceil(); px++ {
-				dst.Set(px, y, clr)
-			}
+			line(fntDrawer.Dot.Y.Floor() + 1)
 		}
 		if strings.Contains(decoration, "overline") {
-			y := dot.Y
-			for px := dot.X; px < fntDrawer.Dot.X.Ceil(); px++ {
-				dst.Set(px, y, clr)
-			}
+			line(dot.Y)
 		}
 		if strings.Contains(decoration, "line-through") {
-			y := dot.Y + lb.metrics.Ascent.Floor()/2
-			for px := dot.X; px < fntDrawer.Dot.X.Ceil(); px++ {
-				dst.Set(px, y, clr)
-			}
+			line(dot.Y + lb.metrics.Ascent.Floor()/2)
 		}
 	}
 
@@ -334,8 +376,20 @@ func (e RenderableDomElement) layoutLineBox(remainingWidth int, textContent stri
 
 	fSize := e.GetFontSize()
 	fontFace := e.GetFontFace(fSize)
+	if smallcaps {
+		if face := e.GetSmallCapsFace(fSize); face != nil {
+			fontFace = face
+			smallcaps = false
+		}
+	}
 	defer fontFace.Close()
 	metrics = fontFace.Metrics()
+	defer func() {
+		// Characters which are drawn with a fallback font can make
+		// the line taller than the element's own font.
+		metrics = css.TextMetrics(fontFace, consumed)
+		size.Y = (metrics.Ascent + metrics.Descent).Ceil()
+	}()
 
 	var smallFace font.Face
 	if smallcaps {
@@ -475,6 +529,13 @@ func (e RenderableDomElement) layoutLineBox(remainingWidth int, textContent stri
 
 // Lays out the element into a viewport of size viewportSize.
 func (e *RenderableDomElement) Layout(ctx context.Context, viewportSize image.Point) error {
+	if !e.layoutDone {
+		// Generated content depends on the counters and quotes that
+		// come before it in the document, so it's resolved before
+		// anything is laid out.
+		e.generateContent(newContentState())
+	}
+	e.viewport = viewportSize
 	e.leftFloats = make(FloatStack, 0)
 	e.rightFloats = make(FloatStack, 0)
 	e.layoutPass(ctx, viewportSize.X, image.ZR, &image.Point{0, 0})
@@ -492,7 +553,48 @@ func (e *RenderableDomElement) InvalidateLayout() {
 	if e == nil {
 		return
 	}
+	e.resetLayout()
+
+	if e.FirstChild != nil {
+		e.FirstChild.InvalidateLayout()
+	}
+	if e.NextSibling != nil {
+		e.NextSibling.InvalidateLayout()
+	}
+}
+
+// InvalidateSubtreeLayout invalidates the layout of e and its descendants,
+// and of the ancestors of e whose layout depends on them. The rest of the
+// page keeps its layout, which is reused by the next Layout where possible.
+func (e *RenderableDomElement) InvalidateSubtreeLayout() {
+	if e == nil {
+		return
+	}
+	e.invalidateSubtree()
+	e.Parent.invalidateBranch()
+}
+
+// invalidateSubtree invalidates the layout of e and its descendants, but
+// not of its siblings.
+func (e *RenderableDomElement) invalidateSubtree() {
+	e.resetLayout()
+	if e.FirstChild != nil {
+		e.FirstChild.InvalidateLayout()
+	}
+}
+
+// invalidateBranch invalidates the layout of e and its ancestors, but not
+// of their other descendants.
+func (e *RenderableDomElement) invalidateBranch() {
+	for ; e != nil; e = e.Parent {
+		e.resetLayout()
+	}
+}
+
+// resetLayout discards the layout of e, but not of its children.
+func (e *RenderableDomElement) resetLayout() {
 	e.layoutDone = false
+	e.layoutResult = nil
 	e.CSSOuterBox = nil
 
 	e.ContentOverlay = nil
@@ -501,22 +603,23 @@ func (e *RenderableDomElement) InvalidateLayout() {
 	e.rightFloats = nil
 	e.curLine = nil
 	e.lineBoxes = nil
+}
 
-	if e.FirstChild != nil {
-		e.FirstChild.InvalidateLayout()
-	}
-	if e.NextSibling != nil {
-		e.NextSibling.InvalidateLayout()
-	}
+// canReuseLayout reports whether the last layout of the child c can be
+// used as is when it's laid out again into a container that's width
+// pixels wide.
+func (c *RenderableDomElement) canReuseLayout(width int) bool {
+	return c.layoutDone && c.reusableLayout && c.layoutResult != nil && c.containerWidth == width
 }
 
-func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth int, r image.Rectangle, dot *image.Point) (image.Image, image.Point) {
+func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth int, r image.Rectangle, dot *image.Point) (result image.Image, resultDot image.Point) {
 	var overlayed *DynamicMemoryDrawer
 	if e.layoutDone {
-		return overlayed, image.Point{}
+		return e.layoutResult, e.layoutResultDot
 	}
 	defer func() {
 		e.layoutDone = true
+		e.layoutResult, e.layoutResultDot = result, resultDot
 	}()
 
 	width := e.GetContainerWidth(containerWidth)
@@ -538,6 +641,14 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 			for _, attr := range e.Attr {
 				switch attr.Key {
 				case "src":
+					if img := e.generatedImage; img != nil {
+						e.ContentOverlay = img
+						size := img.Bounds().Size()
+						iwidth = size.X
+						iheight = size.Y
+						loadedImage = true
+						continue
+					}
 					// Seeing this print way too many times.. something's wrong.
 					u, err := url.Parse(attr.Val)
 					if err != nil {
@@ -551,9 +662,11 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 					}
 					if code < 200 || code >= 300 {
 						fmt.Println("Error", code)
+						r.Close()
 						continue
 					}
 					content, format, err := image.Decode(r)
+					r.Close()
 					if err == nil {
 						e.ContentOverlay = content
 						size := content.Bounds().Size()
@@ -583,7 +696,7 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 				}
 			}
 
-			if css := e.Styles.Width.Value; css != "" {
+			if e.ComputedStyle().Width.Type != css.LengthAuto {
 				if w := e.GetWidth(); w > 0 {
 					ewidth = true
 					iwidth = w
@@ -592,7 +705,7 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 					}
 				}
 			}
-			if css := e.Styles.Height.Value; css != "" {
+			if e.ComputedStyle().Height.Type != css.LengthAuto {
 				if h := e.GetHeight(); h > 0 {
 					eheight = true
 					iheight = h
@@ -747,6 +860,22 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 				resetboxprop(c.ConditionalStyles.FirstLine)
 				resetboxprop(c.ConditionalStyles.FirstLetter)
 
+				// The copies are e's own styles, so they're
+				// computed against the same parent as e's were,
+				// rather than inheriting from e.
+				var parent *css.ComputedStyle
+				if e.Parent != nil && e.Parent.ConditionalStyles.Unconditional != nil {
+					parent = e.Parent.ConditionalStyles.Unconditional.ComputedStyle()
+				}
+				for _, styles := range []*css.StyledElement{
+					c.Styles,
+					c.ConditionalStyles.Unconditional,
+					c.ConditionalStyles.FirstLine,
+					c.ConditionalStyles.FirstLetter,
+				} {
+					styles.ComputeStyle(parent, c.units())
+				}
+
 			}
 
 			lfWidth := e.leftFloats.WidthAt(*dot)
@@ -755,7 +884,6 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 				dot.X = lfWidth
 			}
 
-			dot.X += c.listIndent()
 			if firstLine == true {
 				c.Styles = c.ConditionalStyles.FirstLine
 				dot.X += c.GetTextIndent(width)
@@ -936,6 +1064,11 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 					dot.X += c.GetMarginLeftSize()
 				}
 
+				// Inlines depend on where they start on the
+				// line, so they're always laid out again.
+				if c.layoutDone {
+					c.invalidateSubtree()
+				}
 				if c.GetFloat() == "none" {
 					c.leftFloats = e.leftFloats
 					c.rightFloats = e.rightFloats
@@ -1011,7 +1144,13 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 				// draw the border, background, and CSS outer box.
 				cdot := image.Point{0, 0}
 
-				if c.GetDisplayProp() == "block" && float == "none" {
+				// Blocks in the normal flow are laid out in
+				// their own coordinate space, so unless there
+				// are floats beside them they don't need to be
+				// laid out again if nothing inside them changed.
+				inFlow := c.GetDisplayProp() == "block" && float == "none"
+				var leftFloats, rightFloats FloatStack
+				if inFlow {
 					// We tell the element that it has the whole width,
 					// but add new floats (adjusted to the child's coordinate
 					// space) so that if it goes past the existing floats it'll
@@ -1021,21 +1160,29 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 					// Floats don't inherit the other floats, because the parent
 					// will make them collide and move them appropriately, they
 					// don't take up line space from each other internally.
-					c.leftFloats = make(FloatStack, 0, len(e.leftFloats))
-					c.rightFloats = make(FloatStack, len(e.rightFloats))
+					leftFloats = make(FloatStack, 0, len(e.leftFloats))
+					rightFloats = make(FloatStack, len(e.rightFloats))
 					for _, lf := range e.leftFloats {
 						float := new(RenderableDomElement)
 						float.BoxDrawRectangle = lf.BoxDrawRectangle.Sub(image.Point{0, dot.Y})
 						if float.BoxDrawRectangle.Max.Y > 0 {
 							//if float.BoxDrawRectangle.Max.X > 0 && float.BoxDrawRectangle.Max.Y > 0 {
-							c.leftFloats = append(c.leftFloats, float)
+							leftFloats = append(leftFloats, float)
 						}
 					}
 					for i, rf := range e.rightFloats {
-						c.rightFloats[i] = new(RenderableDomElement)
-						c.rightFloats[i].BoxDrawRectangle = rf.BoxDrawRectangle.Sub(image.Point{dot.X, dot.Y})
+						rightFloats[i] = new(RenderableDomElement)
+						rightFloats[i].BoxDrawRectangle = rf.BoxDrawRectangle.Sub(image.Point{dot.X, dot.Y})
 					}
 				}
+				reusable := inFlow && !c.isReplaced() && len(leftFloats) == 0 && len(rightFloats) == 0
+				if c.layoutDone && !(reusable && c.canReuseLayout(width)) {
+					c.invalidateSubtree()
+				}
+				c.reusableLayout = reusable
+				if inFlow {
+					c.leftFloats, c.rightFloats = leftFloats, rightFloats
+				}
 				var childContent image.Image
 				childContent, dotAdj := c.layoutPass(ctx, width, image.ZR, &cdot)
 				c.ContentOverlay = childContent
@@ -1126,47 +1273,6 @@ func (e *RenderableDomElement) layoutPass(ctx context.Context, containerWidth in
 	return overlayed, *dot
 }
 
-func (e *RenderableDomElement) drawBullet(dst draw.Image, drawRectangle, contentRectangle image.Point, bulletNum int) {
-	clr := e.GetColor()
-	fSize := e.GetFontSize()
-	fontFace := e.GetFontFace(fSize)
-	fntDrawer := font.Drawer{
-		Dst:  dst,
-		Src:  &image.Uniform{clr},
-		Face: fontFace,
-	}
-	// FIXME: Add list-style-type support
-	// normal bullet:
-	bullet := "\u2219"
-	if e.GetListStyleType() == "decimal" {
-		bullet = fmt.Sprintf("%d.", bulletNum)
-	}
-	bulletSize := fntDrawer.MeasureString(bullet)
-	fontMetrics := fontFace.Metrics()
-	bulletOffset := e.listIndent()
-	fntDrawer.Dot = fixed.P(
-		// Center the X coordinate in the middle of the empty space between the draw rectangle
-		// and the content rectangle
-		drawRectangle.X+contentRectangle.X+bulletOffset-(20-bulletSize.Ceil()/2),
-		// And have the height at the top of the first line.
-		drawRectangle.Y+contentRectangle.Y+fontMetrics.Ascent.Floor(),
-	)
-	fntDrawer.DrawString(bullet)
-}
-
-func (e *RenderableDomElement) listIndent() int {
-	if e == nil {
-		return 0
-	}
-	i := 0
-	for c := e; c != nil; c = c.Parent {
-		if c.GetDisplayProp() == "list-item" {
-			i += 40
-		}
-	}
-	return i
-}
-
 // positions child c, which has the size size as a right floating element.
 // dot is used to get the starting height to position the float.
 func (e *RenderableDomElement) positionRightFloat(dot image.Point, size image.Point, c *RenderableDomElement) {
@@ -1510,6 +1616,10 @@ func (e *RenderableDomElement) drawInto(ctx context.Context, dst draw.Image, cur
 		}
 	}
 
+	if e.GetDisplayProp() == "list-item" {
+		e.drawMarker(dst, cursor)
+	}
+
 	absrect := e.getAbsoluteDrawRectangle()
 
 	for _, box := range e.lineBoxes {
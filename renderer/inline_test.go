@@ -14,7 +14,7 @@ func TestInlineLineHeight(t *testing.T) {
 		t,
 		`<html>
 		<head>
-			<style>html, body { padding: 0; margin 0; }</style>
+			<style>html, body { padding: 0; margin: 0; }</style>
 		</head>
 		<body style="line-height: 20px; font-size: 14px;">
 			This is inline text within the body. It has a line-height of 20px,
@@ -50,7 +50,7 @@ func TestInlineSmallLineHeight(t *testing.T) {
 		t,
 		`<html>
 		<head>
-			<style>html, body { padding: 0; margin 0; }</style>
+			<style>html, body { padding: 0; margin: 0; }</style>
 		</head>
 		<body style="line-height: 14px; font-size: 20px;">
 			This is inline text within the body. It has a line-height of 14px,
@@ -86,7 +86,7 @@ func TestInlineBorder(t *testing.T) {
 		t,
 		`<html>
 		<head>
-			<style>html, body { padding: 0; margin 0; }</style>
+			<style>html, body { padding: 0; margin: 0; }</style>
 		</head>
 		<body style="line-height: 20px; font-size: 12px">
 		before <span style="border: 1px solid green; padding: 1px;">
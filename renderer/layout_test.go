@@ -2,6 +2,7 @@ package renderer
 
 import (
 	"bytes"
+	"encoding/binary"
 	"image"
 	"image/color"
 	"image/png"
@@ -14,6 +15,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/driusan/fonts"
 	"github.com/driusan/gob/net"
 )
 
@@ -33,6 +35,9 @@ func (r colouredRect) At(x, y int) color.Color {
 	return color.Transparent
 }
 func (t testLoader) GetURL(u *url.URL) (io.ReadCloser, int, error) {
+	if u.Scheme == "file" {
+		return t.DefaultReader.GetURL(u)
+	}
 	// Fake some URLs used by the test suite.
 	switch u.Path {
 	case "/100x50.png":
@@ -59,6 +64,29 @@ func (t testLoader) GetURL(u *url.URL) (io.ReadCloser, int, error) {
 		}
 		reader := bytes.NewReader(b.Bytes())
 		return ioutil.NopCloser(reader), 200, nil
+	case "/brand.ttf":
+		b, err := fonts.Asset("DejaVuSerif-Bold.ttf")
+		if err != nil {
+			return nil, 500, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), 200, nil
+	case "/tall.ttf":
+		// DejaVu Sans with twice the ascent, so that lines using it
+		// are taller.
+		b, err := fonts.Asset("DejaVuSans.ttf")
+		if err != nil {
+			return nil, 500, err
+		}
+		b = append([]byte(nil), b...)
+		for i := 0; i < int(binary.BigEndian.Uint16(b[4:])); i++ {
+			if entry := b[12+16*i:]; string(entry[:4]) == "hhea" {
+				ascender := b[binary.BigEndian.Uint32(entry[8:])+4:]
+				binary.BigEndian.PutUint16(ascender, 2*binary.BigEndian.Uint16(ascender))
+			}
+		}
+		return ioutil.NopCloser(bytes.NewReader(b)), 200, nil
+	case "/missing.png":
+		return ioutil.NopCloser(&bytes.Buffer{}), 404, nil
 	default:
 		panic("Unhandled test path: " + u.Path)
 	}
@@ -627,3 +655,99 @@ TestTestTest TestTestTest
 	}
 
 }
+
+func TestMathFunctionWidths(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<body style="margin: 0">
+		<div style="width: 400px">
+			<div id="calc" style="width: calc(100% - 2em); height: 10px"></div>
+			<div id="clamp" style="width: clamp(50px, 10%, 100px); height: 10px"></div>
+			<div id="invalid" style="width: calc(100% - 2); height: 10px"></div>
+		</div>
+	</body>
+</html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	ids := elementsByID(page)
+	fontSize := ids["calc"].GetFontSize()
+	tests := map[string]int{
+		"calc":  400 - 2*fontSize,
+		"clamp": 50,
+		// Invalid, so the same as auto.
+		"invalid": 400,
+	}
+	for id, want := range tests {
+		if got := ids[id].BoxDrawRectangle.Dx(); got != want {
+			t.Errorf("Unexpected width for %s: got %v want %v", id, got, want)
+		}
+	}
+}
+
+func TestRelativeUnits(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html style="font-size: 20px">
+	<body style="margin: 0">
+		<div id="vw" style="width: 50vw; height: 10vh"></div>
+		<div id="rem" style="font-size: 10px; width: 10rem; height: 2em"></div>
+		<div id="font" style="font-size: 5vw; width: 2em; height: 1px"></div>
+		<div id="parent" style="font-size: 30px"><div id="em" style="font-size: 0.5em"></div></div>
+	</body>
+</html>`,
+	)
+	page.SetViewportSize(image.Point{400, 300})
+	page.Content.Layout(context.TODO(), image.Point{400, 300})
+	ids := elementsByID(page)
+
+	tests := []struct {
+		ID            string
+		Width, Height int
+	}{
+		{"vw", 200, 30},
+		{"rem", 200, 20},
+		{"font", 40, 1},
+	}
+	for _, tc := range tests {
+		if got := ids[tc.ID].BoxDrawRectangle.Size(); got != (image.Point{tc.Width, tc.Height}) {
+			t.Errorf("Unexpected size for %s: got %v want %v", tc.ID, got, image.Point{tc.Width, tc.Height})
+		}
+	}
+	if got := ids["em"].GetFontSize(); got != 15 {
+		t.Errorf("Unexpected em font size: got %v want 15", got)
+	}
+
+	// Viewport units are resolved again when the window is resized.
+	page.SetViewportSize(image.Point{800, 600})
+	page.Content.InvalidateLayout()
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	ids = elementsByID(page)
+	if got := ids["vw"].BoxDrawRectangle.Size(); got != (image.Point{400, 60}) {
+		t.Errorf("Unexpected size after resize: got %v want %v", got, image.Point{400, 60})
+	}
+	if got := ids["font"].GetFontSize(); got != 40 {
+		t.Errorf("Unexpected font size after resize: got %v want 40", got)
+	}
+}
+
+// Test that border widths in viewport units are resolved at layout time, so
+// that they change with the viewport even if styles aren't reapplied.
+func TestViewportBorderWidths(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><body><div id="border" style="border: 1vw solid black"></div></body></html>`,
+	)
+	page.SetViewportSize(image.Point{400, 300})
+	page.Content.Layout(context.TODO(), image.Point{400, 300})
+	if got := elementsByID(page)["border"].GetBorderLeftWidth(); got != 4 {
+		t.Errorf("Unexpected border width: got %v want 4", got)
+	}
+
+	page.SetViewportSize(image.Point{800, 600})
+	page.Content.InvalidateLayout()
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	if got := elementsByID(page)["border"].GetBorderLeftWidth(); got != 8 {
+		t.Errorf("Unexpected border width after resize: got %v want 8", got)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"fmt"
 	"image"
 	"testing"
+
+	"github.com/driusan/gob/css"
 )
 
 // Test that when an image is in the middle of a line, the lineheight for the
@@ -478,3 +480,37 @@ func TestImgNegativeMarginBorder(t *testing.T) {
 	}
 
 }
+
+// Test that a line containing characters which are drawn with a fallback
+// font is tall enough for the fallback font.
+func TestFallbackFontLineheight(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><head><style>
+	@font-face { font-family: Latin; src: url(/brand.ttf); unicode-range: U+0-7F; }
+	@font-face { font-family: Tall; src: url(/tall.ttf); }
+	p { font-family: Latin, Tall; }
+</style></head>
+<body>
+	<p id="plain">Plain<br>next</p>
+	<p id="accented">Accentu&eacute;<br>next</p>
+	<p id="fixed" style="line-height: 20px">Accentu&eacute;<br>next</p>
+</body>
+</html>`,
+	)
+	defer css.LoadFontFaces(testLoader{}, nil)
+	page.Content.Layout(context.TODO(), image.Point{500, 300})
+	els := elementsByID(page)
+	nextLine := func(id string) int {
+		lbs := els[id].lineBoxes
+		return lbs[len(lbs)-1].origin.Y
+	}
+	// The accented character is drawn with the tall font, so the next
+	// line starts lower down, unless the line height is fixed.
+	if plain, accented := nextLine("plain"), nextLine("accented"); accented <= plain {
+		t.Errorf("Expected the line with a fallback font to be taller: next line at %v, after plain line at %v", accented, plain)
+	}
+	if got := nextLine("fixed"); got != 20 {
+		t.Errorf("Unexpected Y position for line after fixed line height: got %v want 20", got)
+	}
+}
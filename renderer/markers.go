@@ -0,0 +1,113 @@
+package renderer
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/driusan/gob/css"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// setMarker works out the marker for the list item e from its list-style
+// properties and the current value of its list-item counter.
+func (e *RenderableDomElement) setMarker() {
+	e.marker, e.markerImage = nil, nil
+	if u := e.listStyleImageURL(); u != "" {
+		// If the image can't be loaded, list-style-type is used
+		// instead.
+		if img := e.loadImage(u); img != nil {
+			e.marker = []css.ContentItem{{Type: css.ContentURL, Value: u}}
+			e.markerImage = img
+			return
+		}
+	}
+	switch typ := e.GetListStyleType(); typ {
+	case "none":
+	default:
+		items, err := css.ParseContent(typ)
+		if err == nil && len(items) == 1 && items[0].Type == css.ContentString {
+			// A string is used as the marker as-is.
+			e.marker = items
+			return
+		}
+		e.marker = []css.ContentItem{{
+			Type:  css.ContentString,
+			Value: e.counterStyleDefs.Marker(e.listItem, typ),
+		}}
+	}
+}
+
+// markerText returns the text of e's marker, or an empty string if it
+// doesn't have one or the marker is an image.
+func (e *RenderableDomElement) markerText() string {
+	var text string
+	for _, item := range e.marker {
+		if item.Type == css.ContentString {
+			text += item.Value
+		}
+	}
+	return text
+}
+
+// firstLineBox returns the first line box in the list item e and the block
+// which contains it, or nil if it doesn't have any.
+func (e *RenderableDomElement) firstLineBox() (*RenderableDomElement, *lineBox) {
+	if len(e.lineBoxes) > 0 {
+		return e, e.lineBoxes[0]
+	}
+	for c := e.FirstChild; c != nil; c = c.NextSibling {
+		if c.Element == nil || c.PseudoElement == "marker" {
+			continue
+		}
+		switch c.GetDisplayProp() {
+		case "none", "inline":
+			continue
+		}
+		if c.GetFloat() != "none" {
+			continue
+		}
+		if block, lb := c.firstLineBox(); lb != nil {
+			return block, lb
+		}
+	}
+	return nil, nil
+}
+
+// drawMarker draws the marker of the list item e into dst if it's outside
+// of the principal box. The marker ends at the left edge of the content box,
+// and has the same baseline as the first line of the list item.
+func (e *RenderableDomElement) drawMarker(dst draw.Image, cursor image.Point) {
+	if e.GetListStylePosition() != "outside" || len(e.marker) == 0 {
+		return
+	}
+	content := e.getAbsoluteDrawRectangle().Min.Add(e.BoxContentRectangle.Min)
+
+	fontFace := e.GetFontFace(e.GetFontSize())
+	defer fontFace.Close()
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  &image.Uniform{e.GetColor()},
+		Face: fontFace,
+	}
+
+	baseline := content.Y + fontFace.Metrics().Ascent.Ceil()
+	if block, lb := e.firstLineBox(); lb != nil {
+		blockContent := block.getAbsoluteDrawRectangle().Min.Add(block.BoxContentRectangle.Min)
+		baseline = blockContent.Y + lb.origin.Y + lb.Baseline()
+	}
+
+	if img := e.markerImage; img != nil {
+		// Images are followed by a space, like the suffix of the
+		// predefined counter styles.
+		size := img.Bounds().Size()
+		right := content.X - drawer.MeasureString(" ").Ceil()
+		r := image.Rect(right-size.X, baseline-size.Y, right, baseline)
+		draw.Draw(dst, r.Sub(cursor), img, img.Bounds().Min, draw.Over)
+		return
+	}
+	text := e.markerText()
+	width := drawer.MeasureString(text).Ceil()
+	drawer.Dot = fixed.P(content.X-width-cursor.X, baseline-cursor.Y)
+	drawer.DrawString(text)
+}
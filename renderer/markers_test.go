@@ -0,0 +1,220 @@
+package renderer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestListMarkers(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		@counter-style paren { system: extends decimal; prefix: "("; suffix: ") " }
+		.roman { list-style-type: upper-roman }
+		.alpha { list-style: lower-alpha inside }
+		.string { list-style-type: "-> " }
+		.none { list-style: none }
+		.paren { list-style-type: paren }
+		.unknown { list-style-type: unknown }
+		.image { list-style-image: url(15x15.png) }
+		.missing { list-style: square url(missing.png) }
+		</style>
+	</head>
+	<body>
+		<ul>
+			<li id="disc">Disc</li>
+			<li id="disc-inside" style="list-style-position: inside">Disc</li>
+			<li><ul><li id="circle">Circle</li></ul></li>
+		</ul>
+		<ol>
+			<li id="decimal">One</li>
+			<li id="roman" class="roman">Two</li>
+			<li id="alpha" class="alpha">Three</li>
+			<li id="string" class="string">Four</li>
+			<li id="none" class="none">Five</li>
+			<li id="paren" class="paren">Six</li>
+			<li id="unknown" class="unknown">Seven</li>
+			<li id="image" class="image">Eight</li>
+			<li id="missing" class="missing">Nine</li>
+		</ol>
+	</body>
+</html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	ids := elementsByID(page)
+
+	tests := []struct {
+		ID       string
+		Expected string
+		Inside   bool
+	}{
+		{"disc", "• ", false},
+		{"disc-inside", "• ", true},
+		{"circle", "◦ ", false},
+		{"decimal", "1. ", false},
+		{"roman", "II. ", false},
+		{"alpha", "c. ", true},
+		{"string", "-> ", false},
+		{"none", "", false},
+		{"paren", "(6) ", false},
+		{"unknown", "7. ", false},
+		{"image", "", false},
+		// Images that can't be loaded use the list-style-type.
+		{"missing", "▪ ", false},
+	}
+	for _, tc := range tests {
+		el := ids[tc.ID]
+		if got := el.markerText(); got != tc.Expected {
+			t.Errorf("Unexpected marker for %s: got %q want %q", tc.ID, got, tc.Expected)
+		}
+		text, ok := generatedText(el, "marker")
+		if ok != tc.Inside {
+			t.Errorf("Unexpected ::marker box for %s: got %v want %v", tc.ID, ok, tc.Inside)
+		} else if ok && text != tc.Expected {
+			t.Errorf("Unexpected ::marker content for %s: got %q want %q", tc.ID, text, tc.Expected)
+		}
+	}
+	if ids["image"].markerImage == nil {
+		t.Error("Expected an image marker for list-style-image")
+	}
+}
+
+// countingLoader counts how many times the path of each URL is loaded, and
+// how many of the readers for it haven't been closed.
+type countingLoader struct {
+	testLoader
+	loads, open map[string]int
+}
+
+func newCountingLoader() countingLoader {
+	return countingLoader{loads: make(map[string]int), open: make(map[string]int)}
+}
+
+func (l countingLoader) GetURL(u *url.URL) (io.ReadCloser, int, error) {
+	l.loads[u.Path]++
+	r, code, err := l.testLoader.GetURL(u)
+	if r == nil {
+		return r, code, err
+	}
+	l.open[u.Path]++
+	return closeCounter{r, func() { l.open[u.Path]-- }}, code, err
+}
+
+// closeCounter calls onClose when it's closed.
+type closeCounter struct {
+	io.ReadCloser
+	onClose func()
+}
+
+func (c closeCounter) Close() error {
+	c.onClose()
+	return c.ReadCloser.Close()
+}
+
+// Test that the image of a marker inside of the list item is only loaded
+// once, for both the list item and the box of its ::marker.
+func TestInsideImageMarkerLoadedOnce(t *testing.T) {
+	u, err := url.Parse("https://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := newCountingLoader()
+	page := LoadPage(strings.NewReader(`<html>
+	<head>
+		<style>
+		li { list-style: url(15x15.png) inside }
+		</style>
+	</head>
+	<body>
+		<ul><li id="li">Item</li></ul>
+	</body>
+</html>`), loader, u)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+
+	marker := elementsByID(page)["li"].pseudoElement("marker")
+	if marker == nil || marker.FirstChild == nil || marker.FirstChild.generatedImage == nil {
+		t.Fatal("Expected an image in the ::marker box")
+	}
+	if got := loader.loads["/15x15.png"]; got != 1 {
+		t.Errorf("Unexpected number of loads of the marker image: got %v want 1", got)
+	}
+}
+
+// Test that the readers for images are closed once the images have been
+// loaded, including when they can't be.
+func TestImageReadersClosed(t *testing.T) {
+	u, err := url.Parse("https://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := newCountingLoader()
+	page := LoadPage(strings.NewReader(`<html>
+	<head>
+		<style>
+		.image { list-style-image: url(15x15.png) }
+		.missing { list-style: square url(missing.png) }
+		p::before { content: url(15x15.png) }
+		</style>
+	</head>
+	<body>
+		<ul><li class="image">Image</li><li class="missing">Missing</li></ul>
+		<p>Paragraph</p>
+		<img src="100x50.png">
+	</body>
+</html>`), loader, u)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+
+	for _, path := range []string{"/15x15.png", "/missing.png", "/100x50.png"} {
+		if loader.loads[path] == 0 {
+			t.Errorf("%s was not loaded", path)
+		}
+		if n := loader.open[path]; n != 0 {
+			t.Errorf("%v readers for %s were not closed", n, path)
+		}
+	}
+}
+
+func TestOutsideMarkerDrawn(t *testing.T) {
+	for _, tc := range []struct {
+		Style  string
+		Marker bool
+	}{
+		{"", true},
+		{"list-style-type: none", false},
+		{"list-style-position: inside", false},
+	} {
+		page := parseHTML(
+			t,
+			`<html>
+	<body style="margin: 0">
+		<ul style="margin: 0; padding-left: 40px">
+			<li style="color: #F00; `+tc.Style+`">Item</li>
+		</ul>
+	</body>
+</html>`,
+		)
+		canvas := image.NewRGBA(image.Rectangle{image.ZP, image.Point{400, 300}})
+		page.Content.Layout(context.TODO(), canvas.Bounds().Size())
+		page.Content.RenderInto(context.TODO(), canvas, image.ZP)
+
+		red := color.RGBA{255, 0, 0, 255}
+		found := false
+		for x := 0; x < 40; x++ {
+			for y := 0; y < 30; y++ {
+				if colorEQ(canvas.At(x, y), red) {
+					found = true
+				}
+			}
+		}
+		if found != tc.Marker {
+			t.Errorf("%q: unexpected marker in the padding: got %v want %v", tc.Style, found, tc.Marker)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package renderer
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Test that media queries are re-evaluated when the viewport size changes.
+func TestMediaQueryViewport(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		body { background: red }
+		@media (max-width: 500px) {
+			body { background: blue }
+		}
+		</style>
+		<style media="(min-width: 1000px)">
+		body { background: green }
+		</style>
+	</head>
+	<body>
+		The background depends on the viewport width.
+	</body>
+</html>`,
+	)
+
+	tests := []struct {
+		Size     image.Point
+		Expected color.Color
+	}{
+		{image.Point{800, 600}, color.RGBA{255, 0, 0, 255}},
+		{image.Point{400, 600}, color.RGBA{0, 0, 255, 255}},
+		{image.Point{1200, 600}, color.RGBA{0, 128, 0, 255}},
+		{image.Point{800, 600}, color.RGBA{255, 0, 0, 255}},
+	}
+	for i, tc := range tests {
+		page.SetViewportSize(tc.Size)
+		if !colorEQ(page.Background, tc.Expected) {
+			t.Errorf("Unexpected background for test %d (%v): got %v want %v", i, tc.Size, page.Background, tc.Expected)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package renderer
 
 import (
 	"github.com/driusan/gob/css"
+	"golang.org/x/net/html"
 
+	"image"
 	"image/color"
 	"net/url"
 )
@@ -13,7 +15,223 @@ type Page struct {
 	Background color.Color
 	URL        *url.URL
 
-	userAgentStyles, authorStyles css.Stylesheet
+	userAgentStyles, userStyles, authorStyles css.Stylesheet
+
+	// The features that media queries are evaluated against.
+	media css.MediaFeatures
+
+	// The counter styles defined by @counter-style rules.
+	counterStyles css.CounterStyles
+
+	// Maps nodes to the element that renders them, so that selectors
+	// can look up the state of other elements.
+	nodes map[*html.Node]*RenderableDomElement
+
+	// The elements that are currently hovered, active and focused.
+	hover, active, focus *RenderableDomElement
+}
+
+// SetHover marks el and its ancestors as being hovered over, and
+// removes the hover state from the previously hovered element. el may be
+// nil. It returns the topmost elements whose styles changed, which need to
+// be laid out again with InvalidateSubtreeLayout.
+func (p *Page) SetHover(el *RenderableDomElement) []*RenderableDomElement {
+	return p.updateStates(p.hover, el, func() {
+		for e := p.hover; e != nil; e = e.Parent {
+			e.State.Hover = false
+		}
+		for e := el; e != nil; e = e.Parent {
+			e.State.Hover = true
+		}
+		p.hover = el
+	})
+}
+
+// SetActive marks el and its ancestors as being activated (for instance,
+// while the mouse button is held down) and removes the active state from
+// the previously active element. el may be nil. It returns the topmost
+// elements whose styles changed.
+func (p *Page) SetActive(el *RenderableDomElement) []*RenderableDomElement {
+	return p.updateStates(p.active, el, func() {
+		for e := p.active; e != nil; e = e.Parent {
+			e.State.Active = false
+		}
+		for e := el; e != nil; e = e.Parent {
+			e.State.Active = true
+		}
+		p.active = el
+	})
+}
+
+// SetFocus gives el the focus, and marks its ancestors as having focus
+// within them. el may be nil. It returns the topmost elements whose styles
+// changed.
+func (p *Page) SetFocus(el *RenderableDomElement) []*RenderableDomElement {
+	return p.updateStates(p.focus, el, func() {
+		for e := p.focus; e != nil; e = e.Parent {
+			e.State.Focus = false
+			e.State.FocusWithin = false
+		}
+		if el != nil {
+			el.State.Focus = true
+			for e := el.Parent; e != nil; e = e.Parent {
+				e.State.FocusWithin = true
+			}
+		}
+		p.focus = el
+	})
+}
+
+// updateStates calls update to change the state of old, new, and their
+// ancestors, and then restyles the parts of the page that may be affected.
+// It returns the topmost elements whose styles changed.
+func (p *Page) updateStates(old, new *RenderableDomElement, update func()) []*RenderableDomElement {
+	if old == new {
+		return nil
+	}
+	before := make(map[*RenderableDomElement]css.State)
+	for _, el := range []*RenderableDomElement{old, new} {
+		for e := el; e != nil; e = e.Parent {
+			before[e] = e.State
+		}
+	}
+	update()
+
+	if !p.hasDynamicRules() {
+		return nil
+	}
+
+	// A change in state can affect how the element, its descendants and
+	// its later siblings (and their descendants) are styled, so restyle
+	// the subtree of the parent of each element that changed. Ancestors
+	// which depend on the element through :has() are not restyled.
+	var roots []*RenderableDomElement
+	for e, st := range before {
+		if e.State == st {
+			continue
+		}
+		root := e
+		if e.Parent != nil {
+			root = e.Parent
+		}
+		roots = append(roots, root)
+	}
+	var changed []*RenderableDomElement
+	for i, root := range roots {
+		if hasAncestorIn(root, roots) || containsElement(roots[:i], root) {
+			// Already covered by restyling an ancestor, or
+			// the same parent of another element.
+			continue
+		}
+		changed = append(changed, p.restyleChanged(root)...)
+	}
+	if len(changed) > 0 {
+		p.updateBackground()
+	}
+	return changed
+}
+
+// containsElement reports whether el is in els.
+func containsElement(els []*RenderableDomElement, el *RenderableDomElement) bool {
+	for _, e := range els {
+		if e == el {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAncestorIn reports whether any strict ancestor of el is in els.
+func hasAncestorIn(el *RenderableDomElement, els []*RenderableDomElement) bool {
+	for _, e := range els {
+		if e == el {
+			continue
+		}
+		for a := el.Parent; a != nil; a = a.Parent {
+			if a == e {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stylesheets returns the user agent, user and author stylesheets of the
+// page.
+func (p *Page) stylesheets() []css.Stylesheet {
+	return []css.Stylesheet{p.userAgentStyles, p.userStyles, p.authorStyles}
+}
+
+// hasDynamicRules reports whether any rule on the page depends on
+// the state of the elements that it applies to.
+func (p *Page) hasDynamicRules() bool {
+	for _, sheet := range p.stylesheets() {
+		for _, rule := range sheet {
+			if rule.Selector.IsDynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// restyleChanged restyles the subtree rooted at el, and returns the topmost
+// elements in it whose styles changed.
+func (p *Page) restyleChanged(el *RenderableDomElement) []*RenderableDomElement {
+	if el == nil || el.Type != html.ElementNode {
+		return nil
+	}
+	// applyStyles replaces the styles rather than modifying them, so
+	// the old ones can be compared with the new ones afterwards.
+	old := el.ConditionalStyles
+	oldBefore, oldAfter := el.pseudoElementStyle("before"), el.pseudoElementStyle("after")
+	oldMarker := el.pseudoElementStyle("marker")
+	p.applyStyles(el)
+	changed := old.Unconditional == nil ||
+		!old.Unconditional.SameStyle(el.ConditionalStyles.Unconditional) ||
+		!old.FirstLine.SameStyle(el.ConditionalStyles.FirstLine) ||
+		!old.FirstLetter.SameStyle(el.ConditionalStyles.FirstLetter) ||
+		!oldBefore.same(el.pseudoElementStyle("before")) ||
+		!oldAfter.same(el.pseudoElementStyle("after")) ||
+		!oldMarker.same(el.pseudoElementStyle("marker"))
+	var changedChildren []*RenderableDomElement
+	for c := el.FirstChild; c != nil; c = c.NextSibling {
+		if c.PseudoElement == "" {
+			changedChildren = append(changedChildren, p.restyleChanged(c)...)
+		}
+	}
+	if changed {
+		return []*RenderableDomElement{el}
+	}
+	return changedChildren
+}
+
+// SetViewportSize sets the size of the viewport that media queries and
+// viewport-relative units are evaluated against. If the new size changes
+// which rules apply or any font sizes, the styles are reapplied. The caller
+// is still responsible for invalidating the layout.
+func (p *Page) SetViewportSize(size image.Point) {
+	old := p.media
+	p.media.Width, p.media.Height = size.X, size.Y
+	if p.Content == nil {
+		return
+	}
+	oldSize := p.Content.viewport
+	p.Content.viewport = size
+	if size != oldSize && p.hasViewportFontSizes() {
+		// Font sizes are computed when styles are applied, so they
+		// need to be recomputed for the new viewport.
+		p.ReapplyStyles()
+		return
+	}
+	for _, sheet := range p.stylesheets() {
+		for _, rule := range sheet {
+			if rule.Media != nil && rule.MediaMatches(old) != rule.MediaMatches(p.media) {
+				p.ReapplyStyles()
+				return
+			}
+		}
+	}
 }
 
 func (p *Page) getBody() *RenderableDomElement {
@@ -30,3 +248,24 @@ func (p *Page) getBody() *RenderableDomElement {
 	}
 	return nil
 }
+
+// hasViewportFontSizes reports whether the font size of any element on the
+// page depends on the size of the viewport.
+func (p *Page) hasViewportFontSizes() bool {
+	found := false
+	p.Content.Walk(func(el *RenderableDomElement) {
+		if el.Type != html.ElementNode || el.ConditionalStyles.Unconditional == nil {
+			return
+		}
+		for _, styles := range []*css.StyledElement{
+			el.ConditionalStyles.Unconditional,
+			el.ConditionalStyles.FirstLine,
+			el.ConditionalStyles.FirstLetter,
+		} {
+			if css.HasViewportUnits(styles.FontSize.Value) {
+				found = true
+			}
+		}
+	})
+	return found
+}
@@ -7,7 +7,9 @@ import (
 
 	"golang.org/x/net/html"
 
+	"image"
 	"image/color"
+	"image/draw"
 	"io"
 	"net/url"
 	"strconv"
@@ -47,153 +49,273 @@ func LoadPage(r io.Reader, loader net.URLReader, urlContext *url.URL) Page {
 	renderable := convertNodeToRenderableElement(root, loader)
 
 	userAgentStyles, cssOrder := css.ParseStylesheet(css.DefaultCSS, css.UserAgentSrc, loader, urlContext, cssOrder)
+	userStyles, cssOrder := loadUserStyles(loader, urlContext, cssOrder)
 
 	p := Page{
 		Content:         renderable,
 		URL:             urlContext,
 		userAgentStyles: userAgentStyles,
+		userStyles:      userStyles,
 		authorStyles:    styles,
+		nodes:           make(map[*html.Node]*RenderableDomElement),
 	}
+	renderable.Walk(func(el *RenderableDomElement) {
+		p.nodes[(*html.Node)(el.Element)] = el
+	})
+	css.LoadFontFaces(loader, css.NewFontFaces(p.media, p.stylesheets()...))
 	p.ReapplyStyles()
 	return p
 }
 
+// ReapplyStyles recalculates the styles of every element on the page.
 func (p *Page) ReapplyStyles() {
-	cssOrder := uint(0)
-	p.Background = color.Transparent
-	p.Content.Walk(func(el *RenderableDomElement) {
-		el.Styles.ClearStyles()
-		el.ConditionalStyles = struct {
-			Unconditional *css.StyledElement
-			FirstLine     *css.StyledElement
-			FirstLetter   *css.StyledElement
-		}{
-			new(css.StyledElement),
-			new(css.StyledElement),
-			new(css.StyledElement),
-		}
-		el.PageLocation = p.URL
-		for _, rule := range p.userAgentStyles {
-			if rule.Matches((*html.Node)(el.Element), el.State) {
-				if strings.Index(rule.Selector.Selector, "first-line") >= 0 {
-					el.ConditionalStyles.FirstLine.AddStyle(rule)
-				} else if strings.Index(rule.Selector.Selector, "first-letter") >= 0 {
-					el.ConditionalStyles.FirstLetter.AddStyle(rule)
-				} else {
-					el.ConditionalStyles.Unconditional.AddStyle(rule)
-				}
-			}
+	p.counterStyles = css.NewCounterStyles(p.media, p.stylesheets()...)
+	p.restyle(p.Content)
+	p.updateBackground()
+}
+
+// restyle recalculates the styles of el and all of its descendants.
+func (p *Page) restyle(el *RenderableDomElement) {
+	if el == nil || el.Type != html.ElementNode {
+		return
+	}
+	p.applyStyles(el)
+	for c := el.FirstChild; c != nil; c = c.NextSibling {
+		if c.PseudoElement == "" {
+			p.restyle(c)
 		}
+	}
+}
+
+// state returns the dynamic state of the element for the node n.
+func (p *Page) state(n *html.Node) css.State {
+	if el, ok := p.nodes[n]; ok {
+		return el.State
+	}
+	return css.State{}
+}
 
-		for _, rule := range p.authorStyles {
-			if rule.Matches((*html.Node)(el.Element), el.State) {
-				if strings.Index(rule.Selector.Selector, "first-line") >= 0 {
+// applyStyles finds the rules which match el and applies them. The parent's
+// styles must already be applied.
+func (p *Page) applyStyles(el *RenderableDomElement) {
+	cssOrder := uint(0)
+	el.Styles.ClearStyles()
+	el.ConditionalStyles = struct {
+		Unconditional *css.StyledElement
+		FirstLine     *css.StyledElement
+		FirstLetter   *css.StyledElement
+	}{
+		new(css.StyledElement),
+		new(css.StyledElement),
+		new(css.StyledElement),
+	}
+	el.PageLocation = p.URL
+	el.counterStyleDefs = p.counterStyles
+	before, after, marker := new(css.StyledElement), new(css.StyledElement), new(css.StyledElement)
+	// The order that the rules are added in doesn't matter, since
+	// they're sorted by their origin when the styles are sorted.
+	for _, sheet := range p.stylesheets() {
+		for _, rule := range sheet {
+			if rule.MediaMatches(p.media) && rule.MatchesStates((*html.Node)(el.Element), p.state) {
+				switch rule.Selector.PseudoElement() {
+				case "":
+					el.ConditionalStyles.Unconditional.AddStyle(rule)
+				case "first-line":
 					el.ConditionalStyles.FirstLine.AddStyle(rule)
-				} else if strings.Index(rule.Selector.Selector, "first-letter") >= 0 {
+				case "first-letter":
 					el.ConditionalStyles.FirstLetter.AddStyle(rule)
-				} else {
-					el.ConditionalStyles.Unconditional.AddStyle(rule)
+				case "before":
+					before.AddStyle(rule)
+				case "after":
+					after.AddStyle(rule)
+				case "marker":
+					marker.AddStyle(rule)
 				}
 			}
 		}
+	}
 
-		for _, attr := range el.Element.Attr {
-			if strings.ToLower(attr.Key) == "style" {
-				vals := css.ParseBlock(attr.Val)
-				for name, val := range vals {
-					el.ConditionalStyles.Unconditional.AddStyle(
-						css.StyleRule{
-							Selector: css.CSSSelector{"", cssOrder},
-							Name:     name,
-							Value:    val,
-							Src:      css.InlineStyleSrc,
-						})
-					cssOrder++
-				}
-			}
-		}
+	for _, rule := range presentationalHints(el) {
+		el.ConditionalStyles.Unconditional.AddStyle(rule)
+	}
 
-		// TODO(driusan): User styles too
-
-		fl := el.ConditionalStyles.FirstLine.MergeStyles(
-			el.ConditionalStyles.Unconditional,
-		)
-		el.ConditionalStyles.FirstLine = &fl
-		flet := el.ConditionalStyles.FirstLetter.MergeStyles(
-			el.ConditionalStyles.FirstLine,
-		)
-		el.ConditionalStyles.FirstLetter = &flet
-
-		el.ConditionalStyles.Unconditional.SortStyles()
-		el.ConditionalStyles.FirstLine.SortStyles()
-		el.ConditionalStyles.FirstLetter.SortStyles()
-
-		// Set the font size for this element, because em and ex
-		// units depend on it.
-		var base int
-		switch strVal := el.ConditionalStyles.Unconditional.FontSize.Value; strVal {
-		case "":
-			// nothing specified, so inherit from parent, or
-			// fall back on default if there is no parent.
-			if el.Parent == nil {
-				el.ConditionalStyles.Unconditional.SetFontSize(css.DefaultFontSize)
-				base = css.DefaultFontSize
-			} else {
-				size, _ := el.Parent.Styles.GetFontSize()
-				el.ConditionalStyles.Unconditional.SetFontSize(size)
-				base = size
+	for _, attr := range el.Element.Attr {
+		if strings.ToLower(attr.Key) == "style" {
+			for _, rule := range css.ParseInlineStyle(attr.Val, nil) {
+				rule.Selector = css.CSSSelector{OrderNumber: cssOrder}
+				el.ConditionalStyles.Unconditional.AddStyle(rule)
+				cssOrder++
 			}
-		default:
-			base = fontSizeToPx(strVal, el.Parent)
-			el.ConditionalStyles.Unconditional.SetFontSize(base)
 		}
+	}
 
-		switch strVal := el.ConditionalStyles.FirstLine.FontSize.Value; strVal {
-		case "":
-			el.ConditionalStyles.FirstLine.SetFontSize(base)
-		default:
-			base = fontSizeToPx(strVal, el.Parent)
-			el.ConditionalStyles.FirstLine.SetFontSize(base)
-		}
+	fl := el.ConditionalStyles.FirstLine.MergeStyles(
+		el.ConditionalStyles.Unconditional,
+	)
+	el.ConditionalStyles.FirstLine = &fl
+	flet := el.ConditionalStyles.FirstLetter.MergeStyles(
+		el.ConditionalStyles.FirstLine,
+	)
+	el.ConditionalStyles.FirstLetter = &flet
+
+	// Properties are inherited from the parent's element styles, not
+	// its first line.
+	if el.Parent != nil && el.Parent.ConditionalStyles.Unconditional != nil {
+		parent := el.Parent.ConditionalStyles.Unconditional
+		inherited := parent.CustomProperties()
+		el.ConditionalStyles.Unconditional.InheritCustomProperties(inherited)
+		el.ConditionalStyles.FirstLine.InheritCustomProperties(inherited)
+		el.ConditionalStyles.FirstLetter.InheritCustomProperties(inherited)
+		el.ConditionalStyles.Unconditional.InheritStyles(parent)
+		el.ConditionalStyles.FirstLine.InheritStyles(parent)
+		el.ConditionalStyles.FirstLetter.InheritStyles(parent)
+	}
 
-		switch strVal := el.ConditionalStyles.FirstLetter.FontSize.Value; strVal {
-		case "":
-			el.ConditionalStyles.FirstLetter.SetFontSize(base)
-		default:
-			// First-letter is relative to the first line, not relative
-			// to the parent.
-			el.Styles = el.ConditionalStyles.FirstLine
-			base = fontSizeToPx(strVal, el)
-			el.ConditionalStyles.FirstLetter.SetFontSize(base)
+	el.ConditionalStyles.Unconditional.SortStyles()
+	el.ConditionalStyles.FirstLine.SortStyles()
+	el.ConditionalStyles.FirstLetter.SortStyles()
+
+	// Set the font size for this element, because em and ex
+	// units depend on it.
+	var base int
+	switch strVal := el.ConditionalStyles.Unconditional.FontSize.Value; strVal {
+	case "":
+		// nothing specified, so inherit from parent, or
+		// fall back on default if there is no parent.
+		if el.Parent == nil {
+			el.ConditionalStyles.Unconditional.SetFontSize(css.DefaultFontSize)
+			base = css.DefaultFontSize
+		} else {
+			size, _ := el.Parent.Styles.GetFontSize()
+			el.ConditionalStyles.Unconditional.SetFontSize(size)
+			base = size
 		}
+	default:
+		base = p.fontSizeToPx(strVal, el.Parent)
+		el.ConditionalStyles.Unconditional.SetFontSize(base)
+	}
+
+	switch strVal := el.ConditionalStyles.FirstLine.FontSize.Value; strVal {
+	case "":
+		el.ConditionalStyles.FirstLine.SetFontSize(base)
+	default:
+		base = p.fontSizeToPx(strVal, el.Parent)
+		el.ConditionalStyles.FirstLine.SetFontSize(base)
+	}
 
+	switch strVal := el.ConditionalStyles.FirstLetter.FontSize.Value; strVal {
+	case "":
+		el.ConditionalStyles.FirstLetter.SetFontSize(base)
+	default:
+		// First-letter is relative to the first line, not relative
+		// to the parent.
 		el.Styles = el.ConditionalStyles.FirstLine
+		base = p.fontSizeToPx(strVal, el)
+		el.ConditionalStyles.FirstLetter.SetFontSize(base)
+	}
 
-		if p.Background == color.Transparent {
-			if el.Type == html.ElementNode {
-				switch strings.ToLower(el.Data) {
-				case "html", "body":
-					// Since body is a child of HTML, html
-					// would have made the outer if false
-					// if both are set, so this should
-					// implicitly result in html having
-					// precedence over body.
-					bg := el.GetBackgroundColor()
-					if bg != color.Transparent {
-						p.Background = bg
-					}
-				}
+	// Work out the typed values of the properties once, so that layout
+	// doesn't need to parse them again.
+	var parentStyle *css.ComputedStyle
+	if el.Parent != nil && el.Parent.ConditionalStyles.Unconditional != nil {
+		parentStyle = el.Parent.ConditionalStyles.Unconditional.ComputedStyle()
+	}
+	for _, styles := range []*css.StyledElement{
+		el.ConditionalStyles.Unconditional,
+		el.ConditionalStyles.FirstLine,
+		el.ConditionalStyles.FirstLetter,
+	} {
+		el.Styles = styles
+		styles.ComputeStyle(parentStyle, el.units())
+	}
+
+	// Pseudo-elements inherit from the element itself, not from its
+	// first line.
+	el.Styles = el.ConditionalStyles.Unconditional
+	p.setPseudoElement(el, "before", before)
+	p.setPseudoElement(el, "after", after)
+	p.setPseudoElement(el, "marker", marker)
+
+	el.Styles = el.ConditionalStyles.FirstLine
+}
+
+// presentationalHintOrder is the order number of presentational hints, which
+// come after every rule in the user agent stylesheet.
+const presentationalHintOrder = ^uint(0)
+
+// presentationalHints returns the rules that HTML attributes on el map to.
+// They're treated as user agent rules with the specificity of an attribute
+// selector, so that any author rule overrides them.
+func presentationalHints(el *RenderableDomElement) []css.StyleRule {
+	var rules []css.StyleRule
+	hint := func(selector string, name css.StyleAttribute, value string) {
+		rules = append(rules, css.StyleRule{
+			Selector: css.CSSSelector{Selector: selector, OrderNumber: presentationalHintOrder},
+			Name:     name,
+			Value:    css.StyleValue{Value: value},
+			Src:      css.UserAgentSrc,
+		})
+	}
+	switch strings.ToLower(el.Data) {
+	case "ol":
+		start, err := strconv.Atoi(strings.TrimSpace(el.GetAttribute("start")))
+		if err != nil {
+			break
+		}
+		reversed := false
+		for _, attr := range el.Attr {
+			if attr.Key == "reversed" {
+				reversed = true
 			}
 		}
-	})
+		// The first list item increments the counter before it's
+		// displayed, so the reset is one step before start.
+		if reversed {
+			hint("ol[start]", "counter-reset", "reversed(list-item) "+strconv.Itoa(start+1))
+		} else {
+			hint("ol[start]", "counter-reset", "list-item "+strconv.Itoa(start-1))
+		}
+	case "li":
+		if value, err := strconv.Atoi(strings.TrimSpace(el.GetAttribute("value"))); err == nil {
+			hint("li[value]", "counter-set", "list-item "+strconv.Itoa(value))
+		}
+	}
+	return rules
+}
+
+// isTransparent reports whether c is fully transparent.
+func isTransparent(c color.Color) bool {
+	_, _, _, a := c.RGBA()
+	return a == 0
+}
 
-	// There was no explicit background, so use grey.
-	if p.Background == color.Transparent {
-		p.Background = color.RGBA{0xE0, 0xE0, 0xE0, 0xFF}
+// updateBackground sets the page's background colour from the html or body
+// element.
+func (p *Page) updateBackground() {
+	p.Background = color.Transparent
+	if p.Content != nil && p.Content.Type == html.ElementNode && strings.ToLower(p.Content.Data) == "html" {
+		// html has precedence over body.
+		if bg := p.Content.GetBackgroundColor(); !isTransparent(bg) {
+			p.Background = bg
+		} else if body := p.getBody(); body != nil {
+			p.Background = body.GetBackgroundColor()
+		}
+	}
+
+	// There was no explicit background, so use grey. If the background
+	// isn't opaque, the grey shows through it.
+	grey := color.RGBA{0xE0, 0xE0, 0xE0, 0xFF}
+	if isTransparent(p.Background) {
+		p.Background = grey
+	} else if _, _, _, a := p.Background.RGBA(); a < 0xffff {
+		canvas := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		canvas.Set(0, 0, grey)
+		draw.Draw(canvas, canvas.Bounds(), &image.Uniform{p.Background}, image.ZP, draw.Over)
+		p.Background = canvas.At(0, 0)
 	}
 }
 
-func fontSizeToPx(val string, parent *RenderableDomElement) int {
+func (p *Page) fontSizeToPx(val string, parent *RenderableDomElement) int {
 	DefaultFontSize := css.DefaultFontSize
 	if len(val) == 0 {
 		psize, err := parent.Styles.GetFontSize()
@@ -229,10 +351,6 @@ func fontSizeToPx(val string, parent *RenderableDomElement) int {
 	case "larger":
 		psize, _ := parent.Styles.GetFontSize()
 		return int(1.2 * float64(psize))
-	// inherit
-	case "inherit":
-		psize, _ := parent.Styles.GetFontSize()
-		return psize
 	// 0 doesn't need a unit
 	case "0":
 		return 0
@@ -260,7 +378,17 @@ func fontSizeToPx(val string, parent *RenderableDomElement) int {
 			psize = ps
 		}
 	}
-	size, err := css.ConvertUnitToPx(DefaultFontSize, psize, val)
+	// Font relative units are relative to the parent's font, and root
+	// relative units in the root element are relative to the initial
+	// font size.
+	units := css.UnitContext{FontSize: psize}
+	if parent != nil {
+		units = parent.units()
+		units.FontSize = psize
+	} else if p.Content != nil {
+		units.ViewportWidth, units.ViewportHeight = p.Content.viewport.X, p.Content.viewport.Y
+	}
+	size, err := css.ConvertLengthToPx(units, psize, val)
 	if err != nil {
 		return DefaultFontSize
 	}
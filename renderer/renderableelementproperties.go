@@ -5,6 +5,7 @@ import (
 	"github.com/driusan/gob/css"
 	"golang.org/x/image/font"
 	"golang.org/x/net/html"
+	"image"
 	"image/color"
 	"os"
 	"strings"
@@ -56,7 +57,7 @@ func (e *RenderableDomElement) GetLineHeight() int {
 		return e.Parent.getLineHeight(fSize)
 	}
 	stringVal := e.Styles.LineHeight.Value
-	if stringVal == "" || stringVal == "inherit" {
+	if stringVal == "" {
 		if e.Parent == nil {
 			fontFace := e.GetFontFace(fSize)
 			return getFontHeight(fontFace)
@@ -66,19 +67,47 @@ func (e *RenderableDomElement) GetLineHeight() int {
 	return e.getLineHeight(fSize)
 }
 
+// hasNormalLineHeight reports whether e's line-height is normal, rather than
+// a length, number or percentage.
+func (e *RenderableDomElement) hasNormalLineHeight() bool {
+	for ; e != nil; e = e.Parent {
+		if e.Styles == nil {
+			continue
+		}
+		switch e.Styles.LineHeight.Value {
+		case "":
+			continue
+		case "normal":
+			return true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 // calculate the line height in pixels assuming a font size of fsize
 // (Used to ensure when a child inherits the line height, it's relative to
 // its own font size, not the parent's.
 func (e *RenderableDomElement) getLineHeight(fSize int) int {
 	stringVal := e.Styles.LineHeight.Value
-	if stringVal == "" || stringVal == "inherit" {
+	if stringVal == "" {
 		if e.Parent == nil {
 			fontFace := e.GetFontFace(fSize)
 			return getFontHeight(fontFace)
 		}
 		return e.Parent.getLineHeight(fSize)
 	}
-	lHeightSize, err := css.ConvertUnitToPx(fSize, fSize, stringVal)
+	units := e.units()
+	units.FontSize = fSize
+	// lh in line-height is relative to the parent's line height.
+	units.LineHeight = func() float64 {
+		if e.Parent == nil {
+			return float64(getFontHeight(e.GetFontFace(fSize)))
+		}
+		return float64(e.Parent.getLineHeight(fSize))
+	}
+	lHeightSize, err := css.ConvertLengthToPx(units, fSize, stringVal)
 	if err != nil {
 		fontFace := e.GetFontFace(fSize)
 		return getFontHeight(fontFace)
@@ -88,6 +117,62 @@ func (e *RenderableDomElement) getLineHeight(fSize int) int {
 	// return getFontHeight(fontFace)
 }
 
+// root returns the root element of the document that e is in.
+func (e *RenderableDomElement) root() *RenderableDomElement {
+	for e.Parent != nil {
+		e = e.Parent
+	}
+	return e
+}
+
+// units returns the values that relative lengths in e's styles are resolved
+// against.
+func (e *RenderableDomElement) units() css.UnitContext {
+	root := e.root()
+	fSize := e.GetFontSize()
+	return css.UnitContext{
+		FontSize:       fSize,
+		RootFontSize:   root.GetFontSize(),
+		ViewportWidth:  root.viewport.X,
+		ViewportHeight: root.viewport.Y,
+		ZeroWidth: func() float64 {
+			face := e.GetFontFace(fSize)
+			defer face.Close()
+			if adv, ok := face.GlyphAdvance('0'); ok {
+				return float64(adv) / 64
+			}
+			return float64(fSize) / 2
+		},
+		LineHeight: func() float64 {
+			return float64(e.GetLineHeight())
+		},
+	}
+}
+
+// ComputedStyle returns the typed values of e's properties after the cascade.
+// Elements which didn't have their styles applied directly, such as text
+// nodes, compute them from their parent the first time that they're needed.
+func (e *RenderableDomElement) ComputedStyle() *css.ComputedStyle {
+	if e.Styles == nil {
+		if e.Parent != nil {
+			return e.Parent.ComputedStyle()
+		}
+		var styles css.StyledElement
+		styles.SetFontSize(css.DefaultFontSize)
+		styles.ComputeStyle(nil, css.UnitContext{FontSize: css.DefaultFontSize})
+		return styles.ComputedStyle()
+	}
+	if cs := e.Styles.ComputedStyle(); cs != nil {
+		return cs
+	}
+	var parent *css.ComputedStyle
+	if e.Parent != nil {
+		parent = e.Parent.ComputedStyle()
+	}
+	e.Styles.ComputeStyle(parent, e.units())
+	return e.Styles.ComputedStyle()
+}
+
 func (e *RenderableDomElement) GetFontSize() int {
 	fromCSS, err := e.Styles.GetFontSize()
 	switch err {
@@ -105,65 +190,21 @@ func (e *RenderableDomElement) GetFontSize() int {
 }
 
 func (e RenderableDomElement) GetBackgroundColor() color.Color {
-	switch bgc := e.Styles.BackgroundColor.Value; bgc {
-	case "inherit":
-		if e.Parent == nil {
-			// return dfltBackground
-			return color.Transparent
-		}
-		return e.Parent.GetBackgroundColor()
-	case "", "transparent":
-		return color.Transparent
-	default:
-		c, err := css.ConvertColorToRGBA(bgc)
-		if err != nil {
-			return color.Transparent
-			//panic(err)
-		}
-		return c
-
-	}
+	return e.ComputedStyle().BackgroundColor
 }
 
 func (e RenderableDomElement) GetColor() color.Color {
-	var deflt color.RGBA
-	if e.Type == html.ElementNode && e.Data == "a" {
-		deflt = color.RGBA{0, 0, 0xFF, 0xFF}
-	} else {
-		deflt = color.RGBA{0, 0, 0, 0xFF}
-	}
-	switch cssColor, err := e.Styles.GetColor(deflt); err {
-	case css.InheritValue:
-		if e.Parent == nil {
-			return deflt
-		}
-		return e.Parent.GetColor()
-	case css.NoStyles:
-		if e.Parent == nil {
-			return deflt
-		}
-		return e.Parent.GetColor()
-	default:
-		return cssColor
-	}
+	return e.ComputedStyle().Color
 }
 
 func (e RenderableDomElement) GetFloat() string {
-	switch float := e.Styles.Float.Value; float {
-	case "inherit":
-		return e.Parent.GetFloat()
-	case "left", "right", "none":
-		return float
-	default:
-		return "none"
-
-	}
+	return string(e.ComputedStyle().Float)
 }
 func (e RenderableDomElement) GetDisplayProp() string {
 	if e.Type == html.TextNode {
 		return "inline"
 	}
-	if cssVal := e.Styles.DisplayProp(); cssVal != "" {
+	if cssVal := string(e.ComputedStyle().Display); cssVal != "" {
 		// Apply section 9.7 of CSS spec: Relationships between display, position, and float"
 		if cssVal == "none" {
 			return cssVal
@@ -205,8 +246,6 @@ func (e *RenderableDomElement) GetTextDecoration() string {
 	}
 
 	switch decoration := e.Styles.TextDecoration.Value; decoration {
-	case "inherit":
-		return e.Parent.GetTextDecoration()
 	default:
 		trimmed := strings.TrimSpace(decoration)
 		if trimmed != "" {
@@ -221,364 +260,158 @@ func (e *RenderableDomElement) GetTextDecoration() string {
 	}
 }
 func (e RenderableDomElement) GetTextTransform() string {
-	if e.Styles == nil {
-		return "none"
-	}
-
-	switch transformation := e.Styles.TextTransform.Value; transformation {
-	case "inherit":
-		return e.Parent.GetTextTransform()
-	case "capitalize", "uppercase", "lowercase", "none":
-		return transformation
-	default:
-		if e.Parent == nil {
-			return "none"
-		}
-		return e.Parent.GetTextTransform()
-	}
+	return string(e.ComputedStyle().TextTransform)
 }
 
 func (e RenderableDomElement) GetTextIndent(containerWidth int) int {
-	// it's inherited, with the initial value of 0
-	if e.Styles == nil {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetTextIndent(containerWidth)
-	}
-	val := e.Styles.TextIndent.Value
-	if val == "" {
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetTextIndent(containerWidth)
-	}
-	px, err := css.ConvertUnitToPx(e.GetFontSize(), containerWidth, val)
-	if err != nil {
-		return 0
-	}
-	return px
+	return e.ComputedStyle().TextIndent.Px(containerWidth, e.units)
 }
 
 func (e RenderableDomElement) GetContainerWidth(containerWidth int) int {
-	width := containerWidth - (e.GetMarginLeftSize() + e.GetMarginRightSize() + e.GetBorderLeftWidth() + e.GetBorderRightWidth() + e.GetPaddingLeft() + e.GetPaddingRight())
-	if e.Styles == nil {
-		return width
-	}
-	cssVal := e.Styles.Width.Value
-	switch cssVal {
-	case "inherit":
-		if e.Parent == nil {
-			return width
-		}
-		return e.Parent.GetContainerWidth(containerWidth)
-	case "", "auto":
-		return width
-	default:
-		calVal, err := css.ConvertUnitToPx(e.GetFontSize(), containerWidth, cssVal)
-		if err == nil {
-			return calVal
-		}
-		return width
+	if width := e.ComputedStyle().Width; width.Type != css.LengthAuto {
+		return width.Px(containerWidth, e.units)
 	}
+	return containerWidth - (e.GetMarginLeftSize() + e.GetMarginRightSize() + e.GetBorderLeftWidth() + e.GetBorderRightWidth() + e.GetPaddingLeft() + e.GetPaddingRight())
 }
 func (e RenderableDomElement) GetMaxHeight() int {
-	if e.Styles == nil {
-		return -1
-	}
-	cssVal := e.Styles.MaxHeight.Value
-	switch cssVal {
-	case "inherit":
-		if e.Parent == nil {
-			return -1
-		}
-		return e.Parent.GetMaxHeight()
-	case "":
-		return -1
-	default:
-		calVal, err := css.ConvertUnitToPx(e.GetFontSize(), 0, cssVal)
-		if err == nil {
-			return calVal
-		}
+	l := e.ComputedStyle().MaxHeight
+	if l.Type == css.LengthNone {
 		return -1
 	}
+	return l.Px(0, e.units)
 }
 func (e RenderableDomElement) GetMaxWidth() int {
-	if e.Styles == nil {
-		return -1
-	}
-	cssVal := e.Styles.MaxWidth.Value
-	switch cssVal {
-	case "inherit":
-		if e.Parent == nil {
-			return -1
-		}
-		return e.Parent.GetMaxWidth()
-	case "":
-		return -1
-	default:
-		calVal, err := css.ConvertUnitToPx(e.GetFontSize(), 0, cssVal)
-		if err == nil {
-			return calVal
-		}
+	l := e.ComputedStyle().MaxWidth
+	if l.Type == css.LengthNone {
 		return -1
 	}
+	return l.Px(0, e.units)
 }
 func (e RenderableDomElement) GetHeight() int {
-	if e.Styles == nil {
-		return -1
-	}
-	cssVal := e.Styles.Height.Value
-	switch cssVal {
-	case "inherit":
-		if e.Parent == nil {
-			return -1
-		}
-		return e.Parent.GetHeight()
-	case "", "auto":
-		return -1
-	default:
-		calVal, err := css.ConvertUnitToPx(e.GetFontSize(), e.containerHeight, cssVal)
-		if err == nil {
-			return calVal
-		}
+	l := e.ComputedStyle().Height
+	if l.Type == css.LengthAuto {
 		return -1
 	}
+	return l.Px(e.containerHeight, e.units)
 }
 func (e RenderableDomElement) GetWidth() int {
-	if e.Styles == nil {
-		return -1
-	}
-	cssVal := e.Styles.Width.Value
-	switch cssVal {
-	case "inherit":
-		if e.Parent == nil {
-			return -1
-		}
-		return e.Parent.GetWidth()
-	case "", "auto":
-		return -1
-	default:
-		calVal, err := css.ConvertUnitToPx(e.GetFontSize(), e.containerWidth, cssVal)
-		if err == nil {
-			return calVal
-		}
+	l := e.ComputedStyle().Width
+	if l.Type == css.LengthAuto {
 		return -1
 	}
+	return l.Px(e.containerWidth, e.units)
 }
 func (e RenderableDomElement) GetMinWidth() int {
-	if e.Styles == nil {
-		return 0
-	}
-	cssVal := e.Styles.MinWidth.Value
-	switch cssVal {
-	case "inherit":
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetMinWidth()
-	case "":
-		return 0
-	default:
-		calVal, err := css.ConvertUnitToPx(e.GetFontSize(), 0, cssVal)
-		if err == nil {
-			return calVal
-		}
-		return 0
-	}
+	return e.ComputedStyle().MinWidth.Px(0, e.units)
 }
 func (e RenderableDomElement) GetMinHeight() int {
-	if e.Styles == nil {
-		return 0
-	}
-	cssVal := e.Styles.MinHeight.Value
-	switch cssVal {
-	case "inherit":
-		if e.Parent == nil {
-			return 0
-		}
-		return e.Parent.GetMinHeight()
-	case "":
-		return 0
-	default:
-		calVal, err := css.ConvertUnitToPx(e.GetFontSize(), 0, cssVal)
-		if err == nil {
-			return calVal
-		}
-		return 0
-	}
+	return e.ComputedStyle().MinHeight.Px(0, e.units)
 }
 
 func (e *RenderableDomElement) GetFontWeight() font.Weight {
-	switch e.Styles.FontWeight.Value {
-	case "normal":
-		return font.WeightNormal
-	case "100":
-		return font.WeightThin
-	case "200":
-		return font.WeightExtraLight
-	case "300":
-		return font.WeightLight
-	case "400":
-		return font.WeightNormal
-	case "500":
-		return font.WeightMedium
-	case "600":
-		return font.WeightSemiBold
-	case "700", "bold":
-		return font.WeightBold
-	case "800":
-		return font.WeightExtraBold
-	case "900":
-		return font.WeightBlack
-	case "bolder":
-		if e.Parent == nil {
-			return font.WeightMedium
-		}
-		parent := e.Parent.GetFontWeight()
-		if parent == font.WeightBlack {
-			return font.WeightBlack
-		}
-		return parent + 1
-	case "lighter":
-		if e.Parent == nil {
-			return font.WeightLight
-		}
-		parent := e.Parent.GetFontWeight()
-		if parent == font.WeightThin {
-			return font.WeightThin
-		}
-		return parent - 1
-	case "inherit":
-		fallthrough
-	default:
-		//inherit
-		if e.Parent == nil {
-			return font.WeightNormal
-		}
-		return e.Parent.GetFontWeight()
-
-	}
+	return e.ComputedStyle().FontWeight
 }
 func (e *RenderableDomElement) GetFontStyle() font.Style {
-	switch s := e.Styles.FontStyle.Value; s {
-	case "normal":
-		return font.StyleNormal
-	case "italic":
-		return font.StyleItalic
-	case "oblique":
-		return font.StyleOblique
-	case "inherit":
-		fallthrough
-	default:
-		if e.Parent == nil {
-			return font.StyleNormal
-		}
-		return e.Parent.GetFontStyle()
-
-	}
+	return e.ComputedStyle().FontStyle
+}
+func (e *RenderableDomElement) GetFontStretch() css.FontStretch {
+	return e.ComputedStyle().FontStretch
 }
 
 func (e *RenderableDomElement) GetFontFamily() css.FontFamily {
-	switch s := strings.ToLower(e.Styles.FontFamily.Value); s {
-	case "sans-serif", "serif", "monospace":
-		return css.FontFamily(s)
-	case "fantasy", "cursive":
-		//unhandled font families that are nonetheless valid.
-		// fallback on sans-serif
-		return css.FontFamily("sans-serif")
-	}
-	if e.Parent == nil {
-		return css.FontFamily("sans-serif")
-	}
-	return e.Parent.GetFontFamily()
-
+	return e.ComputedStyle().FontFamily
 }
 
 func (e *RenderableDomElement) FontVariant() string {
-	// FIXME: Make this an enum/const, not a string
-
-	switch s := strings.ToLower(e.Styles.FontVariant.Value); s {
-	case "normal", "":
-		return "normal"
-	case "small-caps":
-		return s
-	case "inherit":
-		if e.Parent == nil {
-			return "normal"
-		}
-		return e.Parent.FontVariant()
-	default:
-		return "normal"
-	}
+	return string(e.ComputedStyle().FontVariant)
 }
 
 func (e *RenderableDomElement) GetFontFace(fsize int) font.Face {
-	return e.Styles.GetFontFace(fsize, e.GetFontFamily(), e.GetFontWeight(), e.GetFontStyle())
+	return e.Styles.GetFontFace(fsize, e.GetFontFamily(), e.GetFontWeight(), e.GetFontStyle(), e.GetFontStretch())
+}
+
+// GetSmallCapsFace returns the face of a small-caps font for the element's
+// font family, or nil if it doesn't have one and small-caps need to be
+// synthesized.
+func (e *RenderableDomElement) GetSmallCapsFace(fsize int) font.Face {
+	return e.Styles.GetSmallCapsFace(fsize, e.GetFontFamily(), e.GetFontWeight(), e.GetFontStyle(), e.GetFontStretch())
 }
 
 func (e *RenderableDomElement) GetWhiteSpace() string {
-	switch s := strings.ToLower(e.Styles.WhiteSpace.Value); s {
-	case "normal", "pre", "nowrap":
-		return s
-	case "pre-wrap", "pre-line":
-		panic("Unimplemented WhiteSpace value: " + s)
-	}
-	// default is inherited, inherit will also fall through
-	// to here.
-	if e.Parent == nil {
-		return "normal"
+	switch s := e.ComputedStyle().WhiteSpace; s {
+	case css.WhiteSpacePreWrap, css.WhiteSpacePreLine:
+		panic("Unimplemented WhiteSpace value: " + string(s))
+	default:
+		return string(s)
 	}
-	return e.Parent.GetWhiteSpace()
-
 }
 
+// GetOverflow returns "hidden" if the overflow of e is hidden or clipped in
+// either direction, and "visible" otherwise.
 func (e *RenderableDomElement) GetOverflow() string {
-	switch s := strings.ToLower(e.Styles.Overflow.Value); s {
-	case "visible", "":
-		return "visible"
-	case "hidden":
-		return s
-	case "scroll", "auto":
-		fmt.Fprintf(os.Stderr, "Unimplemented WhiteSpace value: %s. Defaulting to visible.", s)
-		return "visible"
-	case "inherit":
-		if e.Parent == nil {
-			return "visible"
+	cs := e.ComputedStyle()
+	for _, s := range []css.Overflow{cs.OverflowX, cs.OverflowY} {
+		switch s {
+		case css.OverflowHidden, css.OverflowClip:
+			return "hidden"
+		case css.OverflowScroll, css.OverflowAuto:
+			fmt.Fprintf(os.Stderr, "Unimplemented overflow value: %s. Defaulting to visible.", s)
 		}
-		return e.Parent.GetOverflow()
-	default:
-		return "visible"
 	}
+	return "visible"
 }
 
 func (e *RenderableDomElement) GetVerticalAlign() string {
-	switch s := strings.ToLower(e.Styles.VerticalAlign.Value); s {
-	case "baseline", "sub", "super", "top", "text-top", "middle", "botom", "text-bottom":
-		return s
-	case "inherit":
+	// FIXME: Handle lengths and percentage values
+	return string(e.ComputedStyle().VerticalAlign)
+}
+
+// GetListStyleType returns the list-style-type of e. This is the name of a
+// counter style, "none", or a quoted string to use as the marker.
+func (e *RenderableDomElement) GetListStyleType() string {
+	switch s := strings.TrimSpace(e.Styles.ListStyleType.Value); {
+	case s == "":
 		if e.Parent == nil {
-			return "baseline"
+			return "disc"
 		}
-		return e.Parent.GetVerticalAlign()
+		return e.Parent.GetListStyleType()
+	case strings.EqualFold(s, "none"):
+		return "none"
+	case strings.HasPrefix(s, `"`), strings.HasPrefix(s, "'"):
+		return s
+	case e.counterStyleDefs.Exists(s):
+		return s
 	default:
-		// FIXME: Handle lengths and percentage values
-		return "baseline"
+		// References to counter styles that don't exist are
+		// treated as decimal.
+		return "decimal"
 	}
 }
 
-func (e *RenderableDomElement) GetListStyleType() string {
-	switch s := strings.ToLower(e.Styles.ListStyleType.Value); s {
-	case "disc", "circle", "square", "decimal", "decimal-leading-zero", "lower-roman",
-		"upper-roman", "lower-greek", "lower-latin", "upper-latin", "armenian", "georgian",
-		"lower-alpha", "upper-alpha", "none":
-		return s
-	case "inherit":
-		fallthrough
-	default:
+// GetListStylePosition returns the list-style-position of e, either
+// "inside" or "outside".
+func (e *RenderableDomElement) GetListStylePosition() string {
+	return string(e.ComputedStyle().ListStylePosition)
+}
+
+// listStyleImageURL returns the URL of the list-style-image of e, or an
+// empty string if it doesn't have one.
+func (e *RenderableDomElement) listStyleImageURL() string {
+	u, err := e.Styles.GetListStyleImage()
+	if err != nil {
 		if e.Parent == nil {
-			return "disc"
+			return ""
 		}
-		return e.Parent.GetListStyleType()
+		return e.Parent.listStyleImageURL()
+	}
+	return u
+}
+
+// GetListStyleImage returns the image to use as the marker of e, or nil if
+// it doesn't have one or the image can't be loaded.
+func (e *RenderableDomElement) GetListStyleImage() image.Image {
+	if u := e.listStyleImageURL(); u != "" {
+		return e.loadImage(u)
 	}
+	return nil
 }
@@ -0,0 +1,215 @@
+package renderer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Test that changing the hover, active and focus states restyles the
+// elements that they affect.
+func TestDynamicPseudoClasses(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		p { color: black }
+		p:hover { color: red }
+		div:hover > span { color: blue }
+		div:focus-within { background: green }
+		a:focus { color: purple }
+		div > span:active { color: yellow }
+		</style>
+	</head>
+	<body>
+		<div><span>Span</span> <a href="#">Link</a></div>
+		<p>Paragraph</p>
+	</body>
+</html>`,
+	)
+	body := page.getBody()
+	var div, span, a, p *RenderableDomElement
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Data {
+		case "div":
+			div = c
+		case "p":
+			p = c
+		}
+	}
+	for c := div.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Data {
+		case "span":
+			span = c
+		case "a":
+			a = c
+		}
+	}
+	if div == nil || span == nil || a == nil || p == nil {
+		t.Fatal("Could not find elements in test document")
+	}
+
+	black := color.RGBA{0, 0, 0, 255}
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	green := color.RGBA{0, 128, 0, 255}
+	purple := color.RGBA{128, 0, 128, 255}
+	yellow := color.RGBA{255, 255, 0, 255}
+
+	if c := p.GetColor(); !colorEQ(c, black) {
+		t.Errorf("Unexpected initial colour of p: got %v want %v", c, black)
+	}
+	if len(page.SetHover(p)) == 0 {
+		t.Error("Hovering over p did not change any styles")
+	}
+	if c := p.GetColor(); !colorEQ(c, red) {
+		t.Errorf("Unexpected colour of p:hover: got %v want %v", c, red)
+	}
+	if len(page.SetHover(p)) > 0 {
+		t.Error("Hovering over the same element changed styles")
+	}
+
+	// Hovering over the span makes its parent div hovered too.
+	if len(page.SetHover(span)) == 0 {
+		t.Error("Hovering over span did not change any styles")
+	}
+	if c := p.GetColor(); !colorEQ(c, black) {
+		t.Errorf("Unexpected colour of p after hover moved: got %v want %v", c, black)
+	}
+	if c := span.GetColor(); !colorEQ(c, blue) {
+		t.Errorf("Unexpected colour of span in div:hover: got %v want %v", c, blue)
+	}
+	if !div.State.Hover || !body.State.Hover || p.State.Hover {
+		t.Errorf("Unexpected hover state: div %v body %v p %v", div.State.Hover, body.State.Hover, p.State.Hover)
+	}
+
+	if len(page.SetActive(span)) == 0 {
+		t.Error("Activating span did not change any styles")
+	}
+	if c := span.GetColor(); !colorEQ(c, yellow) {
+		t.Errorf("Unexpected colour of span:active: got %v want %v", c, yellow)
+	}
+	page.SetActive(nil)
+	if c := span.GetColor(); !colorEQ(c, blue) {
+		t.Errorf("Unexpected colour of span after deactivating: got %v want %v", c, blue)
+	}
+
+	if len(page.SetFocus(a)) == 0 {
+		t.Error("Focusing link did not change any styles")
+	}
+	if c := a.GetColor(); !colorEQ(c, purple) {
+		t.Errorf("Unexpected colour of a:focus: got %v want %v", c, purple)
+	}
+	if c := div.GetBackgroundColor(); !colorEQ(c, green) {
+		t.Errorf("Unexpected background of div:focus-within: got %v want %v", c, green)
+	}
+	page.SetFocus(nil)
+	if c := div.GetBackgroundColor(); colorEQ(c, green) {
+		t.Errorf("div:focus-within still applied after removing focus")
+	}
+}
+
+// Test that state changes don't restyle anything when no rules depend on
+// them.
+func TestStateWithoutDynamicRules(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html><body><p>Paragraph</p></body></html>`,
+	)
+	if len(page.SetHover(page.getBody())) > 0 {
+		t.Error("Hover changed styles without any :hover rules")
+	}
+	if !page.getBody().State.Hover {
+		t.Error("Hover state was not set")
+	}
+}
+
+// Test that after a state change only the subtrees whose styles changed are
+// laid out again, and that the page is laid out the same as it would be if
+// all of it was.
+func TestRelayoutChangedSubtrees(t *testing.T) {
+	const doc = `<html>
+	<head>
+		<style>
+		p { margin: 0 }
+		#hover:hover { font-size: 32px }
+		</style>
+	</head>
+	<body>
+		<div><p id="hover">Hover</p></div>
+		<div id="other"><p id="after">After</p></div>
+	</body>
+</html>`
+	size := image.Point{800, 600}
+	page := parseHTML(t, doc)
+	page.Content.Layout(context.TODO(), size)
+	ids := elementsByID(page)
+	other := ids["other"]
+	before := other.BoxDrawRectangle
+	result := other.layoutResult
+
+	page.SetHover(ids["after"])
+	changed := page.SetHover(ids["hover"])
+	if len(changed) != 1 || changed[0] != ids["hover"] {
+		t.Fatalf("Unexpected changed elements: got %v want only #hover", changed)
+	}
+	for _, el := range changed {
+		el.InvalidateSubtreeLayout()
+	}
+	if page.Content.layoutDone || ids["hover"].layoutDone {
+		t.Error("Changed element or its ancestors still laid out")
+	}
+	if !other.layoutDone {
+		t.Error("Unchanged subtree was invalidated")
+	}
+	page.Content.Layout(context.TODO(), size)
+	if other.layoutResult != result {
+		t.Error("Unchanged block was laid out again")
+	}
+	if other.BoxDrawRectangle.Min.Y <= before.Min.Y {
+		t.Errorf("Block after the changed element did not move down: got %v, was %v", other.BoxDrawRectangle, before)
+	}
+
+	full := parseHTML(t, doc)
+	fullIDs := elementsByID(full)
+	full.SetHover(fullIDs["hover"])
+	full.Content.Layout(context.TODO(), size)
+	for id, el := range fullIDs {
+		if got, want := ids[id].BoxDrawRectangle, el.BoxDrawRectangle; got != want {
+			t.Errorf("Unexpected box for #%s: got %v want %v", id, got, want)
+		}
+	}
+	if got, want := page.Content.layoutResult.Bounds(), full.Content.layoutResult.Bounds(); got != want {
+		t.Errorf("Unexpected page bounds: got %v want %v", got, want)
+	}
+}
+
+// Test that a state change which matches new rules without changing any
+// values isn't reported as a change in styles.
+func TestStateWithoutStyleChanges(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		p { color: red }
+		p:hover { color: #f00 }
+		p:active { color: rgb(255, 0, 0) }
+		p:active::after { content: "!" }
+		</style>
+	</head>
+	<body>
+		<p id="p">Paragraph</p>
+	</body>
+</html>`,
+	)
+	p := elementsByID(page)["p"]
+	if changed := page.SetHover(p); len(changed) > 0 {
+		t.Errorf("Hovering changed styles without changing any values: got %v", changed)
+	}
+	if changed := page.SetActive(p); len(changed) != 1 || changed[0] != p {
+		t.Errorf("Unexpected changes when activating: got %v want only p", changed)
+	}
+}
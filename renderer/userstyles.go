@@ -0,0 +1,52 @@
+package renderer
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/driusan/gob/css"
+	"github.com/driusan/gob/net"
+)
+
+// UserStylesDir is the directory that user stylesheets are loaded from.
+// user.css applies to every page, and sites/<host>.css applies to pages
+// from that host. It's the gob configuration directory by default.
+var UserStylesDir = net.GetConfigDir()
+
+// userStylesheetPaths returns the files that the user stylesheets for pages
+// at urlContext are loaded from, in the order that they're applied.
+func userStylesheetPaths(urlContext *url.URL) []string {
+	if UserStylesDir == "" {
+		return nil
+	}
+	paths := []string{filepath.Join(UserStylesDir, "user.css")}
+	if urlContext != nil {
+		if host := strings.ToLower(urlContext.Hostname()); host != "" && !strings.ContainsAny(host, `/\`) {
+			paths = append(paths, filepath.Join(UserStylesDir, "sites", host+".css"))
+		}
+	}
+	return paths
+}
+
+// loadUserStyles loads the user stylesheets for pages at urlContext, with
+// order numbers starting at orderNo. Stylesheets which don't exist are
+// skipped. @import rules are resolved relative to the stylesheet's file.
+func loadUserStyles(loader net.URLReader, urlContext *url.URL, orderNo uint) (css.Stylesheet, uint) {
+	var styles css.Stylesheet
+	for _, path := range userStylesheetPaths(urlContext) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		sheetURL, err := url.Parse("file:" + filepath.ToSlash(path))
+		if err != nil {
+			continue
+		}
+		sheet, nextOrderNo := css.ParseStylesheet(string(content), css.UserSrc, loader, sheetURL, orderNo)
+		styles = append(styles, sheet...)
+		orderNo = nextOrderNo
+	}
+	return styles, orderNo
+}
@@ -0,0 +1,86 @@
+package renderer
+
+import (
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Don't let the stylesheets of whoever is running the tests change
+	// the results.
+	UserStylesDir = ""
+	os.Exit(m.Run())
+}
+
+func TestUserStyles(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { UserStylesDir = "" }()
+	UserStylesDir = dir
+
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("user.css", `
+		@import "imported.css";
+		p { color: red; margin-left: 7px }
+		.ad { display: none !important }
+		#important { color: green !important }
+	`)
+	write("imported.css", `p { margin-right: 3px }`)
+	write("sites/localhost.css", `p { margin-top: 4px }`)
+	write("sites/example.com.css", `p { padding-bottom: 9px }`)
+
+	page := parseHTML(
+		t,
+		`<html><head><style>
+	p { color: blue; margin: 0 }
+	div.ad { display: block }
+</style></head>
+<body>
+	<p id="author">Author styles win over normal user styles</p>
+	<p id="important" style="color: blue !important">Important user styles win</p>
+	<div id="ad" class="ad" style="display: block">Hidden</div>
+	<span id="user">Only user styles</span>
+</body></html>`,
+	)
+	els := elementsByID(page)
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	green := color.RGBA{0, 0x80, 0, 0xff}
+	if c := els["author"].GetColor(); !colorEQ(c, blue) {
+		t.Errorf("Normal user style overrode author style: got %v want %v", c, blue)
+	}
+	if m := els["author"].GetMarginLeftSize(); m != 0 {
+		t.Errorf("Normal user style overrode author style: got margin %v want 0", m)
+	}
+	if c := els["important"].GetColor(); !colorEQ(c, green) {
+		t.Errorf("Important user style didn't override important inline style: got %v want %v", c, green)
+	}
+	if d := els["ad"].GetDisplayProp(); d != "none" {
+		t.Errorf("Important user style didn't hide element: got %v", d)
+	}
+
+	page = parseHTML(t, `<html><body><p id="p">a</p></body></html>`)
+	p := elementsByID(page)["p"]
+	if c := p.GetColor(); !colorEQ(c, color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("User style was not applied: got %v", c)
+	}
+	if m := p.GetMarginRightSize(); m != 3 {
+		t.Errorf("Imported user style was not applied: got margin %v want 3", m)
+	}
+	if m := p.GetMarginTopSize(); m != 4 {
+		t.Errorf("Site user style was not applied: got margin %v want 4", m)
+	}
+	if pad := p.GetPaddingBottom(); pad != 0 {
+		t.Errorf("Style for another site was applied: got padding %v want 0", pad)
+	}
+}
@@ -0,0 +1,52 @@
+package renderer
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestCustomPropertyInheritance(t *testing.T) {
+	page := parseHTML(
+		t,
+		`<html>
+	<head>
+		<style>
+		html { --width: 100px; --label: "Note: " }
+		.wide { --width: 200px }
+		div { width: var(--width); height: 10px; margin: var(--margin, 0) }
+		.broken { width: 50px; width: var(--missing) }
+		p::before { content: var(--label) }
+		</style>
+	</head>
+	<body style="margin: 0">
+		<div id="a"></div>
+		<section class="wide">
+			<div id="b"></div>
+			<div id="c" style="--width: 300px; --margin: 5px"></div>
+		</section>
+		<div id="d" class="broken"></div>
+		<p id="e">Text</p>
+	</body>
+</html>`,
+	)
+	page.Content.Layout(context.TODO(), image.Point{800, 600})
+	ids := elementsByID(page)
+
+	tests := map[string]int{"a": 100, "b": 200, "c": 300}
+	for id, want := range tests {
+		if got := ids[id].GetWidth(); got != want {
+			t.Errorf("Unexpected width for %s: got %v want %v", id, got, want)
+		}
+	}
+	if got := ids["c"].GetMarginLeftSize(); got != 5 {
+		t.Errorf("Unexpected margin for c: got %v want 5", got)
+	}
+	// Invalid at computed value time, so width is auto rather than 50px.
+	if got := ids["d"].GetWidth(); got == 50 {
+		t.Errorf("Unexpected width for d: got %v", got)
+	}
+	if text, _ := generatedText(ids["e"], "before"); text != "Note: " {
+		t.Errorf("Unexpected generated content: got %q want %q", text, "Note: ")
+	}
+}